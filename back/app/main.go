@@ -10,6 +10,8 @@ import (
 	"github.com/wonderfulsuccess/go-web-app/back/database"
 	"github.com/wonderfulsuccess/go-web-app/back/logger"
 	"github.com/wonderfulsuccess/go-web-app/back/model"
+	"github.com/wonderfulsuccess/go-web-app/back/seed"
+	"github.com/wonderfulsuccess/go-web-app/back/tenant"
 	"github.com/wonderfulsuccess/go-web-app/back/utils"
 	"github.com/wonderfulsuccess/go-web-app/back/webserver"
 )
@@ -34,9 +36,49 @@ func main() {
 		logger.Errorf("failed to migrate database schema: %v", err)
 	}
 
-	server := webserver.NewServer(cfg, db)
+	tenants, err := tenant.New(db, cfg.Database, cfg.Tenant)
+	if err != nil {
+		// Serving without tenants would mix their data.
+		logger.Errorf("failed to initialise tenants: %v", err)
+		return
+	}
+
+	seedDatabase(ctx, cfg.Seed, seed.New(db, tenants))
+
+	server := webserver.NewServer(cfg, db, tenants)
 
 	if err := server.Start(ctx); err != nil {
 		logger.Errorf("server exited with error: %v", err)
 	}
 }
+
+// seedDatabase loads the fixtures when SEED_ON_START is set and creates the
+// admin account on the first start.
+func seedDatabase(ctx context.Context, cfg config.SeedConfig, seeder *seed.Seeder) {
+	if cfg.OnStart {
+		results, err := seeder.Run(ctx, seed.Fixtures(cfg.Dir), cfg.Env)
+		if err != nil {
+			logger.Errorf("failed to seed %s fixtures: %v", cfg.Env, err)
+		}
+		for _, r := range results {
+			logger.Infof("seeded %s %s: %d created, %d updated, %d unchanged", r.File, r.Model, r.Created, r.Updated, r.Unchanged)
+		}
+	}
+
+	if _, ok, err := seeder.Admin(ctx); err != nil {
+		logger.Errorf("failed to look up the admin account: %v", err)
+		return
+	} else if ok {
+		return
+	}
+	if cfg.AdminEmail == "" {
+		logger.Warningf("there is no admin account yet; set ADMIN_EMAIL or run `go run ./cmd/db admin`")
+		return
+	}
+	admin, err := seeder.CreateAdmin(ctx, cfg.AdminName, cfg.AdminEmail)
+	if err != nil {
+		logger.Errorf("failed to create the admin account: %v", err)
+		return
+	}
+	logger.Infof("created admin account %s", admin.Email)
+}
@@ -0,0 +1,220 @@
+// Package apptest runs the whole server inside a test. New builds a Server
+// on a private in-memory SQLite database, or on the MySQL or PostgreSQL
+// database named by TEST_DB_TYPE and TEST_DB_DSN, in which case everything
+// the test writes happens in one transaction that is rolled back when the
+// test ends. Env then offers fixtures, an HTTP client and a WebSocket client
+// that asserts on the WSMessages it receives:
+//
+//	env := apptest.New(t, apptest.Options{})
+//	env.Load(&model.User{Name: "Ada", Email: "ada@example.com"})
+//	ws := env.Client().Dial("ada")
+//	env.Client().As("ada").Post("/api/tasks", body).Expect(http.StatusAccepted)
+//	ws.Expect(webserver.MessageTypePresenceJoin)
+package apptest
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+	"github.com/wonderfulsuccess/go-web-app/back/database"
+	"github.com/wonderfulsuccess/go-web-app/back/model"
+	"github.com/wonderfulsuccess/go-web-app/back/search"
+	"github.com/wonderfulsuccess/go-web-app/back/tenant"
+	"github.com/wonderfulsuccess/go-web-app/back/webserver"
+)
+
+// Environment variables selecting the database of the tests.
+const (
+	EnvDBType = "TEST_DB_TYPE"
+	EnvDBDSN  = "TEST_DB_DSN"
+)
+
+// Options adjusts the environment built by New.
+type Options struct {
+	// Config edits the configuration before the server is built, e.g. to
+	// lower connection limits.
+	Config func(cfg *config.Config)
+}
+
+// Env is a running server and the database behind it.
+type Env struct {
+	T      testing.TB
+	Config config.Config
+	// DB is the connection the server uses: the in-memory database, or the
+	// transaction on a shared database.
+	DB      *gorm.DB
+	Server  *webserver.Server
+	Tenants *tenant.Manager
+	// URL is the base URL of the server, e.g. http://127.0.0.1:41234.
+	URL string
+}
+
+var memoryDBs, savepoints atomic.Int64
+
+// New starts a server for the test and stops it, and discards its data,
+// when the test ends.
+func New(t testing.TB, opts Options) *Env {
+	t.Helper()
+	cfg := config.Load()
+	cfg.Mode = gin.TestMode
+	cfg.Backup.Dir = filepath.Join(t.TempDir(), "backups")
+	cfg.Session.Secret = ""
+	cfg.Session.KeyFile = filepath.Join(t.TempDir(), "session.key")
+	cfg.Tenant.SQLiteFiles = false
+	cfg.Database.ReplicaDSNs = nil
+	cfg.Database.ReplicaHosts = nil
+	// Tests send requests in bursts; those about limits turn them on.
+	cfg.RateLimit.Enabled = false
+
+	dbType, dsn := os.Getenv(EnvDBType), os.Getenv(EnvDBDSN)
+	if dsn == "" {
+		// Each environment gets its own database; one connection keeps it
+		// alive for the whole test.
+		cfg.Database.Type = config.DBTypeSQLite
+		cfg.Database.DSN = fmt.Sprintf("file:apptest-%d?mode=memory&cache=shared", memoryDBs.Add(1))
+		cfg.Database.SQLite.Readers = 0
+	} else {
+		if dbType == "" {
+			t.Fatalf("apptest: %s is set but %s is not", EnvDBDSN, EnvDBType)
+		}
+		cfg.Database.Type = config.DatabaseType(dbType)
+		cfg.Database.DSN = dsn
+	}
+	if opts.Config != nil {
+		opts.Config(&cfg)
+	}
+
+	db := open(t, cfg.Database)
+	if cfg.Database.Type != config.DBTypeSQLite {
+		migrateShared(t, cfg.Database)
+		db = begin(t, db)
+	} else if err := model.AutoMigrate(db); err != nil {
+		t.Fatalf("apptest: migrate: %v", err)
+	}
+
+	tenants, err := tenant.New(db, cfg.Database, cfg.Tenant)
+	if err != nil {
+		t.Fatalf("apptest: tenants: %v", err)
+	}
+	server := webserver.NewServer(cfg, db, tenants)
+	srv := httptest.NewServer(server.Handler())
+	t.Cleanup(srv.Close)
+
+	return &Env{T: t, Config: cfg, DB: db, Server: server, Tenants: tenants, URL: srv.URL}
+}
+
+func open(t testing.TB, cfg config.DatabaseConfig) *gorm.DB {
+	t.Helper()
+	db, err := database.InitDatabase(cfg)
+	if err != nil {
+		t.Fatalf("apptest: open %s database: %v", cfg.Type, err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// begin starts the transaction that confines the test's writes. Nested
+// transactions of the code under test become savepoints.
+func begin(t testing.TB, db *gorm.DB) *gorm.DB {
+	t.Helper()
+	tx := db.Begin()
+	if tx.Error != nil {
+		t.Fatalf("apptest: begin transaction: %v", tx.Error)
+	}
+	t.Cleanup(func() { tx.Rollback() })
+	return tx
+}
+
+var (
+	sharedMu       sync.Mutex
+	sharedMigrated = make(map[string]bool)
+)
+
+// migrateShared creates the schema of a shared database once per test
+// binary. It runs outside the tests' transactions on a connection of its
+// own, as MySQL commits the open transaction on every schema change and
+// the search engine would otherwise install its callbacks twice.
+func migrateShared(t testing.TB, cfg config.DatabaseConfig) {
+	t.Helper()
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	if sharedMigrated[cfg.DSN] {
+		return
+	}
+	db, err := database.InitDatabase(cfg)
+	if err != nil {
+		t.Fatalf("apptest: open %s database: %v", cfg.Type, err)
+	}
+	defer func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	}()
+	if err := model.AutoMigrate(db); err != nil {
+		t.Fatalf("apptest: migrate: %v", err)
+	}
+	if err := search.New(db, cfg.Type).Migrate(); err != nil {
+		t.Fatalf("apptest: migrate search: %v", err)
+	}
+	sharedMigrated[cfg.DSN] = true
+}
+
+// Context returns a context scoped to the default tenant, for using DB
+// directly.
+func (e *Env) Context() context.Context {
+	return tenant.WithID(context.Background(), e.Tenants.Default().ID)
+}
+
+// CreateTenant adds a tenant, which requests select with Client.Tenant.
+func (e *Env) CreateTenant(slug string) model.Tenant {
+	e.T.Helper()
+	t, err := e.Tenants.Create(context.Background(), slug, "")
+	if err != nil {
+		e.T.Fatalf("apptest: create tenant %s: %v", slug, err)
+	}
+	return t
+}
+
+// DrainTasks runs the queued background tasks that are due and returns how
+// many ran. The environment starts no workers, so tasks only run here.
+func (e *Env) DrainTasks() int {
+	e.T.Helper()
+	n, err := e.Server.Tasks().Drain(context.Background())
+	if err != nil {
+		e.T.Fatalf("apptest: run tasks: %v", err)
+	}
+	return n
+}
+
+// Tx runs the rest of the test inside a transaction on db that is rolled
+// back when the test ends, for tests that use a *gorm.DB without going
+// through the server. When db is a transaction already, such as Env.DB on
+// a shared database, the work is undone with a savepoint instead. Use only
+// the returned *gorm.DB meanwhile: SQLite has a single connection, which
+// the transaction holds.
+func Tx(t testing.TB, db *gorm.DB) *gorm.DB {
+	t.Helper()
+	if committer, ok := db.Statement.ConnPool.(gorm.TxCommitter); ok && committer != nil {
+		name := fmt.Sprintf("apptest_%d", savepoints.Add(1))
+		if err := db.SavePoint(name).Error; err != nil {
+			t.Fatalf("apptest: savepoint: %v", err)
+		}
+		t.Cleanup(func() { db.RollbackTo(name) })
+		return db
+	}
+	return begin(t, db)
+}
@@ -0,0 +1,62 @@
+package apptest_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/wonderfulsuccess/go-web-app/back/apptest"
+	"github.com/wonderfulsuccess/go-web-app/back/model"
+)
+
+func TestTxRollsBack(t *testing.T) {
+	env := apptest.New(t, apptest.Options{})
+	env.Load(&model.User{Name: "Ada", Email: "ada@example.com"})
+
+	t.Run("inside", func(t *testing.T) {
+		db := apptest.Tx(t, env.DB).WithContext(env.Context())
+		if err := db.Create(&model.User{Name: "Cy", Email: "cy@example.com"}).Error; err != nil {
+			t.Fatal(err)
+		}
+		var n int64
+		db.Model(&model.User{}).Count(&n)
+		if n != 2 {
+			t.Fatalf("counted %d users inside the transaction, want 2", n)
+		}
+	})
+
+	var users []model.User
+	if err := env.DB.WithContext(env.Context()).Find(&users).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Name != "Ada" {
+		t.Fatalf("after rollback found %+v, want only Ada", users)
+	}
+}
+
+func TestEnvironmentsAreIsolated(t *testing.T) {
+	first := apptest.New(t, apptest.Options{})
+	second := apptest.New(t, apptest.Options{})
+	first.Load(&[]model.User{{Name: "Ada", Email: "ada@example.com"}, {Name: "Cy", Email: "cy@example.com"}})
+
+	var users []model.User
+	first.Client().Get("/api/users").Expect(http.StatusOK).JSON(&users)
+	if len(users) != 2 {
+		t.Fatalf("first environment lists %d users, want 2", len(users))
+	}
+	second.Client().Get("/api/users").Expect(http.StatusOK).JSON(&users)
+	if len(users) != 0 {
+		t.Fatalf("second environment lists %+v, want none", users)
+	}
+}
+
+func TestSeed(t *testing.T) {
+	env := apptest.New(t, apptest.Options{})
+	if results := env.Seed("test"); len(results) == 0 {
+		t.Fatal("the test fixtures loaded nothing")
+	}
+	var users []model.User
+	env.Client().Get("/api/users").Expect(http.StatusOK).JSON(&users)
+	if len(users) == 0 {
+		t.Fatal("no users after seeding")
+	}
+}
@@ -0,0 +1,163 @@
+package apptest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// Client sends requests to the server of an Env, keeping cookies between
+// them like a browser.
+type Client struct {
+	t      testing.TB
+	env    *Env
+	http   *http.Client
+	header http.Header
+}
+
+// Client returns a client with a cookie jar of its own.
+func (e *Env) Client() *Client {
+	jar, _ := cookiejar.New(nil)
+	return &Client{t: e.T, env: e, http: &http.Client{Jar: jar}, header: make(http.Header)}
+}
+
+// With returns a copy of the client that sends an extra header. The copy
+// shares the cookies of c.
+func (c *Client) With(key, value string) *Client {
+	clone := *c
+	clone.header = c.header.Clone()
+	clone.header.Set(key, value)
+	return &clone
+}
+
+// As returns a client with a cookie jar of its own holding a session of
+// userID, so that its requests and connections act as that user.
+func (c *Client) As(userID string) *Client {
+	c.t.Helper()
+	jar, _ := cookiejar.New(nil)
+	base, err := url.Parse(c.env.URL)
+	if err != nil {
+		c.t.Fatalf("apptest: %v", err)
+	}
+	jar.SetCookies(base, []*http.Cookie{{
+		Name:  c.env.Config.Session.Cookie,
+		Value: c.env.Server.Sessions().Issue(userID),
+		Path:  "/",
+	}})
+	clone := *c
+	clone.http = &http.Client{Jar: jar}
+	return &clone
+}
+
+// Tenant returns a copy of the client acting for the tenant with the given
+// slug.
+func (c *Client) Tenant(slug string) *Client {
+	return c.With(c.env.Config.Tenant.Header, slug)
+}
+
+// Get sends a GET request to path, e.g. "/api/users".
+func (c *Client) Get(path string) *Response {
+	c.t.Helper()
+	return c.Do(http.MethodGet, path, nil)
+}
+
+// Post sends body as JSON, see Do.
+func (c *Client) Post(path string, body interface{}) *Response {
+	c.t.Helper()
+	return c.Do(http.MethodPost, path, body)
+}
+
+// Put sends body as JSON, see Do.
+func (c *Client) Put(path string, body interface{}) *Response {
+	c.t.Helper()
+	return c.Do(http.MethodPut, path, body)
+}
+
+// Delete sends a DELETE request to path.
+func (c *Client) Delete(path string) *Response {
+	c.t.Helper()
+	return c.Do(http.MethodDelete, path, nil)
+}
+
+// Do sends a request and reads the whole response. A []byte, string or
+// io.Reader body is sent as is; anything else is encoded as JSON. A
+// transport error fails the test.
+func (c *Client) Do(method, path string, body interface{}) *Response {
+	c.t.Helper()
+	var reader io.Reader
+	contentType := ""
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		reader = bytes.NewReader(b)
+	case string:
+		reader = strings.NewReader(b)
+	case io.Reader:
+		reader = b
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			c.t.Fatalf("apptest: encode %s %s body: %v", method, path, err)
+		}
+		reader = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+
+	req, err := http.NewRequest(method, c.env.URL+path, reader)
+	if err != nil {
+		c.t.Fatalf("apptest: %s %s: %v", method, path, err)
+	}
+	for key, values := range c.header {
+		req.Header[key] = values
+	}
+	if contentType != "" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		c.t.Fatalf("apptest: %s %s: %v", method, path, err)
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		c.t.Fatalf("apptest: read %s %s response: %v", method, path, err)
+	}
+	return &Response{t: c.t, req: method + " " + path, Status: res.StatusCode, Header: res.Header, Body: data}
+}
+
+// Response is a complete HTTP response.
+type Response struct {
+	t      testing.TB
+	req    string
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// Expect fails the test unless the response has the given status.
+func (r *Response) Expect(status int) *Response {
+	r.t.Helper()
+	if r.Status != status {
+		r.t.Fatalf("%s: expected status %d, got %d: %s", r.req, status, r.Status, r.Body)
+	}
+	return r
+}
+
+// JSON decodes the body into v, failing the test when it is not JSON.
+func (r *Response) JSON(v interface{}) *Response {
+	r.t.Helper()
+	if err := json.Unmarshal(r.Body, v); err != nil {
+		r.t.Fatalf("%s: decode response: %v: %s", r.req, err, r.Body)
+	}
+	return r
+}
+
+// String returns the body as text.
+func (r *Response) String() string {
+	return string(r.Body)
+}
@@ -0,0 +1,49 @@
+package apptest
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"reflect"
+
+	"github.com/wonderfulsuccess/go-web-app/back/seed"
+)
+
+// Seed loads a built-in fixture set of package seed, usually "test".
+func (e *Env) Seed(env string) []seed.Result {
+	e.T.Helper()
+	results, err := seed.New(e.DB, e.Tenants).Run(context.Background(), seed.Builtin(), env)
+	if err != nil {
+		e.T.Fatalf("apptest: seed %s: %v", env, err)
+	}
+	return results
+}
+
+// Load inserts fixtures into the default tenant. Each value is a pointer to
+// a model or to a slice of models; their ids are filled in on return.
+func (e *Env) Load(values ...interface{}) {
+	e.T.Helper()
+	db := e.DB.WithContext(e.Context())
+	for _, v := range values {
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Pointer && rv.Elem().Kind() == reflect.Slice && rv.Elem().Len() == 0 {
+			continue
+		}
+		if err := db.Create(v).Error; err != nil {
+			e.T.Fatalf("apptest: load %T: %v", v, err)
+		}
+	}
+}
+
+// LoadJSON decodes the fixture file at path, a JSON array of records, into
+// dest, a pointer to a slice of models, and inserts them like Load.
+func (e *Env) LoadJSON(path string, dest interface{}) {
+	e.T.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		e.T.Fatalf("apptest: read fixtures: %v", err)
+	}
+	if err := json.Unmarshal(data, dest); err != nil {
+		e.T.Fatalf("apptest: decode fixtures %s: %v", path, err)
+	}
+	e.Load(dest)
+}
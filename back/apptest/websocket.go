@@ -0,0 +1,245 @@
+package apptest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/wonderfulsuccess/go-web-app/back/webserver"
+)
+
+// DefaultTimeout bounds how long a WSClient waits for an expected message.
+const DefaultTimeout = 5 * time.Second
+
+// WSClient is a WebSocket connection to /api/ws that records the messages
+// it receives.
+type WSClient struct {
+	t    testing.TB
+	conn *websocket.Conn
+	// Timeout bounds Expect and Next; it defaults to DefaultTimeout.
+	Timeout time.Duration
+
+	messages  chan webserver.WSMessage
+	done      chan struct{}
+	closeOnce sync.Once
+	// err is why the read loop stopped; it is set before done is closed.
+	err error
+}
+
+// Dial connects as userID, see As, subscribed to topics, with the client's
+// headers (and so its tenant). The connection is closed when the test ends.
+func (c *Client) Dial(userID string, topics ...string) *WSClient {
+	c.t.Helper()
+	return c.As(userID).DialSession(topics...)
+}
+
+// DialSession connects as the user of the client's session, which the
+// server picks when the client has none yet.
+func (c *Client) DialSession(topics ...string) *WSClient {
+	c.t.Helper()
+	query := url.Values{}
+	if len(topics) > 0 {
+		query.Set("topics", strings.Join(topics, ","))
+	}
+	conn, res, err := c.DialRaw(query)
+	if err != nil {
+		status := 0
+		if res != nil {
+			status = res.StatusCode
+		}
+		c.t.Fatalf("apptest: dial websocket: %v (status %d)", err, status)
+	}
+
+	ws := &WSClient{
+		t:        c.t,
+		conn:     conn,
+		Timeout:  DefaultTimeout,
+		messages: make(chan webserver.WSMessage, 256),
+		done:     make(chan struct{}),
+	}
+	go ws.read()
+	c.t.Cleanup(ws.Close)
+	return ws
+}
+
+// DialRaw opens a WebSocket to /api/ws with the given query parameters,
+// the client's headers and its cookies, leaving errors to the caller.
+func (c *Client) DialRaw(query url.Values) (*websocket.Conn, *http.Response, error) {
+	target := "ws" + strings.TrimPrefix(c.env.URL, "http") + "/api/ws?" + query.Encode()
+	dialer := *websocket.DefaultDialer
+	dialer.Jar = c.http.Jar
+	return dialer.Dial(target, c.header)
+}
+
+func (ws *WSClient) read() {
+	defer close(ws.done)
+	for {
+		_, data, err := ws.conn.ReadMessage()
+		if err != nil {
+			ws.err = err
+			return
+		}
+		var msg webserver.WSMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			ws.err = err
+			return
+		}
+		ws.messages <- msg
+	}
+}
+
+// Send pushes a message to the server, which dispatches it to receiver.
+func (ws *WSClient) Send(msgType, receiver string, payload interface{}) {
+	ws.t.Helper()
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		ws.t.Fatalf("apptest: encode %s payload: %v", msgType, err)
+	}
+	msg := webserver.WSMessage{Type: msgType, Receiver: receiver, Timestamp: time.Now().UTC(), Payload: raw}
+	if err := ws.conn.WriteJSON(msg); err != nil {
+		ws.t.Fatalf("apptest: send %s: %v", msgType, err)
+	}
+}
+
+// SendRaw writes data as a text frame, e.g. to send malformed JSON.
+func (ws *WSClient) SendRaw(data []byte) {
+	ws.t.Helper()
+	if err := ws.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		ws.t.Fatalf("apptest: send raw frame: %v", err)
+	}
+}
+
+// ExpectClosed waits until the server closes the connection, skipping the
+// messages received meanwhile, and returns the read error, which carries
+// the close code.
+func (ws *WSClient) ExpectClosed() error {
+	ws.t.Helper()
+	timeout := time.NewTimer(ws.Timeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case <-ws.messages:
+		case <-ws.done:
+			return ws.err
+		case <-timeout.C:
+			ws.t.Fatalf("apptest: websocket still open after %s", ws.Timeout)
+			return nil
+		}
+	}
+}
+
+// Next returns the next message, failing the test when none arrives in
+// time or the connection closes.
+func (ws *WSClient) Next() webserver.WSMessage {
+	ws.t.Helper()
+	timeout := time.NewTimer(ws.Timeout)
+	defer timeout.Stop()
+	select {
+	case msg := <-ws.messages:
+		return msg
+	case <-ws.done:
+		// Messages read before the connection closed still count.
+		select {
+		case msg := <-ws.messages:
+			return msg
+		default:
+		}
+		ws.t.Fatalf("apptest: websocket closed: %v", ws.err)
+	case <-timeout.C:
+		ws.t.Fatalf("apptest: no websocket message within %s", ws.Timeout)
+	}
+	return webserver.WSMessage{}
+}
+
+// Expect skips messages until one of type msgType arrives and returns it.
+func (ws *WSClient) Expect(msgType string) webserver.WSMessage {
+	ws.t.Helper()
+	deadline := time.Now().Add(ws.Timeout)
+	var skipped []string
+	for {
+		msg, ok := ws.receive(time.Until(deadline))
+		if !ok {
+			ws.t.Fatalf("apptest: no %s message within %s, got %v", msgType, ws.Timeout, skipped)
+		}
+		if msg.Type == msgType {
+			return msg
+		}
+		skipped = append(skipped, msg.Type)
+	}
+}
+
+// ExpectPayload is Expect that also decodes the payload into v.
+func (ws *WSClient) ExpectPayload(msgType string, v interface{}) webserver.WSMessage {
+	ws.t.Helper()
+	msg := ws.Expect(msgType)
+	if err := json.Unmarshal(msg.Payload, v); err != nil {
+		ws.t.Fatalf("apptest: decode %s payload: %v: %s", msgType, err, msg.Payload)
+	}
+	return msg
+}
+
+// ExpectNone fails the test if a message of type msgType arrives within d.
+func (ws *WSClient) ExpectNone(msgType string, d time.Duration) {
+	ws.t.Helper()
+	deadline := time.Now().Add(d)
+	for {
+		msg, ok := ws.receive(time.Until(deadline))
+		if !ok {
+			return
+		}
+		if msg.Type == msgType {
+			ws.t.Fatalf("apptest: unexpected %s message: %s", msgType, msg.Payload)
+		}
+	}
+}
+
+// receive waits up to d for a message; false means none came or the
+// connection closed.
+func (ws *WSClient) receive(d time.Duration) (webserver.WSMessage, bool) {
+	if d <= 0 {
+		select {
+		case msg := <-ws.messages:
+			return msg, true
+		default:
+			return webserver.WSMessage{}, false
+		}
+	}
+	timeout := time.NewTimer(d)
+	defer timeout.Stop()
+	select {
+	case msg := <-ws.messages:
+		return msg, true
+	case <-ws.done:
+		select {
+		case msg := <-ws.messages:
+			return msg, true
+		default:
+			return webserver.WSMessage{}, false
+		}
+	case <-timeout.C:
+		return webserver.WSMessage{}, false
+	}
+}
+
+// Close closes the connection. It is safe to call more than once.
+func (ws *WSClient) Close() {
+	ws.closeOnce.Do(func() {
+		_ = ws.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		ws.conn.Close()
+		// Unblock the read loop if the test left the buffer full.
+		for {
+			select {
+			case <-ws.messages:
+			case <-ws.done:
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,365 @@
+// Package backup takes, verifies and restores database backups while the
+// server keeps running. SQLite databases are copied with VACUUM INTO and
+// restored through SQLite's online backup API; MySQL and PostgreSQL use
+// logical dumps made by mysqldump and pg_dump. Every backup is gzip
+// compressed into one directory, where the newest ones are retained.
+package backup
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+	"github.com/wonderfulsuccess/go-web-app/back/logger"
+)
+
+var (
+	// ErrNotFound is returned for unknown backup names.
+	ErrNotFound = errors.New("backup not found")
+	// ErrBusy is returned while another backup or restore is running.
+	ErrBusy = errors.New("another backup or restore is in progress")
+	// ErrInvalid is returned when restoring a backup that fails
+	// verification.
+	ErrInvalid = errors.New("backup failed verification")
+	// ErrTenantFiles is returned by Backup and Restore while tenants are
+	// kept in SQLite files of their own, which a backup of the main
+	// database would silently leave out.
+	ErrTenantFiles = errors.New("backups cover only the main database and are disabled while TENANT_SQLITE_FILES is set; back up the data directory with the server stopped instead")
+)
+
+// Info describes one backup file.
+type Info struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Verification is the outcome of checking a backup.
+type Verification struct {
+	Name string `json:"name"`
+	OK   bool   `json:"ok"`
+	// Problems lists what the check found, e.g. the rows of
+	// PRAGMA integrity_check.
+	Problems []string `json:"problems"`
+}
+
+// engine is the database-specific part of a Manager. dump writes an
+// uncompressed backup, verify and restore read one.
+type engine interface {
+	ext() string
+	dump(ctx context.Context, w io.Writer) error
+	verify(ctx context.Context, r io.Reader) ([]string, error)
+	restore(ctx context.Context, r io.Reader) error
+}
+
+// Manager owns the backup directory of one database.
+type Manager struct {
+	db     *gorm.DB
+	dbCfg  config.DatabaseConfig
+	dir    string
+	keep   int
+	engine engine
+	// tenantFiles is set when tenants live in files of their own.
+	tenantFiles bool
+
+	mu        sync.Mutex
+	onRestore []func()
+}
+
+// New returns a manager writing backups of db to cfg.Dir. It refuses to
+// back up or restore when tenantCfg keeps tenants in SQLite files of their
+// own.
+func New(db *gorm.DB, dbCfg config.DatabaseConfig, cfg config.BackupConfig, tenantCfg config.TenantConfig) *Manager {
+	m := &Manager{db: db, dbCfg: dbCfg, dir: cfg.Dir, keep: cfg.Keep, tenantFiles: tenantCfg.SQLiteFiles}
+	switch dbCfg.Type {
+	case config.DBTypeSQLite:
+		m.engine = &sqliteEngine{db: db, dsn: dbCfg.DSN}
+	case config.DBTypeMySQL:
+		m.engine = mysqlEngine{cfg: dbCfg}
+	case config.DBTypePostgres:
+		m.engine = postgresEngine{cfg: dbCfg}
+	}
+	return m
+}
+
+// OnRestore registers fn to run after a successful restore, e.g. to re-run
+// migrations against the restored schema.
+func (m *Manager) OnRestore(fn func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onRestore = append(m.onRestore, fn)
+}
+
+// namePattern matches the files written by Backup.
+var namePattern = regexp.MustCompile(`^backup-\d{8}T\d{6}Z(-[a-z0-9-]+)?\.(db|sql)\.gz$`)
+
+// Backup writes a new compressed backup and prunes old ones. label, when
+// set, is appended to the file name, e.g. "pre-restore".
+func (m *Manager) Backup(ctx context.Context, label string) (Info, error) {
+	if err := m.supported(); err != nil {
+		return Info{}, err
+	}
+	if !m.mu.TryLock() {
+		return Info{}, ErrBusy
+	}
+	defer m.mu.Unlock()
+	info, err := m.backup(ctx, label)
+	if err != nil {
+		return Info{}, err
+	}
+	if err := m.prune(); err != nil {
+		logger.Warningf("failed to prune old backups: %v", err)
+	}
+	return info, nil
+}
+
+func (m *Manager) backup(ctx context.Context, label string) (Info, error) {
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return Info{}, err
+	}
+	name := m.newName(label)
+	tmp, err := os.CreateTemp(m.dir, ".partial-*")
+	if err != nil {
+		return Info{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	gz := gzip.NewWriter(tmp)
+	if err := m.engine.dump(ctx, gz); err != nil {
+		return Info{}, err
+	}
+	if err := gz.Close(); err != nil {
+		return Info{}, err
+	}
+	if err := tmp.Sync(); err != nil {
+		return Info{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return Info{}, err
+	}
+	path := filepath.Join(m.dir, name)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return Info{}, err
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return Info{}, err
+	}
+	logger.Infof("wrote database backup %s (%d bytes)", name, stat.Size())
+	return Info{Name: name, Size: stat.Size(), CreatedAt: stat.ModTime().UTC()}, nil
+}
+
+// supported returns why backups of this database cannot be taken or
+// restored, or nil.
+func (m *Manager) supported() error {
+	if m.tenantFiles {
+		return ErrTenantFiles
+	}
+	if m.engine == nil {
+		return fmt.Errorf("backups are not supported for database type %q", m.dbCfg.Type)
+	}
+	return nil
+}
+
+func (m *Manager) newName(label string) string {
+	base := "backup-" + time.Now().UTC().Format("20060102T150405Z")
+	if label != "" {
+		base += "-" + label
+	}
+	name := base + "." + m.engine.ext() + ".gz"
+	for i := 2; fileExists(filepath.Join(m.dir, name)); i++ {
+		name = base + "-" + strconv.Itoa(i) + "." + m.engine.ext() + ".gz"
+	}
+	return name
+}
+
+// List returns the backups in the directory, newest first.
+func (m *Manager) List() ([]Info, error) {
+	entries, err := os.ReadDir(m.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Info{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Info, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !namePattern.MatchString(entry.Name()) {
+			continue
+		}
+		stat, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		out = append(out, Info{Name: entry.Name(), Size: stat.Size(), CreatedAt: stat.ModTime().UTC()})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].CreatedAt.After(out[j].CreatedAt)
+		}
+		return out[i].Name > out[j].Name
+	})
+	return out, nil
+}
+
+// Path returns the file of the named backup.
+func (m *Manager) Path(name string) (string, error) {
+	if !namePattern.MatchString(name) {
+		return "", ErrNotFound
+	}
+	path := filepath.Join(m.dir, name)
+	if !fileExists(path) {
+		return "", ErrNotFound
+	}
+	return path, nil
+}
+
+// Delete removes the named backup.
+func (m *Manager) Delete(name string) error {
+	path, err := m.Path(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Verify decompresses the named backup and checks it: SQLite backups with
+// PRAGMA integrity_check, dumps for a complete trailer.
+func (m *Manager) Verify(ctx context.Context, name string) (Verification, error) {
+	path, err := m.Path(name)
+	if err != nil {
+		return Verification{}, err
+	}
+	problems, err := m.verifyFile(ctx, path)
+	if err != nil {
+		return Verification{}, err
+	}
+	return Verification{Name: name, OK: len(problems) == 0, Problems: problems}, nil
+}
+
+func (m *Manager) verifyFile(ctx context.Context, path string) ([]string, error) {
+	if m.engine == nil {
+		return nil, fmt.Errorf("backups are not supported for database type %q", m.dbCfg.Type)
+	}
+	if !strings.HasSuffix(path, "."+m.engine.ext()+".gz") {
+		return nil, fmt.Errorf("%w: %s is not a %s backup", ErrInvalid, filepath.Base(path), m.dbCfg.Type)
+	}
+	// Decompress once up front so a truncated or damaged archive is
+	// reported as such rather than as a broken database.
+	if err := readArchive(path, func(r io.Reader) error {
+		_, err := io.Copy(io.Discard, r)
+		return err
+	}); err != nil {
+		return []string{"corrupt archive: " + err.Error()}, nil
+	}
+	var problems []string
+	err := readArchive(path, func(r io.Reader) (err error) {
+		problems, err = m.engine.verify(ctx, r)
+		return err
+	})
+	if problems == nil {
+		problems = []string{}
+	}
+	return problems, err
+}
+
+// readArchive calls fn with the decompressed content of the backup at path.
+func readArchive(path string, fn func(io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	return fn(gz)
+}
+
+// Restore replaces the database with the named backup.
+func (m *Manager) Restore(ctx context.Context, name string) error {
+	path, err := m.Path(name)
+	if err != nil {
+		return err
+	}
+	return m.RestoreFile(ctx, path)
+}
+
+// RestoreFile verifies the backup at path, saves the current database as a
+// "pre-restore" backup and then replaces the database with the backup.
+func (m *Manager) RestoreFile(ctx context.Context, path string) error {
+	if err := m.supported(); err != nil {
+		return err
+	}
+	if !m.mu.TryLock() {
+		return ErrBusy
+	}
+	defer m.mu.Unlock()
+
+	problems, err := m.verifyFile(ctx, path)
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s: %s", ErrInvalid, filepath.Base(path), problems[0])
+	}
+	if _, err := m.backup(ctx, "pre-restore"); err != nil {
+		return fmt.Errorf("save current database before restoring: %w", err)
+	}
+
+	if err := readArchive(path, func(r io.Reader) error {
+		return m.engine.restore(ctx, r)
+	}); err != nil {
+		return err
+	}
+	logger.Infof("restored database from %s", filepath.Base(path))
+
+	for _, fn := range m.onRestore {
+		fn()
+	}
+	return nil
+}
+
+// Prune deletes all but the newest Keep backups.
+func (m *Manager) Prune() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.prune()
+}
+
+func (m *Manager) prune() error {
+	if m.keep <= 0 {
+		return nil
+	}
+	list, err := m.List()
+	if err != nil {
+		return err
+	}
+	for i := m.keep; i < len(list); i++ {
+		if err := os.Remove(filepath.Join(m.dir, list[i].Name)); err != nil {
+			return err
+		}
+		logger.Infof("deleted old database backup %s", list[i].Name)
+	}
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,297 @@
+package backup
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+)
+
+type item struct {
+	ID   uint
+	Name string
+}
+
+// newManager returns a manager over a SQLite file holding one item, with
+// backups kept in a directory of their own.
+func newManager(t *testing.T, keep int) (*Manager, *gorm.DB) {
+	t.Helper()
+	dir := t.TempDir()
+	dsn := filepath.Join(dir, "app.db")
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&item{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&item{Name: "before"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	dbCfg := config.DatabaseConfig{Type: config.DBTypeSQLite, DSN: dsn}
+	return New(db, dbCfg, config.BackupConfig{Dir: filepath.Join(dir, "backups"), Keep: keep}, config.TenantConfig{}), db
+}
+
+func names(db *gorm.DB) []string {
+	var out []string
+	db.Model(&item{}).Order("id").Pluck("name", &out)
+	return out
+}
+
+// writeArchive gzips content into the backup directory under name.
+func writeArchive(t *testing.T, m *Manager, name string, content []byte) {
+	t.Helper()
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(filepath.Join(m.dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	if _, err := gz.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	m, db := newManager(t, 0)
+	ctx := context.Background()
+	restored := 0
+	m.OnRestore(func() { restored++ })
+
+	info, err := m.Backup(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !namePattern.MatchString(info.Name) || !strings.HasSuffix(info.Name, ".db.gz") || info.Size == 0 {
+		t.Fatalf("wrote %+v", info)
+	}
+	list, err := m.List()
+	if err != nil || len(list) != 1 || list[0].Name != info.Name {
+		t.Fatalf("listed %+v, %v; want %s", list, err, info.Name)
+	}
+	v, err := m.Verify(ctx, info.Name)
+	if err != nil || !v.OK || len(v.Problems) != 0 {
+		t.Fatalf("verified %+v, %v", v, err)
+	}
+
+	if err := db.Model(&item{}).Where("1 = 1").Update("name", "after").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&item{Name: "added"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Restore(ctx, info.Name); err != nil {
+		t.Fatal(err)
+	}
+	// The open connections see the restored data.
+	if got := names(db); !reflect.DeepEqual(got, []string{"before"}) {
+		t.Fatalf("items after restore %q, want the backed up one", got)
+	}
+	if restored != 1 {
+		t.Fatalf("restore hooks ran %d times, want once", restored)
+	}
+
+	list, _ = m.List()
+	var pre string
+	for _, b := range list {
+		if strings.Contains(b.Name, "-pre-restore") {
+			pre = b.Name
+		}
+	}
+	if pre == "" {
+		t.Fatalf("no pre-restore backup among %+v", list)
+	}
+	if err := m.Restore(ctx, pre); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(db); !reflect.DeepEqual(got, []string{"after", "added"}) {
+		t.Fatalf("items after undoing the restore %q", got)
+	}
+}
+
+func TestVerifyReportsDamage(t *testing.T) {
+	m, _ := newManager(t, 0)
+	ctx := context.Background()
+	info, err := m.Backup(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	good, err := os.ReadFile(filepath.Join(m.dir, info.Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		write   func(name string)
+		problem string
+	}{
+		{
+			name: "truncated archive",
+			write: func(name string) {
+				if err := os.WriteFile(filepath.Join(m.dir, name), good[:len(good)/2], 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			problem: "corrupt archive",
+		},
+		{
+			name: "not gzip",
+			write: func(name string) {
+				if err := os.WriteFile(filepath.Join(m.dir, name), []byte("plain text"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			problem: "corrupt archive",
+		},
+		{
+			name:    "not a database",
+			write:   func(name string) { writeArchive(t, m, name, []byte(strings.Repeat("not sqlite ", 1000))) },
+			problem: "not a database",
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := "backup-20260101T00000" + string(rune('0'+i)) + "Z.db.gz"
+			tt.write(name)
+			v, err := m.Verify(ctx, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v.OK || len(v.Problems) == 0 || !strings.Contains(v.Problems[0], tt.problem) {
+				t.Fatalf("verified %+v, want a problem mentioning %q", v, tt.problem)
+			}
+			if err := m.Restore(ctx, name); !errors.Is(err, ErrInvalid) {
+				t.Fatalf("restoring: %v, want ErrInvalid", err)
+			}
+		})
+	}
+
+	// A dump of another database type is refused without being read.
+	writeArchive(t, m, "backup-20260101T000000Z.sql.gz", []byte("-- Dump completed\n"))
+	if _, err := m.Verify(ctx, "backup-20260101T000000Z.sql.gz"); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("verifying a dump: %v, want ErrInvalid", err)
+	}
+}
+
+func TestNames(t *testing.T) {
+	m, _ := newManager(t, 0)
+	writeArchive(t, m, "backup-20260101T000000Z.db.gz", nil)
+	if err := os.WriteFile(filepath.Join(m.dir, "notes.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{name: "backup-20260101T000000Z.db.gz"},
+		{name: "backup-20260102T000000Z.db.gz", err: ErrNotFound},
+		{name: "notes.txt", err: ErrNotFound},
+		{name: "../app.db", err: ErrNotFound},
+		{name: "backup-20260101T000000Z-../../x.db.gz", err: ErrNotFound},
+		{name: "", err: ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := m.Path(tt.name); !errors.Is(err, tt.err) {
+				t.Fatalf("Path: %v, want %v", err, tt.err)
+			}
+		})
+	}
+	if list, err := m.List(); err != nil || len(list) != 1 {
+		t.Fatalf("listed %+v, %v; want only the backup", list, err)
+	}
+	if err := m.Delete("backup-20260101T000000Z.db.gz"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Delete("backup-20260101T000000Z.db.gz"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("deleting twice: %v, want ErrNotFound", err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	m, _ := newManager(t, 2)
+	ctx := context.Background()
+	var made []string
+	for i := 0; i < 3; i++ {
+		info, err := m.Backup(ctx, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Backups within one second would otherwise share a timestamp.
+		at := time.Now().Add(time.Duration(i-10) * time.Minute)
+		if err := os.Chtimes(filepath.Join(m.dir, info.Name), at, at); err != nil {
+			t.Fatal(err)
+		}
+		made = append(made, info.Name)
+	}
+	list, err := m.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, b := range list {
+		got = append(got, b.Name)
+	}
+	// The third backup pruned the first.
+	if want := []string{made[2], made[1]}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("kept %q, want %q", got, want)
+	}
+}
+
+func TestCheckTrailer(t *testing.T) {
+	tests := []struct {
+		name string
+		dump string
+		ok   bool
+	}{
+		{name: "complete", dump: "CREATE TABLE t (id int);\n-- Dump completed on 2026-01-01\n\n", ok: true},
+		{name: "cut off", dump: "CREATE TABLE t (id int);\nINSERT INTO t VALUES", ok: false},
+		{name: "trailer not last", dump: "-- Dump completed\nINSERT INTO t VALUES (1);\n", ok: false},
+		{name: "empty", dump: "", ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems, err := checkTrailer(strings.NewReader(tt.dump), mysqlTrailer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (len(problems) == 0) != tt.ok {
+				t.Fatalf("problems %q, want ok %v", problems, tt.ok)
+			}
+		})
+	}
+}
+
+func TestTenantFilesAreRefused(t *testing.T) {
+	dir := t.TempDir()
+	m := New(nil, config.DatabaseConfig{Type: config.DBTypeSQLite}, config.BackupConfig{Dir: dir}, config.TenantConfig{SQLiteFiles: true})
+	if _, err := m.Backup(context.Background(), ""); !errors.Is(err, ErrTenantFiles) {
+		t.Fatalf("Backup: %v, want ErrTenantFiles", err)
+	}
+	if err := m.RestoreFile(context.Background(), filepath.Join(dir, "backup-20260101T000000Z.db.gz")); !errors.Is(err, ErrTenantFiles) {
+		t.Fatalf("RestoreFile: %v, want ErrTenantFiles", err)
+	}
+}
@@ -0,0 +1,188 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+	"github.com/wonderfulsuccess/go-web-app/back/database"
+)
+
+// Trailers written at the end of complete dumps.
+const (
+	mysqlTrailer    = "-- Dump completed"
+	postgresTrailer = "-- PostgreSQL database dump complete"
+)
+
+// mysqlEngine shells out to mysqldump and mysql, which must be on PATH.
+type mysqlEngine struct {
+	cfg config.DatabaseConfig
+}
+
+func (mysqlEngine) ext() string { return "sql" }
+
+func (e mysqlEngine) command(ctx context.Context, name string, args ...string) (*exec.Cmd, error) {
+	dsn, err := database.DSN(e.cfg)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("parse mysql DSN: %w", err)
+	}
+	var conn []string
+	if cfg.User != "" {
+		conn = append(conn, "--user="+cfg.User)
+	}
+	if cfg.Net == "unix" {
+		conn = append(conn, "--socket="+cfg.Addr)
+	} else if cfg.Addr != "" {
+		host, port, _ := strings.Cut(cfg.Addr, ":")
+		conn = append(conn, "--host="+host, "--protocol=tcp")
+		if port != "" {
+			conn = append(conn, "--port="+port)
+		}
+	}
+	cmd := exec.CommandContext(ctx, name, append(append(conn, args...), cfg.DBName)...)
+	// The password goes through the environment so it never shows up in
+	// the process list.
+	cmd.Env = append(os.Environ(), "MYSQL_PWD="+cfg.Passwd)
+	return cmd, nil
+}
+
+// dump runs a consistent, non-locking dump of InnoDB tables.
+func (e mysqlEngine) dump(ctx context.Context, w io.Writer) error {
+	cmd, err := e.command(ctx, "mysqldump", "--single-transaction", "--routines", "--triggers", "--no-tablespaces")
+	if err != nil {
+		return err
+	}
+	return run(cmd, nil, w)
+}
+
+func (mysqlEngine) verify(_ context.Context, r io.Reader) ([]string, error) {
+	return checkTrailer(r, mysqlTrailer)
+}
+
+// restore replays the dump, whose DROP TABLE IF EXISTS statements replace
+// the existing tables.
+func (e mysqlEngine) restore(ctx context.Context, r io.Reader) error {
+	cmd, err := e.command(ctx, "mysql")
+	if err != nil {
+		return err
+	}
+	return run(cmd, r, io.Discard)
+}
+
+// postgresEngine shells out to pg_dump and psql, which must be on PATH.
+type postgresEngine struct {
+	cfg config.DatabaseConfig
+}
+
+func (postgresEngine) ext() string { return "sql" }
+
+// command passes the connection through libpq environment variables, since
+// gorm DSNs may carry options such as TimeZone that libpq rejects.
+func (e postgresEngine) command(ctx context.Context, name string, args ...string) (*exec.Cmd, error) {
+	dsn, err := database.DSN(e.cfg)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := pgconn.ParseConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("parse postgres DSN: %w", err)
+	}
+	sslMode := "disable"
+	if tls := cfg.TLSConfig; tls != nil {
+		switch {
+		case len(cfg.Fallbacks) > 0:
+			sslMode = "prefer"
+		case !tls.InsecureSkipVerify:
+			sslMode = "verify-full"
+		case tls.VerifyPeerCertificate != nil:
+			sslMode = "verify-ca"
+		default:
+			sslMode = "require"
+		}
+	}
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = append(os.Environ(),
+		"PGHOST="+cfg.Host,
+		"PGPORT="+strconv.Itoa(int(cfg.Port)),
+		"PGUSER="+cfg.User,
+		"PGPASSWORD="+cfg.Password,
+		"PGDATABASE="+cfg.Database,
+		"PGSSLMODE="+sslMode,
+	)
+	return cmd, nil
+}
+
+// dump writes a plain SQL dump that drops and recreates every object, so it
+// can be replayed over an existing database.
+func (e postgresEngine) dump(ctx context.Context, w io.Writer) error {
+	cmd, err := e.command(ctx, "pg_dump", "--format=plain", "--clean", "--if-exists", "--no-owner")
+	if err != nil {
+		return err
+	}
+	return run(cmd, nil, w)
+}
+
+func (postgresEngine) verify(_ context.Context, r io.Reader) ([]string, error) {
+	return checkTrailer(r, postgresTrailer)
+}
+
+func (e postgresEngine) restore(ctx context.Context, r io.Reader) error {
+	cmd, err := e.command(ctx, "psql", "--quiet", "--no-psqlrc", "--single-transaction", "--set=ON_ERROR_STOP=1")
+	if err != nil {
+		return err
+	}
+	return run(cmd, r, io.Discard)
+}
+
+// run executes cmd and includes the tail of its stderr in the error.
+func run(cmd *exec.Cmd, stdin io.Reader, stdout io.Writer) error {
+	var stderr bytes.Buffer
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) > 500 {
+			msg = "…" + msg[len(msg)-500:]
+		}
+		if msg != "" {
+			return fmt.Errorf("%s: %w: %s", cmd.Path, err, msg)
+		}
+		return fmt.Errorf("%s: %w", cmd.Path, err)
+	}
+	return nil
+}
+
+// checkTrailer reports a problem unless the last non-empty line of the dump
+// starts with trailer, which the dump tools only write on success.
+func checkTrailer(r io.Reader, trailer string) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var last string
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			last = line
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return []string{"unreadable dump: " + err.Error()}, nil
+	}
+	if !strings.HasPrefix(last, trailer) {
+		return []string{"dump is incomplete: missing \"" + trailer + "\" trailer"}, nil
+	}
+	return nil, nil
+}
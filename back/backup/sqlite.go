@@ -0,0 +1,219 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"gorm.io/gorm"
+
+	"github.com/wonderfulsuccess/go-web-app/back/database"
+)
+
+// maxProblems caps the rows read from PRAGMA integrity_check.
+const maxProblems = 20
+
+type sqliteEngine struct {
+	db  *gorm.DB
+	dsn string
+}
+
+func (*sqliteEngine) ext() string { return "db" }
+
+// dump copies the live database with VACUUM INTO, which reads a consistent
+// snapshot without blocking writers for long, checks the copy and streams it
+// to w.
+func (e *sqliteEngine) dump(ctx context.Context, w io.Writer) error {
+	dir, err := e.tempDir()
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	copyPath := filepath.Join(dir, "snapshot.db")
+
+	if err := e.db.WithContext(ctx).Exec("VACUUM INTO ?", copyPath).Error; err != nil {
+		return fmt.Errorf("vacuum into snapshot: %w", err)
+	}
+	problems, err := checkFile(ctx, copyPath)
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("database failed integrity check: %s", strings.Join(problems, "; "))
+	}
+
+	f, err := os.Open(copyPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func (e *sqliteEngine) verify(ctx context.Context, r io.Reader) ([]string, error) {
+	dir, path, err := e.extract(r)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	return checkFile(ctx, path)
+}
+
+// restore copies the backup over the live database page by page with the
+// online backup API, so open connections see the restored data without a
+// restart.
+func (e *sqliteEngine) restore(ctx context.Context, r io.Reader) error {
+	dir, path, err := e.extract(r)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	src, err := sql.Open("sqlite3", "file:"+filepath.ToSlash(path)+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	live, err := e.db.DB()
+	if err != nil {
+		return err
+	}
+
+	err = withConn(ctx, live, func(dest *sqlite3.SQLiteConn) error {
+		return withConn(ctx, src, func(source *sqlite3.SQLiteConn) error {
+			return copyDatabase(ctx, dest, source)
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("restore database: %w", err)
+	}
+
+	problems, err := integrityCheck(ctx, live)
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("restored database failed integrity check: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// copyDatabase runs the backup API from source into dest, retrying while
+// other connections hold locks.
+func copyDatabase(ctx context.Context, dest, source *sqlite3.SQLiteConn) error {
+	b, err := dest.Backup("main", source, "main")
+	if err != nil {
+		return err
+	}
+	for {
+		done, err := b.Step(-1)
+		if err != nil && !isBusy(err) {
+			b.Finish()
+			return err
+		}
+		if done {
+			return b.Finish()
+		}
+		select {
+		case <-ctx.Done():
+			b.Finish()
+			return ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+func isBusy(err error) bool {
+	if se, ok := err.(sqlite3.Error); ok {
+		return se.Code == sqlite3.ErrBusy || se.Code == sqlite3.ErrLocked
+	}
+	return false
+}
+
+func withConn(ctx context.Context, db *sql.DB, fn func(*sqlite3.SQLiteConn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(driverConn interface{}) error {
+		sc, ok := driverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return fmt.Errorf("unexpected sqlite driver connection %T", driverConn)
+		}
+		return fn(sc)
+	})
+}
+
+// extract writes r to a file in a temporary directory.
+func (e *sqliteEngine) extract(r io.Reader) (dir, path string, err error) {
+	dir, err = e.tempDir()
+	if err != nil {
+		return "", "", err
+	}
+	path = filepath.Join(dir, "backup.db")
+	f, err := os.Create(path)
+	if err == nil {
+		_, err = io.Copy(f, r)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", "", err
+	}
+	return dir, path, nil
+}
+
+// tempDir is created next to the database, which is known to be writable
+// and usually has room for a copy of it.
+func (e *sqliteEngine) tempDir() (string, error) {
+	path, err := database.SQLitePath(e.dsn)
+	if err != nil {
+		return "", err
+	}
+	return os.MkdirTemp(filepath.Dir(path), ".backup-*")
+}
+
+func checkFile(ctx context.Context, path string) ([]string, error) {
+	db, err := sql.Open("sqlite3", "file:"+filepath.ToSlash(path)+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	problems, err := integrityCheck(ctx, db)
+	if err != nil {
+		// Not a database at all, e.g. a truncated or foreign file.
+		return []string{err.Error()}, nil
+	}
+	return problems, nil
+}
+
+// integrityCheck runs PRAGMA integrity_check and returns its findings,
+// empty when the database is intact.
+func integrityCheck(ctx context.Context, db *sql.DB) ([]string, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("PRAGMA integrity_check(%d)", maxProblems))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var problems []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return nil, err
+		}
+		if line != "ok" {
+			problems = append(problems, line)
+		}
+	}
+	return problems, rows.Err()
+}
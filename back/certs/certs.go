@@ -0,0 +1,139 @@
+// Package certs provides the TLS configuration of the server: a provided
+// certificate, or a self-signed one for serving on a local network, and a
+// small certificate authority that issues client certificates to devices
+// for mutual TLS.
+//
+// Generated files are kept in config.TLSConfig.Dir:
+//
+//	server.crt, server.key   self-signed server certificate
+//	ca.crt, ca.key           authority of the client certificates
+package certs
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+)
+
+// Names of the generated files in config.TLSConfig.Dir.
+const (
+	ServerCertFile = "server.crt"
+	ServerKeyFile  = "server.key"
+	CACertFile     = "ca.crt"
+	CAKeyFile      = "ca.key"
+)
+
+// Client authentication modes of config.TLSConfig.ClientAuth.
+const (
+	ClientAuthRequire  = "require"
+	ClientAuthOptional = "optional"
+)
+
+// ServerConfig returns the TLS configuration of the server described by
+// cfg, generating the self-signed certificate if needed.
+func ServerConfig(cfg config.TLSConfig) (*tls.Config, error) {
+	var (
+		cert tls.Certificate
+		err  error
+	)
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err = tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	} else {
+		cert, err = SelfSigned(cfg.Dir, cfg.Hosts)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tc := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+	if cfg.ClientCAFile == "" {
+		return tc, nil
+	}
+	switch cfg.ClientAuth {
+	case ClientAuthRequire:
+		tc.ClientAuth = tls.RequireAndVerifyClientCert
+	case ClientAuthOptional:
+		tc.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return nil, fmt.Errorf("unknown client auth %q, expected %s or %s", cfg.ClientAuth, ClientAuthRequire, ClientAuthOptional)
+	}
+	tc.ClientCAs, err = loadPool(cfg.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	return tc, nil
+}
+
+// ClientName returns the common name of the verified client certificate of
+// r, which names the device that made the request.
+func ClientName(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName, true
+}
+
+// Fingerprint returns the SHA-256 fingerprint of cert, as shown by
+// browsers, so users can check a self-signed certificate before trusting
+// it.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func loadPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s contains no PEM certificates", path)
+	}
+	return pool, nil
+}
+
+// writePair writes a certificate and its private key, which only the owner
+// may read.
+func writePair(dir, certName, keyName string, certDER []byte, key interface{}) (certPEM, keyPEM []byte, err error) {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, keyName), keyPEM, 0o600); err != nil {
+		return nil, nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, certName), certPEM, 0o644); err != nil {
+		return nil, nil, err
+	}
+	return certPEM, keyPEM, nil
+}
+
+// leaf returns the parsed certificate of pair.
+func leaf(pair tls.Certificate) (*x509.Certificate, error) {
+	if pair.Leaf != nil {
+		return pair.Leaf, nil
+	}
+	if len(pair.Certificate) == 0 {
+		return nil, errors.New("no certificate in key pair")
+	}
+	return x509.ParseCertificate(pair.Certificate[0])
+}
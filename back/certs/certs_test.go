@@ -0,0 +1,326 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+)
+
+func TestHosts(t *testing.T) {
+	hosts := Hosts([]string{" App.Local ", "localhost", "", "10.0.0.5", "app.local"})
+	for _, want := range []string{"localhost", "127.0.0.1", "::1", "app.local", "10.0.0.5"} {
+		if !slices.Contains(hosts, want) {
+			t.Errorf("hosts %q lack %s", hosts, want)
+		}
+	}
+	seen := map[string]bool{}
+	for _, h := range hosts {
+		if h == "" || seen[h] {
+			t.Fatalf("hosts %q contain an empty or repeated entry", hosts)
+		}
+		seen[h] = true
+	}
+	if hosts[0] != "localhost" {
+		t.Fatalf("hosts start with %q, want localhost as the common name", hosts[0])
+	}
+}
+
+// writeServerCert stores a self-signed certificate for hosts that expires
+// after validity, as if an earlier run had generated it.
+func writeServerCert(t *testing.T, dir string, hosts []string, validity time.Duration) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serialNumber(),
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(validity),
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := writePair(dir, ServerCertFile, ServerKeyFile, der, key); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSelfSigned(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(dir string)
+		extra []string
+		kept  bool
+	}{
+		{name: "generated when missing", setup: func(string) {}},
+		{
+			name:  "kept while it covers every host",
+			setup: func(dir string) { writeServerCert(t, dir, Hosts([]string{"app.local"}), selfSignedValidity) },
+			extra: []string{"app.local"},
+			kept:  true,
+		},
+		{
+			name:  "renewed for a new host",
+			setup: func(dir string) { writeServerCert(t, dir, Hosts(nil), selfSignedValidity) },
+			extra: []string{"app.local"},
+		},
+		{
+			name:  "renewed before it expires",
+			setup: func(dir string) { writeServerCert(t, dir, Hosts(nil), renewBefore-time.Hour) },
+		},
+		{
+			name: "replaced when unreadable",
+			setup: func(dir string) {
+				_ = os.WriteFile(filepath.Join(dir, ServerCertFile), []byte("garbage"), 0o644)
+				_ = os.WriteFile(filepath.Join(dir, ServerKeyFile), []byte("garbage"), 0o600)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tt.setup(dir)
+			before, _ := os.ReadFile(filepath.Join(dir, ServerCertFile))
+
+			pair, err := SelfSigned(dir, tt.extra)
+			if err != nil {
+				t.Fatal(err)
+			}
+			after, err := os.ReadFile(filepath.Join(dir, ServerCertFile))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kept := bytes.Equal(before, after); kept != tt.kept {
+				t.Fatalf("certificate kept %v, want %v", kept, tt.kept)
+			}
+			cert, err := leaf(pair)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !covers(cert, Hosts(tt.extra)) {
+				t.Fatalf("certificate for %q, %v does not cover %q", cert.DNSNames, cert.IPAddresses, Hosts(tt.extra))
+			}
+			stat, err := os.Stat(filepath.Join(dir, ServerKeyFile))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mode := stat.Mode().Perm(); mode != 0o600 {
+				t.Fatalf("key file mode %o, want 600", mode)
+			}
+		})
+	}
+}
+
+func TestCA(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := OpenCA(dir, false); !errors.Is(err, ErrNoCA) {
+		t.Fatalf("opening a missing authority: %v, want ErrNoCA", err)
+	}
+	ca, err := OpenCA(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := OpenCA(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reopened.Certificate().Equal(ca.Certificate()) || !ca.Certificate().IsCA {
+		t.Fatal("reopening the authority returned another certificate")
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Certificate())
+
+	tests := []struct {
+		name     string
+		device   string
+		validity time.Duration
+		wantCN   string
+		capped   bool
+		err      bool
+	}{
+		{name: "device", device: "kitchen-tablet", validity: 365 * 24 * time.Hour, wantCN: "kitchen-tablet"},
+		{name: "trimmed", device: "  phone ", validity: time.Hour, wantCN: "phone"},
+		{name: "capped at the authority", device: "forever", validity: 2 * caValidity, wantCN: "forever", capped: true},
+		{name: "no name", device: "  ", validity: time.Hour, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certPEM, keyPEM, err := ca.Issue(tt.device, tt.validity)
+			if tt.err {
+				if err == nil {
+					t.Fatal("issued a certificate without a device name")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			pair, err := tls.X509KeyPair(certPEM, keyPEM)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cert, err := leaf(pair)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cert.Subject.CommonName != tt.wantCN {
+				t.Fatalf("issued for %q, want %q", cert.Subject.CommonName, tt.wantCN)
+			}
+			if capped := cert.NotAfter.Equal(ca.Certificate().NotAfter); capped != tt.capped {
+				t.Fatalf("expires %s, authority %s; want capped %v", cert.NotAfter, ca.Certificate().NotAfter, tt.capped)
+			}
+			_, err = cert.Verify(x509.VerifyOptions{Roots: pool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+			if err != nil {
+				t.Fatalf("issued certificate does not verify as a client certificate: %v", err)
+			}
+		})
+	}
+}
+
+func TestServerConfig(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := OpenCA(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeServerCert(t, dir, []string{"provided.example"}, time.Hour)
+	emptyCA := filepath.Join(dir, "empty.crt")
+	if err := os.WriteFile(emptyCA, []byte("no certificates here"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	provided := config.TLSConfig{CertFile: filepath.Join(dir, ServerCertFile), KeyFile: filepath.Join(dir, ServerKeyFile)}
+	with := func(fn func(*config.TLSConfig)) config.TLSConfig {
+		cfg := provided
+		fn(&cfg)
+		return cfg
+	}
+
+	tests := []struct {
+		name       string
+		cfg        config.TLSConfig
+		clientAuth tls.ClientAuthType
+		err        bool
+	}{
+		{name: "provided certificate", cfg: provided, clientAuth: tls.NoClientCert},
+		{name: "self-signed", cfg: config.TLSConfig{Dir: t.TempDir()}, clientAuth: tls.NoClientCert},
+		{name: "client certificates required", cfg: with(func(c *config.TLSConfig) { c.ClientCAFile, c.ClientAuth = ca.CertFile, ClientAuthRequire }), clientAuth: tls.RequireAndVerifyClientCert},
+		{name: "client certificates optional", cfg: with(func(c *config.TLSConfig) { c.ClientCAFile, c.ClientAuth = ca.CertFile, ClientAuthOptional }), clientAuth: tls.VerifyClientCertIfGiven},
+		{name: "client auth ignored without a ca", cfg: with(func(c *config.TLSConfig) { c.ClientAuth = "bogus" }), clientAuth: tls.NoClientCert},
+		{name: "unknown client auth", cfg: with(func(c *config.TLSConfig) { c.ClientCAFile, c.ClientAuth = ca.CertFile, "bogus" }), err: true},
+		{name: "ca file without certificates", cfg: with(func(c *config.TLSConfig) { c.ClientCAFile, c.ClientAuth = emptyCA, ClientAuthRequire }), err: true},
+		{name: "missing ca file", cfg: with(func(c *config.TLSConfig) {
+			c.ClientCAFile, c.ClientAuth = filepath.Join(dir, "missing.crt"), ClientAuthRequire
+		}), err: true},
+		{name: "key without certificate", cfg: config.TLSConfig{KeyFile: provided.KeyFile}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc, err := ServerConfig(tt.cfg)
+			if tt.err {
+				if err == nil {
+					t.Fatal("accepted an invalid configuration")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.MinVersion != tls.VersionTLS12 || tc.ClientAuth != tt.clientAuth || len(tc.Certificates) != 1 {
+				t.Fatalf("got min version %x, client auth %v, %d certificates", tc.MinVersion, tc.ClientAuth, len(tc.Certificates))
+			}
+		})
+	}
+}
+
+func TestClientName(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := OpenCA(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, keyPEM, err := ca.Issue("kitchen-tablet", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc, err := ServerConfig(config.TLSConfig{Dir: dir, ClientCAFile: ca.CertFile, ClientAuth: ClientAuthOptional})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, ok := ClientName(r)
+		if !ok {
+			name = "anonymous"
+		}
+		_, _ = io.WriteString(w, name)
+	}))
+	srv.TLS = tc
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(tc.Certificates[0].Leaf)
+	tests := []struct {
+		name  string
+		certs []tls.Certificate
+		want  string
+	}{
+		{name: "device certificate", certs: []tls.Certificate{device}, want: "kitchen-tablet"},
+		{name: "no certificate", want: "anonymous"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+				RootCAs:      roots,
+				ServerName:   "localhost",
+				Certificates: tt.certs,
+			}}}
+			res, err := client.Get(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			body, _ := io.ReadAll(res.Body)
+			if string(body) != tt.want {
+				t.Fatalf("server saw %q, want %q", body, tt.want)
+			}
+		})
+	}
+
+	if _, ok := ClientName(httptest.NewRequest(http.MethodGet, "/", nil)); ok {
+		t.Fatal("plain HTTP request has a client name")
+	}
+	if fp := Fingerprint(ca.Certificate()); len(fp) != 64 || fp != Fingerprint(ca.Certificate()) {
+		t.Fatalf("fingerprint %q, want 64 hex digits", fp)
+	}
+}
@@ -0,0 +1,122 @@
+// Command certs manages the certificates of the built-in HTTPS server in
+// TLS_DIR, with the same configuration as the server:
+//
+//	go run ./cmd/certs server                 create or show the self-signed server certificate
+//	go run ./cmd/certs ca                     create or show the authority of client certificates
+//	go run ./cmd/certs issue -name <device>   issue a client certificate for a device
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/wonderfulsuccess/go-web-app/back/certs"
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: certs server | ca | issue -name device [-days n] [-out dir]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(flag.Arg(0), flag.Args()[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "certs:", err)
+		os.Exit(1)
+	}
+}
+
+func run(command string, args []string) error {
+	cfg := config.Load().TLS
+	switch command {
+	case "server":
+		if cfg.CertFile != "" {
+			return fmt.Errorf("TLS_CERT_FILE is set, the server does not use a self-signed certificate")
+		}
+		pair, err := certs.SelfSigned(cfg.Dir, cfg.Hosts)
+		if err != nil {
+			return err
+		}
+		cert, err := x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			return err
+		}
+		describe(filepath.Join(cfg.Dir, certs.ServerCertFile), cert)
+	case "ca":
+		ca, err := certs.OpenCA(cfg.Dir, true)
+		if err != nil {
+			return err
+		}
+		describe(ca.CertFile, ca.Certificate())
+		fmt.Printf("\nset TLS_CLIENT_CA_FILE=%s to require client certificates issued by it\n", ca.CertFile)
+	case "issue":
+		return issue(cfg, args)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	return nil
+}
+
+func issue(cfg config.TLSConfig, args []string) error {
+	flags := flag.NewFlagSet("issue", flag.ExitOnError)
+	name := flags.String("name", "", "device name, the common name of the certificate")
+	days := flags.Int("days", 365, "validity in days")
+	out := flags.String("out", filepath.Join(cfg.Dir, "clients"), "directory to write <name>.crt and <name>.key to")
+	flags.Parse(args)
+	if *name == "" || strings.ContainsAny(*name, `/\`) {
+		return errors.New("issue needs a -name without path separators")
+	}
+
+	ca, err := certs.OpenCA(cfg.Dir, false)
+	if errors.Is(err, certs.ErrNoCA) {
+		return fmt.Errorf("%w in %s, create it with `certs ca`", err, cfg.Dir)
+	}
+	if err != nil {
+		return err
+	}
+	certPEM, keyPEM, err := ca.Issue(*name, time.Duration(*days)*24*time.Hour)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*out, 0o700); err != nil {
+		return err
+	}
+	certPath, keyPath := filepath.Join(*out, *name+".crt"), filepath.Join(*out, *name+".key")
+	if err := os.WriteFile(keyPath, keyPEM, 0o600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(certPath, certPEM, 0o644); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s and %s\n", certPath, keyPath)
+	return nil
+}
+
+func describe(path string, cert *x509.Certificate) {
+	fmt.Printf("certificate  %s\n", path)
+	fmt.Printf("subject      %s\n", cert.Subject.CommonName)
+	if hosts := hostsOf(cert); len(hosts) > 0 {
+		fmt.Printf("hosts        %s\n", strings.Join(hosts, ", "))
+	}
+	fmt.Printf("valid until  %s\n", cert.NotAfter.Local().Format("2006-01-02 15:04:05"))
+	fmt.Printf("SHA-256      %s\n", certs.Fingerprint(cert))
+}
+
+func hostsOf(cert *x509.Certificate) []string {
+	hosts := append([]string(nil), cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		hosts = append(hosts, ip.String())
+	}
+	return hosts
+}
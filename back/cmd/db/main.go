@@ -0,0 +1,117 @@
+// Command db manages database backups and seed data with the same
+// configuration as the server, and can run while the server is up:
+//
+//	go run ./cmd/db backup            write a new backup and prune old ones
+//	go run ./cmd/db list              list backups, newest first
+//	go run ./cmd/db verify <name>     check a backup
+//	go run ./cmd/db restore <name>    restore a backup, or a backup file path
+//	go run ./cmd/db prune             delete backups beyond BACKUP_KEEP
+//	go run ./cmd/db seed [-env dev]   load a fixture set, see package seed
+//	go run ./cmd/db admin             create or promote an admin account
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/wonderfulsuccess/go-web-app/back/backup"
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+	"github.com/wonderfulsuccess/go-web-app/back/database"
+	"github.com/wonderfulsuccess/go-web-app/back/model"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: db backup | list | verify <name> | restore <name|path> | prune | seed [-env name] [-dir path] | admin [-name name] [-email email]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := run(ctx, flag.Arg(0), flag.Args()[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "db:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, command string, args []string) error {
+	cfg := config.Load()
+	db, err := database.InitDatabase(cfg.Database)
+	if err != nil {
+		return err
+	}
+	switch command {
+	case "seed":
+		return seedCommand(ctx, cfg, db, args)
+	case "admin":
+		return adminCommand(ctx, cfg, db, args)
+	}
+
+	backups := backup.New(db, cfg.Database, cfg.Backup, cfg.Tenant)
+	backups.OnRestore(func() {
+		if err := model.AutoMigrate(db); err != nil {
+			fmt.Fprintln(os.Stderr, "db: failed to migrate restored database:", err)
+		}
+	})
+
+	switch command {
+	case "backup":
+		info, err := backups.Backup(ctx, "")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("wrote %s (%d bytes)\n", info.Name, info.Size)
+	case "list":
+		list, err := backups.List()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tSIZE\tCREATED")
+		for _, info := range list {
+			fmt.Fprintf(w, "%s\t%d\t%s\n", info.Name, info.Size, info.CreatedAt.Local().Format("2006-01-02 15:04:05"))
+		}
+		return w.Flush()
+	case "verify":
+		if len(args) != 1 {
+			return fmt.Errorf("verify needs a backup name")
+		}
+		result, err := backups.Verify(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		if !result.OK {
+			return fmt.Errorf("%s failed verification:\n  %s", result.Name, strings.Join(result.Problems, "\n  "))
+		}
+		fmt.Printf("%s is ok\n", result.Name)
+	case "restore":
+		if len(args) != 1 {
+			return fmt.Errorf("restore needs a backup name or path")
+		}
+		if strings.ContainsAny(args[0], `/\`) {
+			err = backups.RestoreFile(ctx, args[0])
+		} else {
+			err = backups.Restore(ctx, args[0])
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("restored %s\n", args[0])
+	case "prune":
+		return backups.Prune()
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"gorm.io/gorm"
+
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+	"github.com/wonderfulsuccess/go-web-app/back/model"
+	"github.com/wonderfulsuccess/go-web-app/back/seed"
+	"github.com/wonderfulsuccess/go-web-app/back/tenant"
+)
+
+// newSeeder migrates the schema, so a new install can be seeded before the
+// server ever ran, and returns a seeder for it.
+func newSeeder(cfg config.Config, db *gorm.DB) (*seed.Seeder, error) {
+	if err := model.AutoMigrate(db); err != nil {
+		return nil, err
+	}
+	tenants, err := tenant.New(db, cfg.Database, cfg.Tenant)
+	if err != nil {
+		return nil, err
+	}
+	return seed.New(db, tenants), nil
+}
+
+func seedCommand(ctx context.Context, cfg config.Config, db *gorm.DB, args []string) error {
+	flags := flag.NewFlagSet("seed", flag.ExitOnError)
+	env := flags.String("env", cfg.Seed.Env, "fixture set to load (SEED_ENV)")
+	dir := flags.String("dir", cfg.Seed.Dir, "directory of fixture sets instead of the built-in ones (SEED_DIR)")
+	list := flags.Bool("list", false, "list the fixture sets and exit")
+	flags.Parse(args)
+
+	fixtures := seed.Fixtures(*dir)
+	if *list {
+		envs, err := seed.Environments(fixtures)
+		if err != nil {
+			return err
+		}
+		fmt.Println(strings.Join(envs, "\n"))
+		return nil
+	}
+
+	seeder, err := newSeeder(cfg, db)
+	if err != nil {
+		return err
+	}
+	results, err := seeder.Run(ctx, fixtures, *env)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tTENANT\tMODEL\tCREATED\tUPDATED\tUNCHANGED")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\n", r.File, r.Tenant, r.Model, r.Created, r.Updated, r.Unchanged)
+	}
+	w.Flush()
+	if err != nil {
+		return err
+	}
+
+	if _, ok, err := seeder.Admin(ctx); err != nil || ok {
+		return err
+	}
+	if cfg.Seed.AdminEmail == "" && !interactive() {
+		fmt.Println("there is no admin account yet; run `go run ./cmd/db admin` to create one")
+		return nil
+	}
+	name := ""
+	if cfg.Seed.AdminEmail != "" {
+		name = cfg.Seed.AdminName
+	}
+	return createAdmin(ctx, seeder, name, cfg.Seed.AdminEmail)
+}
+
+func adminCommand(ctx context.Context, cfg config.Config, db *gorm.DB, args []string) error {
+	flags := flag.NewFlagSet("admin", flag.ExitOnError)
+	name := flags.String("name", "", "name of the admin (ADMIN_NAME)")
+	email := flags.String("email", cfg.Seed.AdminEmail, "email of the admin (ADMIN_EMAIL); asked for when missing")
+	flags.Parse(args)
+
+	seeder, err := newSeeder(cfg, db)
+	if err != nil {
+		return err
+	}
+	if *name == "" && *email != "" {
+		*name = cfg.Seed.AdminName
+	}
+	return createAdmin(ctx, seeder, *name, *email)
+}
+
+// createAdmin asks for the name and email left empty when run in a
+// terminal, then creates the admin.
+func createAdmin(ctx context.Context, seeder *seed.Seeder, name, email string) error {
+	if email == "" {
+		if !interactive() {
+			return fmt.Errorf("admin email is required; pass -email or set ADMIN_EMAIL")
+		}
+		in := bufio.NewReader(os.Stdin)
+		email = prompt(in, "Admin email", "")
+		if name == "" {
+			name = prompt(in, "Admin name", email)
+		}
+	}
+	admin, err := seeder.CreateAdmin(ctx, name, email)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s (%s) is an admin\n", admin.Name, admin.Email)
+	return nil
+}
+
+// interactive reports whether stdin is a terminal.
+func interactive() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func prompt(in *bufio.Reader, label, fallback string) string {
+	if fallback != "" {
+		fmt.Printf("%s [%s]: ", label, fallback)
+	} else {
+		fmt.Printf("%s: ", label)
+	}
+	line, _ := in.ReadString('\n')
+	if line = strings.TrimSpace(line); line != "" {
+		return line
+	}
+	return fallback
+}
@@ -0,0 +1,53 @@
+// Command openapi writes the OpenAPI document generated from the routes in
+// webserver.NewRouter. With -check it fails when a route is undocumented or
+// when the committed document no longer matches the handlers.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+	"github.com/wonderfulsuccess/go-web-app/back/webserver"
+)
+
+func main() {
+	output := flag.String("o", "../docs/openapi.json", "path of the generated document")
+	check := flag.Bool("check", false, "verify the committed document is up to date instead of writing it")
+	flag.Parse()
+
+	gin.SetMode(gin.ReleaseMode)
+	docs, err := webserver.BuildOpenAPI(config.Config{})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	data, err := docs.MarshalIndent()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "openapi:", err)
+		os.Exit(1)
+	}
+
+	if *check {
+		existing, err := os.ReadFile(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "openapi:", err)
+			os.Exit(1)
+		}
+		if !bytes.Equal(existing, data) {
+			fmt.Fprintf(os.Stderr, "openapi: %s is out of date, run `go run ./cmd/openapi`\n", *output)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := os.WriteFile(*output, data, 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, "openapi:", err)
+		os.Exit(1)
+	}
+	fmt.Println("wrote", *output)
+}
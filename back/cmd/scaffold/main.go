@@ -0,0 +1,418 @@
+// Command scaffold generates the model, controller, migration, route
+// registration, tests, TypeScript client and CRUD page for a new table.
+//
+// Usage (from the back directory):
+//
+//	go run ./cmd/scaffold Product name:string price:float stock:int active:bool
+//
+// Each field is written as name:type[:unique|:index]. Supported types are
+// string, text, int, int64, uint, float, bool and time. Existing files are
+// never overwritten; the command aborts before touching the tree instead.
+// Afterwards run `go run ./cmd/openapi` to refresh docs/openapi.json.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+type fieldType struct {
+	Go     string
+	TS     string
+	Input  string
+	Sample string
+	Zero   string
+}
+
+var fieldTypes = map[string]fieldType{
+	"string": {Go: "string", TS: "string", Input: "text", Sample: `"sample"`, Zero: `""`},
+	"text":   {Go: "string", TS: "string", Input: "textarea", Sample: `"sample text"`, Zero: `""`},
+	"int":    {Go: "int", TS: "number", Input: "number", Sample: "1", Zero: "0"},
+	"int64":  {Go: "int64", TS: "number", Input: "number", Sample: "1", Zero: "0"},
+	"uint":   {Go: "uint", TS: "number", Input: "number", Sample: "1", Zero: "0"},
+	"float":  {Go: "float64", TS: "number", Input: "number", Sample: "1.5", Zero: "0"},
+	"bool":   {Go: "bool", TS: "boolean", Input: "checkbox", Sample: "true", Zero: "false"},
+	"time":   {Go: "time.Time", TS: "string", Input: "datetime-local", Sample: `"2024-01-01T00:00:00Z"`, Zero: `new Date().toISOString()`},
+}
+
+var reservedFields = map[string]bool{"ID": true, "TenantID": true, "CreatedAt": true, "UpdatedAt": true}
+
+// Field describes one column of the generated model.
+type Field struct {
+	Name    string // Go field name, e.g. UnitPrice
+	JSON    string // json key, e.g. unitPrice
+	Column  string // snake_case column name, e.g. unit_price
+	Type    fieldType
+	TypeKey string
+	Tag     string
+}
+
+// Spec is the data handed to every template.
+type Spec struct {
+	Name   string // Product
+	Snake  string // product
+	Camel  string // product
+	Kebab  string // product
+	Plural string // products (route segment)
+	Label  string
+	Fields []Field
+	// TenantTag tags the TenantID field, which leads every unique index
+	// so that unique values are unique per tenant.
+	TenantTag string
+	GoModule  string
+}
+
+func main() {
+	backDir := flag.String("back", ".", "path to the back directory containing go.mod")
+	frontDir := flag.String("front", "../front", "path to the front directory")
+	label := flag.String("label", "", "navigation label for the generated page (defaults to the model name)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: go run ./cmd/scaffold [flags] <Name> field:type[:unique|:index] ...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	spec, err := buildSpec(flag.Arg(0), flag.Args()[1:], *label)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "scaffold:", err)
+		os.Exit(2)
+	}
+
+	module, err := readModulePath(filepath.Join(*backDir, "go.mod"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "scaffold:", err)
+		os.Exit(1)
+	}
+	spec.GoModule = module
+
+	if err := run(spec, *backDir, *frontDir); err != nil {
+		fmt.Fprintln(os.Stderr, "scaffold:", err)
+		os.Exit(1)
+	}
+}
+
+func run(spec Spec, backDir, frontDir string) error {
+	files := []struct {
+		path  string
+		tmpl  string
+		gofmt bool
+	}{
+		{filepath.Join(backDir, "model", spec.Snake+".go"), modelTemplate, true},
+		{filepath.Join(backDir, "controller", spec.Snake+".go"), controllerTemplate, true},
+		{filepath.Join(backDir, "controller", spec.Snake+"_test.go"), controllerTestTemplate, true},
+		{filepath.Join(frontDir, "src", "api", spec.Camel+".ts"), clientTemplate, false},
+		{filepath.Join(frontDir, "src", "pages", spec.Kebab+".tsx"), pageTemplate, false},
+	}
+
+	edits := []edit{
+		{
+			path:   filepath.Join(backDir, "model", "migrate.go"),
+			marker: "// scaffold:tenant-models",
+			insert: fmt.Sprintf("&%s{},", spec.Name),
+		},
+		{
+			path:   filepath.Join(backDir, "webserver", "router.go"),
+			marker: "// scaffold:routes",
+			insert: fmt.Sprintf("mount(api, docs, \"/%s\", controller.New%sController(db))", spec.Plural, spec.Name),
+		},
+		{
+			path:   filepath.Join(frontDir, "src", "App.tsx"),
+			marker: "// scaffold:imports",
+			insert: fmt.Sprintf("import %sPage from \"@/pages/%s\";", spec.Name, spec.Kebab),
+		},
+		{
+			path:   filepath.Join(frontDir, "src", "App.tsx"),
+			marker: "// scaffold:navigation",
+			insert: fmt.Sprintf("{ to: \"/%s\", label: %q, icon: <FiDatabase className=\"h-4 w-4\" /> },", spec.Plural, spec.Label),
+		},
+		{
+			path:   filepath.Join(frontDir, "src", "App.tsx"),
+			marker: "{/* scaffold:routes */}",
+			insert: fmt.Sprintf("<Route path=\"/%s\" element={<%sPage />} />", spec.Plural, spec.Name),
+		},
+	}
+
+	// Validate everything before writing so a failure leaves the tree untouched.
+	rendered := make(map[string][]byte, len(files))
+	for _, f := range files {
+		if _, err := os.Stat(f.path); err == nil {
+			return fmt.Errorf("%s already exists, refusing to overwrite", f.path)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		out, err := render(f.tmpl, spec)
+		if err != nil {
+			return fmt.Errorf("render %s: %w", f.path, err)
+		}
+		if f.gofmt {
+			if out, err = format.Source(out); err != nil {
+				return fmt.Errorf("format %s: %w", f.path, err)
+			}
+		}
+		rendered[f.path] = out
+	}
+
+	patched := make(map[string]string)
+	for _, e := range edits {
+		src, ok := patched[e.path]
+		if !ok {
+			data, err := os.ReadFile(e.path)
+			if err != nil {
+				return err
+			}
+			src = string(data)
+		}
+		next, err := e.apply(src)
+		if err != nil {
+			return err
+		}
+		patched[e.path] = next
+	}
+	if app := filepath.Join(frontDir, "src", "App.tsx"); patched[app] != "" {
+		patched[app] = ensureIconImport(patched[app], "FiDatabase")
+	}
+
+	for _, f := range files {
+		if err := os.WriteFile(f.path, rendered[f.path], 0o644); err != nil {
+			return err
+		}
+		fmt.Println("created", f.path)
+	}
+	for path, src := range patched {
+		out := []byte(src)
+		if strings.HasSuffix(path, ".go") {
+			formatted, err := format.Source(out)
+			if err != nil {
+				return fmt.Errorf("format %s: %w", path, err)
+			}
+			out = formatted
+		}
+		if err := os.WriteFile(path, out, 0o644); err != nil {
+			return err
+		}
+		fmt.Println("updated", path)
+	}
+	return nil
+}
+
+// edit inserts a line directly above a marker comment, keeping its indentation.
+type edit struct {
+	path   string
+	marker string
+	insert string
+}
+
+func (e edit) apply(src string) (string, error) {
+	idx := strings.Index(src, e.marker)
+	if idx < 0 {
+		return "", fmt.Errorf("%s: marker %q not found", e.path, e.marker)
+	}
+	if strings.Contains(src, e.insert) {
+		return "", fmt.Errorf("%s already contains %q", e.path, e.insert)
+	}
+	lineStart := strings.LastIndex(src[:idx], "\n") + 1
+	indent := src[lineStart:idx]
+	return src[:lineStart] + indent + e.insert + "\n" + src[lineStart:], nil
+}
+
+var iconImport = regexp.MustCompile(`import \{([^}]*)\} from "react-icons/fi";`)
+
+func ensureIconImport(src, icon string) string {
+	m := iconImport.FindStringSubmatchIndex(src)
+	if m == nil {
+		return "import { " + icon + " } from \"react-icons/fi\";\n" + src
+	}
+	names := src[m[2]:m[3]]
+	for _, name := range strings.Split(names, ",") {
+		if strings.TrimSpace(name) == icon {
+			return src
+		}
+	}
+	return src[:m[2]] + " " + icon + "," + names + src[m[3]:]
+}
+
+func buildSpec(name string, rawFields []string, label string) (Spec, error) {
+	words := splitWords(name)
+	if len(words) == 0 {
+		return Spec{}, fmt.Errorf("invalid model name %q", name)
+	}
+	spec := Spec{
+		Name:  pascal(words),
+		Snake: strings.Join(lower(words), "_"),
+		Camel: camel(words),
+		Kebab: strings.Join(lower(words), "-"),
+		Label: label,
+	}
+	if !unicode.IsLetter(rune(spec.Name[0])) {
+		return Spec{}, fmt.Errorf("model name %q must start with a letter", name)
+	}
+	last := lower(words)
+	last[len(last)-1] = pluralize(last[len(last)-1])
+	spec.Plural = strings.Join(last, "-")
+	if spec.Label == "" {
+		spec.Label = spec.Name
+	}
+	if spec.Snake == "user" {
+		return Spec{}, errors.New("the user table already exists")
+	}
+
+	if len(rawFields) == 0 {
+		return Spec{}, errors.New("at least one field:type is required")
+	}
+	seen := make(map[string]bool)
+	tenantTag := []string{"index", "not null", "default:0"}
+	for _, raw := range rawFields {
+		parts := strings.Split(raw, ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return Spec{}, fmt.Errorf("invalid field %q, expected name:type[:unique|:index]", raw)
+		}
+		fw := splitWords(parts[0])
+		if len(fw) == 0 {
+			return Spec{}, fmt.Errorf("invalid field name in %q", raw)
+		}
+		ft, ok := fieldTypes[strings.ToLower(parts[1])]
+		if !ok {
+			return Spec{}, fmt.Errorf("unsupported type %q in %q", parts[1], raw)
+		}
+		f := Field{
+			Name:    pascal(fw),
+			JSON:    camel(fw),
+			Column:  strings.Join(lower(fw), "_"),
+			Type:    ft,
+			TypeKey: strings.ToLower(parts[1]),
+		}
+		if reservedFields[f.Name] {
+			return Spec{}, fmt.Errorf("field %s is added automatically", f.Name)
+		}
+		if seen[f.Name] {
+			return Spec{}, fmt.Errorf("duplicate field %s", f.Name)
+		}
+		seen[f.Name] = true
+
+		var gormTag []string
+		if f.TypeKey == "text" {
+			gormTag = append(gormTag, "type:text")
+		}
+		if len(parts) == 3 {
+			switch parts[2] {
+			case "unique":
+				index := fmt.Sprintf("idx_%s_tenant_%s", spec.Snake, f.Column)
+				gormTag = append(gormTag, "uniqueIndex:"+index+",priority:2")
+				tenantTag = append(tenantTag, "uniqueIndex:"+index+",priority:1")
+			case "index":
+				gormTag = append(gormTag, "index")
+			default:
+				return Spec{}, fmt.Errorf("unknown modifier %q in %q", parts[2], raw)
+			}
+		}
+		if len(gormTag) > 0 {
+			f.Tag = fmt.Sprintf("`gorm:\"%s\" json:\"%s\"`", strings.Join(gormTag, ";"), f.JSON)
+		} else {
+			f.Tag = fmt.Sprintf("`json:\"%s\"`", f.JSON)
+		}
+		spec.Fields = append(spec.Fields, f)
+	}
+	spec.TenantTag = fmt.Sprintf("`gorm:\"%s\" json:\"tenantId\"`", strings.Join(tenantTag, ";"))
+	return spec, nil
+}
+
+func render(tmpl string, spec Spec) ([]byte, error) {
+	t, err := template.New("scaffold").Delims("[[", "]]").Parse(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, spec); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func readModulePath(goMod string) (string, error) {
+	data, err := os.ReadFile(goMod)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "module ") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "module ")), nil
+		}
+	}
+	return "", fmt.Errorf("%s: module directive not found", goMod)
+}
+
+// splitWords breaks snake_case, kebab-case and CamelCase identifiers into words.
+func splitWords(s string) []string {
+	var words []string
+	var cur []rune
+	flush := func() {
+		if len(cur) > 0 {
+			words = append(words, string(cur))
+			cur = cur[:0]
+		}
+	}
+	runes := []rune(s)
+	for i, r := range runes {
+		switch {
+		case r == '_' || r == '-' || r == ' ':
+			flush()
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			return nil
+		case unicode.IsUpper(r) && i > 0 && (unicode.IsLower(runes[i-1]) ||
+			(i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1]))):
+			flush()
+			cur = append(cur, r)
+		default:
+			cur = append(cur, r)
+		}
+	}
+	flush()
+	return words
+}
+
+func lower(words []string) []string {
+	out := make([]string, len(words))
+	for i, w := range words {
+		out[i] = strings.ToLower(w)
+	}
+	return out
+}
+
+func pascal(words []string) string {
+	var b strings.Builder
+	for _, w := range lower(words) {
+		b.WriteString(strings.ToUpper(w[:1]) + w[1:])
+	}
+	return b.String()
+}
+
+func camel(words []string) string {
+	p := pascal(words)
+	return strings.ToLower(p[:1]) + p[1:]
+}
+
+func pluralize(w string) string {
+	switch {
+	case strings.HasSuffix(w, "y") && len(w) > 1 && !strings.ContainsRune("aeiou", rune(w[len(w)-2])):
+		return w[:len(w)-1] + "ies"
+	case strings.HasSuffix(w, "s"), strings.HasSuffix(w, "x"), strings.HasSuffix(w, "ch"), strings.HasSuffix(w, "sh"):
+		return w + "es"
+	default:
+		return w + "s"
+	}
+}
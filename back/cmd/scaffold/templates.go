@@ -0,0 +1,543 @@
+package main
+
+const modelTemplate = `package model
+
+import "time"
+
+// [[.Name]] was generated by cmd/scaffold.
+type [[.Name]] struct {
+	ID       uint ` + "`" + `gorm:"primaryKey" json:"id"` + "`" + `
+	TenantID uint [[.TenantTag]]
+[[- range .Fields]]
+	[[.Name]] [[.Type.Go]] [[.Tag]]
+[[- end]]
+	CreatedAt time.Time ` + "`" + `json:"createdAt"` + "`" + `
+	UpdatedAt time.Time ` + "`" + `json:"updatedAt"` + "`" + `
+}
+`
+
+const controllerTemplate = `package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"[[.GoModule]]/model"
+	"[[.GoModule]]/openapi"
+)
+
+// [[.Name]]Controller contains CRUD handlers for the [[.Name]] model.
+type [[.Name]]Controller struct {
+	db *gorm.DB
+}
+
+func New[[.Name]]Controller(db *gorm.DB) *[[.Name]]Controller {
+	return &[[.Name]]Controller{db: db}
+}
+
+func (ctl *[[.Name]]Controller) RegisterRoutes(group *gin.RouterGroup) {
+	group.GET("", ctl.List)
+	group.POST("", ctl.Create)
+	group.GET(":id", ctl.Get)
+	group.PUT(":id", ctl.Update)
+	group.DELETE(":id", ctl.Delete)
+}
+
+// Operations documents the routes added by RegisterRoutes.
+func (ctl *[[.Name]]Controller) Operations() []openapi.Operation {
+	tags := []string{"[[.Plural]]"}
+	notFound := openapi.Response{Status: http.StatusNotFound, Body: ErrorResponse{}}
+	badRequest := openapi.Response{Status: http.StatusBadRequest, Body: ErrorResponse{}}
+	serverError := openapi.Response{Status: http.StatusInternalServerError, Body: ErrorResponse{}}
+
+	return []openapi.Operation{
+		{
+			ID: "list[[.Name]]s", Method: http.MethodGet, Path: "", Summary: "List [[.Plural]]", Tags: tags,
+			Responses: []openapi.Response{{Status: http.StatusOK, Body: []model.[[.Name]]{}}, serverError},
+		},
+		{
+			ID: "create[[.Name]]", Method: http.MethodPost, Path: "", Summary: "Create a [[.Snake]]", Tags: tags, Request: model.[[.Name]]{},
+			Responses: []openapi.Response{{Status: http.StatusCreated, Body: model.[[.Name]]{}}, badRequest, serverError},
+		},
+		{
+			ID: "get[[.Name]]", Method: http.MethodGet, Path: ":id", Summary: "Get a [[.Snake]]", Tags: tags,
+			Responses: []openapi.Response{{Status: http.StatusOK, Body: model.[[.Name]]{}}, badRequest, notFound, serverError},
+		},
+		{
+			ID: "update[[.Name]]", Method: http.MethodPut, Path: ":id", Summary: "Update a [[.Snake]]", Tags: tags, Request: model.[[.Name]]{},
+			Responses: []openapi.Response{{Status: http.StatusOK, Body: model.[[.Name]]{}}, badRequest, serverError},
+		},
+		{
+			ID: "delete[[.Name]]", Method: http.MethodDelete, Path: ":id", Summary: "Delete a [[.Snake]]", Tags: tags,
+			Responses: []openapi.Response{{Status: http.StatusNoContent}, badRequest, serverError},
+		},
+	}
+}
+
+func (ctl *[[.Name]]Controller) List(c *gin.Context) {
+	var items []model.[[.Name]]
+	if err := ctl.db.WithContext(c.Request.Context()).Find(&items).Error; err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+func (ctl *[[.Name]]Controller) Get(c *gin.Context) {
+	id, err := parseID(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+
+	var item model.[[.Name]]
+	if err := ctl.db.WithContext(c.Request.Context()).First(&item, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respondError(c, http.StatusNotFound, ErrCodeNotFound, "[[.Snake]] not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, item)
+}
+
+func (ctl *[[.Name]]Controller) Create(c *gin.Context) {
+	var payload model.[[.Name]]
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+
+	if err := ctl.db.WithContext(c.Request.Context()).Create(&payload).Error; err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusCreated, payload)
+}
+
+func (ctl *[[.Name]]Controller) Update(c *gin.Context) {
+	id, err := parseID(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+
+	var payload model.[[.Name]]
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+
+	updates := map[string]interface{}{
+[[- range .Fields]]
+		"[[.Column]]": payload.[[.Name]],
+[[- end]]
+	}
+
+	if err := ctl.db.WithContext(c.Request.Context()).Model(&model.[[.Name]]{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+
+	payload.ID = id
+	c.JSON(http.StatusOK, payload)
+}
+
+func (ctl *[[.Name]]Controller) Delete(c *gin.Context) {
+	id, err := parseID(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+
+	if err := ctl.db.WithContext(c.Request.Context()).Delete(&model.[[.Name]]{}, id).Error; err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+`
+
+const controllerTestTemplate = `package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"[[.GoModule]]/model"
+)
+
+func new[[.Name]]TestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	if err := db.AutoMigrate(&model.[[.Name]]{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	router := gin.New()
+	New[[.Name]]Controller(db).RegisterRoutes(router.Group("/[[.Plural]]"))
+	return router
+}
+
+func do[[.Name]]Request(t *testing.T, router *gin.Engine, method, path string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatalf("encode body: %v", err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func Test[[.Name]]ControllerCRUD(t *testing.T) {
+	router := new[[.Name]]TestRouter(t)
+
+	payload := map[string]interface{}{
+[[- range .Fields]]
+		"[[.JSON]]": [[.Type.Sample]],
+[[- end]]
+	}
+
+	rec := do[[.Name]]Request(t, router, http.MethodPost, "/[[.Plural]]", payload)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var created model.[[.Name]]
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatalf("decode created: %v", err)
+	}
+	if created.ID == 0 {
+		t.Fatalf("create: expected an id to be assigned")
+	}
+	itemPath := fmt.Sprintf("/[[.Plural]]/%d", created.ID)
+
+	if rec := do[[.Name]]Request(t, router, http.MethodGet, itemPath, nil); rec.Code != http.StatusOK {
+		t.Fatalf("get: expected 200, got %d", rec.Code)
+	}
+
+	rec = do[[.Name]]Request(t, router, http.MethodGet, "/[[.Plural]]", nil)
+	var items []model.[[.Name]]
+	if err := json.Unmarshal(rec.Body.Bytes(), &items); err != nil || len(items) != 1 {
+		t.Fatalf("list: expected one item, got %s", rec.Body.String())
+	}
+
+	if rec := do[[.Name]]Request(t, router, http.MethodPut, itemPath, payload); rec.Code != http.StatusOK {
+		t.Fatalf("update: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := do[[.Name]]Request(t, router, http.MethodDelete, itemPath, nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete: expected 204, got %d", rec.Code)
+	}
+
+	if rec := do[[.Name]]Request(t, router, http.MethodGet, itemPath, nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("get after delete: expected 404, got %d", rec.Code)
+	}
+}
+`
+
+const clientTemplate = `export interface [[.Name]] {
+  id: number;
+[[- range .Fields]]
+  [[.JSON]]: [[.Type.TS]];
+[[- end]]
+  createdAt: string;
+  updatedAt: string;
+}
+
+export type [[.Name]]Input = Omit<[[.Name]], "id" | "createdAt" | "updatedAt">;
+
+const BASE_URL = "/api/[[.Plural]]";
+
+async function request<T>(input: string, init?: RequestInit): Promise<T> {
+  const response = await fetch(input, {
+    ...init,
+    headers: { "Content-Type": "application/json", ...init?.headers },
+  });
+  if (!response.ok) {
+    let message = ` + "`请求失败: ${response.status}`" + `;
+    try {
+      const body = (await response.json()) as { error?: string };
+      if (body.error) {
+        message = body.error;
+      }
+    } catch {
+      // ignore non-JSON error bodies
+    }
+    throw new Error(message);
+  }
+  if (response.status === 204) {
+    return undefined as T;
+  }
+  return (await response.json()) as T;
+}
+
+export function list[[.Name]]s() {
+  return request<[[.Name]][]>(BASE_URL);
+}
+
+export function get[[.Name]](id: number) {
+  return request<[[.Name]]>(` + "`${BASE_URL}/${id}`" + `);
+}
+
+export function create[[.Name]](input: [[.Name]]Input) {
+  return request<[[.Name]]>(BASE_URL, {
+    method: "POST",
+    body: JSON.stringify(input),
+  });
+}
+
+export function update[[.Name]](id: number, input: [[.Name]]Input) {
+  return request<[[.Name]]>(` + "`${BASE_URL}/${id}`" + `, {
+    method: "PUT",
+    body: JSON.stringify(input),
+  });
+}
+
+export function delete[[.Name]](id: number) {
+  return request<void>(` + "`${BASE_URL}/${id}`" + `, { method: "DELETE" });
+}
+`
+
+const pageTemplate = `import { useCallback, useEffect, useState } from "react";
+import { FiEdit2, FiPlus, FiRefreshCcw, FiTrash2 } from "react-icons/fi";
+
+import {
+  create[[.Name]],
+  delete[[.Name]],
+  list[[.Name]]s,
+  update[[.Name]],
+  type [[.Name]],
+  type [[.Name]]Input,
+} from "@/api/[[.Camel]]";
+import { Button } from "@/components/ui/button";
+import {
+  Card,
+  CardContent,
+  CardDescription,
+  CardHeader,
+  CardTitle,
+} from "@/components/ui/card";
+
+const EMPTY_FORM: [[.Name]]Input = {
+[[- range .Fields]]
+  [[.JSON]]: [[.Type.Zero]],
+[[- end]]
+};
+
+function [[.Name]]Page() {
+  const [items, setItems] = useState<[[.Name]][]>([]);
+  const [form, setForm] = useState<[[.Name]]Input>(EMPTY_FORM);
+  const [editingId, setEditingId] = useState<number | null>(null);
+  const [loading, setLoading] = useState(false);
+  const [error, setError] = useState<string | null>(null);
+
+  const fetchItems = useCallback(async () => {
+    setLoading(true);
+    setError(null);
+    try {
+      setItems(await list[[.Name]]s());
+    } catch (err) {
+      setError(err instanceof Error ? err.message : "加载失败");
+    } finally {
+      setLoading(false);
+    }
+  }, []);
+
+  useEffect(() => {
+    fetchItems();
+  }, [fetchItems]);
+
+  const resetForm = () => {
+    setForm(EMPTY_FORM);
+    setEditingId(null);
+  };
+
+  const submit = async (event: React.FormEvent) => {
+    event.preventDefault();
+    setError(null);
+    try {
+      if (editingId === null) {
+        const created = await create[[.Name]](form);
+        setItems((prev) => [created, ...prev]);
+      } else {
+        const updated = await update[[.Name]](editingId, form);
+        setItems((prev) =>
+          prev.map((item) => (item.id === editingId ? { ...item, ...updated } : item))
+        );
+      }
+      resetForm();
+    } catch (err) {
+      setError(err instanceof Error ? err.message : "保存失败");
+    }
+  };
+
+  const edit = (item: [[.Name]]) => {
+    setEditingId(item.id);
+    setForm({
+[[- range .Fields]]
+      [[.JSON]]: item.[[.JSON]],
+[[- end]]
+    });
+  };
+
+  const remove = async (id: number) => {
+    setError(null);
+    try {
+      await delete[[.Name]](id);
+      setItems((prev) => prev.filter((item) => item.id !== id));
+      if (editingId === id) {
+        resetForm();
+      }
+    } catch (err) {
+      setError(err instanceof Error ? err.message : "删除失败");
+    }
+  };
+
+  return (
+    <div className="space-y-6">
+      <Card>
+        <CardHeader>
+          <CardTitle>{editingId === null ? "新建" : "编辑"} [[.Label]]</CardTitle>
+        </CardHeader>
+        <CardContent>
+          <form className="grid gap-4 md:grid-cols-2" onSubmit={submit}>
+[[- range .Fields]]
+            <label className="space-y-1 text-sm">
+              <span className="font-medium">[[.Name]]</span>
+[[- if eq .Type.Input "checkbox"]]
+              <input
+                type="checkbox"
+                className="block h-4 w-4"
+                checked={form.[[.JSON]]}
+                onChange={(e) => setForm({ ...form, [[.JSON]]: e.target.checked })}
+              />
+[[- else if eq .Type.Input "textarea"]]
+              <textarea
+                className="block w-full rounded-md border bg-background px-3 py-2"
+                value={form.[[.JSON]]}
+                onChange={(e) => setForm({ ...form, [[.JSON]]: e.target.value })}
+              />
+[[- else if eq .Type.Input "number"]]
+              <input
+                type="number"
+                className="block w-full rounded-md border bg-background px-3 py-2"
+                value={form.[[.JSON]]}
+                onChange={(e) => setForm({ ...form, [[.JSON]]: Number(e.target.value) })}
+              />
+[[- else if eq .Type.Input "datetime-local"]]
+              <input
+                type="datetime-local"
+                className="block w-full rounded-md border bg-background px-3 py-2"
+                value={form.[[.JSON]].slice(0, 16)}
+                onChange={(e) =>
+                  setForm({ ...form, [[.JSON]]: new Date(e.target.value).toISOString() })
+                }
+              />
+[[- else]]
+              <input
+                type="text"
+                className="block w-full rounded-md border bg-background px-3 py-2"
+                value={form.[[.JSON]]}
+                onChange={(e) => setForm({ ...form, [[.JSON]]: e.target.value })}
+              />
+[[- end]]
+            </label>
+[[- end]]
+            <div className="flex gap-2 md:col-span-2">
+              <Button type="submit" size="sm">
+                <FiPlus className="mr-2 h-4 w-4" /> {editingId === null ? "创建" : "保存"}
+              </Button>
+              {editingId !== null && (
+                <Button type="button" variant="outline" size="sm" onClick={resetForm}>
+                  取消
+                </Button>
+              )}
+            </div>
+          </form>
+        </CardContent>
+      </Card>
+
+      <Card>
+        <CardHeader className="flex flex-row items-center justify-between">
+          <div>
+            <CardTitle>[[.Label]]</CardTitle>
+            <CardDescription>由 cmd/scaffold 生成的增删改查页面。</CardDescription>
+          </div>
+          <Button variant="outline" size="sm" onClick={fetchItems} disabled={loading}>
+            <FiRefreshCcw className="mr-2 h-4 w-4" /> 刷新
+          </Button>
+        </CardHeader>
+        <CardContent>
+          {loading ? (
+            <p className="text-sm text-muted-foreground">加载中...</p>
+          ) : error ? (
+            <p className="text-sm text-destructive">{error}</p>
+          ) : items.length === 0 ? (
+            <p className="text-sm text-muted-foreground">暂无数据。</p>
+          ) : (
+            <div className="overflow-hidden rounded-md border">
+              <table className="min-w-full divide-y divide-border text-sm">
+                <thead className="bg-muted/60">
+                  <tr>
+                    <th className="px-4 py-2 text-left font-medium">ID</th>
+[[- range .Fields]]
+                    <th className="px-4 py-2 text-left font-medium">[[.Name]]</th>
+[[- end]]
+                    <th className="px-4 py-2 text-right font-medium">操作</th>
+                  </tr>
+                </thead>
+                <tbody className="divide-y divide-border">
+                  {items.map((item) => (
+                    <tr key={item.id}>
+                      <td className="px-4 py-2 text-muted-foreground">{item.id}</td>
+[[- range .Fields]]
+                      <td className="px-4 py-2 text-muted-foreground">{String(item.[[.JSON]])}</td>
+[[- end]]
+                      <td className="px-4 py-2 text-right">
+                        <div className="flex justify-end gap-2">
+                          <Button variant="outline" size="sm" onClick={() => edit(item)}>
+                            <FiEdit2 className="h-4 w-4" />
+                          </Button>
+                          <Button variant="outline" size="sm" onClick={() => remove(item.id)}>
+                            <FiTrash2 className="h-4 w-4" />
+                          </Button>
+                        </div>
+                      </td>
+                    </tr>
+                  ))}
+                </tbody>
+              </table>
+            </div>
+          )}
+        </CardContent>
+      </Card>
+    </div>
+  );
+}
+
+export default [[.Name]]Page;
+`
@@ -0,0 +1,73 @@
+// Command tsgen writes front/src/api/generated.ts: TypeScript declarations for
+// the models, DTOs, error codes and WebSocket messages of the back end, plus a
+// typed fetch function for every documented route.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+	"github.com/wonderfulsuccess/go-web-app/back/controller"
+	"github.com/wonderfulsuccess/go-web-app/back/tsgen"
+	"github.com/wonderfulsuccess/go-web-app/back/webserver"
+)
+
+func main() {
+	output := flag.String("o", "../front/src/api/generated.ts", "path of the generated module")
+	check := flag.Bool("check", false, "verify the generated module is up to date instead of writing it")
+	flag.Parse()
+
+	gin.SetMode(gin.ReleaseMode)
+
+	docs, err := webserver.BuildOpenAPI(config.Config{})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	var codes []string
+	for _, code := range controller.ErrorCodes() {
+		codes = append(codes, string(code))
+	}
+	var messages []tsgen.Message
+	for _, m := range webserver.MessageTypes() {
+		messages = append(messages, tsgen.Message{
+			Type:        m.Type,
+			Description: m.Description,
+			Direction:   m.Direction.String(),
+			Payload:     m.Payload,
+		})
+	}
+
+	src, err := tsgen.Generate(tsgen.Input{
+		Routes:     docs.Routes(),
+		ErrorCodes: codes,
+		Messages:   messages,
+		Envelope:   webserver.WSMessage{},
+		Types:      []interface{}{controller.ErrorResponse{}},
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "tsgen:", err)
+		os.Exit(1)
+	}
+
+	if *check {
+		existing, err := os.ReadFile(*output)
+		if err != nil || !bytes.Equal(existing, []byte(src)) {
+			fmt.Fprintf(os.Stderr, "tsgen: %s is out of date, run `go run ./cmd/tsgen`\n", *output)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := os.WriteFile(*output, []byte(src), 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, "tsgen:", err)
+		os.Exit(1)
+	}
+	fmt.Println("wrote", *output)
+}
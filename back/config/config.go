@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // DatabaseType represents the configured database driver.
@@ -20,10 +22,295 @@ const (
 )
 
 // DatabaseConfig collects the inputs required to create a Gorm connection.
+// DSN, when set, is used as is; otherwise MySQL and PostgreSQL connection
+// strings are assembled from the individual fields.
 type DatabaseConfig struct {
 	Type DatabaseType
 	DSN  string
 	Log  bool
+
+	Host string
+	Port int
+	User string
+	// Password is read from PasswordFile when empty, which suits secrets
+	// mounted by Docker or Kubernetes.
+	Password     string
+	PasswordFile string
+	Name         string
+	// TLSMode is disable, prefer, require, verify-ca or verify-full, named
+	// after PostgreSQL's sslmode; empty keeps the driver default.
+	TLSMode string
+	// TimeZone is an IANA name such as "UTC"; empty keeps the server's
+	// zone for PostgreSQL and the local zone for MySQL.
+	TimeZone string
+	Charset  string
+
+	// ReplicaDSNs and ReplicaHosts add read replicas for MySQL and
+	// PostgreSQL. Hosts ("host" or "host:port") share the primary's user,
+	// password and options.
+	ReplicaDSNs  []string
+	ReplicaHosts []string
+	// ConnectTimeout is how long startup keeps retrying an unreachable
+	// database; 0 tries once.
+	ConnectTimeout time.Duration
+	// HealthInterval is how often the primary and replicas are pinged; 0
+	// disables the checks.
+	HealthInterval time.Duration
+	// PrimaryAfterWrite sends a client's reads to the primary for this long
+	// after it wrote, so it reads its own writes despite replication lag.
+	PrimaryAfterWrite time.Duration
+
+	Pool   PoolConfig
+	SQLite SQLiteConfig
+}
+
+// PoolConfig sizes the connection pool of MySQL and PostgreSQL. SQLite
+// sizes its own pools from SQLiteConfig.
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// SQLiteConfig tunes the SQLite connection. The defaults suit a desktop app:
+// WAL lets readers run while a write is in progress, and a single writer
+// connection serialises writes in the process instead of failing with
+// "database is locked".
+type SQLiteConfig struct {
+	// JournalMode is DELETE, TRUNCATE, PERSIST, MEMORY, WAL or OFF.
+	JournalMode string
+	// Synchronous is OFF, NORMAL, FULL or EXTRA. NORMAL is safe with WAL.
+	Synchronous string
+	ForeignKeys bool
+	BusyTimeout time.Duration
+	// MMapSize is the number of bytes of the file mapped into memory; 0
+	// disables memory-mapped I/O.
+	MMapSize int64
+	// Readers is the size of the read-only connection pool used for
+	// queries outside transactions; 0 sends every query to the writer.
+	Readers int
+	// OptimizeInterval is how often PRAGMA optimize runs; 0 disables it.
+	OptimizeInterval time.Duration
+}
+
+// WebSocketConfig controls who may open WebSocket connections and the
+// limits applied to each connection.
+type WebSocketConfig struct {
+	// AllowedOrigins lists accepted Origin headers, e.g.
+	// "https://app.example.com", "https://*.example.com" or "*". Requests
+	// from the same host as the server are always accepted.
+	AllowedOrigins []string
+	// AllowLocalhost accepts any http(s)://localhost or loopback origin,
+	// which covers the Vite dev server and the desktop shell.
+	AllowLocalhost bool
+
+	MaxConnections        int
+	MaxConnectionsPerIP   int
+	MaxConnectionsPerUser int
+
+	ReadLimit       int64
+	ReadBufferSize  int
+	WriteBufferSize int
+	PongWait        time.Duration
+	PingInterval    time.Duration
+	WriteWait       time.Duration
+
+	// MessageRate limits the frames a single connection sends, valid or
+	// not; a WebSocket going over it is closed.
+	MessageRate Rate
+
+	SendQueueSize int
+	Backpressure  string
+}
+
+// TaskConfig sizes the background task queue.
+type TaskConfig struct {
+	Workers     int
+	MaxAttempts int
+	// Retention is how long finished tasks are kept before being purged.
+	Retention time.Duration
+}
+
+// BackupConfig controls where database backups are written and how many
+// are kept.
+type BackupConfig struct {
+	Dir string
+	// Keep is the number of newest backups retained; 0 keeps all.
+	Keep int
+	// Schedule is when the db.backup job runs, in scheduler syntax; "off"
+	// disables it.
+	Schedule string
+}
+
+// ImportConfig bounds file imports such as POST /api/users/import.
+type ImportConfig struct {
+	// MaxBytes is the largest accepted request body; 0 means no limit.
+	MaxBytes int64
+}
+
+// TenantConfig controls how requests are mapped to tenants.
+type TenantConfig struct {
+	// Header names the request header carrying a tenant slug.
+	Header string
+	// BaseDomain, e.g. "example.com", maps acme.example.com to the tenant
+	// acme; empty disables subdomain resolution.
+	BaseDomain string
+	// Cookie names the cookie remembering the tenant a browser chose.
+	Cookie string
+	// Default is the slug of the tenant used when a request names none.
+	Default string
+	// SQLiteFiles stores each tenant in a SQLite file of its own instead
+	// of sharing the tables of the main database.
+	SQLiteFiles bool
+}
+
+// SeedConfig selects the fixtures loaded by the seed package and the first
+// admin account.
+type SeedConfig struct {
+	// Env names the fixture set, e.g. dev, demo or test.
+	Env string
+	// Dir, when set, holds the fixture sets instead of the built-in ones,
+	// one sub-directory per environment.
+	Dir string
+	// OnStart loads the fixtures every time the server starts.
+	OnStart bool
+	// AdminName and AdminEmail describe the user marked as admin on the
+	// first start; without AdminEmail none is created. The role grants no
+	// access by itself, see SecurityConfig.AdminToken.
+	AdminName  string
+	AdminEmail string
+}
+
+// Rate allows Count requests per Period. Requests may come in bursts of up
+// to Count; the allowance refills evenly over Period. A zero Count disables
+// the limit.
+type Rate struct {
+	Count  int
+	Period time.Duration
+}
+
+// Enabled reports whether the rate limits anything.
+func (r Rate) Enabled() bool {
+	return r.Count > 0 && r.Period > 0
+}
+
+// RateLimitConfig throttles clients of the REST API and, separately, the
+// messages they send over WebSocket connections and event streams.
+type RateLimitConfig struct {
+	Enabled bool
+	// Store is "memory", or "database" to share the limits between the
+	// nodes of a cluster through the primary database. SQLite always uses
+	// memory.
+	Store string
+
+	// IP and User limit the API requests of a client address and of a
+	// user; Groups adds limits for the routes below /api/<group>, counted
+	// per address and, in addition, per user. Users are only known from
+	// verified client certificates: sessions are handed out to anyone and
+	// never count.
+	IP     Rate
+	User   Rate
+	Groups map[string]Rate
+
+	// WSIP and WSUser limit the messages received from a client address
+	// and from a user, identified as for User, across all their
+	// connections.
+	WSIP   Rate
+	WSUser Rate
+}
+
+// DefaultCSP is the Content-Security-Policy sent by default. It allows only
+// the server's own scripts, styles and connections, which is all the built
+// front-end needs.
+const DefaultCSP = "default-src 'self'; script-src 'self'; style-src 'self'; img-src 'self' data: blob:; " +
+	"font-src 'self' data:; connect-src 'self'; object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'"
+
+// SecurityConfig controls cross-origin access, the security headers of every
+// response and the protection against cross-site request forgery.
+type SecurityConfig struct {
+	// CORSOrigins lists the origins of other sites allowed to call the API
+	// with the user's cookies, e.g. "https://admin.example.com"; patterns
+	// work as in WebSocketConfig.AllowedOrigins.
+	CORSOrigins []string
+	// CORSAllowLocalhost accepts any http(s)://localhost or loopback
+	// origin, such as a Vite dev server that is not used as a proxy.
+	CORSAllowLocalhost bool
+	// CORSMaxAge is how long browsers may cache a preflight response.
+	CORSMaxAge time.Duration
+
+	// CSP is the Content-Security-Policy header; empty omits it.
+	// CSPReportOnly sends it as Content-Security-Policy-Report-Only, which
+	// reports violations without blocking anything.
+	CSP           string
+	CSPReportOnly bool
+	// HSTSMaxAge is the max-age of Strict-Transport-Security, sent on
+	// HTTPS requests only; 0 omits the header.
+	HSTSMaxAge time.Duration
+	// FrameOptions and ReferrerPolicy are the X-Frame-Options and
+	// Referrer-Policy headers; empty omits them.
+	FrameOptions   string
+	ReferrerPolicy string
+
+	// CSRF refuses state-changing requests that a browser sends on behalf
+	// of another site, unless the site is one of CORSOrigins.
+	CSRF bool
+
+	// TrustedProxies lists the addresses or CIDR ranges of reverse proxies
+	// whose X-Forwarded-For header names the client address used for rate
+	// and connection limits, and whose X-Forwarded-Proto header decides
+	// whether HSTS is sent. Without any, the peer address is used, as
+	// clients could otherwise pick their address.
+	TrustedProxies []string
+
+	// AdminToken is the bearer token required by the administration
+	// endpoints: jobs, backups, tenants and WebSocket statistics. When it
+	// is empty they only answer requests from the local machine.
+	AdminToken string
+}
+
+// SessionConfig controls the signed cookie that gives each browser a user
+// identity of its own. Clients with a verified client certificate are
+// identified by it instead.
+type SessionConfig struct {
+	// Cookie names the session cookie.
+	Cookie string
+	// Secret signs the session cookies. When empty, a random key is
+	// generated in KeyFile and reused on later starts; nodes of a cluster
+	// need the same Secret.
+	Secret  string
+	KeyFile string
+	// MaxAge is how long a session lasts; it is renewed on use once half
+	// of it has passed.
+	MaxAge time.Duration
+}
+
+// TLSConfig enables HTTPS and, optionally, client certificates.
+type TLSConfig struct {
+	Enabled bool
+	// CertFile and KeyFile hold a PEM certificate chain and its key. When
+	// both are empty a self-signed certificate is generated in Dir and
+	// reused on later starts.
+	CertFile string
+	KeyFile  string
+	// Dir keeps the generated certificates: the self-signed server
+	// certificate and the authority issuing client certificates.
+	Dir string
+	// Hosts lists extra host names and IP addresses for the self-signed
+	// certificate, which always covers localhost, the machine's host name
+	// and its network addresses.
+	Hosts []string
+	// RedirectPort, when set, serves plain HTTP on that port and redirects
+	// every request to HTTPS.
+	RedirectPort string
+
+	// ClientCAFile holds the PEM certificates of the authorities whose
+	// client certificates are accepted; empty disables client
+	// certificates.
+	ClientCAFile string
+	// ClientAuth is "require", refusing connections without a valid
+	// client certificate, or "optional", verifying one only when given.
+	ClientAuth string
 }
 
 // Config centralises configuration used by the application runtime.
@@ -32,6 +319,16 @@ type Config struct {
 	StaticDir string
 	Database  DatabaseConfig
 	Mode      string
+	WebSocket WebSocketConfig
+	Tasks     TaskConfig
+	Backup    BackupConfig
+	Import    ImportConfig
+	Tenant    TenantConfig
+	Seed      SeedConfig
+	RateLimit RateLimitConfig
+	Security  SecurityConfig
+	Session   SessionConfig
+	TLS       TLSConfig
 }
 
 // Load reads environment variables and provides sane defaults so the
@@ -52,19 +349,189 @@ func Load() Config {
 			_ = os.MkdirAll(dataDir, 0o755)
 			dbDSN = filepath.Join(dataDir, "app.db")
 		}
-		// normalise sqlite DSN to file path syntax
-		dbDSN = fmt.Sprintf("file:%s?_busy_timeout=5000&cache=shared", filepath.ToSlash(dbDSN))
+		// normalise sqlite DSN to file path syntax; connection options are
+		// added from SQLiteConfig when the database is opened.
+		if !strings.HasPrefix(dbDSN, "file:") {
+			dbDSN = "file:" + filepath.ToSlash(dbDSN)
+		}
+	}
+
+	tenant := TenantConfig{
+		Header:      firstNonEmpty(os.Getenv("TENANT_HEADER"), "X-Tenant"),
+		BaseDomain:  os.Getenv("TENANT_BASE_DOMAIN"),
+		Cookie:      firstNonEmpty(os.Getenv("TENANT_COOKIE"), "tenant"),
+		Default:     strings.ToLower(firstNonEmpty(os.Getenv("TENANT_DEFAULT"), "default")),
+		SQLiteFiles: parseBool(os.Getenv("TENANT_SQLITE_FILES"), false),
+	}
+	sqliteReaders := parseInt(os.Getenv("SQLITE_READERS"), 4)
+	if tenant.SQLiteFiles {
+		// Tenant files are routed by replacing the connection pool, which
+		// the reader pool's resolver would bypass.
+		sqliteReaders = 0
 	}
 
 	return Config{
 		Port:      port,
 		StaticDir: staticDir,
 		Database: DatabaseConfig{
-			Type: dbType,
-			DSN:  dbDSN,
-			Log:  parseBool(os.Getenv("DB_LOG_SQL"), false),
+			Type:         dbType,
+			DSN:          dbDSN,
+			Log:          parseBool(os.Getenv("DB_LOG_SQL"), false),
+			Host:         os.Getenv("DB_HOST"),
+			Port:         parseInt(os.Getenv("DB_PORT"), 0),
+			User:         os.Getenv("DB_USER"),
+			Password:     os.Getenv("DB_PASSWORD"),
+			PasswordFile: os.Getenv("DB_PASSWORD_FILE"),
+			Name:         os.Getenv("DB_NAME"),
+			TLSMode:      strings.ToLower(os.Getenv("DB_TLS_MODE")),
+			TimeZone:     os.Getenv("DB_TIMEZONE"),
+			Charset:      os.Getenv("DB_CHARSET"),
+
+			ReplicaDSNs:       splitList(os.Getenv("DB_REPLICA_DSNS")),
+			ReplicaHosts:      splitList(os.Getenv("DB_REPLICA_HOSTS")),
+			ConnectTimeout:    parseDuration(os.Getenv("DB_CONNECT_TIMEOUT"), time.Minute),
+			HealthInterval:    parseDuration(os.Getenv("DB_HEALTH_INTERVAL"), 30*time.Second),
+			PrimaryAfterWrite: parseDuration(os.Getenv("DB_PRIMARY_AFTER_WRITE"), 5*time.Second),
+			Pool: PoolConfig{
+				MaxOpenConns:    parseInt(os.Getenv("DB_MAX_OPEN_CONNS"), 25),
+				MaxIdleConns:    parseInt(os.Getenv("DB_MAX_IDLE_CONNS"), 5),
+				ConnMaxLifetime: parseDuration(os.Getenv("DB_CONN_MAX_LIFETIME"), 30*time.Minute),
+				ConnMaxIdleTime: parseDuration(os.Getenv("DB_CONN_MAX_IDLE_TIME"), 10*time.Minute),
+			},
+			SQLite: SQLiteConfig{
+				JournalMode:      strings.ToUpper(firstNonEmpty(os.Getenv("SQLITE_JOURNAL_MODE"), "WAL")),
+				Synchronous:      strings.ToUpper(firstNonEmpty(os.Getenv("SQLITE_SYNCHRONOUS"), "NORMAL")),
+				ForeignKeys:      parseBool(os.Getenv("SQLITE_FOREIGN_KEYS"), true),
+				BusyTimeout:      parseDuration(os.Getenv("SQLITE_BUSY_TIMEOUT"), 5*time.Second),
+				MMapSize:         int64(parseInt(os.Getenv("SQLITE_MMAP_SIZE"), 128<<20)),
+				Readers:          sqliteReaders,
+				OptimizeInterval: parseDuration(os.Getenv("SQLITE_OPTIMIZE_INTERVAL"), 6*time.Hour),
+			},
+		},
+		Mode:      firstNonEmpty(os.Getenv("GIN_MODE"), "release"),
+		WebSocket: loadWebSocket(),
+		Tasks: TaskConfig{
+			Workers:     parseInt(os.Getenv("TASK_WORKERS"), 2),
+			MaxAttempts: parseInt(os.Getenv("TASK_MAX_ATTEMPTS"), 5),
+			Retention:   parseDuration(os.Getenv("TASK_RETENTION"), 7*24*time.Hour),
 		},
-		Mode: firstNonEmpty(os.Getenv("GIN_MODE"), "release"),
+		Backup: BackupConfig{
+			Dir:      firstNonEmpty(os.Getenv("BACKUP_DIR"), filepath.Join(cwd, "data", "backups")),
+			Keep:     parseInt(os.Getenv("BACKUP_KEEP"), 7),
+			Schedule: firstNonEmpty(os.Getenv("BACKUP_SCHEDULE"), "0 3 * * *"),
+		},
+		Import: ImportConfig{
+			MaxBytes: int64(parseInt(os.Getenv("IMPORT_MAX_BYTES"), 64<<20)),
+		},
+		Tenant: tenant,
+		Seed: SeedConfig{
+			Env:        strings.ToLower(firstNonEmpty(os.Getenv("SEED_ENV"), "dev")),
+			Dir:        os.Getenv("SEED_DIR"),
+			OnStart:    parseBool(os.Getenv("SEED_ON_START"), false),
+			AdminName:  firstNonEmpty(os.Getenv("ADMIN_NAME"), "Admin"),
+			AdminEmail: strings.TrimSpace(os.Getenv("ADMIN_EMAIL")),
+		},
+		RateLimit: loadRateLimit(),
+		Security: SecurityConfig{
+			CORSOrigins:        splitList(os.Getenv("CORS_ALLOWED_ORIGINS")),
+			CORSAllowLocalhost: parseBool(os.Getenv("CORS_ALLOW_LOCALHOST"), true),
+			CORSMaxAge:         parseDuration(os.Getenv("CORS_MAX_AGE"), 10*time.Minute),
+			CSP:                csp(),
+			CSPReportOnly:      parseBool(os.Getenv("CSP_REPORT_ONLY"), false),
+			HSTSMaxAge:         parseDuration(os.Getenv("HSTS_MAX_AGE"), 180*24*time.Hour),
+			FrameOptions:       headerValue(os.Getenv("FRAME_OPTIONS"), "DENY"),
+			ReferrerPolicy:     headerValue(os.Getenv("REFERRER_POLICY"), "strict-origin-when-cross-origin"),
+			CSRF:               parseBool(os.Getenv("CSRF_PROTECTION"), true),
+			TrustedProxies:     splitList(os.Getenv("TRUSTED_PROXIES")),
+			AdminToken:         strings.TrimSpace(os.Getenv("ADMIN_TOKEN")),
+		},
+		Session: SessionConfig{
+			Cookie:  firstNonEmpty(os.Getenv("SESSION_COOKIE"), "session"),
+			Secret:  os.Getenv("SESSION_SECRET"),
+			KeyFile: firstNonEmpty(os.Getenv("SESSION_KEY_FILE"), filepath.Join(cwd, "data", "session.key")),
+			MaxAge:  parseDuration(os.Getenv("SESSION_MAX_AGE"), 30*24*time.Hour),
+		},
+		TLS: TLSConfig{
+			Enabled:      parseBool(os.Getenv("TLS_ENABLED"), false),
+			CertFile:     os.Getenv("TLS_CERT_FILE"),
+			KeyFile:      os.Getenv("TLS_KEY_FILE"),
+			Dir:          firstNonEmpty(os.Getenv("TLS_DIR"), filepath.Join(cwd, "data", "tls")),
+			Hosts:        splitList(os.Getenv("TLS_HOSTS")),
+			RedirectPort: os.Getenv("TLS_REDIRECT_PORT"),
+			ClientCAFile: os.Getenv("TLS_CLIENT_CA_FILE"),
+			ClientAuth:   strings.ToLower(firstNonEmpty(os.Getenv("TLS_CLIENT_AUTH"), "require")),
+		},
+	}
+}
+
+// csp returns CSP_POLICY, or DefaultCSP with the sources of CSP_EXTRA_SOURCES
+// (e.g. "http://localhost:5173 ws://localhost:5173") allowed for scripts,
+// styles and connections.
+func csp() string {
+	policy := headerValue(os.Getenv("CSP_POLICY"), DefaultCSP)
+	extra := strings.Join(strings.Fields(os.Getenv("CSP_EXTRA_SOURCES")), " ")
+	if policy == "" || extra == "" {
+		return policy
+	}
+	directives := strings.Split(policy, ";")
+	for i, d := range directives {
+		name, _, _ := strings.Cut(strings.TrimSpace(d), " ")
+		switch name {
+		case "script-src", "style-src", "connect-src", "img-src", "font-src":
+			directives[i] = strings.TrimRight(d, " ") + " " + extra
+		}
+	}
+	return strings.Join(directives, ";")
+}
+
+// headerValue is firstNonEmpty for optional headers: "off" or "none"
+// omits the header.
+func headerValue(value, fallback string) string {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "":
+		return fallback
+	case "off", "none":
+		return ""
+	}
+	return strings.TrimSpace(value)
+}
+
+func loadRateLimit() RateLimitConfig {
+	groups := make(map[string]Rate)
+	for _, item := range splitList(firstNonEmpty(os.Getenv("RATE_LIMIT_GROUPS"), "search=60/1m")) {
+		name, rate, ok := strings.Cut(item, "=")
+		if !ok {
+			continue
+		}
+		groups[strings.ToLower(strings.TrimSpace(name))] = parseRate(rate, Rate{})
+	}
+	return RateLimitConfig{
+		Enabled: parseBool(os.Getenv("RATE_LIMIT_ENABLED"), true),
+		Store:   strings.ToLower(firstNonEmpty(os.Getenv("RATE_LIMIT_STORE"), "memory")),
+		IP:      parseRate(os.Getenv("RATE_LIMIT_IP"), Rate{Count: 600, Period: time.Minute}),
+		User:    parseRate(os.Getenv("RATE_LIMIT_USER"), Rate{Count: 300, Period: time.Minute}),
+		Groups:  groups,
+		WSIP:    parseRate(os.Getenv("RATE_LIMIT_WS_IP"), Rate{Count: 50, Period: time.Second}),
+		WSUser:  parseRate(os.Getenv("RATE_LIMIT_WS_USER"), Rate{Count: 20, Period: time.Second}),
+	}
+}
+
+func loadWebSocket() WebSocketConfig {
+	return WebSocketConfig{
+		AllowedOrigins:        splitList(os.Getenv("WS_ALLOWED_ORIGINS")),
+		AllowLocalhost:        parseBool(os.Getenv("WS_ALLOW_LOCALHOST"), true),
+		MaxConnections:        parseInt(os.Getenv("WS_MAX_CONNECTIONS"), 1000),
+		MaxConnectionsPerIP:   parseInt(os.Getenv("WS_MAX_CONNECTIONS_PER_IP"), 50),
+		MaxConnectionsPerUser: parseInt(os.Getenv("WS_MAX_CONNECTIONS_PER_USER"), 20),
+		ReadLimit:             int64(parseInt(os.Getenv("WS_READ_LIMIT"), 5120)),
+		MessageRate:           parseRate(os.Getenv("WS_MESSAGE_RATE"), Rate{Count: 40, Period: time.Second}),
+		ReadBufferSize:        parseInt(os.Getenv("WS_READ_BUFFER_SIZE"), 4096),
+		WriteBufferSize:       parseInt(os.Getenv("WS_WRITE_BUFFER_SIZE"), 4096),
+		PongWait:              parseDuration(os.Getenv("WS_PONG_WAIT"), 60*time.Second),
+		PingInterval:          parseDuration(os.Getenv("WS_PING_INTERVAL"), 30*time.Second),
+		WriteWait:             parseDuration(os.Getenv("WS_WRITE_WAIT"), 10*time.Second),
+		SendQueueSize:         parseInt(os.Getenv("WS_SEND_QUEUE_SIZE"), 16),
+		Backpressure:          firstNonEmpty(os.Getenv("WS_BACKPRESSURE"), "disconnect"),
 	}
 }
 
@@ -93,3 +560,58 @@ func parseBool(value string, fallback bool) bool {
 		return fallback
 	}
 }
+
+func parseInt(value string, fallback int) int {
+	v, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+func parseDuration(value string, fallback time.Duration) time.Duration {
+	v, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+// parseRate reads "<count>/<period>", e.g. "600/1m" or "20/s"; "0" or
+// "off" disables the limit.
+func parseRate(value string, fallback Rate) Rate {
+	value = strings.TrimSpace(value)
+	switch strings.ToLower(value) {
+	case "":
+		return fallback
+	case "0", "off", "none":
+		return Rate{}
+	}
+	count, period, ok := strings.Cut(value, "/")
+	if !ok {
+		return fallback
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(count))
+	if err != nil || n < 0 {
+		return fallback
+	}
+	period = strings.TrimSpace(period)
+	if period != "" && (period[0] < '0' || period[0] > '9') {
+		period = "1" + period
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return fallback
+	}
+	return Rate{Count: n, Period: d}
+}
+
+func splitList(value string) []string {
+	var out []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
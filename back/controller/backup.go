@@ -0,0 +1,141 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wonderfulsuccess/go-web-app/back/backup"
+	"github.com/wonderfulsuccess/go-web-app/back/openapi"
+)
+
+// BackupController exposes database backups for administration.
+type BackupController struct {
+	backups *backup.Manager
+}
+
+func NewBackupController(backups *backup.Manager) *BackupController {
+	return &BackupController{backups: backups}
+}
+
+func (bc *BackupController) RegisterRoutes(group *gin.RouterGroup) {
+	group.GET("", bc.List)
+	group.POST("", bc.Create)
+	group.GET(":name", bc.Download)
+	group.DELETE(":name", bc.Delete)
+	group.POST(":name/verify", bc.Verify)
+	group.POST(":name/restore", bc.Restore)
+}
+
+// Operations documents the routes added by RegisterRoutes.
+func (bc *BackupController) Operations() []openapi.Operation {
+	tags := []string{"backups"}
+	notFound := openapi.Response{Status: http.StatusNotFound, Body: ErrorResponse{}}
+	conflict := openapi.Response{Status: http.StatusConflict, Description: "Another backup or restore is running, or tenants are kept in SQLite files of their own", Body: ErrorResponse{}}
+	serverError := openapi.Response{Status: http.StatusInternalServerError, Body: ErrorResponse{}}
+
+	return []openapi.Operation{
+		{
+			ID: "listBackups", Method: http.MethodGet, Path: "", Summary: "List database backups, newest first", Tags: tags,
+			Responses: []openapi.Response{{Status: http.StatusOK, Body: []backup.Info{}}, serverError},
+		},
+		{
+			ID: "createBackup", Method: http.MethodPost, Path: "", Summary: "Back up the database now", Tags: tags,
+			Description: "Takes a consistent online backup and prunes backups beyond BACKUP_KEEP.",
+			Responses:   []openapi.Response{{Status: http.StatusCreated, Body: backup.Info{}}, conflict, serverError},
+		},
+		{
+			ID: "downloadBackup", Method: http.MethodGet, Path: ":name", Summary: "Download a backup", Tags: tags,
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Description: "The gzip compressed backup", Body: openapi.File{}, ContentType: "application/gzip"},
+				notFound,
+			},
+		},
+		{
+			ID: "deleteBackup", Method: http.MethodDelete, Path: ":name", Summary: "Delete a backup", Tags: tags,
+			Responses: []openapi.Response{{Status: http.StatusNoContent}, notFound, serverError},
+		},
+		{
+			ID: "verifyBackup", Method: http.MethodPost, Path: ":name/verify", Summary: "Check a backup", Tags: tags,
+			Description: "SQLite backups are checked with PRAGMA integrity_check, dumps for a complete trailer.",
+			Responses:   []openapi.Response{{Status: http.StatusOK, Body: backup.Verification{}}, notFound, serverError},
+		},
+		{
+			ID: "restoreBackup", Method: http.MethodPost, Path: ":name/restore", Summary: "Restore the database from a backup", Tags: tags,
+			Description: "Verifies the backup, saves the current database as a pre-restore backup, then restores while the server keeps running.",
+			Responses: []openapi.Response{
+				{Status: http.StatusNoContent},
+				notFound,
+				conflict,
+				{Status: http.StatusUnprocessableEntity, Description: "The backup failed verification", Body: ErrorResponse{}},
+				serverError,
+			},
+		},
+	}
+}
+
+func (bc *BackupController) List(c *gin.Context) {
+	list, err := bc.backups.List()
+	if err != nil {
+		respondBackupError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+func (bc *BackupController) Create(c *gin.Context) {
+	info, err := bc.backups.Backup(c.Request.Context(), "")
+	if err != nil {
+		respondBackupError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, info)
+}
+
+func (bc *BackupController) Download(c *gin.Context) {
+	path, err := bc.backups.Path(c.Param("name"))
+	if err != nil {
+		respondBackupError(c, err)
+		return
+	}
+	c.FileAttachment(path, c.Param("name"))
+}
+
+func (bc *BackupController) Delete(c *gin.Context) {
+	if err := bc.backups.Delete(c.Param("name")); err != nil {
+		respondBackupError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (bc *BackupController) Verify(c *gin.Context) {
+	result, err := bc.backups.Verify(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		respondBackupError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (bc *BackupController) Restore(c *gin.Context) {
+	if err := bc.backups.Restore(c.Request.Context(), c.Param("name")); err != nil {
+		respondBackupError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func respondBackupError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, backup.ErrNotFound):
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, err.Error())
+	case errors.Is(err, backup.ErrBusy), errors.Is(err, backup.ErrTenantFiles):
+		respondError(c, http.StatusConflict, ErrCodeConflict, err.Error())
+	case errors.Is(err, backup.ErrInvalid):
+		respondError(c, http.StatusUnprocessableEntity, ErrCodeInvalidRequest, err.Error())
+	default:
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+	}
+}
@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wonderfulsuccess/go-web-app/back/openapi"
+	"github.com/wonderfulsuccess/go-web-app/back/scheduler"
+)
+
+// JobController exposes the scheduled jobs for administration.
+type JobController struct {
+	jobs *scheduler.Scheduler
+}
+
+func NewJobController(jobs *scheduler.Scheduler) *JobController {
+	return &JobController{jobs: jobs}
+}
+
+func (jc *JobController) RegisterRoutes(group *gin.RouterGroup) {
+	group.GET("", jc.List)
+	group.GET(":name", jc.Get)
+	group.POST(":name/run", jc.Run)
+	group.POST(":name/enable", jc.Enable)
+	group.POST(":name/disable", jc.Disable)
+}
+
+// Operations documents the routes added by RegisterRoutes.
+func (jc *JobController) Operations() []openapi.Operation {
+	tags := []string{"jobs"}
+	notFound := openapi.Response{Status: http.StatusNotFound, Body: ErrorResponse{}}
+
+	return []openapi.Operation{
+		{
+			ID: "listJobs", Method: http.MethodGet, Path: "", Summary: "List scheduled jobs", Tags: tags,
+			Responses: []openapi.Response{{Status: http.StatusOK, Body: []scheduler.JobStatus{}}},
+		},
+		{
+			ID: "getJob", Method: http.MethodGet, Path: ":name", Summary: "Get a scheduled job", Tags: tags,
+			Responses: []openapi.Response{{Status: http.StatusOK, Body: scheduler.JobStatus{}}, notFound},
+		},
+		{
+			ID: "runJob", Method: http.MethodPost, Path: ":name/run", Summary: "Run a job now", Tags: tags,
+			Responses: []openapi.Response{
+				{Status: http.StatusAccepted, Body: scheduler.JobStatus{}},
+				notFound,
+				{Status: http.StatusConflict, Description: "The job is already running", Body: ErrorResponse{}},
+			},
+		},
+		{
+			ID: "enableJob", Method: http.MethodPost, Path: ":name/enable", Summary: "Enable a job's schedule", Tags: tags,
+			Responses: []openapi.Response{{Status: http.StatusOK, Body: scheduler.JobStatus{}}, notFound},
+		},
+		{
+			ID: "disableJob", Method: http.MethodPost, Path: ":name/disable", Summary: "Disable a job's schedule", Tags: tags,
+			Responses: []openapi.Response{{Status: http.StatusOK, Body: scheduler.JobStatus{}}, notFound},
+		},
+	}
+}
+
+func (jc *JobController) List(c *gin.Context) {
+	c.JSON(http.StatusOK, jc.jobs.List())
+}
+
+func (jc *JobController) Get(c *gin.Context) {
+	status, err := jc.jobs.Get(c.Param("name"))
+	if err != nil {
+		respondJobError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+func (jc *JobController) Run(c *gin.Context) {
+	name := c.Param("name")
+	if err := jc.jobs.Trigger(name); err != nil {
+		respondJobError(c, err)
+		return
+	}
+	status, _ := jc.jobs.Get(name)
+	c.JSON(http.StatusAccepted, status)
+}
+
+func (jc *JobController) Enable(c *gin.Context) {
+	jc.setEnabled(c, true)
+}
+
+func (jc *JobController) Disable(c *gin.Context) {
+	jc.setEnabled(c, false)
+}
+
+func (jc *JobController) setEnabled(c *gin.Context, enabled bool) {
+	status, err := jc.jobs.SetEnabled(c.Param("name"), enabled)
+	if err != nil {
+		respondJobError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+func respondJobError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, scheduler.ErrNotFound):
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, err.Error())
+	case errors.Is(err, scheduler.ErrRunning):
+		respondError(c, http.StatusConflict, ErrCodeConflict, err.Error())
+	default:
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+	}
+}
@@ -0,0 +1,45 @@
+package controller
+
+import "github.com/gin-gonic/gin"
+
+// ErrorCode is a stable, machine-readable identifier for a failure so clients
+// do not need to parse the human readable message.
+type ErrorCode string
+
+const (
+	// ErrCodeInvalidRequest means the path, query or body could not be parsed.
+	ErrCodeInvalidRequest ErrorCode = "invalid_request"
+	// ErrCodeNotFound means the addressed record does not exist.
+	ErrCodeNotFound ErrorCode = "not_found"
+	// ErrCodeConflict means the request clashes with the current state, e.g.
+	// running a job that is still running.
+	ErrCodeConflict ErrorCode = "conflict"
+	// ErrCodeInternal means the server failed to complete the request.
+	ErrCodeInternal ErrorCode = "internal_error"
+	// ErrCodeForbidden means the request was refused regardless of its
+	// content, e.g. a cross-site request from another web site.
+	ErrCodeForbidden ErrorCode = "forbidden"
+	// ErrCodeRateLimited means the client sent too many requests; retry
+	// after the number of seconds in the Retry-After header.
+	ErrCodeRateLimited ErrorCode = "rate_limited"
+	// ErrCodeUnauthorized means the request lacks valid credentials, e.g.
+	// the admin token of an administration endpoint.
+	ErrCodeUnauthorized ErrorCode = "unauthorized"
+	// ErrCodeTooLarge means the request body exceeds the configured limit.
+	ErrCodeTooLarge ErrorCode = "too_large"
+)
+
+// ErrorCodes lists every ErrorCode, in declaration order, for code generators.
+func ErrorCodes() []ErrorCode {
+	return []ErrorCode{ErrCodeInvalidRequest, ErrCodeNotFound, ErrCodeConflict, ErrCodeInternal, ErrCodeForbidden, ErrCodeRateLimited, ErrCodeUnauthorized, ErrCodeTooLarge}
+}
+
+// ErrorResponse is the body returned by every handler on failure.
+type ErrorResponse struct {
+	Error string    `json:"error"`
+	Code  ErrorCode `json:"code"`
+}
+
+func respondError(c *gin.Context, status int, code ErrorCode, message string) {
+	c.JSON(status, ErrorResponse{Error: message, Code: code})
+}
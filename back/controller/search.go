@@ -0,0 +1,113 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wonderfulsuccess/go-web-app/back/model"
+	"github.com/wonderfulsuccess/go-web-app/back/openapi"
+	"github.com/wonderfulsuccess/go-web-app/back/search"
+	"github.com/wonderfulsuccess/go-web-app/back/tasks"
+	"github.com/wonderfulsuccess/go-web-app/back/tenant"
+)
+
+// SearchReindexTask is the task kind that rebuilds search indexes; its
+// payload is a SearchReindexInput.
+const SearchReindexTask = "search.reindex"
+
+// SearchController exposes full-text search over the registered indexes.
+type SearchController struct {
+	engine *search.Engine
+	queue  *tasks.Queue
+}
+
+// SearchReindexInput selects the index to rebuild; an empty kind rebuilds
+// every index.
+type SearchReindexInput struct {
+	Kind string `json:"kind"`
+}
+
+func NewSearchController(engine *search.Engine, queue *tasks.Queue) *SearchController {
+	return &SearchController{engine: engine, queue: queue}
+}
+
+func (sc *SearchController) RegisterRoutes(group *gin.RouterGroup) {
+	group.GET("", sc.Search)
+	group.POST("reindex", sc.Reindex)
+}
+
+// Operations documents the routes added by RegisterRoutes.
+func (sc *SearchController) Operations() []openapi.Operation {
+	tags := []string{"search"}
+	badRequest := openapi.Response{Status: http.StatusBadRequest, Body: ErrorResponse{}}
+	serverError := openapi.Response{Status: http.StatusInternalServerError, Body: ErrorResponse{}}
+
+	return []openapi.Operation{
+		{
+			ID: "search", Method: http.MethodGet, Path: "", Summary: "Full-text search", Tags: tags,
+			Description: "Every word must match, by prefix; Chinese, Japanese and Korean text matches consecutive characters. " +
+				"Hits are ranked by relevance and carry HTML-escaped highlights with matches wrapped in <mark>.",
+			Params: []openapi.Param{
+				{Name: "q", In: "query", Required: true, Description: "Search text"},
+				{Name: "kinds", In: "query", Description: "Comma separated indexes to search, e.g. user; all when omitted"},
+				{Name: "limit", In: "query", Description: "Maximum number of hits, default 20, at most 100"},
+				{Name: "offset", In: "query", Description: "Number of hits to skip"},
+			},
+			Responses: []openapi.Response{{Status: http.StatusOK, Body: search.Results{}}, badRequest, serverError},
+		},
+		{
+			ID: "reindexSearch", Method: http.MethodPost, Path: "reindex", Summary: "Rebuild search indexes in the background", Tags: tags,
+			Description: "task.update progress messages go to the user of the request's session.",
+			Request:     SearchReindexInput{},
+			Responses:   []openapi.Response{{Status: http.StatusAccepted, Body: model.Task{}}, badRequest, serverError},
+		},
+	}
+}
+
+func (sc *SearchController) Search(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	offset, _ := strconv.Atoi(c.Query("offset"))
+	query := search.Query{Text: c.Query("q"), Limit: limit, Offset: offset}
+	for _, kind := range strings.Split(c.Query("kinds"), ",") {
+		if kind = strings.TrimSpace(kind); kind != "" {
+			query.Kinds = append(query.Kinds, kind)
+		}
+	}
+
+	results, err := sc.engine.Search(c.Request.Context(), query)
+	if err != nil {
+		if errors.Is(err, search.ErrEmptyQuery) || errors.Is(err, search.ErrUnknownKind) {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, results)
+}
+
+func (sc *SearchController) Reindex(c *gin.Context) {
+	var input SearchReindexInput
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+			return
+		}
+	}
+	if input.Kind != "" && !contains(sc.engine.Kinds(), input.Kind) {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, search.ErrUnknownKind.Error()+" "+strconv.Quote(input.Kind))
+		return
+	}
+
+	tenantID, _ := tenant.FromContext(c.Request.Context())
+	task, err := sc.queue.Enqueue(SearchReindexTask, input, tasks.EnqueueOptions{Submitter: requestUser(c), Tenant: tenantID})
+	if err != nil {
+		respondTaskError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, task)
+}
@@ -0,0 +1,175 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wonderfulsuccess/go-web-app/back/model"
+	"github.com/wonderfulsuccess/go-web-app/back/openapi"
+	"github.com/wonderfulsuccess/go-web-app/back/session"
+	"github.com/wonderfulsuccess/go-web-app/back/tasks"
+	"github.com/wonderfulsuccess/go-web-app/back/tenant"
+)
+
+// TaskController exposes the background task queue.
+type TaskController struct {
+	queue *tasks.Queue
+}
+
+// TaskInput is the request body accepted by Create.
+type TaskInput struct {
+	Kind    string          `json:"kind"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+func NewTaskController(queue *tasks.Queue) *TaskController {
+	return &TaskController{queue: queue}
+}
+
+func (tc *TaskController) RegisterRoutes(group *gin.RouterGroup) {
+	group.GET("", tc.List)
+	group.POST("", tc.Create)
+	group.GET("kinds", tc.Kinds)
+	group.GET(":id", tc.Get)
+	group.POST(":id/cancel", tc.Cancel)
+	group.POST(":id/retry", tc.Retry)
+}
+
+// Operations documents the routes added by RegisterRoutes.
+func (tc *TaskController) Operations() []openapi.Operation {
+	tags := []string{"tasks"}
+	notFound := openapi.Response{Status: http.StatusNotFound, Body: ErrorResponse{}}
+	badRequest := openapi.Response{Status: http.StatusBadRequest, Body: ErrorResponse{}}
+	conflict := openapi.Response{Status: http.StatusConflict, Body: ErrorResponse{}}
+	serverError := openapi.Response{Status: http.StatusInternalServerError, Body: ErrorResponse{}}
+
+	return []openapi.Operation{
+		{
+			ID: "listTasks", Method: http.MethodGet, Path: "", Summary: "List recent tasks", Tags: tags,
+			Params: []openapi.Param{
+				{Name: "status", In: "query", Description: "queued, running, succeeded, dead or canceled"},
+				{Name: "kind", In: "query", Description: "Task kind"},
+				{Name: "limit", In: "query", Description: "Maximum number of tasks, default 100"},
+			},
+			Responses: []openapi.Response{{Status: http.StatusOK, Body: []model.Task{}}, serverError},
+		},
+		{
+			ID: "createTask", Method: http.MethodPost, Path: "", Summary: "Enqueue a task", Tags: tags, Request: TaskInput{},
+			Description: "task.update progress messages go to the user of the request's session.",
+			Responses:   []openapi.Response{{Status: http.StatusAccepted, Body: model.Task{}}, badRequest, serverError},
+		},
+		{
+			ID: "listTaskKinds", Method: http.MethodGet, Path: "kinds", Summary: "List task kinds that can be enqueued", Tags: tags,
+			Responses: []openapi.Response{{Status: http.StatusOK, Body: []string{}}},
+		},
+		{
+			ID: "getTask", Method: http.MethodGet, Path: ":id", Summary: "Get a task", Tags: tags,
+			Responses: []openapi.Response{{Status: http.StatusOK, Body: model.Task{}}, badRequest, notFound, serverError},
+		},
+		{
+			ID: "cancelTask", Method: http.MethodPost, Path: ":id/cancel", Summary: "Cancel a queued or running task", Tags: tags,
+			Responses: []openapi.Response{{Status: http.StatusOK, Body: model.Task{}}, badRequest, notFound, conflict, serverError},
+		},
+		{
+			ID: "retryTask", Method: http.MethodPost, Path: ":id/retry", Summary: "Requeue a dead or canceled task", Tags: tags,
+			Responses: []openapi.Response{{Status: http.StatusAccepted, Body: model.Task{}}, badRequest, notFound, conflict, serverError},
+		},
+	}
+}
+
+func (tc *TaskController) List(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	tenantID, _ := tenant.FromContext(c.Request.Context())
+	list, err := tc.queue.List(tasks.ListFilter{
+		Status: model.TaskStatus(c.Query("status")),
+		Kind:   c.Query("kind"),
+		Tenant: tenantID,
+		Limit:  limit,
+	})
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+func (tc *TaskController) Create(c *gin.Context) {
+	var input TaskInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	payload := input.Payload
+	if len(payload) == 0 {
+		payload = json.RawMessage("null")
+	}
+
+	tenantID, _ := tenant.FromContext(c.Request.Context())
+	task, err := tc.queue.Enqueue(input.Kind, payload, tasks.EnqueueOptions{Submitter: requestUser(c), Tenant: tenantID})
+	if err != nil {
+		respondTaskError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, task)
+}
+
+func (tc *TaskController) Kinds(c *gin.Context) {
+	c.JSON(http.StatusOK, tc.queue.Kinds())
+}
+
+func (tc *TaskController) Get(c *gin.Context) {
+	tc.withID(c, http.StatusOK, tc.queue.Get)
+}
+
+func (tc *TaskController) Cancel(c *gin.Context) {
+	tc.withID(c, http.StatusOK, tc.queue.Cancel)
+}
+
+func (tc *TaskController) Retry(c *gin.Context) {
+	tc.withID(c, http.StatusAccepted, tc.queue.Retry)
+}
+
+func (tc *TaskController) withID(c *gin.Context, status int, op func(uint) (model.Task, error)) {
+	id, err := parseID(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	// Tasks of other tenants do not exist as far as this request knows.
+	if tenantID, ok := tenant.FromContext(c.Request.Context()); ok {
+		if task, err := tc.queue.Get(id); err == nil && task.TenantID != tenantID {
+			respondTaskError(c, tasks.ErrNotFound)
+			return
+		}
+	}
+	task, err := op(id)
+	if err != nil {
+		respondTaskError(c, err)
+		return
+	}
+	c.JSON(status, task)
+}
+
+// requestUser returns the user of the request's session or client
+// certificate, who receives the progress of the tasks it submits.
+func requestUser(c *gin.Context) string {
+	user, _ := session.UserFromContext(c.Request.Context())
+	return user
+}
+
+func respondTaskError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, tasks.ErrNotFound):
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, err.Error())
+	case errors.Is(err, tasks.ErrUnknownKind):
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+	case errors.Is(err, tasks.ErrFinished), errors.Is(err, tasks.ErrNotRetryable):
+		respondError(c, http.StatusConflict, ErrCodeConflict, err.Error())
+	default:
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+	}
+}
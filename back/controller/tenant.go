@@ -0,0 +1,164 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wonderfulsuccess/go-web-app/back/model"
+	"github.com/wonderfulsuccess/go-web-app/back/openapi"
+	"github.com/wonderfulsuccess/go-web-app/back/tenant"
+)
+
+// tenantCookieMaxAge keeps a browser's tenant choice for a year.
+const tenantCookieMaxAge = 365 * 24 * 60 * 60
+
+// TenantController administers tenants and lets a browser pick one.
+type TenantController struct {
+	tenants *tenant.Manager
+}
+
+// TenantInput is the request body accepted by Create.
+type TenantInput struct {
+	// Slug names the tenant in subdomains, the X-Tenant header and the
+	// tenant cookie: lowercase letters, digits and hyphens.
+	Slug string `json:"slug"`
+	Name string `json:"name"`
+}
+
+// TenantSelection is the request body accepted by Select.
+type TenantSelection struct {
+	Slug string `json:"slug"`
+}
+
+func NewTenantController(tenants *tenant.Manager) *TenantController {
+	return &TenantController{tenants: tenants}
+}
+
+func (tc *TenantController) RegisterRoutes(group *gin.RouterGroup) {
+	group.GET("", tc.List)
+	group.POST("", tc.Create)
+	group.GET("current", tc.Current)
+	group.PUT("current", tc.Select)
+	group.DELETE(":id", tc.Delete)
+}
+
+// Operations documents the routes added by RegisterRoutes.
+func (tc *TenantController) Operations() []openapi.Operation {
+	tags := []string{"tenants"}
+	notFound := openapi.Response{Status: http.StatusNotFound, Body: ErrorResponse{}}
+	badRequest := openapi.Response{Status: http.StatusBadRequest, Body: ErrorResponse{}}
+	serverError := openapi.Response{Status: http.StatusInternalServerError, Body: ErrorResponse{}}
+
+	return []openapi.Operation{
+		{
+			ID: "listTenants", Method: http.MethodGet, Path: "", Summary: "List tenants", Tags: tags,
+			Responses: []openapi.Response{{Status: http.StatusOK, Body: []model.Tenant{}}, serverError},
+		},
+		{
+			ID: "createTenant", Method: http.MethodPost, Path: "", Summary: "Create a tenant", Tags: tags, Request: TenantInput{},
+			Responses: []openapi.Response{
+				{Status: http.StatusCreated, Body: model.Tenant{}},
+				badRequest,
+				{Status: http.StatusConflict, Description: "The slug is taken", Body: ErrorResponse{}},
+				serverError,
+			},
+		},
+		{
+			ID: "getCurrentTenant", Method: http.MethodGet, Path: "current", Summary: "Get the tenant of this request", Tags: tags,
+			Description: "Resolved from the X-Tenant header, the subdomain, the tenant cookie or the default tenant, in that order.",
+			Responses:   []openapi.Response{{Status: http.StatusOK, Body: model.Tenant{}}, notFound, serverError},
+		},
+		{
+			ID: "selectTenant", Method: http.MethodPut, Path: "current", Summary: "Choose the tenant of later requests", Tags: tags,
+			Description: "Sets the tenant cookie, which applies to requests without an X-Tenant header or tenant subdomain.",
+			Request:     TenantSelection{},
+			Responses:   []openapi.Response{{Status: http.StatusOK, Body: model.Tenant{}}, badRequest, notFound, serverError},
+		},
+		{
+			ID: "deleteTenant", Method: http.MethodDelete, Path: ":id", Summary: "Delete a tenant and all of its data", Tags: tags,
+			Responses: []openapi.Response{
+				{Status: http.StatusNoContent},
+				badRequest,
+				notFound,
+				{Status: http.StatusConflict, Description: "The default tenant cannot be deleted", Body: ErrorResponse{}},
+				serverError,
+			},
+		},
+	}
+}
+
+func (tc *TenantController) List(c *gin.Context) {
+	list, err := tc.tenants.List(c.Request.Context())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+func (tc *TenantController) Create(c *gin.Context) {
+	var input TenantInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	t, err := tc.tenants.Create(c.Request.Context(), input.Slug, input.Name)
+	if err != nil {
+		respondTenantError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, t)
+}
+
+func (tc *TenantController) Current(c *gin.Context) {
+	t, err := tc.tenants.FromRequest(c.Request)
+	if err != nil {
+		respondTenantError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, t)
+}
+
+func (tc *TenantController) Select(c *gin.Context) {
+	var input TenantSelection
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	t, err := tc.tenants.Resolve(c.Request.Context(), input.Slug)
+	if err != nil {
+		respondTenantError(c, err)
+		return
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(tc.tenants.Cookie(), t.Slug, tenantCookieMaxAge, "/", "", false, true)
+	c.JSON(http.StatusOK, t)
+}
+
+func (tc *TenantController) Delete(c *gin.Context) {
+	id, err := parseID(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	if err := tc.tenants.Delete(c.Request.Context(), id); err != nil {
+		respondTenantError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func respondTenantError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, tenant.ErrNotFound):
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, err.Error())
+	case errors.Is(err, tenant.ErrInvalidSlug):
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+	case errors.Is(err, tenant.ErrExists), errors.Is(err, tenant.ErrDefault):
+		respondError(c, http.StatusConflict, ErrCodeConflict, err.Error())
+	default:
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+	}
+}
@@ -7,30 +7,105 @@ import (
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 
+	"github.com/wonderfulsuccess/go-web-app/back/database"
 	"github.com/wonderfulsuccess/go-web-app/back/model"
+	"github.com/wonderfulsuccess/go-web-app/back/openapi"
 )
 
 // UserController contains CRUD handlers for the User model.
 type UserController struct {
 	db *gorm.DB
+	// maxImportBytes caps the body of Import; 0 means no limit.
+	maxImportBytes int64
 }
 
-func NewUserController(db *gorm.DB) *UserController {
-	return &UserController{db: db}
+// UserInput is the request body accepted by Create and Update.
+type UserInput struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+func NewUserController(db *gorm.DB, maxImportBytes int64) *UserController {
+	return &UserController{db: db, maxImportBytes: maxImportBytes}
 }
 
 func (uc *UserController) RegisterRoutes(group *gin.RouterGroup) {
 	group.GET("", uc.List)
 	group.POST("", uc.Create)
+	group.POST("import", uc.Import)
+	group.GET("export", uc.Export)
 	group.GET(":id", uc.Get)
 	group.PUT(":id", uc.Update)
 	group.DELETE(":id", uc.Delete)
 }
 
+// Operations documents the routes added by RegisterRoutes.
+func (uc *UserController) Operations() []openapi.Operation {
+	tags := []string{"users"}
+	notFound := openapi.Response{Status: http.StatusNotFound, Body: ErrorResponse{}}
+	badRequest := openapi.Response{Status: http.StatusBadRequest, Body: ErrorResponse{}}
+	serverError := openapi.Response{Status: http.StatusInternalServerError, Body: ErrorResponse{}}
+	filters := []openapi.Param{
+		{Name: "q", In: "query", Description: "Case-insensitive match on name or email"},
+		{Name: "role", In: "query", Description: "Exact role"},
+	}
+
+	return []openapi.Operation{
+		{
+			ID: "listUsers", Method: http.MethodGet, Path: "", Summary: "List users", Tags: tags, Params: filters,
+			Responses: []openapi.Response{{Status: http.StatusOK, Body: []model.User{}}, serverError},
+		},
+		{
+			ID: "importUsers", Method: http.MethodPost, Path: "import", Summary: "Import users from CSV, JSON Lines or XLSX", Tags: tags,
+			Description: "Upserts users by email. The file is the raw request body or the \"file\" part of a multipart form; " +
+				"CSV and XLSX take column names from the first row.",
+			Request: openapi.File{}, RequestContentType: "application/octet-stream",
+			Params: []openapi.Param{
+				{Name: "format", In: "query", Description: "csv, jsonl or xlsx; detected from the content type or file name when omitted"},
+				{Name: "mapping", In: "query", Description: `JSON object mapping source columns to fields, e.g. {"E-mail":"email"}`},
+				{Name: "mode", In: "query", Description: "transactional (default, all or nothing) or best-effort"},
+				{Name: "dryRun", In: "query", Description: "Validate and report without saving"},
+			},
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Body: UserImportResult{}},
+				badRequest,
+				{Status: http.StatusRequestEntityTooLarge, Description: "The file exceeds IMPORT_MAX_BYTES", Body: ErrorResponse{}},
+				{Status: http.StatusUnprocessableEntity, Description: "Some rows failed", Body: UserImportResult{}},
+				serverError,
+			},
+		},
+		{
+			ID: "exportUsers", Method: http.MethodGet, Path: "export", Summary: "Export users as CSV, JSON Lines or XLSX", Tags: tags,
+			Params: append([]openapi.Param{{Name: "format", In: "query", Description: "csv (default), jsonl or xlsx"}}, filters...),
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Description: "The exported file", Body: openapi.File{}, ContentType: "application/octet-stream"},
+				badRequest,
+			},
+		},
+		{
+			ID: "createUser", Method: http.MethodPost, Path: "", Summary: "Create a user", Tags: tags, Request: UserInput{},
+			Responses: []openapi.Response{{Status: http.StatusCreated, Body: model.User{}}, badRequest, serverError},
+		},
+		{
+			ID: "getUser", Method: http.MethodGet, Path: ":id", Summary: "Get a user", Tags: tags,
+			Responses: []openapi.Response{{Status: http.StatusOK, Body: model.User{}}, badRequest, notFound, serverError},
+		},
+		{
+			ID: "updateUser", Method: http.MethodPut, Path: ":id", Summary: "Update a user", Tags: tags, Request: UserInput{},
+			Responses: []openapi.Response{{Status: http.StatusOK, Body: model.User{}}, badRequest, notFound, serverError},
+		},
+		{
+			ID: "deleteUser", Method: http.MethodDelete, Path: ":id", Summary: "Delete a user", Tags: tags,
+			Responses: []openapi.Response{{Status: http.StatusNoContent}, badRequest, notFound, serverError},
+		},
+	}
+}
+
 func (uc *UserController) List(c *gin.Context) {
 	var users []model.User
-	if err := uc.db.Find(&users).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := filterUsers(uc.db, c).Find(&users).Error; err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
 		return
 	}
 	c.JSON(http.StatusOK, users)
@@ -39,17 +114,17 @@ func (uc *UserController) List(c *gin.Context) {
 func (uc *UserController) Get(c *gin.Context) {
 	id, err := parseID(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
 		return
 	}
 
 	var user model.User
-	if err := uc.db.First(&user, id).Error; err != nil {
+	if err := uc.db.WithContext(c.Request.Context()).First(&user, id).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			respondError(c, http.StatusNotFound, ErrCodeNotFound, "user not found")
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
 		return
 	}
 
@@ -57,58 +132,72 @@ func (uc *UserController) Get(c *gin.Context) {
 }
 
 func (uc *UserController) Create(c *gin.Context) {
-	var payload model.User
-	if err := c.ShouldBindJSON(&payload); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	var input UserInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
 		return
 	}
 
-	if err := uc.db.Create(&payload).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	user := model.User{Name: input.Name, Email: input.Email, Role: input.Role}
+	if err := uc.db.WithContext(c.Request.Context()).Create(&user).Error; err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
 		return
 	}
 
-	c.JSON(http.StatusCreated, payload)
+	c.JSON(http.StatusCreated, user)
 }
 
 func (uc *UserController) Update(c *gin.Context) {
 	id, err := parseID(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
 		return
 	}
 
-	var payload model.User
-	if err := c.ShouldBindJSON(&payload); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	var input UserInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
 		return
 	}
-	payload.ID = id
 
 	updates := map[string]interface{}{
-		"name":  payload.Name,
-		"email": payload.Email,
-		"role":  payload.Role,
+		"name":  input.Name,
+		"email": input.Email,
+		"role":  input.Role,
 	}
 
-	if err := uc.db.Model(&model.User{}).Where("id = ?", id).Updates(updates).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	res := uc.db.WithContext(c.Request.Context()).Model(&model.User{ID: id}).Updates(updates)
+	if res.Error != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, res.Error.Error())
+		return
+	}
+	if res.RowsAffected == 0 {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "user not found")
 		return
 	}
 
-	payload.ID = id
-	c.JSON(http.StatusOK, payload)
+	var user model.User
+	if err := uc.db.WithContext(database.WithPrimary(c.Request.Context())).First(&user, id).Error; err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, user)
 }
 
 func (uc *UserController) Delete(c *gin.Context) {
 	id, err := parseID(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
 		return
 	}
 
-	if err := uc.db.Delete(&model.User{}, id).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	res := uc.db.WithContext(c.Request.Context()).Delete(&model.User{ID: id})
+	if res.Error != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, res.Error.Error())
+		return
+	}
+	if res.RowsAffected == 0 {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "user not found")
 		return
 	}
 
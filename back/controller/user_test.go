@@ -0,0 +1,76 @@
+package controller_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/wonderfulsuccess/go-web-app/back/apptest"
+	"github.com/wonderfulsuccess/go-web-app/back/controller"
+	"github.com/wonderfulsuccess/go-web-app/back/model"
+)
+
+func TestUserCRUD(t *testing.T) {
+	env := apptest.New(t, apptest.Options{})
+	client := env.Client()
+
+	var created model.User
+	client.Post("/api/users", controller.UserInput{Name: "Ada", Email: "ada@example.com", Role: model.RoleViewer}).
+		Expect(http.StatusCreated).JSON(&created)
+	if created.ID == 0 || created.Name != "Ada" {
+		t.Fatalf("created %+v", created)
+	}
+	path := fmt.Sprintf("/api/users/%d", created.ID)
+
+	var updated model.User
+	client.Put(path, controller.UserInput{Name: "Ada Lovelace", Email: "ada@example.com", Role: model.RoleAdmin}).
+		Expect(http.StatusOK).JSON(&updated)
+	if updated.Name != "Ada Lovelace" || updated.Role != model.RoleAdmin {
+		t.Fatalf("updated %+v", updated)
+	}
+
+	var users []model.User
+	client.Get("/api/users").Expect(http.StatusOK).JSON(&users)
+	if len(users) != 1 || users[0].ID != created.ID {
+		t.Fatalf("listed %+v", users)
+	}
+
+	client.Delete(path).Expect(http.StatusNoContent)
+	var missing controller.ErrorResponse
+	client.Get(path).Expect(http.StatusNotFound).JSON(&missing)
+	if missing.Code != controller.ErrCodeNotFound {
+		t.Fatalf("got code %q, want %q", missing.Code, controller.ErrCodeNotFound)
+	}
+	client.Get("/api/users/abc").Expect(http.StatusBadRequest)
+}
+
+func TestUsersAreScopedToTenant(t *testing.T) {
+	env := apptest.New(t, apptest.Options{})
+	env.Load(&model.User{Name: "Ada", Email: "ada@example.com"})
+	env.CreateTenant("acme")
+	acme := env.Client().Tenant("acme")
+
+	var cy model.User
+	acme.Post("/api/users", controller.UserInput{Name: "Cy", Email: "cy@example.com"}).
+		Expect(http.StatusCreated).JSON(&cy)
+	// The same email may exist once per tenant.
+	acme.Post("/api/users", controller.UserInput{Name: "Ada", Email: "ada@example.com"}).
+		Expect(http.StatusCreated)
+
+	var defaults, acmes []model.User
+	env.Client().Get("/api/users").Expect(http.StatusOK).JSON(&defaults)
+	acme.Get("/api/users").Expect(http.StatusOK).JSON(&acmes)
+	if len(defaults) != 1 || defaults[0].Name != "Ada" {
+		t.Fatalf("default tenant lists %+v", defaults)
+	}
+	if len(acmes) != 2 {
+		t.Fatalf("acme lists %+v", acmes)
+	}
+
+	path := fmt.Sprintf("/api/users/%d", cy.ID)
+	env.Client().Get(path).Expect(http.StatusNotFound)
+	env.Client().Delete(path).Expect(http.StatusNotFound)
+	acme.Get(path).Expect(http.StatusOK)
+
+	env.Client().Tenant("nobody").Get("/api/users").Expect(http.StatusNotFound)
+}
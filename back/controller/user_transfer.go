@@ -0,0 +1,441 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/wonderfulsuccess/go-web-app/back/database"
+	"github.com/wonderfulsuccess/go-web-app/back/model"
+	"github.com/wonderfulsuccess/go-web-app/back/tabular"
+)
+
+const (
+	// ImportTransactional imports every row or none of them.
+	ImportTransactional = "transactional"
+	// ImportBestEffort imports the valid rows and reports the others.
+	ImportBestEffort = "best-effort"
+
+	importBatchSize = 500
+	maxImportErrors = 1000
+)
+
+// UserImportResult reports the outcome of an import.
+type UserImportResult struct {
+	DryRun    bool   `json:"dryRun"`
+	Mode      string `json:"mode"`
+	Rows      int    `json:"rows"`
+	Created   int    `json:"created"`
+	Updated   int    `json:"updated"`
+	Failed    int    `json:"failed"`
+	Committed bool   `json:"committed"`
+	// Errors is capped at 1000 entries; ErrorsTruncated tells when more rows
+	// failed.
+	Errors          []ImportRowError `json:"errors"`
+	ErrorsTruncated bool             `json:"errorsTruncated"`
+}
+
+// ImportRowError describes why a row was rejected. Row is the line (CSV,
+// JSON Lines) or sheet row (XLSX) of the source file.
+type ImportRowError struct {
+	Row     int    `json:"row"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+var userImportFields = []string{"name", "email", "role"}
+
+var errRollback = errors.New("rollback")
+
+// Import upserts users by email from a CSV, JSON Lines or XLSX body, sent
+// either as the raw request body or as the "file" part of a multipart form.
+func (uc *UserController) Import(c *gin.Context) {
+	mode := c.DefaultQuery("mode", ImportTransactional)
+	if mode != ImportTransactional && mode != ImportBestEffort {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("unknown mode %q", mode))
+		return
+	}
+	mapping, err := parseColumnMapping(c.Query("mapping"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+
+	if uc.maxImportBytes > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, uc.maxImportBytes)
+	}
+	body, contentType, filename, err := importBody(c)
+	if err != nil {
+		respondImportError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err)
+		return
+	}
+	format, err := requestFormat(c.Query("format"), contentType, filename)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	reader, err := tabular.NewReader(format, body)
+	if err != nil {
+		respondImportError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err)
+		return
+	}
+	defer reader.Close()
+
+	imp := &userImporter{
+		mapping: mapping,
+		result:  UserImportResult{DryRun: c.Query("dryRun") == "true", Mode: mode, Errors: []ImportRowError{}},
+	}
+	// Dry runs and transactional imports share one transaction that is
+	// rolled back unless everything succeeded; best-effort imports commit
+	// batch by batch.
+	if imp.result.DryRun || mode == ImportTransactional {
+		err = uc.db.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+			imp.db = tx
+			if err := imp.run(reader); err != nil {
+				return err
+			}
+			if imp.result.DryRun || imp.result.Failed > 0 {
+				return errRollback
+			}
+			return nil
+		})
+		if err == nil {
+			imp.result.Committed = true
+		}
+	} else {
+		// Each batch looks up the rows committed by the previous ones, which
+		// a replica may not have yet.
+		imp.db = uc.db.WithContext(database.WithPrimary(c.Request.Context()))
+		err = imp.run(reader)
+		imp.result.Committed = err == nil
+	}
+	if err != nil && !errors.Is(err, errRollback) {
+		respondImportError(c, http.StatusInternalServerError, ErrCodeInternal, err)
+		return
+	}
+
+	status := http.StatusOK
+	if imp.result.Failed > 0 && !imp.result.DryRun {
+		status = http.StatusUnprocessableEntity
+	}
+	c.JSON(status, imp.result)
+}
+
+// respondImportError answers with 413 when err comes from a body over the
+// import limit, and with status and code otherwise.
+func respondImportError(c *gin.Context, status int, code ErrorCode, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respondError(c, http.StatusRequestEntityTooLarge, ErrCodeTooLarge, fmt.Sprintf("file exceeds the import limit of %d bytes", tooLarge.Limit))
+		return
+	}
+	respondError(c, status, code, err.Error())
+}
+
+// importBody returns the uploaded file without buffering it: the first
+// "file" part of a multipart form, or the request body itself.
+func importBody(c *gin.Context) (io.Reader, string, string, error) {
+	contentType := c.ContentType()
+	if !strings.HasPrefix(contentType, "multipart/") {
+		return c.Request.Body, contentType, "", nil
+	}
+	mr, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, "", "", err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, "", "", errors.New(`multipart body has no "file" part`)
+		}
+		if err != nil {
+			return nil, "", "", err
+		}
+		if part.FormName() == "file" {
+			return part, part.Header.Get("Content-Type"), part.FileName(), nil
+		}
+	}
+}
+
+func requestFormat(name, contentType, filename string) (tabular.Format, error) {
+	if name != "" {
+		return tabular.ParseFormat(name)
+	}
+	if f, ok := tabular.DetectFormat(contentType, filename); ok {
+		return f, nil
+	}
+	return "", errors.New("cannot tell the file format, pass ?format=csv, jsonl or xlsx")
+}
+
+// parseColumnMapping decodes {"source column": "field"} and checks the
+// fields exist.
+func parseColumnMapping(raw string) (map[string]string, error) {
+	mapping := map[string]string{}
+	if raw == "" {
+		return mapping, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &mapping); err != nil {
+		return nil, fmt.Errorf("mapping must be a JSON object of source column to field: %w", err)
+	}
+	for column, field := range mapping {
+		if !contains(userImportFields, field) {
+			return nil, fmt.Errorf("column %q maps to unknown field %q, expected one of %s", column, field, strings.Join(userImportFields, ", "))
+		}
+	}
+	return mapping, nil
+}
+
+func contains(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+type importRow struct {
+	row  int
+	user model.User
+}
+
+type userImporter struct {
+	db      *gorm.DB
+	mapping map[string]string
+	result  UserImportResult
+	batch   []importRow
+	emails  map[string]bool
+}
+
+func (imp *userImporter) run(reader tabular.Reader) error {
+	imp.emails = make(map[string]bool)
+	for {
+		rec, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return err
+		}
+		if err != nil {
+			// The rest of the file cannot be trusted after a syntax error.
+			imp.result.Rows++
+			imp.fail(ImportRowError{Row: rec.Row, Message: err.Error()})
+			break
+		}
+		imp.result.Rows++
+
+		user, rowErr := imp.convert(rec)
+		if rowErr != nil {
+			imp.fail(*rowErr)
+			continue
+		}
+		// A later row for the same email wins; flush so the batch never
+		// contains the email twice.
+		if imp.emails[user.Email] {
+			if err := imp.flush(); err != nil {
+				return err
+			}
+		}
+		imp.batch = append(imp.batch, importRow{row: rec.Row, user: user})
+		imp.emails[user.Email] = true
+		if len(imp.batch) >= importBatchSize {
+			if err := imp.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return imp.flush()
+}
+
+// convert applies the column mapping and validates the row.
+func (imp *userImporter) convert(rec tabular.Record) (model.User, *ImportRowError) {
+	fields := make(map[string]string, len(userImportFields))
+	for column, value := range rec.Values {
+		field, ok := imp.mapping[column]
+		if !ok {
+			field = strings.ToLower(column)
+		}
+		if contains(userImportFields, field) {
+			fields[field] = value
+		}
+	}
+
+	user := model.User{Name: fields["name"], Email: strings.ToLower(fields["email"]), Role: fields["role"]}
+	switch {
+	case user.Email == "":
+		return user, &ImportRowError{Row: rec.Row, Field: "email", Message: "email is required"}
+	case !validEmail(user.Email):
+		return user, &ImportRowError{Row: rec.Row, Field: "email", Message: fmt.Sprintf("%q is not a valid email address", user.Email)}
+	case user.Name == "":
+		return user, &ImportRowError{Row: rec.Row, Field: "name", Message: "name is required"}
+	}
+	return user, nil
+}
+
+func validEmail(s string) bool {
+	addr, err := mail.ParseAddress(s)
+	return err == nil && addr.Address == s
+}
+
+// flush upserts the batch. When the batch statement fails, rows are retried
+// one by one so the error is reported against the offending row.
+func (imp *userImporter) flush() error {
+	if len(imp.batch) == 0 {
+		return nil
+	}
+	batch := imp.batch
+	imp.batch = nil
+	imp.emails = make(map[string]bool)
+
+	emails := make([]string, len(batch))
+	for i, r := range batch {
+		emails[i] = r.user.Email
+	}
+	var existing []model.User
+	if err := imp.db.Select("email", "role").Where("email IN ?", emails).Find(&existing).Error; err != nil {
+		return err
+	}
+	known := make(map[string]bool, len(existing))
+	roles := make(map[string]string, len(existing))
+	for _, u := range existing {
+		known[u.Email] = true
+		roles[u.Email] = u.Role
+	}
+
+	users := make([]model.User, len(batch))
+	for i := range batch {
+		// A row without a role keeps the current one, new users default to
+		// viewer.
+		if batch[i].user.Role == "" {
+			batch[i].user.Role = firstNonEmpty(roles[batch[i].user.Email], model.RoleViewer)
+		}
+		users[i] = batch[i].user
+	}
+
+	// Nested transactions become savepoints, so a failed statement does not
+	// abort the surrounding transaction on Postgres.
+	err := imp.db.Transaction(func(tx *gorm.DB) error {
+		return upsertUsers(tx, users)
+	})
+	if err == nil {
+		for _, r := range batch {
+			imp.count(known[r.user.Email])
+		}
+		return nil
+	}
+
+	for _, r := range batch {
+		user := r.user
+		err := imp.db.Transaction(func(tx *gorm.DB) error {
+			return upsertUsers(tx, []model.User{user})
+		})
+		if err != nil {
+			imp.fail(ImportRowError{Row: r.row, Message: err.Error()})
+			continue
+		}
+		imp.count(known[user.Email])
+	}
+	return nil
+}
+
+func upsertUsers(tx *gorm.DB, users []model.User) error {
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "tenant_id"}, {Name: "email"}},
+		DoUpdates: clause.AssignmentColumns([]string{"name", "role", "updated_at"}),
+	}).Create(&users).Error
+}
+
+func (imp *userImporter) count(updated bool) {
+	if updated {
+		imp.result.Updated++
+	} else {
+		imp.result.Created++
+	}
+}
+
+func (imp *userImporter) fail(e ImportRowError) {
+	imp.result.Failed++
+	if len(imp.result.Errors) < maxImportErrors {
+		imp.result.Errors = append(imp.result.Errors, e)
+	} else {
+		imp.result.ErrorsTruncated = true
+	}
+}
+
+// Export streams the users matching the list filters as CSV, JSON Lines or
+// XLSX, reading them from the database in batches.
+func (uc *UserController) Export(c *gin.Context) {
+	format, err := tabular.ParseFormat(c.DefaultQuery("format", "csv"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+
+	filename := fmt.Sprintf("users-%s.%s", time.Now().Format("20060102-150405"), format)
+	c.Header("Content-Type", format.ContentType())
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	c.Status(http.StatusOK)
+
+	w, err := tabular.NewWriter(format, c.Writer, []string{"id", "name", "email", "role", "createdAt", "updatedAt"})
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+
+	var batch []model.User
+	res := filterUsers(uc.db, c).Order("id").FindInBatches(&batch, importBatchSize, func(tx *gorm.DB, _ int) error {
+		for _, u := range batch {
+			if err := w.Write([]interface{}{u.ID, u.Name, u.Email, u.Role, u.CreatedAt, u.UpdatedAt}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if res.Error != nil {
+		// Headers are gone already; all that is left is to cut the file short.
+		_ = c.Error(res.Error)
+		return
+	}
+	if err := w.Close(); err != nil {
+		_ = c.Error(err)
+	}
+}
+
+// likeEscaper escapes the LIKE wildcards of a search term with "!", which,
+// unlike a backslash, needs no quoting in any of the supported databases.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// filterUsers applies the list filters: q matches name or email, role
+// matches exactly.
+func filterUsers(db *gorm.DB, c *gin.Context) *gorm.DB {
+	query := db.WithContext(c.Request.Context()).Model(&model.User{})
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		like := "%" + likeEscaper.Replace(strings.ToLower(q)) + "%"
+		query = query.Where("LOWER(name) LIKE ? ESCAPE '!' OR LOWER(email) LIKE ? ESCAPE '!'", like, like)
+	}
+	if role := c.Query("role"); role != "" {
+		query = query.Where("role = ?", role)
+	}
+	return query
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
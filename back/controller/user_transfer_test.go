@@ -0,0 +1,126 @@
+package controller_test
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/wonderfulsuccess/go-web-app/back/apptest"
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+	"github.com/wonderfulsuccess/go-web-app/back/controller"
+	"github.com/wonderfulsuccess/go-web-app/back/model"
+)
+
+func TestImportUsers(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		body    string
+		status  int
+		created int
+		saved   int64
+		errors  []controller.ImportRowError
+	}{
+		{
+			name:    "valid",
+			body:    "name,email\nAda,ADA@example.com\nCy,cy@example.com\n",
+			status:  http.StatusOK,
+			created: 2, saved: 2,
+		},
+		{
+			name:   "invalid row rolls back",
+			body:   "name,email\nAda,ada@example.com\nCy,not-an-email\n",
+			status: http.StatusUnprocessableEntity, created: 1, saved: 0,
+			errors: []controller.ImportRowError{{Row: 3, Field: "email", Message: `"not-an-email" is not a valid email address`}},
+		},
+		{
+			name:    "best effort keeps valid rows",
+			query:   "&mode=best-effort",
+			body:    "name,email\nAda,ada@example.com\n,cy@example.com\n",
+			status:  http.StatusUnprocessableEntity,
+			created: 1, saved: 1,
+			errors: []controller.ImportRowError{{Row: 3, Field: "name", Message: "name is required"}},
+		},
+		{
+			name:   "syntax error reports its line",
+			query:  "&mode=best-effort",
+			body:   "name,email\nAda,ada@example.com\n\nCy,\"cy@\"example.com\n",
+			status: http.StatusUnprocessableEntity, created: 1, saved: 1,
+			errors: []controller.ImportRowError{{Row: 4}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := apptest.New(t, apptest.Options{})
+			var result controller.UserImportResult
+			env.Client().With("Content-Type", "text/csv").
+				Post("/api/users/import?format=csv"+tt.query, tt.body).Expect(tt.status).JSON(&result)
+			if result.Created != tt.created || len(result.Errors) != len(tt.errors) {
+				t.Fatalf("result %+v", result)
+			}
+			for i, want := range tt.errors {
+				got := result.Errors[i]
+				if got.Row != want.Row || got.Field != want.Field || (want.Message != "" && got.Message != want.Message) {
+					t.Errorf("error %d = %+v, want %+v", i, got, want)
+				}
+			}
+			var saved int64
+			env.DB.WithContext(env.Context()).Model(&model.User{}).Count(&saved)
+			if saved != tt.saved {
+				t.Fatalf("%d users saved, want %d", saved, tt.saved)
+			}
+		})
+	}
+}
+
+func TestImportRefusesLargeFiles(t *testing.T) {
+	env := apptest.New(t, apptest.Options{Config: func(cfg *config.Config) {
+		cfg.Import.MaxBytes = 64
+	}})
+	body := "name,email\n" + strings.Repeat("Ada,ada@example.com\n", 10)
+	for _, mode := range []string{"transactional", "best-effort"} {
+		var res controller.ErrorResponse
+		env.Client().With("Content-Type", "text/csv").
+			Post("/api/users/import?mode="+mode, body).Expect(http.StatusRequestEntityTooLarge).JSON(&res)
+		if res.Code != controller.ErrCodeTooLarge {
+			t.Fatalf("%s: got code %q, want %q", mode, res.Code, controller.ErrCodeTooLarge)
+		}
+	}
+	env.Client().With("Content-Type", "text/csv").
+		Post("/api/users/import", "name,email\nAda,ada@example.com\n").Expect(http.StatusOK)
+}
+
+func TestExportUsers(t *testing.T) {
+	env := apptest.New(t, apptest.Options{})
+	env.Load(
+		&model.User{Name: "=HYPERLINK(\"http://evil\")", Email: "evil@example.com", Role: model.RoleViewer},
+		&model.User{Name: "Ada_L", Email: "ada@example.com", Role: model.RoleViewer},
+		&model.User{Name: "AdaXL", Email: "adaxl@example.com", Role: model.RoleViewer},
+		&model.User{Name: "100%", Email: "percent@example.com", Role: model.RoleViewer},
+	)
+	tests := []struct {
+		q     string
+		names []string
+	}{
+		{q: "", names: []string{"'=HYPERLINK(\"http://evil\")", "Ada_L", "AdaXL", "100%"}},
+		{q: "a_l", names: []string{"Ada_L"}},
+		{q: "%", names: []string{"100%"}},
+		{q: "ADA", names: []string{"Ada_L", "AdaXL"}},
+	}
+	for _, tt := range tests {
+		res := env.Client().Get("/api/users/export?format=csv&q=" + url.QueryEscape(tt.q)).Expect(http.StatusOK)
+		rows, err := csv.NewReader(strings.NewReader(res.String())).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, row := range rows[1:] {
+			names = append(names, row[1])
+		}
+		if strings.Join(names, "|") != strings.Join(tt.names, "|") {
+			t.Errorf("q=%q exported %q, want %q", tt.q, names, tt.names)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+
+	"github.com/wonderfulsuccess/go-web-app/back/logger"
+)
+
+// pingTimeout bounds one health check of one database.
+const pingTimeout = 5 * time.Second
+
+// Cluster is the primary database and its read replicas. Queries outside
+// transactions go to a healthy replica, or to the primary when none is;
+// writes, transactions and queries with a primary hint always go to the
+// primary. InitDatabase installs it on the *gorm.DB; ClusterOf returns it.
+type Cluster struct {
+	primary  *node
+	replicas []*node
+	next     atomic.Uint64
+}
+
+type node struct {
+	name    string
+	db      *sql.DB
+	healthy atomic.Bool
+
+	mu        sync.Mutex
+	lastErr   string
+	checkedAt time.Time
+}
+
+// NodeStatus is the outcome of the latest health check of one database.
+type NodeStatus struct {
+	Name      string    `json:"name"`
+	Healthy   bool      `json:"healthy"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+const clusterPlugin = "database:cluster"
+
+// Name implements gorm.Plugin.
+func (*Cluster) Name() string { return clusterPlugin }
+
+// Initialize implements gorm.Plugin and routes queries carrying a primary
+// hint, see WithPrimary, to the primary. gorm runs the last callback
+// registered Before("*") first, so the cluster must be installed after
+// dbresolver.
+func (*Cluster) Initialize(db *gorm.DB) error {
+	hint := func(db *gorm.DB) {
+		if db.Statement.Context != nil && UsesPrimary(db.Statement.Context) {
+			dbresolver.Write.ModifyStatement(db.Statement)
+		}
+	}
+	if err := db.Callback().Query().Before("*").Register("database:primary_hint", hint); err != nil {
+		return err
+	}
+	if err := db.Callback().Row().Before("*").Register("database:primary_hint", hint); err != nil {
+		return err
+	}
+	return db.Callback().Raw().Before("*").Register("database:primary_hint", hint)
+}
+
+// ClusterOf returns the cluster installed on db by InitDatabase, or nil.
+func ClusterOf(db *gorm.DB) *Cluster {
+	if db == nil {
+		return nil
+	}
+	if plugin, ok := db.Config.Plugins[clusterPlugin]; ok {
+		return plugin.(*Cluster)
+	}
+	return nil
+}
+
+func newCluster(primary *sql.DB) *Cluster {
+	c := &Cluster{primary: &node{name: "primary", db: primary}}
+	c.primary.healthy.Store(true)
+	return c
+}
+
+func (c *Cluster) addReplica(db *sql.DB) {
+	c.replicas = append(c.replicas, &node{name: fmt.Sprintf("replica-%d", len(c.replicas)+1), db: db})
+}
+
+// HasReplicas reports whether read replicas are configured.
+func (c *Cluster) HasReplicas() bool {
+	return c != nil && len(c.replicas) > 0
+}
+
+// Resolve implements dbresolver.Policy. It ignores the pools dbresolver
+// offers, which are the replicas in configuration order, and picks the
+// next healthy replica itself.
+func (c *Cluster) Resolve([]gorm.ConnPool) gorm.ConnPool {
+	n := uint64(len(c.replicas))
+	start := c.next.Add(1)
+	for i := uint64(0); i < n; i++ {
+		if r := c.replicas[(start+i)%n]; r.healthy.Load() {
+			return r.db
+		}
+	}
+	return c.primary.db
+}
+
+// Healthy reports whether the primary answered its latest health check.
+func (c *Cluster) Healthy() bool {
+	return c == nil || c.primary.healthy.Load()
+}
+
+// Status returns the latest health check of the primary and each replica.
+func (c *Cluster) Status() []NodeStatus {
+	if c == nil {
+		return []NodeStatus{}
+	}
+	out := make([]NodeStatus, 0, 1+len(c.replicas))
+	for _, n := range append([]*node{c.primary}, c.replicas...) {
+		n.mu.Lock()
+		out = append(out, NodeStatus{Name: n.name, Healthy: n.healthy.Load(), Error: n.lastErr, CheckedAt: n.checkedAt})
+		n.mu.Unlock()
+	}
+	return out
+}
+
+// Check pings every database and returns an error when the primary is
+// unreachable. Replicas that fail stop receiving queries until they answer
+// again.
+func (c *Cluster) Check(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for _, n := range append([]*node{c.primary}, c.replicas...) {
+		wg.Add(1)
+		go func(n *node) {
+			defer wg.Done()
+			n.check(ctx)
+		}(n)
+	}
+	wg.Wait()
+	if !c.primary.healthy.Load() {
+		return fmt.Errorf("primary database is unreachable: %s", c.primary.lastError())
+	}
+	return nil
+}
+
+// Watch runs Check every interval until ctx is cancelled.
+func (c *Cluster) Watch(ctx context.Context, interval time.Duration) {
+	if c == nil || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = c.Check(ctx)
+		}
+	}
+}
+
+func (n *node) check(ctx context.Context) {
+	pingCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+	err := n.db.PingContext(pingCtx)
+	cancel()
+	if ctx.Err() != nil {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	first := n.checkedAt.IsZero()
+	n.checkedAt = time.Now().UTC()
+	n.lastErr = ""
+	if err != nil {
+		n.lastErr = err.Error()
+	}
+	was := n.healthy.Swap(err == nil)
+	switch {
+	case (was || first) && err != nil:
+		logger.Warningf("%s database is unreachable: %v", n.name, err)
+	case !was && err == nil:
+		logger.Infof("%s database is reachable", n.name)
+	}
+}
+
+func (n *node) lastError() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.lastErr
+}
+
+// primaryKey is the context key set by WithPrimary.
+type primaryKey struct{}
+
+// WithPrimary returns a context whose queries read from the primary, e.g.
+// to read back a row just written without waiting for replication.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// UsesPrimary reports whether ctx carries the hint set by WithPrimary.
+func UsesPrimary(ctx context.Context) bool {
+	v, _ := ctx.Value(primaryKey{}).(bool)
+	return v
+}
@@ -0,0 +1,207 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/plugin/dbresolver"
+)
+
+type row struct {
+	ID     uint
+	Source string
+}
+
+// openFile opens a SQLite file holding one row naming source.
+func openFile(t *testing.T, dir, source string) *sql.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(dir, source+".db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&row{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&row{Source: source}).Error; err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	return sqlDB
+}
+
+// newTestCluster returns a primary with two replicas, each a SQLite file
+// whose only row names it, routed like InitDatabase routes MySQL and
+// PostgreSQL replicas.
+func newTestCluster(t *testing.T) (*gorm.DB, *Cluster) {
+	t.Helper()
+	dir := t.TempDir()
+	primary := openFile(t, dir, "primary")
+	cluster := newCluster(primary)
+	var replicas []gorm.Dialector
+	for _, name := range []string{"replica-1", "replica-2"} {
+		conn := openFile(t, dir, name)
+		cluster.addReplica(conn)
+		replicas = append(replicas, sqlite.Dialector{Conn: conn})
+	}
+	db, err := gorm.Open(sqlite.Dialector{Conn: primary}, &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Use(dbresolver.Register(dbresolver.Config{Replicas: replicas, Policy: cluster})); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Use(cluster); err != nil {
+		t.Fatal(err)
+	}
+	if err := cluster.Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return db, cluster
+}
+
+// source returns the database a plain read on db went to.
+func source(t *testing.T, db *gorm.DB) string {
+	t.Helper()
+	var r row
+	if err := db.First(&r).Error; err != nil {
+		t.Fatal(err)
+	}
+	return r.Source
+}
+
+func TestClusterRouting(t *testing.T) {
+	db, cluster := newTestCluster(t)
+	if ClusterOf(db) != cluster || !cluster.HasReplicas() {
+		t.Fatal("cluster is not installed on the database")
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		read func() string
+		want string
+	}{
+		{name: "primary hint", read: func() string { return source(t, db.WithContext(WithPrimary(ctx))) }, want: "primary"},
+		{name: "dbresolver write clause", read: func() string { return source(t, db.Clauses(dbresolver.Write)) }, want: "primary"},
+		{
+			name: "transaction",
+			read: func() string {
+				var got string
+				_ = db.Transaction(func(tx *gorm.DB) error {
+					got = source(t, tx)
+					return nil
+				})
+				return got
+			},
+			want: "primary",
+		},
+		{
+			name: "raw query with primary hint",
+			read: func() string {
+				var got string
+				db.WithContext(WithPrimary(ctx)).Raw("SELECT source FROM rows LIMIT 1").Scan(&got)
+				return got
+			},
+			want: "primary",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.read(); got != tt.want {
+				t.Fatalf("read from %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// Plain reads alternate between the replicas.
+	seen := map[string]int{}
+	for i := 0; i < 4; i++ {
+		seen[source(t, db)]++
+	}
+	if seen["replica-1"] != 2 || seen["replica-2"] != 2 {
+		t.Fatalf("plain reads went to %v, want both replicas twice", seen)
+	}
+	// Writes go to the primary.
+	if err := db.Create(&row{Source: "written"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	var n int64
+	db.WithContext(WithPrimary(ctx)).Model(&row{}).Count(&n)
+	if n != 2 {
+		t.Fatalf("primary has %d rows after the write, want 2", n)
+	}
+}
+
+func TestClusterSkipsFailedReplicas(t *testing.T) {
+	tests := []struct {
+		name   string
+		down   []int // indexes into the replicas
+		want   []string
+		status []bool // primary, replica-1, replica-2
+	}{
+		{name: "all healthy", want: []string{"replica-1", "replica-2"}, status: []bool{true, true, true}},
+		{name: "one down", down: []int{0}, want: []string{"replica-2", "replica-2"}, status: []bool{true, false, true}},
+		{name: "all down", down: []int{0, 1}, want: []string{"primary", "primary"}, status: []bool{true, false, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, cluster := newTestCluster(t)
+			for _, i := range tt.down {
+				cluster.replicas[i].db.Close()
+			}
+			if err := cluster.Check(context.Background()); err != nil {
+				t.Fatalf("check failed with a healthy primary: %v", err)
+			}
+			got := map[string]bool{}
+			for range tt.want {
+				got[source(t, db)] = true
+			}
+			for _, want := range tt.want {
+				if !got[want] {
+					t.Fatalf("reads went to %v, want %v", got, tt.want)
+				}
+			}
+			for i, st := range cluster.Status() {
+				if st.Healthy != tt.status[i] || st.CheckedAt.IsZero() || (st.Error == "") != st.Healthy {
+					t.Fatalf("status %d is %+v, want healthy %v", i, st, tt.status[i])
+				}
+			}
+		})
+	}
+}
+
+func TestClusterPrimaryDown(t *testing.T) {
+	_, cluster := newTestCluster(t)
+	cluster.primary.db.Close()
+	if err := cluster.Check(context.Background()); err == nil {
+		t.Fatal("check passed with the primary closed")
+	}
+	if cluster.Healthy() {
+		t.Fatal("cluster reports healthy with the primary closed")
+	}
+
+	var none *Cluster
+	if !none.Healthy() || none.HasReplicas() || len(none.Status()) != 0 {
+		t.Fatal("a missing cluster should be healthy without replicas")
+	}
+	none.Watch(context.Background(), 0)
+}
+
+func TestPrimaryHint(t *testing.T) {
+	ctx := context.Background()
+	if UsesPrimary(ctx) {
+		t.Fatal("background context carries the primary hint")
+	}
+	if !UsesPrimary(WithPrimary(ctx)) {
+		t.Fatal("WithPrimary did not set the hint")
+	}
+}
@@ -1,55 +1,80 @@
 package database
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	"os"
 	"path/filepath"
 	"time"
 
 	"github.com/wonderfulsuccess/go-web-app/back/config"
+	"github.com/wonderfulsuccess/go-web-app/back/logger"
+	"github.com/wonderfulsuccess/go-web-app/back/utils"
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
-	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
+	gormlogger "gorm.io/gorm/logger"
+	"gorm.io/plugin/dbresolver"
 )
 
 // InitDatabase opens a database connection using the configured driver.
+// MySQL and PostgreSQL are retried with backoff for up to ConnectTimeout,
+// so the app may start before its database does.
 func InitDatabase(cfg config.DatabaseConfig) (*gorm.DB, error) {
 	var (
-		db  *gorm.DB
-		err error
+		db   *gorm.DB
+		open func(dsn string) gorm.Dialector
 	)
 
-	logMode := logger.Silent
+	logMode := gormlogger.Silent
 	if cfg.Log {
-		logMode = logger.Info
+		logMode = gormlogger.Info
 	}
 
-	gormCfg := &gorm.Config{Logger: logger.Default.LogMode(logMode)}
+	gormCfg := &gorm.Config{Logger: gormlogger.Default.LogMode(logMode)}
+
+	dsn, err := DSN(cfg)
+	if err != nil {
+		return nil, err
+	}
+	replicaDSNs, err := ReplicaDSNs(cfg)
+	if err != nil {
+		return nil, err
+	}
+	cfg.DSN = dsn
+	logger.Infof("connecting to %s database %s", cfg.Type, utils.MaskDSN(dsn))
 
 	switch cfg.Type {
 	case config.DBTypeSQLite:
 		if err := ensureSQLiteFile(cfg.DSN); err != nil {
 			return nil, err
 		}
-		db, err = gorm.Open(sqlite.Open(cfg.DSN), gormCfg)
-	case config.DBTypeMySQL:
-		dsn := cfg.DSN
-		if dsn == "" {
-			dsn = "root:password@tcp(127.0.0.1:3306)/app?charset=utf8mb4&parseTime=True&loc=Local"
+		// SQLite sizes its own writer and reader pools.
+		if db, err = openSQLite(cfg, gormCfg); err != nil {
+			return nil, err
 		}
-		db, err = gorm.Open(mysql.Open(dsn), gormCfg)
-	case config.DBTypePostgres:
-		dsn := cfg.DSN
-		if dsn == "" {
-			dsn = "host=127.0.0.1 user=postgres password=postgres dbname=app port=5432 sslmode=disable TimeZone=Asia/Shanghai"
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		cluster := newCluster(sqlDB)
+		if err := db.Use(cluster); err != nil {
+			return nil, err
 		}
-		db, err = gorm.Open(postgres.Open(dsn), gormCfg)
+		_ = cluster.Check(context.Background())
+		return db, nil
+	case config.DBTypeMySQL:
+		open = mysql.Open
+	case config.DBTypePostgres:
+		open = postgres.Open
 	default:
 		return nil, fmt.Errorf("unsupported database type: %s", cfg.Type)
 	}
 
+	db, err = connect(cfg.ConnectTimeout, func() (*gorm.DB, error) {
+		return gorm.Open(open(dsn), gormCfg)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -58,17 +83,113 @@ func InitDatabase(cfg config.DatabaseConfig) (*gorm.DB, error) {
 	if err != nil {
 		return nil, err
 	}
+	setPool(sqlDB, cfg.Pool)
 
-	sqlDB.SetMaxIdleConns(5)
-	sqlDB.SetMaxOpenConns(25)
-	sqlDB.SetConnMaxLifetime(30 * time.Minute)
+	cluster := newCluster(sqlDB)
+	if len(replicaDSNs) > 0 {
+		if err := useReplicas(db, cluster, cfg.Type, replicaDSNs, cfg.Pool); err != nil {
+			return nil, err
+		}
+	}
+	if err := db.Use(cluster); err != nil {
+		return nil, err
+	}
+	_ = cluster.Check(context.Background())
 
 	return db, nil
 }
 
+// connect calls open until it succeeds or timeout has passed, waiting
+// twice as long after each failure.
+func connect(timeout time.Duration, open func() (*gorm.DB, error)) (*gorm.DB, error) {
+	deadline := time.Now().Add(timeout)
+	wait := 500 * time.Millisecond
+	for {
+		db, err := open()
+		if err == nil {
+			return db, nil
+		}
+		if time.Now().Add(wait).After(deadline) {
+			return nil, err
+		}
+		logger.Warningf("database is not reachable, retrying in %s: %v", wait, err)
+		time.Sleep(wait)
+		if wait *= 2; wait > maxConnectWait {
+			wait = maxConnectWait
+		}
+	}
+}
+
+// maxConnectWait caps the pause between connection attempts.
+const maxConnectWait = 10 * time.Second
+
+// useReplicas sends reads outside transactions to the replicas through
+// gorm's dbresolver, with cluster choosing among the healthy ones. Replicas
+// are opened without connecting, so one that is down does not stop startup.
+func useReplicas(db *gorm.DB, cluster *Cluster, dbType config.DatabaseType, dsns []string, pool config.PoolConfig) error {
+	replicas := make([]gorm.Dialector, 0, len(dsns))
+	for _, dsn := range dsns {
+		replica, err := gorm.Open(replicaDialector(dbType, dsn, nil), &gorm.Config{DisableAutomaticPing: true})
+		if err != nil {
+			return fmt.Errorf("open replica %s: %w", utils.MaskDSN(dsn), err)
+		}
+		sqlDB, err := replica.DB()
+		if err != nil {
+			return err
+		}
+		setPool(sqlDB, pool)
+		cluster.addReplica(sqlDB)
+		logger.Infof("using read replica %s", utils.MaskDSN(dsn))
+		// dbresolver opens its replicas itself; hand it the pool above so
+		// the health checks and the queries share connections.
+		replicas = append(replicas, replicaDialector(dbType, "", sqlDB))
+	}
+	if len(replicas) == 1 {
+		// dbresolver skips the policy when there is a single replica, so
+		// list the primary too; the cluster only returns it as a fallback.
+		replicas = append(replicas, replicaDialector(dbType, "", cluster.primary.db))
+	}
+	return db.Use(dbresolver.Register(dbresolver.Config{Replicas: replicas, Policy: cluster}))
+}
+
+// replicaDialector opens dsn, or wraps conn when it is set, without
+// querying the server.
+func replicaDialector(dbType config.DatabaseType, dsn string, conn *sql.DB) gorm.Dialector {
+	if dbType == config.DBTypeMySQL {
+		cfg := mysql.Config{DSN: dsn, SkipInitializeWithVersion: true}
+		if conn != nil {
+			cfg.Conn = conn
+		}
+		return mysql.New(cfg)
+	}
+	cfg := postgres.Config{DSN: dsn}
+	if conn != nil {
+		cfg.Conn = conn
+	}
+	return postgres.New(cfg)
+}
+
+func setPool(db *sql.DB, pool config.PoolConfig) {
+	db.SetMaxOpenConns(pool.MaxOpenConns)
+	db.SetMaxIdleConns(pool.MaxIdleConns)
+	db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(pool.ConnMaxIdleTime)
+}
+
 func ensureSQLiteFile(dsn string) error {
+	path, err := SQLitePath(dsn)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	return os.MkdirAll(dir, 0o755)
+}
+
+// SQLitePath returns the database file named by a sqlite DSN such as
+// "file:data/app.db?mode=rwc".
+func SQLitePath(dsn string) (string, error) {
 	if dsn == "" {
-		return fmt.Errorf("sqlite DSN cannot be empty")
+		return "", fmt.Errorf("sqlite DSN cannot be empty")
 	}
 
 	// strip the file: prefix if present
@@ -80,12 +201,10 @@ func ensureSQLiteFile(dsn string) error {
 		trimmed = trimmed[:idx]
 	}
 	if trimmed == "" {
-		return fmt.Errorf("sqlite DSN did not contain a file path")
+		return "", fmt.Errorf("sqlite DSN did not contain a file path")
 	}
 
-	path := filepath.FromSlash(trimmed)
-	dir := filepath.Dir(path)
-	return os.MkdirAll(dir, 0o755)
+	return filepath.FromSlash(trimmed), nil
 }
 
 func indexRune(s string, r rune) int {
@@ -0,0 +1,164 @@
+package database
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+)
+
+var tlsModes = []string{"disable", "prefer", "require", "verify-ca", "verify-full"}
+
+// DSN returns the connection string for cfg: DB_DSN when it is set,
+// otherwise one assembled from the host, user, database and option fields.
+// There are no default credentials; a missing user or database is an error.
+func DSN(cfg config.DatabaseConfig) (string, error) {
+	if cfg.DSN != "" {
+		return cfg.DSN, nil
+	}
+	switch cfg.Type {
+	case config.DBTypeMySQL, config.DBTypePostgres:
+	case config.DBTypeSQLite:
+		return "", fmt.Errorf("sqlite DSN cannot be empty")
+	default:
+		return "", fmt.Errorf("unsupported database type: %s", cfg.Type)
+	}
+
+	if cfg.User == "" || cfg.Name == "" {
+		return "", fmt.Errorf("set DB_DSN, or DB_USER and DB_NAME, to connect to %s", cfg.Type)
+	}
+	if cfg.TLSMode != "" && !oneOf(cfg.TLSMode, tlsModes) {
+		return "", fmt.Errorf("invalid DB_TLS_MODE %q, expected one of %s", cfg.TLSMode, strings.Join(tlsModes, ", "))
+	}
+	password, err := password(cfg)
+	if err != nil {
+		return "", err
+	}
+	host := cfg.Host
+	if host == "" {
+		host = "127.0.0.1"
+	}
+
+	if cfg.Type == config.DBTypeMySQL {
+		return mysqlDSN(cfg, host, password)
+	}
+	return postgresDSN(cfg, host, password), nil
+}
+
+// password returns cfg.Password, or the first line of cfg.PasswordFile.
+func password(cfg config.DatabaseConfig) (string, error) {
+	if cfg.Password != "" || cfg.PasswordFile == "" {
+		return cfg.Password, nil
+	}
+	data, err := os.ReadFile(cfg.PasswordFile)
+	if err != nil {
+		return "", fmt.Errorf("read DB_PASSWORD_FILE: %w", err)
+	}
+	line, _, _ := strings.Cut(string(data), "\n")
+	return strings.TrimRight(line, "\r"), nil
+}
+
+func mysqlDSN(cfg config.DatabaseConfig, host, password string) (string, error) {
+	port := cfg.Port
+	if port == 0 {
+		port = 3306
+	}
+	m := mysql.NewConfig()
+	m.User = cfg.User
+	m.Passwd = password
+	m.Net = "tcp"
+	m.Addr = net.JoinHostPort(host, strconv.Itoa(port))
+	m.DBName = cfg.Name
+	m.ParseTime = true
+	m.Loc = time.Local
+	if cfg.TimeZone != "" {
+		loc, err := time.LoadLocation(cfg.TimeZone)
+		if err != nil {
+			return "", fmt.Errorf("invalid DB_TIMEZONE: %w", err)
+		}
+		m.Loc = loc
+	}
+	charset := cfg.Charset
+	if charset == "" {
+		charset = "utf8mb4"
+	}
+	m.Params = map[string]string{"charset": charset}
+
+	// The MySQL driver cannot check the certificate's CA without also
+	// checking the host name, so verify-ca is as strict as verify-full.
+	switch cfg.TLSMode {
+	case "disable":
+		m.TLSConfig = "false"
+	case "prefer":
+		m.TLSConfig = "preferred"
+	case "require":
+		m.TLSConfig = "skip-verify"
+	case "verify-ca", "verify-full":
+		m.TLSConfig = "true"
+	}
+	return m.FormatDSN(), nil
+}
+
+func postgresDSN(cfg config.DatabaseConfig, host, password string) string {
+	port := cfg.Port
+	if port == 0 {
+		port = 5432
+	}
+	u := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(cfg.User, password),
+		Host:   net.JoinHostPort(host, strconv.Itoa(port)),
+		Path:   "/" + cfg.Name,
+	}
+	if password == "" {
+		u.User = url.User(cfg.User)
+	}
+	query := url.Values{}
+	if cfg.TLSMode != "" {
+		query.Set("sslmode", cfg.TLSMode)
+	}
+	if cfg.TimeZone != "" {
+		query.Set("TimeZone", cfg.TimeZone)
+	}
+	if cfg.Charset != "" {
+		query.Set("client_encoding", cfg.Charset)
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// ReplicaDSNs returns the connection strings of the configured read
+// replicas. Replica hosts reuse the primary's credentials and options.
+func ReplicaDSNs(cfg config.DatabaseConfig) ([]string, error) {
+	if len(cfg.ReplicaDSNs) == 0 && len(cfg.ReplicaHosts) == 0 {
+		return nil, nil
+	}
+	if cfg.Type != config.DBTypeMySQL && cfg.Type != config.DBTypePostgres {
+		return nil, fmt.Errorf("read replicas are not supported for database type %q", cfg.Type)
+	}
+	dsns := append([]string(nil), cfg.ReplicaDSNs...)
+	for _, addr := range cfg.ReplicaHosts {
+		replica := cfg
+		replica.DSN = ""
+		replica.Host, replica.Port = addr, 0
+		if host, port, err := net.SplitHostPort(addr); err == nil {
+			replica.Host = host
+			if replica.Port, err = strconv.Atoi(port); err != nil {
+				return nil, fmt.Errorf("invalid replica host %q: %w", addr, err)
+			}
+		}
+		dsn, err := DSN(replica)
+		if err != nil {
+			return nil, fmt.Errorf("replica %s: %w", addr, err)
+		}
+		dsns = append(dsns, dsn)
+	}
+	return dsns, nil
+}
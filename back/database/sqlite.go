@@ -0,0 +1,162 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/mattn/go-sqlite3"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+)
+
+var (
+	journalModes = []string{"DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF"}
+	syncLevels   = []string{"OFF", "NORMAL", "FULL", "EXTRA"}
+
+	driverMu    sync.Mutex
+	driverCount int
+)
+
+// openSQLite opens one writer connection, which also serves transactions,
+// and a pool of read-only connections that gorm's dbresolver sends queries
+// to. Writes queue for the writer inside the process instead of racing for
+// SQLite's lock.
+func openSQLite(cfg config.DatabaseConfig, gormCfg *gorm.Config) (*gorm.DB, error) {
+	opts := cfg.SQLite
+	if !oneOf(opts.JournalMode, journalModes) {
+		return nil, fmt.Errorf("invalid sqlite journal mode %q, expected one of %s", opts.JournalMode, strings.Join(journalModes, ", "))
+	}
+	if !oneOf(opts.Synchronous, syncLevels) {
+		return nil, fmt.Errorf("invalid sqlite synchronous level %q, expected one of %s", opts.Synchronous, strings.Join(syncLevels, ", "))
+	}
+
+	// BEGIN IMMEDIATE takes the write lock up front, so a transaction never
+	// fails halfway when another process holds the database.
+	writerDSN := withParam(cfg.DSN, "_txlock", "immediate")
+	db, err := gorm.Open(sqlite.New(sqlite.Config{
+		DriverName: registerDriver(pragmas(opts, false)),
+		DSN:        writerDSN,
+	}), gormCfg)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	writer.SetMaxOpenConns(1)
+	writer.SetMaxIdleConns(1)
+	writer.SetConnMaxLifetime(0)
+
+	if opts.Readers > 0 {
+		err = db.Use(dbresolver.Register(dbresolver.Config{
+			Replicas: []gorm.Dialector{sqlite.New(sqlite.Config{
+				DriverName: registerDriver(pragmas(opts, true)),
+				DSN:        cfg.DSN,
+			})},
+		}).
+			SetMaxOpenConns(opts.Readers).
+			SetMaxIdleConns(opts.Readers).
+			SetConnMaxLifetime(0))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// OpenSQLiteFile opens the SQLite database at path, creating it if needed,
+// with the pragmas of cfg and a single connection like the main writer.
+// It backs the per-tenant database files.
+func OpenSQLiteFile(cfg config.DatabaseConfig, path string) (*sql.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(registerDriver(pragmas(cfg.SQLite, false)), "file:"+filepath.ToSlash(path)+"?_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	db.SetConnMaxLifetime(0)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// pragmas returns the statements run on every new connection. The journal
+// mode is stored in the database file, so only the writer sets it.
+func pragmas(opts config.SQLiteConfig, readOnly bool) []string {
+	stmts := []string{fmt.Sprintf("PRAGMA busy_timeout = %d", opts.BusyTimeout.Milliseconds())}
+	if !readOnly {
+		stmts = append(stmts, "PRAGMA journal_mode = "+opts.JournalMode)
+	}
+	stmts = append(stmts,
+		"PRAGMA synchronous = "+opts.Synchronous,
+		fmt.Sprintf("PRAGMA foreign_keys = %t", opts.ForeignKeys),
+		fmt.Sprintf("PRAGMA mmap_size = %d", opts.MMapSize),
+	)
+	if readOnly {
+		stmts = append(stmts, "PRAGMA query_only = true")
+	}
+	return stmts
+}
+
+// registerDriver registers a sqlite3 driver that runs stmts on every new
+// connection and returns its name. database/sql cannot unregister drivers,
+// so each call gets a fresh name.
+func registerDriver(stmts []string) string {
+	driverMu.Lock()
+	defer driverMu.Unlock()
+	driverCount++
+	name := fmt.Sprintf("sqlite3_app_%d", driverCount)
+	sql.Register(name, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			for _, stmt := range stmts {
+				if _, err := conn.Exec(stmt, nil); err != nil {
+					return fmt.Errorf("%s: %w", stmt, err)
+				}
+			}
+			return nil
+		},
+	})
+	return name
+}
+
+// Optimize runs PRAGMA optimize, which refreshes the query planner's
+// statistics for tables whose contents changed noticeably.
+func Optimize(db *gorm.DB) error {
+	return db.Exec("PRAGMA optimize").Error
+}
+
+// withParam adds key=value to dsn unless the DSN already sets key.
+func withParam(dsn, key, value string) string {
+	base, query, _ := strings.Cut(dsn, "?")
+	for _, pair := range strings.Split(query, "&") {
+		if k, _, _ := strings.Cut(pair, "="); k == key {
+			return dsn
+		}
+	}
+	if query == "" {
+		return base + "?" + key + "=" + value
+	}
+	return dsn + "&" + key + "=" + value
+}
+
+func oneOf(value string, allowed []string) bool {
+	for _, v := range allowed {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+// Job stores the state of a scheduled job so it survives restarts.
+type Job struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	Name           string     `gorm:"uniqueIndex" json:"name"`
+	Schedule       string     `json:"schedule"`
+	Enabled        bool       `json:"enabled"`
+	LastRunAt      *time.Time `json:"lastRunAt"`
+	LastDurationMs int64      `json:"lastDurationMs"`
+	LastError      string     `json:"lastError"`
+	NextRunAt      *time.Time `json:"nextRunAt"`
+	RunCount       int64      `json:"runCount"`
+	FailCount      int64      `json:"failCount"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	UpdatedAt      time.Time  `json:"updatedAt"`
+}
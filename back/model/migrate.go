@@ -4,5 +4,29 @@ import "gorm.io/gorm"
 
 // AutoMigrate ensures the database schema matches the application models.
 func AutoMigrate(db *gorm.DB) error {
-	return db.AutoMigrate(&User{})
+	// Emails were unique across all users before users belonged to a
+	// tenant; they are now unique per tenant.
+	if db.Migrator().HasIndex(&User{}, "idx_users_email") {
+		if err := db.Migrator().DropIndex(&User{}, "idx_users_email"); err != nil {
+			return err
+		}
+	}
+	models := []interface{}{
+		&Tenant{},
+		&Job{},
+		&Task{},
+		&RateLimitBucket{},
+	}
+	return db.AutoMigrate(append(models, TenantScoped()...)...)
+}
+
+// TenantScoped lists the models whose rows belong to a tenant. The tenant
+// package filters every query on them by tenant_id and fills it in on
+// create. Models generated by cmd/scaffold are added here.
+func TenantScoped() []interface{} {
+	return []interface{}{
+		&User{},
+		&SearchDocument{},
+		// scaffold:tenant-models
+	}
 }
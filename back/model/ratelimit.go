@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// RateLimitBucket is the token bucket of one rate limit key. Buckets are
+// only stored when the limits are shared between nodes through the
+// database.
+type RateLimitBucket struct {
+	Bucket string  `gorm:"primaryKey;size:191" json:"bucket"`
+	Tokens float64 `json:"tokens"`
+	// RefilledAt is when Tokens was last brought up to date.
+	RefilledAt time.Time `gorm:"index" json:"refilledAt"`
+}
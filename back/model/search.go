@@ -0,0 +1,20 @@
+package model
+
+import "time"
+
+// SearchDocument is the search index entry of one indexed record. The
+// database-specific full-text index (FTS5 table, FULLTEXT index or tsvector
+// column) is built over Content by the search package.
+type SearchDocument struct {
+	ID       uint   `gorm:"primaryKey" json:"id"`
+	TenantID uint   `gorm:"index;not null;default:0" json:"tenantId"`
+	Kind     string `gorm:"uniqueIndex:idx_search_documents_ref;size:64" json:"kind"`
+	RefID    uint   `gorm:"uniqueIndex:idx_search_documents_ref" json:"refId"`
+	// Fields holds the original values of the indexed fields, used for
+	// results and highlighting.
+	Fields map[string]string `gorm:"serializer:json;type:text" json:"fields"`
+	// Content is the indexed fields normalised for the database's full-text
+	// index.
+	Content   string    `gorm:"type:text" json:"-"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
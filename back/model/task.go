@@ -0,0 +1,43 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// TaskStatus is the lifecycle state of a background task.
+type TaskStatus string
+
+const (
+	TaskQueued    TaskStatus = "queued"
+	TaskRunning   TaskStatus = "running"
+	TaskSucceeded TaskStatus = "succeeded"
+	// TaskDead means the task failed permanently or ran out of attempts.
+	TaskDead     TaskStatus = "dead"
+	TaskCanceled TaskStatus = "canceled"
+)
+
+// Task is a unit of background work persisted so it survives restarts.
+type Task struct {
+	ID          uint            `gorm:"primaryKey" json:"id"`
+	Kind        string          `gorm:"index" json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      TaskStatus      `gorm:"index" json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"maxAttempts"`
+	// RunAt is the earliest time the task may start, pushed back between
+	// retries.
+	RunAt           time.Time       `gorm:"index" json:"runAt"`
+	Progress        int             `json:"progress"`
+	ProgressMessage string          `json:"progressMessage"`
+	Result          json.RawMessage `json:"result"`
+	LastError       string          `json:"lastError"`
+	// Submitter is the user id progress updates are pushed to.
+	Submitter string `json:"submitter"`
+	// TenantID is the tenant the task runs for; 0 for system tasks.
+	TenantID   uint       `gorm:"index;not null;default:0" json:"tenantId"`
+	StartedAt  *time.Time `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	UpdatedAt  time.Time  `json:"updatedAt"`
+}
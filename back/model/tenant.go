@@ -0,0 +1,14 @@
+package model
+
+import "time"
+
+// Tenant is an isolated customer of the app. Rows of tenant-scoped models
+// carry its ID in TenantID.
+type Tenant struct {
+	ID uint `gorm:"primaryKey" json:"id"`
+	// Slug identifies the tenant in subdomains, headers and cookies.
+	Slug      string    `gorm:"uniqueIndex;size:64" json:"slug"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
@@ -2,11 +2,19 @@ package model
 
 import "time"
 
+// Roles of users. Imported users without a role are viewers. Roles are
+// recorded for the application to use; the server itself checks none.
+const (
+	RoleAdmin  = "admin"
+	RoleViewer = "viewer"
+)
+
 // User represents an example table for the template project.
 type User struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
+	TenantID  uint      `gorm:"uniqueIndex:idx_users_tenant_email,priority:1;not null;default:0" json:"tenantId"`
 	Name      string    `json:"name"`
-	Email     string    `gorm:"uniqueIndex" json:"email"`
+	Email     string    `gorm:"uniqueIndex:idx_users_tenant_email,priority:2;size:255" json:"email"`
 	Role      string    `json:"role"`
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
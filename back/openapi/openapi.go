@@ -0,0 +1,315 @@
+// Package openapi assembles an OpenAPI 3.1 document from the operations that
+// controllers declare next to their route registrations.
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Version is the OpenAPI specification version emitted by Document.
+const Version = "3.1.0"
+
+// Param describes a query or header parameter.
+type Param struct {
+	Name        string
+	In          string // "query" or "header"
+	Description string
+	Required    bool
+	Schema      interface{}
+}
+
+// Response documents one status code of an operation. Body is a sample value
+// of the Go type returned, or nil for responses without a body.
+type Response struct {
+	Status      int
+	Description string
+	Body        interface{}
+	// ContentType defaults to application/json.
+	ContentType string
+}
+
+// Operation documents a single route. Path uses gin syntax relative to the
+// group it is registered on, e.g. ":id".
+type Operation struct {
+	ID          string // operationId; derived from method and path when empty
+	Method      string
+	Path        string
+	Summary     string
+	Description string
+	Tags        []string
+	Params      []Param
+	Request     interface{}
+	// RequestContentType defaults to application/json.
+	RequestContentType string
+	Responses          []Response
+}
+
+type entry struct {
+	path string // absolute gin path
+	op   Operation
+}
+
+// Document collects operations and renders them as an OpenAPI document.
+type Document struct {
+	Title       string
+	Version     string
+	Description string
+
+	entries []entry
+}
+
+// NewDocument creates an empty document with the given title and API version.
+func NewDocument(title, version string) *Document {
+	return &Document{Title: title, Version: version}
+}
+
+// Add records operations registered under basePath.
+func (d *Document) Add(basePath string, ops ...Operation) {
+	for _, op := range ops {
+		d.entries = append(d.entries, entry{path: joinPath(basePath, op.Path), op: op})
+	}
+}
+
+// Route is a documented operation together with its absolute gin path.
+type Route struct {
+	Path string
+	Operation
+}
+
+// Routes returns the documented operations in registration order.
+func (d *Document) Routes() []Route {
+	out := make([]Route, len(d.entries))
+	for i, e := range d.entries {
+		out[i] = Route{Path: e.path, Operation: e.op}
+	}
+	return out
+}
+
+// Verify compares the documented operations with the routes registered on a
+// gin engine. Every route below prefix must be documented and every documented
+// operation must be registered.
+func (d *Document) Verify(routes gin.RoutesInfo, prefix string) error {
+	documented := make(map[string]bool, len(d.entries))
+	for _, e := range d.entries {
+		documented[routeKey(e.op.Method, e.path)] = true
+	}
+
+	var problems []string
+	registered := make(map[string]bool, len(routes))
+	for _, r := range routes {
+		if !strings.HasPrefix(r.Path, prefix) {
+			continue
+		}
+		key := routeKey(r.Method, r.Path)
+		registered[key] = true
+		if !documented[key] {
+			problems = append(problems, "undocumented route "+key)
+		}
+	}
+	for key := range documented {
+		if !registered[key] {
+			problems = append(problems, "documented route is not registered: "+key)
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("openapi spec drift:\n  %s", strings.Join(problems, "\n  "))
+}
+
+// Build renders the document as a JSON-compatible value.
+func (d *Document) Build() map[string]interface{} {
+	schemas := newSchemaRegistry()
+	paths := make(map[string]map[string]interface{})
+
+	for _, e := range d.entries {
+		path, pathParams := ConvertPath(e.path)
+		item, ok := paths[path]
+		if !ok {
+			item = make(map[string]interface{})
+			paths[path] = item
+		}
+
+		op := map[string]interface{}{
+			"operationId": e.op.OperationID(e.path),
+		}
+		if e.op.Summary != "" {
+			op["summary"] = e.op.Summary
+		}
+		if e.op.Description != "" {
+			op["description"] = e.op.Description
+		}
+		if len(e.op.Tags) > 0 {
+			op["tags"] = e.op.Tags
+		}
+
+		var params []interface{}
+		for _, name := range pathParams {
+			schema := map[string]interface{}{"type": "string"}
+			if name == "id" || strings.HasSuffix(name, "Id") {
+				schema = map[string]interface{}{"type": "integer", "minimum": 0}
+			}
+			params = append(params, map[string]interface{}{
+				"name": name, "in": "path", "required": true, "schema": schema,
+			})
+		}
+		for _, p := range e.op.Params {
+			schema := map[string]interface{}{"type": "string"}
+			if p.Schema != nil {
+				schema = schemas.schemaFor(p.Schema)
+			}
+			param := map[string]interface{}{"name": p.Name, "in": p.In, "schema": schema}
+			if p.Required {
+				param["required"] = true
+			}
+			if p.Description != "" {
+				param["description"] = p.Description
+			}
+			params = append(params, param)
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+
+		if e.op.Request != nil {
+			contentType := e.op.RequestContentType
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					contentType: map[string]interface{}{"schema": schemas.schemaFor(e.op.Request)},
+				},
+			}
+		}
+
+		responses := make(map[string]interface{})
+		for _, r := range e.op.Responses {
+			desc := r.Description
+			if desc == "" {
+				desc = http.StatusText(r.Status)
+			}
+			resp := map[string]interface{}{"description": desc}
+			if r.Body != nil {
+				contentType := r.ContentType
+				if contentType == "" {
+					contentType = "application/json"
+				}
+				resp["content"] = map[string]interface{}{
+					contentType: map[string]interface{}{"schema": schemas.schemaFor(r.Body)},
+				}
+			}
+			responses[fmt.Sprintf("%d", r.Status)] = resp
+		}
+		if len(responses) == 0 {
+			responses["default"] = map[string]interface{}{"description": "Unspecified response"}
+		}
+		op["responses"] = responses
+
+		item[strings.ToLower(e.op.Method)] = op
+	}
+
+	info := map[string]interface{}{"title": d.Title, "version": d.Version}
+	if d.Description != "" {
+		info["description"] = d.Description
+	}
+
+	return map[string]interface{}{
+		"openapi":    Version,
+		"info":       info,
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas.defs},
+	}
+}
+
+// MarshalIndent renders the document as indented JSON with a trailing newline.
+func (d *Document) MarshalIndent() ([]byte, error) {
+	data, err := json.MarshalIndent(d.Build(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// Handler serves the document as JSON.
+func (d *Document) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, d.Build())
+	}
+}
+
+// ViewerHandler serves a self-contained HTML page that renders the document
+// fetched from specURL. It does not depend on any CDN so it works offline.
+// The page sets a Content-Security-Policy of its own that allows exactly its
+// inline script and style.
+func ViewerHandler(title, specURL string) gin.HandlerFunc {
+	page := strings.NewReplacer("{{TITLE}}", title, "{{SPEC_URL}}", specURL).Replace(viewerHTML)
+	csp := viewerCSP(page)
+	return func(c *gin.Context) {
+		c.Header("Content-Security-Policy", csp)
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(page))
+	}
+}
+
+func routeKey(method, path string) string {
+	return strings.ToUpper(method) + " " + path
+}
+
+func joinPath(base, rel string) string {
+	switch {
+	case rel == "":
+		return base
+	case strings.HasSuffix(base, "/"):
+		return base + strings.TrimPrefix(rel, "/")
+	case strings.HasPrefix(rel, "/"):
+		return base + rel
+	default:
+		return base + "/" + rel
+	}
+}
+
+// ConvertPath turns gin ":param" and "*param" segments into OpenAPI "{param}".
+func ConvertPath(path string) (string, []string) {
+	segments := strings.Split(path, "/")
+	var params []string
+	for i, seg := range segments {
+		if strings.HasPrefix(seg, ":") || strings.HasPrefix(seg, "*") {
+			name := seg[1:]
+			params = append(params, name)
+			segments[i] = "{" + name + "}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// OperationID returns op.ID, or one derived from the method and absolute path.
+func (op Operation) OperationID(path string) string {
+	if op.ID != "" {
+		return op.ID
+	}
+	return OperationID(op.Method, path)
+}
+
+// OperationID derives a camelCase identifier such as "getUsersId" from a route.
+func OperationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, seg := range strings.Split(path, "/") {
+		seg = strings.TrimLeft(seg, ":*")
+		if seg == "" || seg == "api" {
+			continue
+		}
+		for _, part := range strings.FieldsFunc(seg, func(r rune) bool { return r == '-' || r == '_' || r == '.' }) {
+			b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,155 @@
+package openapi
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+)
+
+// viewerCSP returns a policy that allows the inline <script> and <style>
+// elements of page by their hashes, and nothing else but same-origin
+// requests.
+func viewerCSP(page string) string {
+	return "default-src 'none'; connect-src 'self'; img-src 'self'; base-uri 'none'; form-action 'none'; frame-ancestors 'none'; " +
+		"script-src " + inlineHash(page, "script") + "; style-src " + inlineHash(page, "style")
+}
+
+// inlineHash returns the CSP hash source of the first tag element of page.
+func inlineHash(page, tag string) string {
+	_, rest, _ := strings.Cut(page, "<"+tag+">")
+	body, _, _ := strings.Cut(rest, "</"+tag+">")
+	sum := sha256.Sum256([]byte(body))
+	return "'sha256-" + base64.StdEncoding.EncodeToString(sum[:]) + "'"
+}
+
+const viewerHTML = `<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{TITLE}}</title>
+<style>
+  body { font-family: system-ui, sans-serif; margin: 0; background: #f8fafc; color: #0f172a; }
+  header { padding: 16px 24px; background: #0f172a; color: #f8fafc; }
+  header h1 { margin: 0; font-size: 20px; }
+  header p { margin: 4px 0 0; opacity: .7; font-size: 13px; }
+  main { max-width: 1100px; margin: 0 auto; padding: 24px; }
+  h2 { font-size: 16px; margin: 24px 0 8px; text-transform: capitalize; }
+  details { background: #fff; border: 1px solid #e2e8f0; border-radius: 8px; margin-bottom: 8px; }
+  summary { cursor: pointer; padding: 10px 14px; display: flex; gap: 12px; align-items: center; }
+  .method { font-weight: 700; font-size: 12px; min-width: 64px; text-align: center; padding: 3px 6px; border-radius: 4px; color: #fff; }
+  .get { background: #2563eb; } .post { background: #16a34a; } .put { background: #d97706; }
+  .patch { background: #9333ea; } .delete { background: #dc2626; }
+  .path { font-family: ui-monospace, monospace; }
+  .summary { color: #64748b; font-size: 13px; }
+  .body { padding: 0 14px 14px; border-top: 1px solid #e2e8f0; }
+  pre { background: #f1f5f9; padding: 10px; border-radius: 6px; overflow: auto; font-size: 12px; }
+  h4 { margin: 12px 0 4px; font-size: 13px; }
+  .try { display: flex; flex-direction: column; gap: 6px; }
+  .try input, .try textarea { font-family: ui-monospace, monospace; font-size: 12px; padding: 6px; border: 1px solid #cbd5e1; border-radius: 4px; }
+  .try button { align-self: flex-start; padding: 6px 14px; border: 0; border-radius: 4px; background: #0f172a; color: #fff; cursor: pointer; }
+</style>
+</head>
+<body>
+<header><h1>{{TITLE}}</h1><p id="meta">Loading {{SPEC_URL}}…</p></header>
+<main id="root"></main>
+<script>
+(async function () {
+  const res = await fetch("{{SPEC_URL}}");
+  const spec = await res.json();
+  const schemas = (spec.components && spec.components.schemas) || {};
+  document.getElementById("meta").textContent =
+    "OpenAPI " + spec.openapi + " · version " + spec.info.version + " · {{SPEC_URL}}";
+
+  function resolve(schema, depth) {
+    if (!schema || depth > 6) return schema;
+    if (schema.$ref) {
+      const name = schema.$ref.split("/").pop();
+      return resolve(schemas[name], depth + 1);
+    }
+    const out = Array.isArray(schema) ? [] : {};
+    for (const key in schema) {
+      const value = schema[key];
+      out[key] = value && typeof value === "object" ? resolve(value, depth + 1) : value;
+    }
+    return out;
+  }
+
+  function el(tag, attrs, children) {
+    const node = document.createElement(tag);
+    Object.assign(node, attrs || {});
+    (children || []).forEach(function (c) {
+      node.append(c instanceof Node ? c : document.createTextNode(c));
+    });
+    return node;
+  }
+
+  function block(title, value) {
+    return [el("h4", {}, [title]), el("pre", {}, [JSON.stringify(value, null, 2)])];
+  }
+
+  const groups = {};
+  Object.keys(spec.paths).sort().forEach(function (path) {
+    const item = spec.paths[path];
+    Object.keys(item).forEach(function (method) {
+      const op = item[method];
+      const tag = (op.tags && op.tags[0]) || "default";
+      (groups[tag] = groups[tag] || []).push({ path: path, method: method, op: op });
+    });
+  });
+
+  const root = document.getElementById("root");
+  Object.keys(groups).sort().forEach(function (tag) {
+    root.append(el("h2", {}, [tag]));
+    groups[tag].forEach(function (entry) {
+      const op = entry.op;
+      const body = el("div", { className: "body" });
+      if (op.description) body.append(el("p", {}, [op.description]));
+      if (op.parameters) body.append.apply(body, block("Parameters", op.parameters));
+      if (op.requestBody) {
+        body.append.apply(body, block("Request body", resolve(op.requestBody.content["application/json"].schema, 0)));
+      }
+      Object.keys(op.responses).forEach(function (status) {
+        const resp = op.responses[status];
+        const content = resp.content && resp.content["application/json"];
+        body.append.apply(body, block(status + " " + resp.description, content ? resolve(content.schema, 0) : null));
+      });
+
+      const url = el("input", { value: entry.path });
+      const payload = el("textarea", { rows: 4, placeholder: "JSON body" });
+      const output = el("pre", {}, []);
+      const send = el("button", { type: "button" }, ["Send"]);
+      send.onclick = async function () {
+        try {
+          const init = { method: entry.method.toUpperCase(), headers: {} };
+          if (payload.value.trim()) {
+            init.body = payload.value;
+            init.headers["Content-Type"] = "application/json";
+          }
+          const r = await fetch(url.value, init);
+          const text = await r.text();
+          output.textContent = r.status + " " + r.statusText + "\n" + text;
+        } catch (err) {
+          output.textContent = String(err);
+        }
+      };
+      const tryIt = el("div", { className: "try" }, [el("h4", {}, ["Try it"]), url]);
+      if (op.requestBody) tryIt.append(payload);
+      tryIt.append(send, output);
+      body.append(tryIt);
+
+      root.append(el("details", {}, [
+        el("summary", {}, [
+          el("span", { className: "method " + entry.method }, [entry.method.toUpperCase()]),
+          el("span", { className: "path" }, [entry.path]),
+          el("span", { className: "summary" }, [op.summary || ""]),
+        ]),
+        body,
+      ]));
+    });
+  });
+})();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+	"github.com/wonderfulsuccess/go-web-app/back/model"
+)
+
+// DBStore keeps the buckets in the rate_limit_buckets table so that every
+// node of a cluster sees the same limits. Each request costs a short
+// transaction on the primary database that locks the bucket's row.
+type DBStore struct {
+	db *gorm.DB
+}
+
+// NewDBStore returns a store on db, which must be migrated with
+// model.AutoMigrate.
+func NewDBStore(db *gorm.DB) *DBStore {
+	return &DBStore{db: db}
+}
+
+// Take implements Store.
+func (s *DBStore) Take(ctx context.Context, key string, rate config.Rate, now time.Time) (Result, error) {
+	now = now.UTC()
+	var res Result
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		row, found, err := lockBucket(tx, key)
+		if err == nil && !found {
+			// Another node may add the bucket at the same time; whichever
+			// insert loses, both then lock the same row.
+			err = tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&model.RateLimitBucket{Bucket: key, Tokens: float64(rate.Count), RefilledAt: now}).Error
+			if err == nil {
+				row, _, err = lockBucket(tx, key)
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		b := bucket{tokens: row.Tokens, last: row.RefilledAt}
+		res = b.take(rate, now)
+		return tx.Model(&row).Updates(map[string]interface{}{"tokens": b.tokens, "refilled_at": b.last}).Error
+	})
+	return res, err
+}
+
+func lockBucket(tx *gorm.DB, key string) (model.RateLimitBucket, bool, error) {
+	var row model.RateLimitBucket
+	res := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("bucket = ?", key).Limit(1).Find(&row)
+	return row, res.RowsAffected > 0, res.Error
+}
+
+// Purge deletes the buckets untouched since before, which have refilled
+// completely when before is at least the longest period ago.
+func (s *DBStore) Purge(ctx context.Context, before time.Time) (int64, error) {
+	res := s.db.WithContext(ctx).Where("refilled_at < ?", before.UTC()).Delete(&model.RateLimitBucket{})
+	return res.RowsAffected, res.Error
+}
@@ -0,0 +1,56 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+)
+
+// sweepInterval is how often MemoryStore forgets full buckets.
+const sweepInterval = time.Minute
+
+// MemoryStore keeps the buckets in the process. Limits are per node, so a
+// client of a cluster behind a load balancer gets the limit of every node.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+	swept   time.Time
+}
+
+type memoryBucket struct {
+	bucket
+	// full is when the bucket will have refilled completely; from then on
+	// it equals a new bucket and can be dropped.
+	full time.Time
+}
+
+// NewMemoryStore returns an empty store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*memoryBucket)}
+}
+
+// Take implements Store.
+func (s *MemoryStore) Take(_ context.Context, key string, rate config.Rate, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.swept) >= sweepInterval {
+		for k, b := range s.buckets {
+			if !now.Before(b.full) {
+				delete(s.buckets, k)
+			}
+		}
+		s.swept = now
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &memoryBucket{}
+		s.buckets[key] = b
+	}
+	res := b.take(rate, now)
+	b.full = now.Add(res.Reset)
+	return res, nil
+}
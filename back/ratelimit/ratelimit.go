@@ -0,0 +1,114 @@
+// Package ratelimit throttles clients with token buckets. Every key, such
+// as a client address or a user, has a bucket holding up to Rate.Count
+// tokens that refill evenly over Rate.Period; each request takes one token
+// and is refused when the bucket is empty.
+//
+// Buckets live in a Store: MemoryStore keeps them in the process, DBStore
+// in the database so that the nodes of a cluster share them.
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+)
+
+// Result is the state of a bucket after a request took from it.
+type Result struct {
+	Allowed bool
+	// Limit is the bucket size and Remaining the whole tokens left in it.
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until a refused request would be allowed.
+	RetryAfter time.Duration
+}
+
+// Store holds the buckets.
+type Store interface {
+	// Take takes a token from the bucket of key, creating a full bucket
+	// for unknown keys.
+	Take(ctx context.Context, key string, rate config.Rate, now time.Time) (Result, error)
+}
+
+// Rule is one limit checked by Limiter.Allow.
+type Rule struct {
+	Key  string
+	Rate config.Rate
+}
+
+// Limiter checks requests against a set of rules.
+type Limiter struct {
+	store Store
+	now   func() time.Time
+}
+
+// New returns a limiter keeping its buckets in store.
+func New(store Store) *Limiter {
+	return &Limiter{store: store, now: time.Now}
+}
+
+// Allow takes a token from the bucket of every enabled rule, in order, and
+// stops at the first empty one. List the narrowest rules first so that a
+// refused request does not use up the allowance of the broader ones.
+//
+// The result describes the refusing bucket, or else the one with the
+// fewest tokens left; Limit is 0 when no rule is enabled.
+func (l *Limiter) Allow(ctx context.Context, rules ...Rule) (Result, error) {
+	now := l.now()
+	out := Result{Allowed: true}
+	for _, rule := range rules {
+		if !rule.Rate.Enabled() {
+			continue
+		}
+		res, err := l.store.Take(ctx, rule.Key, rule.Rate, now)
+		if err != nil {
+			return Result{}, err
+		}
+		if !res.Allowed {
+			return res, nil
+		}
+		if out.Limit == 0 || res.Remaining < out.Remaining {
+			out = res
+		}
+	}
+	return out, nil
+}
+
+// bucket is the state shared by the stores.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take refills the bucket for the time elapsed since the last request and
+// takes a token if one is left. A zero bucket starts full.
+func (b *bucket) take(rate config.Rate, now time.Time) Result {
+	size := float64(rate.Count)
+	perToken := rate.Period / time.Duration(rate.Count)
+	if perToken <= 0 {
+		perToken = 1
+	}
+	switch {
+	case b.last.IsZero():
+		b.tokens = size
+		b.last = now
+	case now.After(b.last):
+		// Clocks of different nodes may disagree; never refill backwards.
+		b.tokens = min(size, b.tokens+float64(now.Sub(b.last))/float64(perToken))
+		b.last = now
+	}
+
+	res := Result{Limit: rate.Count}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = time.Duration((1 - b.tokens) * float64(perToken))
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = time.Duration((size - b.tokens) * float64(perToken))
+	return res
+}
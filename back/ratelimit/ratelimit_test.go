@@ -0,0 +1,164 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+	"github.com/wonderfulsuccess/go-web-app/back/model"
+)
+
+var start = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// take is one request against a bucket, at offset from start.
+type take struct {
+	at        time.Duration
+	allowed   bool
+	remaining int
+	retry     time.Duration
+}
+
+var bucketTests = []struct {
+	name  string
+	rate  config.Rate
+	takes []take
+}{
+	{
+		name: "burst then refuse",
+		rate: config.Rate{Count: 3, Period: 3 * time.Second},
+		takes: []take{
+			{allowed: true, remaining: 2},
+			{allowed: true, remaining: 1},
+			{allowed: true, remaining: 0},
+			{allowed: false, remaining: 0, retry: time.Second},
+		},
+	},
+	{
+		name: "refills evenly",
+		rate: config.Rate{Count: 2, Period: 2 * time.Second},
+		takes: []take{
+			{allowed: true, remaining: 1},
+			{allowed: true, remaining: 0},
+			{at: 500 * time.Millisecond, allowed: false, retry: 500 * time.Millisecond},
+			{at: time.Second, allowed: true, remaining: 0},
+			{at: 10 * time.Second, allowed: true, remaining: 1},
+		},
+	},
+	{
+		name: "clock going backwards refills nothing",
+		rate: config.Rate{Count: 1, Period: time.Minute},
+		takes: []take{
+			{at: time.Minute, allowed: true},
+			{allowed: false, retry: time.Minute},
+		},
+	},
+}
+
+func runBucketTests(t *testing.T, store Store) {
+	for i, tt := range bucketTests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := fmt.Sprintf("test:%d", i)
+			for j, want := range tt.takes {
+				res, err := store.Take(context.Background(), key, tt.rate, start.Add(want.at))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if res.Allowed != want.allowed || res.Remaining != want.remaining || res.RetryAfter != want.retry || res.Limit != tt.rate.Count {
+					t.Fatalf("take %d: got %+v, want allowed %v remaining %d retry %s", j, res, want.allowed, want.remaining, want.retry)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	runBucketTests(t, NewMemoryStore())
+}
+
+func TestDBStore(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:ratelimit_test?mode=memory&cache=shared"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&model.RateLimitBucket{}); err != nil {
+		t.Fatal(err)
+	}
+	store := NewDBStore(db)
+	runBucketTests(t, store)
+
+	n, err := store.Purge(context.Background(), start.Add(30*time.Second))
+	if err != nil || n != 2 {
+		t.Fatalf("purged %d buckets, %v; want the 2 idle ones", n, err)
+	}
+}
+
+func TestMemoryStoreForgetsFullBuckets(t *testing.T) {
+	store := NewMemoryStore()
+	rate := config.Rate{Count: 1, Period: time.Second}
+	for _, key := range []string{"a", "b"} {
+		if _, err := store.Take(context.Background(), key, rate, start); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.Take(context.Background(), "c", rate, start.Add(sweepInterval)); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.buckets) != 1 {
+		t.Fatalf("kept %d buckets after the sweep, want only c", len(store.buckets))
+	}
+}
+
+func TestAllow(t *testing.T) {
+	narrow := Rule{Key: "narrow", Rate: config.Rate{Count: 1, Period: time.Minute}}
+	broad := Rule{Key: "broad", Rate: config.Rate{Count: 5, Period: time.Minute}}
+	off := Rule{Key: "off"}
+	tests := []struct {
+		name      string
+		rules     []Rule
+		allowed   []bool
+		remaining int
+		limit     int
+	}{
+		{name: "no rules", rules: nil, allowed: []bool{true, true}, limit: 0},
+		{name: "disabled rule", rules: []Rule{off}, allowed: []bool{true, true}, limit: 0},
+		{name: "fewest remaining wins", rules: []Rule{broad, off}, allowed: []bool{true}, remaining: 4, limit: 5},
+		{name: "narrow refuses first", rules: []Rule{narrow, broad}, allowed: []bool{true, false, false}, remaining: 0, limit: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := New(NewMemoryStore())
+			limiter.now = func() time.Time { return start }
+			var res Result
+			for i, want := range tt.allowed {
+				var err error
+				res, err = limiter.Allow(context.Background(), tt.rules...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if res.Allowed != want {
+					t.Fatalf("request %d: allowed %v, want %v", i, res.Allowed, want)
+				}
+			}
+			if res.Remaining != tt.remaining || res.Limit != tt.limit {
+				t.Fatalf("last result %+v, want remaining %d limit %d", res, tt.remaining, tt.limit)
+			}
+		})
+	}
+
+	// A refusal by the narrow rule leaves the broad bucket alone.
+	limiter := New(NewMemoryStore())
+	limiter.now = func() time.Time { return start }
+	for i := 0; i < 3; i++ {
+		_, _ = limiter.Allow(context.Background(), narrow, broad)
+	}
+	res, _ := limiter.Allow(context.Background(), broad)
+	if res.Remaining != 3 {
+		t.Fatalf("broad bucket has %d tokens left, want 3", res.Remaining)
+	}
+}
@@ -1 +1 @@
-go run ./app/main.go
\ No newline at end of file
+go run -tags sqlite_fts5 ./app/main.go
\ No newline at end of file
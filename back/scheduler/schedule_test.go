@@ -0,0 +1,101 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseNext(t *testing.T) {
+	// Thursday, 1 January 2026.
+	from := time.Date(2026, 1, 1, 10, 30, 15, 0, time.UTC)
+	tests := []struct {
+		spec string
+		want []string
+	}{
+		{spec: "* * * * *", want: []string{"2026-01-01 10:31", "2026-01-01 10:32"}},
+		{spec: "0 3 * * *", want: []string{"2026-01-02 03:00", "2026-01-03 03:00"}},
+		{spec: "*/15 * * * *", want: []string{"2026-01-01 10:45", "2026-01-01 11:00"}},
+		{spec: "5-59/20 * * * *", want: []string{"2026-01-01 10:45", "2026-01-01 11:05"}},
+		{spec: "10/25 9-11 * * *", want: []string{"2026-01-01 10:35", "2026-01-01 11:10"}},
+		{spec: "0 9 * * mon-fri", want: []string{"2026-01-02 09:00", "2026-01-05 09:00"}},
+		{spec: "0 0 * * 7", want: []string{"2026-01-04 00:00", "2026-01-11 00:00"}},
+		{spec: "0 0 1,15 feb *", want: []string{"2026-02-01 00:00", "2026-02-15 00:00"}},
+		{spec: "0 0 29 2 *", want: []string{"2028-02-29 00:00"}},
+		// Both day fields restricted: either may match.
+		{spec: "0 0 13 * fri", want: []string{"2026-01-02 00:00", "2026-01-09 00:00", "2026-01-13 00:00"}},
+		// A step over * leaves the day field unrestricted, so both must match.
+		{spec: "0 0 */2 * mon", want: []string{"2026-01-05 00:00", "2026-01-19 00:00"}},
+		{spec: "0 0 1 * */2", want: []string{"2026-02-01 00:00", "2026-03-01 00:00", "2026-08-01 00:00"}},
+		{spec: "0 0 ? * sun", want: []string{"2026-01-04 00:00"}},
+		{spec: "@hourly", want: []string{"2026-01-01 11:00"}},
+		{spec: "@daily", want: []string{"2026-01-02 00:00"}},
+		{spec: "@weekly", want: []string{"2026-01-04 00:00"}},
+		{spec: "@monthly", want: []string{"2026-02-01 00:00"}},
+		{spec: "@yearly", want: []string{"2027-01-01 00:00"}},
+		{spec: "@every 90s", want: []string{"2026-01-01 10:31", "2026-01-01 10:33"}},
+		{spec: "0 0 30 2 *", want: []string{""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			next := from
+			for i, want := range tt.want {
+				next = s.Next(next)
+				got := ""
+				if !next.IsZero() {
+					got = next.Format("2006-01-02 15:04")
+				}
+				if got != want {
+					t.Fatalf("activation %d = %q, want %q", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"* * * foo *",
+		"@every",
+		"@every 0s",
+		"@every -1m",
+		"@often",
+	} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestUnrestricted(t *testing.T) {
+	tests := []struct {
+		field string
+		want  bool
+	}{
+		{"*", true},
+		{"?", true},
+		{"*/2", true},
+		{"1-31", false},
+		{"1/2", false},
+		{"mon", false},
+	}
+	for _, tt := range tests {
+		if got := unrestricted(tt.field); got != tt.want {
+			t.Errorf("unrestricted(%q) = %v, want %v", tt.field, got, tt.want)
+		}
+	}
+}
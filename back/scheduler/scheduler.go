@@ -0,0 +1,417 @@
+// Package scheduler runs named jobs on cron or interval schedules. Each job
+// runs in its own goroutine, never overlaps with itself and has its state
+// persisted in the jobs table.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/wonderfulsuccess/go-web-app/back/logger"
+	"github.com/wonderfulsuccess/go-web-app/back/model"
+)
+
+var (
+	// ErrNotFound is returned for names that were never registered.
+	ErrNotFound = errors.New("job not found")
+	// ErrRunning is returned when triggering a job that is still running.
+	ErrRunning = errors.New("job is already running")
+)
+
+// Job describes a unit of scheduled work.
+type Job struct {
+	Name        string
+	Description string
+	// Schedule is a cron expression or "@every <duration>", see Parse.
+	Schedule string
+	// Enabled is the initial state; once toggled through the scheduler the
+	// persisted state wins.
+	Enabled bool
+	// Volatile jobs start in the Enabled state on every registration;
+	// toggling them lasts until the server restarts.
+	Volatile bool
+	// Timeout cancels the run's context after the given duration; zero
+	// means no limit.
+	Timeout time.Duration
+	Run     func(ctx context.Context) error
+}
+
+// JobStatus is the externally visible state of a job.
+type JobStatus struct {
+	Name           string     `json:"name"`
+	Description    string     `json:"description"`
+	Schedule       string     `json:"schedule"`
+	Enabled        bool       `json:"enabled"`
+	Running        bool       `json:"running"`
+	LastRunAt      *time.Time `json:"lastRunAt"`
+	LastDurationMs int64      `json:"lastDurationMs"`
+	LastError      string     `json:"lastError"`
+	NextRunAt      *time.Time `json:"nextRunAt"`
+	RunCount       int64      `json:"runCount"`
+	FailCount      int64      `json:"failCount"`
+	// Skipped counts activations dropped because the previous run was
+	// still in progress. It is not persisted.
+	Skipped int64 `json:"skipped"`
+}
+
+// Scheduler owns the registered jobs.
+type Scheduler struct {
+	db *gorm.DB
+
+	mu       sync.Mutex
+	entries  map[string]*entry
+	ctx      context.Context
+	onChange []func(JobStatus)
+}
+
+type entry struct {
+	s        *Scheduler
+	job      Job
+	schedule Schedule
+	// frequent is set for jobs running more than once a minute, whose next
+	// run and start are not published.
+	frequent bool
+	wake     chan struct{}
+	trigger  chan struct{}
+	// persistMu keeps saves in the order the changes were made.
+	persistMu sync.Mutex
+
+	mu      sync.Mutex
+	record  model.Job
+	running bool
+	skipped int64
+}
+
+// New creates a scheduler persisting to db; a nil db keeps state in memory.
+func New(db *gorm.DB) *Scheduler {
+	return &Scheduler{db: db, entries: make(map[string]*entry)}
+}
+
+// OnChange registers fn to be called whenever a job's status changes. Call
+// it before Start.
+func (s *Scheduler) OnChange(fn func(JobStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onChange = append(s.onChange, fn)
+}
+
+// Register adds a job. Jobs registered after Start begin immediately.
+func (s *Scheduler) Register(job Job) error {
+	schedule, err := Parse(job.Schedule)
+	if err != nil {
+		return fmt.Errorf("job %s: %w", job.Name, err)
+	}
+	if job.Run == nil {
+		return fmt.Errorf("job %s: Run is nil", job.Name)
+	}
+
+	e := &entry{
+		s:        s,
+		job:      job,
+		schedule: schedule,
+		frequent: isFrequent(schedule),
+		wake:     make(chan struct{}, 1),
+		trigger:  make(chan struct{}, 1),
+		record:   model.Job{Name: job.Name, Schedule: job.Schedule, Enabled: job.Enabled},
+	}
+	if err := s.load(e); err != nil {
+		return fmt.Errorf("job %s: %w", job.Name, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[job.Name]; ok {
+		return fmt.Errorf("job %s is already registered", job.Name)
+	}
+	s.entries[job.Name] = e
+	if s.ctx != nil {
+		go e.loop(s.ctx)
+	}
+	return nil
+}
+
+// load restores the persisted record, creating it on first registration
+// and updating it when the schedule changed.
+func (s *Scheduler) load(e *entry) error {
+	if s.db == nil {
+		return nil
+	}
+	var rec model.Job
+	res := s.db.Where("name = ?", e.job.Name).Limit(1).Find(&rec)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return s.db.Create(&e.record).Error
+	}
+	stale := rec.Schedule != e.job.Schedule || (e.job.Volatile && rec.Enabled != e.job.Enabled)
+	rec.Schedule = e.job.Schedule
+	if e.job.Volatile {
+		rec.Enabled = e.job.Enabled
+	}
+	e.record = rec
+	if stale {
+		return s.db.Save(&rec).Error
+	}
+	return nil
+}
+
+// Start runs every registered job until ctx is done.
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx != nil {
+		return
+	}
+	s.ctx = ctx
+	for _, e := range s.entries {
+		go e.loop(ctx)
+	}
+}
+
+// List returns the status of every job sorted by name.
+func (s *Scheduler) List() []JobStatus {
+	s.mu.Lock()
+	entries := make([]*entry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, e)
+	}
+	s.mu.Unlock()
+
+	list := make([]JobStatus, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, e.status())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Get returns the status of one job.
+func (s *Scheduler) Get(name string) (JobStatus, error) {
+	e, err := s.entry(name)
+	if err != nil {
+		return JobStatus{}, err
+	}
+	return e.status(), nil
+}
+
+// SetEnabled turns a job's schedule on or off and persists the choice. A
+// run in progress is not interrupted.
+func (s *Scheduler) SetEnabled(name string, enabled bool) (JobStatus, error) {
+	e, err := s.entry(name)
+	if err != nil {
+		return JobStatus{}, err
+	}
+	e.mu.Lock()
+	e.record.Enabled = enabled
+	if !enabled {
+		e.record.NextRunAt = nil
+	}
+	e.mu.Unlock()
+	e.changed(true)
+	signal(e.wake)
+	return e.status(), nil
+}
+
+// Trigger runs a job now, regardless of its schedule and enabled state.
+func (s *Scheduler) Trigger(name string) error {
+	e, err := s.entry(name)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	running := e.running
+	e.mu.Unlock()
+	if running {
+		return ErrRunning
+	}
+	signal(e.trigger)
+	return nil
+}
+
+func (s *Scheduler) entry(name string) (*entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return e, nil
+}
+
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// loop waits for the next activation and runs the job inline, so a slow run
+// delays the following activation instead of overlapping with it.
+func (e *entry) loop(ctx context.Context) {
+	for {
+		e.mu.Lock()
+		var next time.Time
+		if e.record.Enabled {
+			next = e.schedule.Next(time.Now())
+		}
+		if next.IsZero() {
+			e.record.NextRunAt = nil
+		} else {
+			e.record.NextRunAt = &next
+		}
+		e.mu.Unlock()
+		if !e.frequent {
+			e.changed(false)
+		}
+
+		var timer *time.Timer
+		var fire <-chan time.Time
+		if !next.IsZero() {
+			timer = time.NewTimer(time.Until(next))
+			fire = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			stopTimer(timer)
+			return
+		case <-e.wake:
+			stopTimer(timer)
+		case <-e.trigger:
+			stopTimer(timer)
+			e.run(ctx, time.Time{})
+		case <-fire:
+			e.run(ctx, next)
+		}
+	}
+}
+
+func stopTimer(t *time.Timer) {
+	if t != nil {
+		t.Stop()
+	}
+}
+
+// run executes the job once. due is the scheduled activation, zero for
+// manual triggers; activations that came due while the job ran are counted
+// as skipped.
+func (e *entry) run(ctx context.Context, due time.Time) {
+	e.mu.Lock()
+	e.running = true
+	e.mu.Unlock()
+	if !e.frequent {
+		e.changed(false)
+	}
+
+	runCtx, cancel := ctx, context.CancelFunc(func() {})
+	if e.job.Timeout > 0 {
+		runCtx, cancel = context.WithTimeout(ctx, e.job.Timeout)
+	}
+	started := time.Now()
+	err := e.call(runCtx)
+	cancel()
+	finished := time.Now()
+
+	e.mu.Lock()
+	e.running = false
+	if !due.IsZero() {
+		e.skipped += missed(e.schedule, due, finished)
+	}
+	e.record.LastRunAt = &started
+	e.record.LastDurationMs = finished.Sub(started).Milliseconds()
+	e.record.RunCount++
+	e.record.LastError = ""
+	if err != nil {
+		e.record.LastError = err.Error()
+		e.record.FailCount++
+	}
+	if next := e.schedule.Next(finished); e.record.Enabled && !next.IsZero() {
+		e.record.NextRunAt = &next
+	}
+	e.mu.Unlock()
+
+	if err != nil {
+		logger.Errorf("job %s failed: %v", e.job.Name, err)
+	}
+	e.changed(true)
+}
+
+// isFrequent reports whether schedule runs more than once a minute.
+func isFrequent(schedule Schedule) bool {
+	first := schedule.Next(time.Now())
+	second := schedule.Next(first)
+	return !first.IsZero() && !second.IsZero() && second.Sub(first) < time.Minute
+}
+
+// missed counts the activations after due and before end, giving up at a
+// thousand.
+func missed(schedule Schedule, due, end time.Time) int64 {
+	var n int64
+	for t := schedule.Next(due); !t.IsZero() && t.Before(end) && n < 1000; t = schedule.Next(t) {
+		n++
+	}
+	return n
+}
+
+// call runs the job, turning a panic into an error.
+func (e *entry) call(ctx context.Context) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+	return e.job.Run(ctx)
+}
+
+func (e *entry) status() JobStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return JobStatus{
+		Name:           e.job.Name,
+		Description:    e.job.Description,
+		Schedule:       e.job.Schedule,
+		Enabled:        e.record.Enabled,
+		Running:        e.running,
+		LastRunAt:      e.record.LastRunAt,
+		LastDurationMs: e.record.LastDurationMs,
+		LastError:      e.record.LastError,
+		NextRunAt:      e.record.NextRunAt,
+		RunCount:       e.record.RunCount,
+		FailCount:      e.record.FailCount,
+		Skipped:        e.skipped,
+	}
+}
+
+// changed notifies listeners and, if persist is set, saves the record.
+// Only finished runs and changes of the enabled state are saved, so busy
+// schedules do not keep the database writer occupied.
+func (e *entry) changed(persist bool) {
+	if persist {
+		e.persistMu.Lock()
+		e.mu.Lock()
+		rec := e.record
+		e.mu.Unlock()
+		if db := e.s.db; db != nil && rec.ID != 0 {
+			if err := db.Save(&rec).Error; err != nil {
+				logger.Errorf("failed to persist job %s: %v", rec.Name, err)
+			}
+		}
+		e.persistMu.Unlock()
+	}
+
+	status := e.status()
+	e.s.mu.Lock()
+	listeners := e.s.onChange
+	e.s.mu.Unlock()
+	for _, fn := range listeners {
+		fn(status)
+	}
+}
@@ -0,0 +1,239 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"github.com/wonderfulsuccess/go-web-app/back/tenant"
+)
+
+// backend is the database-specific half of the engine: it owns the
+// full-text index over search_documents.content and translates parsed
+// queries into that index's syntax.
+type backend interface {
+	// name identifies the backend in results and logs.
+	name() string
+	// migrate creates the full-text index. It runs after AutoMigrate created
+	// search_documents and must be safe to repeat.
+	migrate(db *gorm.DB) error
+	// content prepares the text of the indexed fields for the index.
+	content(text string) string
+	// search returns matching document ids, best first.
+	search(db *gorm.DB, terms []term, kinds []string, limit, offset int) ([]scored, error)
+}
+
+type scored struct {
+	ID    uint
+	Score float64
+}
+
+// filterKinds restricts a query to the given index kinds.
+func filterKinds(tx *gorm.DB, column string, kinds []string) *gorm.DB {
+	if len(kinds) == 0 {
+		return tx
+	}
+	return tx.Where(column+" IN ?", kinds)
+}
+
+// sqliteFTS5 indexes content with an external-content FTS5 table kept in
+// sync by triggers. Content is segmented so the unicode61 tokenizer sees
+// every CJK character as a token.
+type sqliteFTS5 struct{}
+
+func (sqliteFTS5) name() string { return "sqlite-fts5" }
+
+func (sqliteFTS5) content(text string) string { return segment(text) }
+
+var sqliteTriggers = []string{
+	`CREATE TRIGGER IF NOT EXISTS search_documents_ai AFTER INSERT ON search_documents BEGIN
+		INSERT INTO search_fts(rowid, content) VALUES (new.id, new.content);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS search_documents_ad AFTER DELETE ON search_documents BEGIN
+		INSERT INTO search_fts(search_fts, rowid, content) VALUES ('delete', old.id, old.content);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS search_documents_au AFTER UPDATE ON search_documents BEGIN
+		INSERT INTO search_fts(search_fts, rowid, content) VALUES ('delete', old.id, old.content);
+		INSERT INTO search_fts(rowid, content) VALUES (new.id, new.content);
+	END`,
+}
+
+func (sqliteFTS5) migrate(db *gorm.DB) error {
+	var synced int64
+	if err := db.Raw("SELECT count(*) FROM sqlite_master WHERE type = 'trigger' AND name = 'search_documents_ai'").Scan(&synced).Error; err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS search_fts USING fts5(
+			content, content='search_documents', content_rowid='id', tokenize='unicode61 remove_diacritics 2')`).Error; err != nil {
+			return err
+		}
+		for _, stmt := range sqliteTriggers {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		if synced > 0 {
+			return nil
+		}
+		// New table, or documents were written while a build without FTS5
+		// had dropped the triggers.
+		return tx.Exec("INSERT INTO search_fts(search_fts) VALUES ('rebuild')").Error
+	})
+}
+
+func (sqliteFTS5) search(db *gorm.DB, terms []term, kinds []string, limit, offset int) ([]scored, error) {
+	parts := make([]string, len(terms))
+	for i, t := range terms {
+		// Quoted tokens form a phrase; the trailing * makes the last one a
+		// prefix.
+		parts[i] = `"` + strings.Join(t.tokens(), " ") + `"*`
+	}
+	var out []scored
+	tx := db.Table("search_fts").
+		Select("d.id AS id, -bm25(search_fts) AS score").
+		Joins("JOIN search_documents d ON d.id = search_fts.rowid").
+		Where("search_fts MATCH ?", strings.Join(parts, " "))
+	// The tenant scope only rewrites statements on search_documents itself.
+	if id, ok := tenant.FromContext(db.Statement.Context); ok {
+		tx = tx.Where("d."+tenant.Column+" = ?", id)
+	}
+	err := filterKinds(tx, "d.kind", kinds).
+		Order("score DESC").Order("d.id DESC").
+		Limit(limit).Offset(offset).
+		Scan(&out).Error
+	return out, err
+}
+
+// sqliteHasFTS5 reports whether the linked SQLite library was compiled with
+// FTS5, which mattn/go-sqlite3 only does under the sqlite_fts5 build tag.
+func sqliteHasFTS5(db *gorm.DB) (bool, error) {
+	var n int64
+	err := db.Raw("SELECT count(*) FROM pragma_compile_options WHERE compile_options = 'ENABLE_FTS5'").Scan(&n).Error
+	return n > 0, err
+}
+
+// dropSQLiteTriggers removes the FTS5 triggers so a build without FTS5 can
+// still write search_documents.
+func dropSQLiteTriggers(db *gorm.DB) error {
+	for _, name := range []string{"search_documents_ai", "search_documents_ad", "search_documents_au"} {
+		if err := db.Exec("DROP TRIGGER IF EXISTS " + name).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mysqlFullText uses an InnoDB FULLTEXT index with the ngram parser, which
+// splits CJK text on its own, so content is only normalised.
+type mysqlFullText struct{}
+
+const mysqlIndex = "idx_search_documents_content"
+
+func (mysqlFullText) name() string { return "mysql-fulltext" }
+
+func (mysqlFullText) content(text string) string { return normalize(text) }
+
+func (mysqlFullText) migrate(db *gorm.DB) error {
+	var n int64
+	err := db.Raw(`SELECT count(*) FROM information_schema.statistics
+		WHERE table_schema = DATABASE() AND table_name = 'search_documents' AND index_name = ?`, mysqlIndex).Scan(&n).Error
+	if err != nil || n > 0 {
+		return err
+	}
+	return db.Exec("CREATE FULLTEXT INDEX " + mysqlIndex + " ON search_documents (content) WITH PARSER ngram").Error
+}
+
+func (mysqlFullText) search(db *gorm.DB, terms []term, kinds []string, limit, offset int) ([]scored, error) {
+	parts := make([]string, len(terms))
+	for i, t := range terms {
+		// A term shorter than ngram_token_size (2 by default) only matches
+		// as a prefix; longer words are prefix searches and CJK runs are
+		// phrases of their n-grams.
+		if t.cjk && len(t.runes) > 1 {
+			parts[i] = `+"` + t.String() + `"`
+		} else {
+			parts[i] = "+" + t.String() + "*"
+		}
+	}
+	query := strings.Join(parts, " ")
+	var out []scored
+	tx := db.Table("search_documents").
+		Select("id, MATCH(content) AGAINST(? IN BOOLEAN MODE) AS score", query).
+		Where("MATCH(content) AGAINST(? IN BOOLEAN MODE)", query)
+	err := filterKinds(tx, "kind", kinds).
+		Order("score DESC").Order("id DESC").
+		Limit(limit).Offset(offset).
+		Scan(&out).Error
+	return out, err
+}
+
+// postgresTSVector keeps a generated tsvector column with a GIN index. The
+// simple configuration does no stemming, and segmented content lets CJK
+// runs match as phrases of single characters.
+type postgresTSVector struct{}
+
+func (postgresTSVector) name() string { return "postgres-tsvector" }
+
+func (postgresTSVector) content(text string) string { return segment(text) }
+
+func (postgresTSVector) migrate(db *gorm.DB) error {
+	stmts := []string{
+		"ALTER TABLE search_documents ADD COLUMN IF NOT EXISTS tsv tsvector GENERATED ALWAYS AS (to_tsvector('simple', content)) STORED",
+		"CREATE INDEX IF NOT EXISTS idx_search_documents_tsv ON search_documents USING GIN (tsv)",
+	}
+	for _, stmt := range stmts {
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (postgresTSVector) search(db *gorm.DB, terms []term, kinds []string, limit, offset int) ([]scored, error) {
+	parts := make([]string, len(terms))
+	for i, t := range terms {
+		tokens := t.tokens()
+		for j, token := range tokens {
+			// Tokens are letters and digits only, so quoting cannot break
+			// out of the lexeme.
+			tokens[j] = "'" + token + "'"
+		}
+		parts[i] = strings.Join(tokens, " <-> ") + ":*"
+	}
+	var out []scored
+	tx := db.Table("search_documents").
+		Select("id, ts_rank_cd(tsv, to_tsquery('simple', ?)) AS score", strings.Join(parts, " & ")).
+		Where("tsv @@ to_tsquery('simple', ?)", strings.Join(parts, " & "))
+	err := filterKinds(tx, "kind", kinds).
+		Order("score DESC").Order("id DESC").
+		Limit(limit).Offset(offset).
+		Scan(&out).Error
+	return out, err
+}
+
+// likeScan is the fallback when no full-text index is available: every term
+// must appear in the segmented content. It does not rank, so newer
+// documents come first.
+type likeScan struct{}
+
+func (likeScan) name() string { return "like" }
+
+func (likeScan) content(text string) string { return segment(text) }
+
+func (likeScan) migrate(*gorm.DB) error { return nil }
+
+func (likeScan) search(db *gorm.DB, terms []term, kinds []string, limit, offset int) ([]scored, error) {
+	tx := db.Table("search_documents").Select("id, 0 AS score")
+	for _, t := range terms {
+		// Tokens never contain LIKE wildcards.
+		tx = tx.Where("content LIKE ?", fmt.Sprintf("%%%s%%", strings.Join(t.tokens(), " ")))
+	}
+	var out []scored
+	err := filterKinds(tx, "kind", kinds).
+		Order("id DESC").
+		Limit(limit).Offset(offset).
+		Scan(&out).Error
+	return out, err
+}
@@ -0,0 +1,604 @@
+// Package search is a full-text index over configured model fields. Indexed
+// records are copied into the search_documents table by Gorm callbacks and
+// searched with the database's own full-text support: SQLite FTS5, MySQL
+// FULLTEXT with the ngram parser or a PostgreSQL tsvector. Queries match
+// every word by prefix and CJK text by consecutive characters, so Chinese
+// names are found without a word segmenter.
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+	"github.com/wonderfulsuccess/go-web-app/back/logger"
+	"github.com/wonderfulsuccess/go-web-app/back/model"
+	"github.com/wonderfulsuccess/go-web-app/back/tenant"
+)
+
+var (
+	// ErrUnknownKind is returned for kinds without a registered index.
+	ErrUnknownKind = errors.New("unknown search index")
+	// ErrEmptyQuery is returned for queries without any letters or digits.
+	ErrEmptyQuery = errors.New("search query is empty")
+	// ErrNotReady is returned when searching before Migrate succeeded.
+	ErrNotReady = errors.New("search index is not initialised")
+)
+
+const (
+	defaultLimit = 20
+	maxLimit     = 100
+	batchSize    = 500
+)
+
+// Index declares which fields of a model are searchable.
+type Index struct {
+	// Kind names the index in queries and results, e.g. "user".
+	Kind string
+	// Model is a pointer to a zero value of the model, e.g. &model.User{}.
+	// Its primary key must be an unsigned or signed integer.
+	Model interface{}
+	// Fields are the model's columns or field names to index.
+	Fields []string
+}
+
+// Query is one search request.
+type Query struct {
+	Text string
+	// Kinds restricts the search to these indexes; empty searches all.
+	Kinds  []string
+	Limit  int // default 20, at most 100
+	Offset int
+}
+
+// Hit is one matching record.
+type Hit struct {
+	Kind  string  `json:"kind"`
+	ID    uint    `json:"id"`
+	Score float64 `json:"score"`
+	// Fields holds the indexed values of the record.
+	Fields map[string]string `json:"fields"`
+	// Highlights holds the HTML-escaped fields that matched, with matches
+	// wrapped in <mark> and long values cut to a snippet.
+	Highlights map[string]string `json:"highlights"`
+}
+
+// Results is the answer to a Query.
+type Results struct {
+	Query string `json:"query"`
+	// Backend names the full-text implementation in use.
+	Backend string `json:"backend"`
+	Hits    []Hit  `json:"hits"`
+}
+
+type index struct {
+	Index
+	schema *schema.Schema
+	pk     *schema.Field
+	fields []*schema.Field
+}
+
+// Engine maintains and queries the search indexes.
+type Engine struct {
+	db     *gorm.DB
+	dbType config.DatabaseType
+
+	mu      sync.RWMutex
+	backend backend
+	kinds   map[string]*index
+	tables  map[string]*index
+	hooked  bool
+}
+
+// New returns an engine for db. Register the indexes, then call Migrate.
+func New(db *gorm.DB, dbType config.DatabaseType) *Engine {
+	return &Engine{
+		db:     db,
+		dbType: dbType,
+		kinds:  make(map[string]*index),
+		tables: make(map[string]*index),
+	}
+}
+
+// Register adds an index. Writes to its table are indexed once Migrate has
+// run; existing rows are indexed by Reindex.
+func (e *Engine) Register(idx Index) error {
+	if idx.Kind == "" || len(idx.Fields) == 0 {
+		return fmt.Errorf("search index needs a kind and at least one field")
+	}
+	s, err := schema.Parse(idx.Model, &sync.Map{}, e.db.NamingStrategy)
+	if err != nil {
+		return fmt.Errorf("search index %q: %w", idx.Kind, err)
+	}
+	pk := s.PrioritizedPrimaryField
+	if pk == nil || !isInteger(pk.FieldType) {
+		return fmt.Errorf("search index %q: model needs an integer primary key", idx.Kind)
+	}
+	in := &index{Index: idx, schema: s, pk: pk}
+	for _, name := range idx.Fields {
+		field := s.LookUpField(name)
+		if field == nil {
+			return fmt.Errorf("search index %q: unknown field %q", idx.Kind, name)
+		}
+		in.fields = append(in.fields, field)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, exists := e.kinds[idx.Kind]; exists {
+		return fmt.Errorf("search index %q registered twice", idx.Kind)
+	}
+	e.kinds[idx.Kind] = in
+	e.tables[s.Table] = in
+	return nil
+}
+
+// Kinds returns the registered index kinds sorted by name.
+func (e *Engine) Kinds() []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	out := make([]string, 0, len(e.kinds))
+	for kind := range e.kinds {
+		out = append(out, kind)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Backend names the full-text implementation chosen by Migrate.
+func (e *Engine) Backend() string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.backend == nil {
+		return ""
+	}
+	return e.backend.name()
+}
+
+// Migrate picks the backend for the database type, creates its full-text
+// index and starts indexing writes. A SQLite build without FTS5 falls back
+// to unranked LIKE matching.
+func (e *Engine) Migrate() error {
+	var b backend
+	switch e.dbType {
+	case config.DBTypeSQLite:
+		ok, err := sqliteHasFTS5(e.db)
+		if err != nil {
+			return err
+		}
+		if ok {
+			b = sqliteFTS5{}
+		} else {
+			logger.Warningf("sqlite was built without FTS5 (build with -tags sqlite_fts5); search falls back to LIKE matching")
+			if err := dropSQLiteTriggers(e.db); err != nil {
+				return err
+			}
+			b = likeScan{}
+		}
+	case config.DBTypeMySQL:
+		b = mysqlFullText{}
+	case config.DBTypePostgres:
+		b = postgresTSVector{}
+	default:
+		return fmt.Errorf("search: unsupported database type %q", e.dbType)
+	}
+	if err := b.migrate(e.db); err != nil {
+		return fmt.Errorf("search: create %s index: %w", b.name(), err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.backend = b
+	if e.hooked {
+		return nil
+	}
+	callbacks := e.db.Callback()
+	if err := callbacks.Create().After("gorm:create").Register("search:index", e.afterSave); err != nil {
+		return err
+	}
+	if err := callbacks.Update().After("gorm:update").Register("search:index", e.afterSave); err != nil {
+		return err
+	}
+	if err := callbacks.Delete().After("gorm:delete").Register("search:index", e.afterDelete); err != nil {
+		return err
+	}
+	e.hooked = true
+	return nil
+}
+
+// MigrateContext creates the full-text index of the backend chosen by
+// Migrate in the database that statements with ctx go to, such as a
+// tenant's own SQLite file.
+func (e *Engine) MigrateContext(ctx context.Context) error {
+	e.mu.RLock()
+	b := e.backend
+	e.mu.RUnlock()
+	if b == nil {
+		return ErrNotReady
+	}
+	db := e.db.WithContext(ctx)
+	if _, ok := b.(likeScan); ok && e.dbType == config.DBTypeSQLite {
+		return dropSQLiteTriggers(db)
+	}
+	return b.migrate(db)
+}
+
+// Search runs q against the registered indexes. Every word of the query must
+// match, words by prefix and CJK runs as consecutive characters.
+func (e *Engine) Search(ctx context.Context, q Query) (Results, error) {
+	e.mu.RLock()
+	b := e.backend
+	e.mu.RUnlock()
+	if b == nil {
+		return Results{}, ErrNotReady
+	}
+	for _, kind := range q.Kinds {
+		if _, err := e.index(kind); err != nil {
+			return Results{}, err
+		}
+	}
+	terms := split(q.Text)
+	if len(terms) == 0 {
+		return Results{}, ErrEmptyQuery
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	offset := q.Offset
+	if offset < 0 {
+		offset = 0
+	}
+
+	db := e.db.WithContext(ctx)
+	matches, err := b.search(db, terms, q.Kinds, limit, offset)
+	if err != nil {
+		return Results{}, err
+	}
+	results := Results{Query: q.Text, Backend: b.name(), Hits: make([]Hit, 0, len(matches))}
+	if len(matches) == 0 {
+		return results, nil
+	}
+
+	ids := make([]uint, len(matches))
+	for i, m := range matches {
+		ids[i] = m.ID
+	}
+	var docs []model.SearchDocument
+	if err := db.Where("id IN ?", ids).Find(&docs).Error; err != nil {
+		return Results{}, err
+	}
+	byID := make(map[uint]model.SearchDocument, len(docs))
+	for _, doc := range docs {
+		byID[doc.ID] = doc
+	}
+	for _, m := range matches {
+		doc, ok := byID[m.ID]
+		if !ok {
+			continue
+		}
+		hit := Hit{Kind: doc.Kind, ID: doc.RefID, Score: m.Score, Fields: doc.Fields, Highlights: map[string]string{}}
+		for name, value := range doc.Fields {
+			if marked, ok := highlight(value, terms); ok {
+				hit.Highlights[name] = marked
+			}
+		}
+		results.Hits = append(results.Hits, hit)
+	}
+	return results, nil
+}
+
+// Reindex rebuilds the documents of kind from its table and removes
+// documents of deleted rows. progress, when set, is called after every
+// batch.
+func (e *Engine) Reindex(ctx context.Context, kind string, progress func(done, total int64)) error {
+	idx, err := e.index(kind)
+	if err != nil {
+		return err
+	}
+	db := e.db.WithContext(ctx)
+	var total int64
+	if err := db.Model(idx.Model).Count(&total).Error; err != nil {
+		return err
+	}
+
+	var done int64
+	rows := reflect.New(reflect.SliceOf(idx.schema.ModelType))
+	err = db.Model(idx.Model).Order(idx.pk.DBName).FindInBatches(rows.Interface(), batchSize, func(tx *gorm.DB, _ int) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := e.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return e.store(tx, idx, rows.Elem(), nil)
+		}); err != nil {
+			return err
+		}
+		done += int64(rows.Elem().Len())
+		if progress != nil {
+			progress(done, total)
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	return db.Where("kind = ? AND ref_id NOT IN (?)", kind, db.Session(&gorm.Session{NewDB: true}).Model(idx.Model).Select(idx.pk.DBName)).
+		Delete(&model.SearchDocument{}).Error
+}
+
+// Stale returns the kinds whose document count differs from their table's
+// row count, e.g. after the index was added to existing data.
+func (e *Engine) Stale(ctx context.Context) ([]string, error) {
+	db := e.db.WithContext(ctx)
+	var out []string
+	for _, kind := range e.Kinds() {
+		idx, _ := e.index(kind)
+		var rows, docs int64
+		if err := db.Model(idx.Model).Count(&rows).Error; err != nil {
+			return nil, err
+		}
+		if err := db.Model(&model.SearchDocument{}).Where("kind = ?", kind).Count(&docs).Error; err != nil {
+			return nil, err
+		}
+		if rows != docs {
+			out = append(out, kind)
+		}
+	}
+	return out, nil
+}
+
+func (e *Engine) index(kind string) (*index, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	idx, ok := e.kinds[kind]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKind, kind)
+	}
+	return idx, nil
+}
+
+func (e *Engine) indexOf(stmt *gorm.Statement) *index {
+	if stmt.Schema == nil {
+		return nil
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.tables[stmt.Schema.Table]
+}
+
+// afterSave re-indexes the rows written by a create or update statement.
+// Statements that address rows only through conditions, such as
+// db.Model(&User{}).Where(...).Updates(...), carry no primary keys and are
+// picked up by the next Reindex instead.
+func (e *Engine) afterSave(db *gorm.DB) {
+	idx := e.indexOf(db.Statement)
+	if idx == nil || db.Error != nil {
+		return
+	}
+	tx := db.Session(&gorm.Session{NewDB: true})
+	ids, err := idx.written(tx, db.Statement)
+	if err == nil && len(ids) > 0 {
+		err = e.sync(tx, idx, ids)
+	}
+	if err != nil {
+		// The write itself succeeded; a stale entry is fixed by Reindex.
+		logger.Errorf("search: failed to index %s: %v", idx.Kind, err)
+	}
+}
+
+func (e *Engine) afterDelete(db *gorm.DB) {
+	idx := e.indexOf(db.Statement)
+	if idx == nil || db.Error != nil {
+		return
+	}
+	ids := idx.keys(db.Statement)
+	if len(ids) == 0 {
+		return
+	}
+	err := db.Session(&gorm.Session{NewDB: true}).
+		Where("kind = ? AND ref_id IN ?", idx.Kind, ids).
+		Delete(&model.SearchDocument{}).Error
+	if err != nil {
+		logger.Errorf("search: failed to remove %s from the index: %v", idx.Kind, err)
+	}
+}
+
+// sync loads the rows with the given primary keys and stores their
+// documents, removing documents of rows that no longer exist.
+func (e *Engine) sync(tx *gorm.DB, idx *index, ids []uint) error {
+	rows := reflect.New(reflect.SliceOf(idx.schema.ModelType))
+	if err := tx.Model(idx.Model).Where(idx.pk.DBName+" IN ?", ids).Find(rows.Interface()).Error; err != nil {
+		return err
+	}
+	return e.store(tx, idx, rows.Elem(), ids)
+}
+
+// store upserts the documents of rows. ids, when set, are the keys that
+// were expected; those missing from rows are removed from the index.
+func (e *Engine) store(tx *gorm.DB, idx *index, rows reflect.Value, ids []uint) error {
+	e.mu.RLock()
+	b := e.backend
+	e.mu.RUnlock()
+
+	docs := make(map[uint]model.SearchDocument, rows.Len())
+	for i := 0; i < rows.Len(); i++ {
+		row := reflect.Indirect(rows.Index(i))
+		id, ok := toUint(idx.pk, tx, row)
+		if !ok {
+			continue
+		}
+		doc := model.SearchDocument{Kind: idx.Kind, RefID: id, Fields: make(map[string]string, len(idx.fields))}
+		values := make([]string, 0, len(idx.fields))
+		for _, field := range idx.fields {
+			value, _ := field.ValueOf(tx.Statement.Context, row)
+			text := stringify(value)
+			doc.Fields[field.DBName] = text
+			values = append(values, text)
+		}
+		doc.Content = b.content(strings.Join(values, " "))
+		docs[id] = doc
+	}
+
+	refIDs := ids
+	if refIDs == nil {
+		for id := range docs {
+			refIDs = append(refIDs, id)
+		}
+	}
+	if len(refIDs) == 0 {
+		return nil
+	}
+	var existing []model.SearchDocument
+	if err := tx.Where("kind = ? AND ref_id IN ?", idx.Kind, refIDs).Find(&existing).Error; err != nil {
+		return err
+	}
+
+	var removed []uint
+	for _, old := range existing {
+		doc, ok := docs[old.RefID]
+		delete(docs, old.RefID)
+		if !ok {
+			removed = append(removed, old.ID)
+			continue
+		}
+		if doc.Content == old.Content && reflect.DeepEqual(doc.Fields, old.Fields) {
+			continue
+		}
+		old.Fields, old.Content = doc.Fields, doc.Content
+		if err := tx.Select("fields", "content", "updated_at").Save(&old).Error; err != nil {
+			return err
+		}
+	}
+	if len(removed) > 0 {
+		if err := tx.Delete(&model.SearchDocument{}, removed).Error; err != nil {
+			return err
+		}
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+	created := make([]model.SearchDocument, 0, len(docs))
+	for _, doc := range docs {
+		created = append(created, doc)
+	}
+	sort.Slice(created, func(i, j int) bool { return created[i].RefID < created[j].RefID })
+	return tx.Create(&created).Error
+}
+
+// written returns the primary keys of the rows a create or update statement
+// wrote. Upserts report the keys of the conflicting rows they updated
+// unreliably on some drivers, so those are looked up by the conflict column.
+func (idx *index) written(tx *gorm.DB, stmt *gorm.Statement) ([]uint, error) {
+	if c, ok := stmt.Clauses["ON CONFLICT"]; ok {
+		if onConflict, ok := c.Expression.(clause.OnConflict); ok {
+			// Rows conflict within their tenant, whose condition the tenant
+			// scope adds to the lookup.
+			var columns []clause.Column
+			for _, column := range onConflict.Columns {
+				if column.Name != tenant.Column {
+					columns = append(columns, column)
+				}
+			}
+			var field *schema.Field
+			if len(columns) == 1 {
+				field = idx.schema.LookUpField(columns[0].Name)
+			}
+			if field != nil {
+				var values []interface{}
+				eachRow(stmt.ReflectValue, func(row reflect.Value) {
+					if v, zero := field.ValueOf(stmt.Context, row); !zero {
+						values = append(values, v)
+					}
+				})
+				var ids []uint
+				if len(values) == 0 {
+					return nil, nil
+				}
+				err := tx.Model(idx.Model).Where(field.DBName+" IN ?", values).Pluck(idx.pk.DBName, &ids).Error
+				return ids, err
+			}
+		}
+	}
+	return idx.keys(stmt), nil
+}
+
+// keys returns the non-zero primary keys of the statement's model value.
+func (idx *index) keys(stmt *gorm.Statement) []uint {
+	var ids []uint
+	eachRow(stmt.ReflectValue, func(row reflect.Value) {
+		if id, ok := toUint(idx.pk, stmt.DB, row); ok {
+			ids = append(ids, id)
+		}
+	})
+	return ids
+}
+
+func eachRow(v reflect.Value, fn func(reflect.Value)) {
+	v = reflect.Indirect(v)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			fn(reflect.Indirect(v.Index(i)))
+		}
+	case reflect.Struct:
+		fn(v)
+	}
+}
+
+func toUint(pk *schema.Field, db *gorm.DB, row reflect.Value) (uint, bool) {
+	if row.Kind() != reflect.Struct || row.Type() != pk.Schema.ModelType {
+		return 0, false
+	}
+	value, zero := pk.ValueOf(db.Statement.Context, row)
+	if zero {
+		return 0, false
+	}
+	v := reflect.ValueOf(value)
+	switch {
+	case v.CanUint():
+		return uint(v.Uint()), true
+	case v.CanInt() && v.Int() > 0:
+		return uint(v.Int()), true
+	}
+	return 0, false
+}
+
+func isInteger(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+func stringify(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case fmt.Stringer:
+		return v.String()
+	}
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return ""
+		}
+		return stringify(rv.Elem().Interface())
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,333 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+	"github.com/wonderfulsuccess/go-web-app/back/model"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{text: "", want: nil},
+		{text: "  ,.!  ", want: nil},
+		{text: "Ada Lovelace", want: []string{"ada", "lovelace"}},
+		{text: "张三 ada@example.com", want: []string{"张三", "ada", "example", "com"}},
+		{text: "张三丰abc李四", want: []string{"张三丰", "abc", "李四"}},
+		{text: "ひらがなカタカナ 한국", want: []string{"ひらがなカタカナ", "한국"}},
+		{text: "Ünïcode 42", want: []string{"ünïcode", "42"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			var got []string
+			for _, term := range split(tt.text) {
+				got = append(got, term.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("split(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSegmentAndNormalize(t *testing.T) {
+	tests := []struct {
+		text      string
+		segment   string
+		normalize string
+	}{
+		{text: "Ada LOVELACE", segment: "ada lovelace", normalize: "ada lovelace"},
+		{text: "张三丰", segment: "张 三 丰", normalize: "张三丰"},
+		{text: "张三 ada@example.com", segment: "张 三 ada example com", normalize: "张三 ada example com"},
+		{text: "100% _off_", segment: "100 off", normalize: "100 off"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := segment(tt.text); got != tt.segment {
+				t.Errorf("segment(%q) = %q, want %q", tt.text, got, tt.segment)
+			}
+			if got := normalize(tt.text); got != tt.normalize {
+				t.Errorf("normalize(%q) = %q, want %q", tt.text, got, tt.normalize)
+			}
+		})
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	long := strings.Repeat("x ", 100) + "needle" + strings.Repeat(" y", 100)
+	tests := []struct {
+		name  string
+		value string
+		query string
+		want  string
+		ok    bool
+	}{
+		{name: "prefix", value: "Ada Lovelace", query: "love", want: "Ada <mark>Love</mark>lace", ok: true},
+		{name: "every term", value: "Ada Lovelace", query: "lov ad", want: "<mark>Ad</mark>a <mark>Lov</mark>elace", ok: true},
+		{name: "inside a word", value: "Ada Lovelace", query: "lace", ok: false},
+		{name: "cjk", value: "张三丰", query: "三丰", want: "张<mark>三丰</mark>", ok: true},
+		{name: "word after cjk", value: "张三ada", query: "ada", want: "张三<mark>ada</mark>", ok: true},
+		{name: "overlapping", value: "abcdef", query: "abc abcd", want: "<mark>abcd</mark>ef", ok: true},
+		{name: "escaped", value: "<b>ada</b>", query: "ada", want: "&lt;b&gt;<mark>ada</mark>&lt;/b&gt;", ok: true},
+		{name: "snippet", value: long, query: "needle", ok: true,
+			want: "…" + strings.Repeat("x ", 20) + "<mark>needle</mark>" + strings.Repeat(" y", 57) + "…"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := highlight(tt.value, split(tt.query))
+			if ok != tt.ok || got != tt.want {
+				t.Fatalf("highlight(%q, %q) = %q, %v; want %q, %v", tt.value, tt.query, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+type note struct {
+	ID    uint
+	Title string
+	Body  string
+}
+
+type tag struct {
+	ID   int64
+	Name string
+}
+
+// newEngine returns an engine over an in-memory database with notes and
+// tags indexed. Without the sqlite_fts5 build tag it uses the LIKE
+// fallback.
+func newEngine(t *testing.T) (*Engine, *gorm.DB) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&model.SearchDocument{}, &note{}, &tag{}); err != nil {
+		t.Fatal(err)
+	}
+
+	engine := New(db, config.DBTypeSQLite)
+	if err := engine.Register(Index{Kind: "note", Model: &note{}, Fields: []string{"title", "body"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.Register(Index{Kind: "tag", Model: &tag{}, Fields: []string{"Name"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	return engine, db
+}
+
+func TestRegister(t *testing.T) {
+	engine, _ := newEngine(t)
+	tests := []struct {
+		name string
+		idx  Index
+	}{
+		{name: "no kind", idx: Index{Model: &note{}, Fields: []string{"title"}}},
+		{name: "no fields", idx: Index{Kind: "other", Model: &note{}}},
+		{name: "unknown field", idx: Index{Kind: "other", Model: &note{}, Fields: []string{"missing"}}},
+		{name: "string key", idx: Index{Kind: "other", Model: &struct {
+			Code string `gorm:"primaryKey"`
+		}{}, Fields: []string{"code"}}},
+		{name: "twice", idx: Index{Kind: "note", Model: &note{}, Fields: []string{"title"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := engine.Register(tt.idx); err == nil {
+				t.Fatal("registered an invalid index")
+			}
+		})
+	}
+	if got := engine.Kinds(); !reflect.DeepEqual(got, []string{"note", "tag"}) {
+		t.Fatalf("kinds %q, want note and tag", got)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	engine, db := newEngine(t)
+	notes := []note{
+		{Title: "Ada Lovelace", Body: "ada@example.com"},
+		{Title: "张三丰", Body: "太极拳"},
+		{Title: "Grace Hopper", Body: "COBOL"},
+	}
+	if err := db.Create(&notes).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&tag{Name: "Adaptive"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []string // kind:title of the hits, in any order
+	}{
+		{name: "prefix", query: Query{Text: "LOVE"}, want: []string{"note:Ada Lovelace"}},
+		{name: "prefix across kinds", query: Query{Text: "ada"}, want: []string{"note:Ada Lovelace", "tag:Adaptive"}},
+		{name: "kinds filter", query: Query{Text: "ada", Kinds: []string{"tag"}}, want: []string{"tag:Adaptive"}},
+		{name: "every word", query: Query{Text: "ada example"}, want: []string{"note:Ada Lovelace"}},
+		{name: "missing word", query: Query{Text: "ada hopper"}, want: nil},
+		{name: "cjk substring", query: Query{Text: "三丰"}, want: []string{"note:张三丰"}},
+		{name: "cjk not consecutive", query: Query{Text: "张丰"}, want: nil},
+		{name: "cjk and word", query: Query{Text: "太极 张"}, want: []string{"note:张三丰"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := engine.Search(ctx, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Backend != engine.Backend() {
+				t.Fatalf("results name backend %q, engine uses %q", res.Backend, engine.Backend())
+			}
+			var got []string
+			for _, hit := range res.Hits {
+				if len(hit.Highlights) == 0 {
+					t.Errorf("hit %+v has no highlights", hit)
+				}
+				title := hit.Fields["title"]
+				if hit.Kind == "tag" {
+					title = hit.Fields["name"]
+				}
+				got = append(got, hit.Kind+":"+title)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	res, err := engine.Search(ctx, Query{Text: "ada", Limit: 1})
+	if err != nil || len(res.Hits) != 1 {
+		t.Fatalf("limit 1: got %+v, %v", res.Hits, err)
+	}
+
+	errorTests := []struct {
+		name  string
+		query Query
+		want  error
+	}{
+		{name: "empty", query: Query{Text: " -- "}, want: ErrEmptyQuery},
+		{name: "unknown kind", query: Query{Text: "ada", Kinds: []string{"nope"}}, want: ErrUnknownKind},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := engine.Search(ctx, tt.query); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchBeforeMigrate(t *testing.T) {
+	_, db := newEngine(t)
+	if _, err := New(db, config.DBTypeSQLite).Search(context.Background(), Query{Text: "ada"}); !errors.Is(err, ErrNotReady) {
+		t.Fatalf("got %v, want ErrNotReady", err)
+	}
+}
+
+// count returns the number of hits for text.
+func count(t *testing.T, engine *Engine, text string) int {
+	t.Helper()
+	res, err := engine.Search(context.Background(), Query{Text: text})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(res.Hits)
+}
+
+func TestWritesAreIndexed(t *testing.T) {
+	engine, db := newEngine(t)
+	n := note{Title: "Ada Lovelace"}
+	if err := db.Create(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count(t, engine, "ada") != 1 {
+		t.Fatal("created note is not found")
+	}
+
+	n.Title = "Grace Hopper"
+	if err := db.Save(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count(t, engine, "ada") != 0 || count(t, engine, "grace") != 1 {
+		t.Fatal("updated note is found by its old title or not by its new one")
+	}
+
+	if err := db.Delete(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count(t, engine, "grace") != 0 {
+		t.Fatal("deleted note is still found")
+	}
+	var docs int64
+	db.Model(&model.SearchDocument{}).Count(&docs)
+	if docs != 0 {
+		t.Fatalf("%d documents left after the delete", docs)
+	}
+}
+
+func TestReindex(t *testing.T) {
+	engine, db := newEngine(t)
+	if err := db.Create(&[]note{{Title: "Ada"}, {Title: "Grace"}}).Error; err != nil {
+		t.Fatal(err)
+	}
+	// Condition-only updates and raw SQL bypass the callbacks.
+	if err := db.Model(&note{}).Where("title = ?", "Ada").Update("title", "Hedy").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec("DELETE FROM notes WHERE title = ?", "Grace").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec("INSERT INTO tags (name) VALUES (?)", "Katherine").Error; err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	stale, err := engine.Stale(ctx)
+	if err != nil || !reflect.DeepEqual(stale, []string{"note", "tag"}) {
+		t.Fatalf("stale kinds %q, %v; want note and tag", stale, err)
+	}
+
+	for _, kind := range engine.Kinds() {
+		var done, total int64
+		if err := engine.Reindex(ctx, kind, func(d, t int64) { done, total = d, t }); err != nil {
+			t.Fatal(err)
+		}
+		if done != 1 || total != 1 {
+			t.Fatalf("%s: progress %d of %d, want 1 of 1", kind, done, total)
+		}
+	}
+	for text, want := range map[string]int{"ada": 0, "hedy": 1, "grace": 0, "katherine": 1} {
+		if got := count(t, engine, text); got != want {
+			t.Errorf("%q: %d hits after reindexing, want %d", text, got, want)
+		}
+	}
+	if stale, err := engine.Stale(ctx); err != nil || len(stale) != 0 {
+		t.Fatalf("stale kinds %q, %v after reindexing", stale, err)
+	}
+	if err := engine.Reindex(ctx, "nope", nil); !errors.Is(err, ErrUnknownKind) {
+		t.Fatalf("reindexing an unknown kind: %v", err)
+	}
+}
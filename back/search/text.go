@@ -0,0 +1,198 @@
+package search
+
+import (
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// term is one required part of a query: a word matched by prefix, or a run
+// of CJK characters matched as a phrase.
+type term struct {
+	runes []rune
+	cjk   bool
+}
+
+func (t term) String() string {
+	return string(t.runes)
+}
+
+// isCJK reports whether r belongs to a script written without spaces, whose
+// characters are indexed one by one.
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// split breaks text into lower-cased words and CJK runs, dropping
+// punctuation. "张三 ada@example.com" yields 张三, ada, example and com.
+func split(text string) []term {
+	var (
+		out     []term
+		current []rune
+		cjk     bool
+	)
+	flush := func() {
+		if len(current) > 0 {
+			out = append(out, term{runes: current, cjk: cjk})
+			current = nil
+		}
+	}
+	for _, r := range text {
+		switch {
+		case isCJK(r):
+			if !cjk {
+				flush()
+			}
+			cjk = true
+			current = append(current, r)
+		case isWordRune(r):
+			if cjk {
+				flush()
+			}
+			cjk = false
+			current = append(current, unicode.ToLower(r))
+		default:
+			flush()
+		}
+	}
+	flush()
+	return out
+}
+
+// segment returns text as space separated tokens with every CJK character
+// standing alone, so word-based full-text indexes can match Chinese names by
+// consecutive characters: "张三丰" becomes "张 三 丰".
+func segment(text string) string {
+	var b strings.Builder
+	for _, t := range split(text) {
+		for _, token := range t.tokens() {
+			if b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteString(token)
+		}
+	}
+	return b.String()
+}
+
+// tokens returns the index tokens of t: the word itself, or one token per
+// CJK character.
+func (t term) tokens() []string {
+	if !t.cjk {
+		return []string{string(t.runes)}
+	}
+	out := make([]string, len(t.runes))
+	for i, r := range t.runes {
+		out[i] = string(r)
+	}
+	return out
+}
+
+// normalize lower-cases text and collapses punctuation to single spaces
+// without splitting CJK runs, for indexes that tokenise CJK themselves.
+func normalize(text string) string {
+	parts := split(text)
+	out := make([]string, len(parts))
+	for i, t := range parts {
+		out[i] = t.String()
+	}
+	return strings.Join(out, " ")
+}
+
+const (
+	snippetRunes  = 160
+	snippetBefore = 40
+	markOpen      = "<mark>"
+	markClose     = "</mark>"
+)
+
+// highlight returns value HTML-escaped with every match of terms wrapped in
+// <mark>, trimmed to a snippet around the first match when it is long. The
+// second result is false when nothing matched.
+func highlight(value string, terms []term) (string, bool) {
+	text := []rune(value)
+	lower := make([]rune, len(text))
+	for i, r := range text {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	type span struct{ start, end int }
+	var spans []span
+	for _, t := range terms {
+		n := len(t.runes)
+		for i := 0; i+n <= len(lower); i++ {
+			// Words match by prefix, so they have to start a word.
+			if !t.cjk && i > 0 && isWordRune(lower[i-1]) && !isCJK(lower[i-1]) {
+				continue
+			}
+			if equalRunes(lower[i:i+n], t.runes) {
+				spans = append(spans, span{i, i + n})
+			}
+		}
+	}
+	if len(spans) == 0 {
+		return "", false
+	}
+
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	merged := spans[:1]
+	for _, s := range spans[1:] {
+		last := &merged[len(merged)-1]
+		if s.start <= last.end {
+			if s.end > last.end {
+				last.end = s.end
+			}
+			continue
+		}
+		merged = append(merged, s)
+	}
+
+	from, to := 0, len(text)
+	if len(text) > snippetRunes {
+		from = merged[0].start - snippetBefore
+		if from < 0 {
+			from = 0
+		}
+		to = from + snippetRunes
+		if to > len(text) {
+			to = len(text)
+			from = to - snippetRunes
+		}
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	pos := from
+	for _, s := range merged {
+		if s.end <= from || s.start >= to {
+			continue
+		}
+		start, end := max(s.start, from), min(s.end, to)
+		b.WriteString(html.EscapeString(string(text[pos:start])))
+		b.WriteString(markOpen)
+		b.WriteString(html.EscapeString(string(text[start:end])))
+		b.WriteString(markClose)
+		pos = end
+	}
+	b.WriteString(html.EscapeString(string(text[pos:to])))
+	if to < len(text) {
+		b.WriteString("…")
+	}
+	return b.String(), true
+}
+
+func equalRunes(a, b []rune) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
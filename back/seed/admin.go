@@ -0,0 +1,52 @@
+package seed
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"github.com/wonderfulsuccess/go-web-app/back/model"
+	"github.com/wonderfulsuccess/go-web-app/back/tenant"
+)
+
+// Admin returns an admin of the default tenant; false means there is none
+// yet, as on the first start of a new install.
+func (s *Seeder) Admin(ctx context.Context) (model.User, bool, error) {
+	var admin model.User
+	res := s.db.WithContext(s.defaultTenant(ctx)).Where("role = ?", model.RoleAdmin).Order("id").Limit(1).Find(&admin)
+	return admin, res.RowsAffected > 0, res.Error
+}
+
+// CreateAdmin makes the user with the given email an admin of the default
+// tenant, adding the user if needed. The admin role is only a marker on the
+// user record: the user gets no password or other credential, and no
+// endpoint checks the role. The administration endpoints are protected by
+// config.SecurityConfig.AdminToken instead.
+func (s *Seeder) CreateAdmin(ctx context.Context, name, email string) (model.User, error) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil {
+		return model.User{}, fmt.Errorf("invalid admin email %q: %w", email, err)
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = addr.Address
+	}
+
+	db := s.db.WithContext(s.defaultTenant(ctx))
+	var user model.User
+	res := db.Where("email = ?", addr.Address).Limit(1).Find(&user)
+	if res.Error != nil {
+		return model.User{}, res.Error
+	}
+	if res.RowsAffected > 0 {
+		err = db.Model(&user).Update("role", model.RoleAdmin).Error
+		return user, err
+	}
+	user = model.User{Name: name, Email: addr.Address, Role: model.RoleAdmin}
+	return user, db.Create(&user).Error
+}
+
+func (s *Seeder) defaultTenant(ctx context.Context) context.Context {
+	return tenant.WithID(ctx, s.tenants.Default().ID)
+}
@@ -0,0 +1,317 @@
+// Package seed fills the database from declarative fixture files. Fixtures
+// are grouped into environments, one directory each (dev, demo, test), and
+// every file maps registered model names to rows:
+//
+//	tenant: acme        # optional, defaults to the default tenant
+//	users:
+//	  - name: 张三
+//	    email: zhangsan@example.com
+//
+// Rows are matched to existing records by the model's natural key, e.g. a
+// user's email, so seeding twice updates rather than duplicates. The
+// package also creates the first admin account of a new install.
+package seed
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+
+	"github.com/wonderfulsuccess/go-web-app/back/model"
+	"github.com/wonderfulsuccess/go-web-app/back/tenant"
+)
+
+//go:embed fixtures
+var builtin embed.FS
+
+// tenantKey is the file-level key naming the tenant of a fixture file.
+const tenantKey = "tenant"
+
+// Builtin returns the fixture sets shipped with the binary.
+func Builtin() fs.FS {
+	sub, _ := fs.Sub(builtin, "fixtures")
+	return sub
+}
+
+// Fixtures returns the fixture sets in dir, or the built-in ones when dir
+// is empty.
+func Fixtures(dir string) fs.FS {
+	if dir == "" {
+		return Builtin()
+	}
+	return os.DirFS(dir)
+}
+
+// Environments lists the fixture sets of fsys.
+func Environments(fsys fs.FS) ([]string, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	var envs []string
+	for _, e := range entries {
+		if e.IsDir() {
+			envs = append(envs, e.Name())
+		}
+	}
+	return envs, nil
+}
+
+// Model is a table that fixtures can fill.
+type Model struct {
+	// Name is the key of the model's rows in fixture files, e.g. "users".
+	Name string
+	// Model is a pointer to the model, e.g. &model.User{}.
+	Model interface{}
+	// Key lists the columns that identify a row, e.g. "email". A fixture
+	// row whose key matches a record updates it instead of adding one.
+	Key []string
+}
+
+type entry struct {
+	Model
+	schema *schema.Schema
+	// fields maps the lower-cased JSON names of the model to its fields.
+	fields map[string]*schema.Field
+}
+
+// Result counts the changes made by one fixture file to one model.
+type Result struct {
+	File      string `json:"file"`
+	Tenant    string `json:"tenant"`
+	Model     string `json:"model"`
+	Created   int    `json:"created"`
+	Updated   int    `json:"updated"`
+	Unchanged int    `json:"unchanged"`
+}
+
+// Seeder loads fixtures into the registered models.
+type Seeder struct {
+	db      *gorm.DB
+	tenants *tenant.Manager
+	models  map[string]*entry
+	// order is the registration order, in which the models of a file are
+	// loaded so that referenced rows come first.
+	order []string
+}
+
+// New returns a seeder for the built-in models.
+func New(db *gorm.DB, tenants *tenant.Manager) *Seeder {
+	s := &Seeder{db: db, tenants: tenants, models: make(map[string]*entry)}
+	if err := s.Register(Model{Name: "users", Model: &model.User{}, Key: []string{"email"}}); err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Register makes a model available to fixture files.
+func (s *Seeder) Register(m Model) error {
+	if _, ok := s.models[m.Name]; ok || m.Name == tenantKey {
+		return fmt.Errorf("seed model %q is already registered", m.Name)
+	}
+	sch, err := schema.Parse(m.Model, &sync.Map{}, s.db.NamingStrategy)
+	if err != nil {
+		return err
+	}
+	if len(m.Key) == 0 {
+		return fmt.Errorf("seed model %q has no key", m.Name)
+	}
+	for _, column := range m.Key {
+		if sch.LookUpField(column) == nil {
+			return fmt.Errorf("seed model %q has no key column %q", m.Name, column)
+		}
+	}
+	e := &entry{Model: m, schema: sch, fields: make(map[string]*schema.Field)}
+	for _, f := range sch.Fields {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || f.DBName == "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		e.fields[strings.ToLower(name)] = f
+	}
+	s.models[m.Name] = e
+	s.order = append(s.order, m.Name)
+	return nil
+}
+
+// Run loads the fixture set env of fsys. Files are loaded in name order,
+// each in a transaction of its own; prefix names with numbers, such as
+// 10-users.yaml, to control the order.
+func (s *Seeder) Run(ctx context.Context, fsys fs.FS, env string) ([]Result, error) {
+	entries, err := fs.ReadDir(fsys, env)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			envs, _ := Environments(fsys)
+			return nil, fmt.Errorf("unknown seed environment %q, expected one of %s", env, strings.Join(envs, ", "))
+		}
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	var results []Result
+	for _, e := range entries {
+		ext := strings.ToLower(path.Ext(e.Name()))
+		if e.IsDir() || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+			continue
+		}
+		name := path.Join(env, e.Name())
+		res, err := s.load(ctx, fsys, name)
+		if err != nil {
+			return results, fmt.Errorf("%s: %w", name, err)
+		}
+		results = append(results, res...)
+	}
+	return results, nil
+}
+
+// load applies one fixture file.
+func (s *Seeder) load(ctx context.Context, fsys fs.FS, name string) ([]Result, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]interface{}
+	if strings.HasSuffix(strings.ToLower(name), ".json") {
+		err = json.Unmarshal(data, &doc)
+	} else {
+		err = yaml.Unmarshal(data, &doc)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	slug, _ := doc[tenantKey].(string)
+	delete(doc, tenantKey)
+	for key := range doc {
+		if _, ok := s.models[key]; !ok {
+			return nil, fmt.Errorf("unknown model %q", key)
+		}
+	}
+	t, err := s.tenant(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []Result
+	err = s.db.WithContext(tenant.WithID(ctx, t.ID)).Transaction(func(tx *gorm.DB) error {
+		for _, modelName := range s.order {
+			raw, ok := doc[modelName]
+			if !ok {
+				continue
+			}
+			rows, ok := raw.([]interface{})
+			if !ok {
+				return fmt.Errorf("%s must be a list of rows", modelName)
+			}
+			res := Result{File: name, Tenant: t.Slug, Model: modelName}
+			for i, row := range rows {
+				if err := s.upsert(tx, s.models[modelName], row, &res); err != nil {
+					return fmt.Errorf("%s row %d: %w", modelName, i+1, err)
+				}
+			}
+			results = append(results, res)
+		}
+		return nil
+	})
+	return results, err
+}
+
+// tenant returns the tenant named by a fixture file, creating it if needed.
+func (s *Seeder) tenant(ctx context.Context, slug string) (model.Tenant, error) {
+	if slug == "" {
+		return s.tenants.Default(), nil
+	}
+	t, err := s.tenants.Resolve(ctx, slug)
+	if errors.Is(err, tenant.ErrNotFound) {
+		return s.tenants.Create(ctx, slug, slug)
+	}
+	return t, err
+}
+
+// upsert creates the record of row, or updates the fields row sets on the
+// record with the same key.
+func (s *Seeder) upsert(tx *gorm.DB, e *entry, row interface{}, res *Result) error {
+	values, ok := row.(map[string]interface{})
+	if !ok {
+		return errors.New("row must be a mapping of fields")
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	record := reflect.New(e.schema.ModelType)
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(record.Interface()); err != nil {
+		return err
+	}
+
+	ctx := tx.Statement.Context
+	where := make(map[string]interface{}, len(e.Key))
+	for _, column := range e.Key {
+		value, zero := e.schema.LookUpField(column).ValueOf(ctx, record.Elem())
+		if zero {
+			return fmt.Errorf("missing key %s", column)
+		}
+		where[column] = value
+	}
+	existing := reflect.New(e.schema.ModelType)
+	found := tx.Where(where).Limit(1).Find(existing.Interface())
+	if found.Error != nil {
+		return found.Error
+	}
+	if found.RowsAffected == 0 {
+		if err := tx.Create(record.Interface()).Error; err != nil {
+			return err
+		}
+		res.Created++
+		return nil
+	}
+
+	var changed []string
+	for key := range values {
+		f := e.fields[strings.ToLower(key)]
+		if f == nil || f.PrimaryKey {
+			continue
+		}
+		want, _ := f.ValueOf(ctx, record.Elem())
+		have, _ := f.ValueOf(ctx, existing.Elem())
+		if !equal(want, have) {
+			changed = append(changed, f.DBName)
+		}
+	}
+	if len(changed) == 0 {
+		res.Unchanged++
+		return nil
+	}
+	if err := tx.Model(existing.Interface()).Select(changed).Updates(record.Interface()).Error; err != nil {
+		return err
+	}
+	res.Updated++
+	return nil
+}
+
+func equal(a, b interface{}) bool {
+	if ta, ok := a.(time.Time); ok {
+		tb, ok := b.(time.Time)
+		return ok && ta.Equal(tb)
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,19 @@
+package session
+
+import "context"
+
+type contextKey struct{}
+
+// WithUser returns a context carrying the identity of the request's user.
+func WithUser(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// UserFromContext returns the identity set by WithUser.
+func UserFromContext(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	id, ok := ctx.Value(contextKey{}).(string)
+	return id, ok && id != ""
+}
@@ -0,0 +1,149 @@
+// Package session gives every browser a user identity it cannot forge. The
+// server picks a random id for a client that has none and hands it out in
+// a cookie signed with HMAC-SHA256; a client can keep its id, but not
+// choose another one.
+//
+// A token reads "<id>.<issued unix time>.<signature>", the id and the
+// signature base64url encoded.
+package session
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+)
+
+// keySize is the length in bytes of a generated signing key.
+const keySize = 32
+
+var (
+	// ErrInvalid is returned by Verify for malformed tokens and tokens with
+	// a wrong signature.
+	ErrInvalid = errors.New("invalid session token")
+	// ErrExpired is returned by Verify for tokens older than the maximum
+	// age.
+	ErrExpired = errors.New("session expired")
+)
+
+// Signer issues and verifies session tokens.
+type Signer struct {
+	key    []byte
+	maxAge time.Duration
+	now    func() time.Time
+}
+
+// NewSigner returns a signer using key; tokens older than maxAge are
+// refused, and 0 lets them live forever.
+func NewSigner(key []byte, maxAge time.Duration) *Signer {
+	return &Signer{key: key, maxAge: maxAge, now: time.Now}
+}
+
+// Load returns the signer described by cfg: Secret when set, otherwise the
+// key kept in KeyFile, which is generated on the first start.
+func Load(cfg config.SessionConfig) (*Signer, error) {
+	if cfg.Secret != "" {
+		return NewSigner([]byte(cfg.Secret), cfg.MaxAge), nil
+	}
+	key, err := loadKey(cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return NewSigner(key, cfg.MaxAge), nil
+}
+
+// loadKey reads the hex encoded key in path, creating it when missing.
+// Only the owner may read the file.
+func loadKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) < keySize {
+			return nil, fmt.Errorf("session key %s is not %d hex encoded bytes", path, keySize)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0o600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// NewID returns a random user id for a client without a session.
+func NewID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// Issue returns a token naming the user id.
+func (s *Signer) Issue(id string) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(id)) + "." + strconv.FormatInt(s.now().Unix(), 10)
+	return payload + "." + s.sign(payload)
+}
+
+// Verify returns the user id and issue time of token.
+func (s *Signer) Verify(token string) (string, time.Time, error) {
+	cut := strings.LastIndexByte(token, '.')
+	if cut < 0 {
+		return "", time.Time{}, ErrInvalid
+	}
+	payload, sig := token[:cut], token[cut+1:]
+	if !hmac.Equal([]byte(sig), []byte(s.sign(payload))) {
+		return "", time.Time{}, ErrInvalid
+	}
+	rawID, rawIssued, ok := strings.Cut(payload, ".")
+	if !ok {
+		return "", time.Time{}, ErrInvalid
+	}
+	id, err := base64.RawURLEncoding.DecodeString(rawID)
+	if err != nil || len(id) == 0 {
+		return "", time.Time{}, ErrInvalid
+	}
+	unix, err := strconv.ParseInt(rawIssued, 10, 64)
+	if err != nil {
+		return "", time.Time{}, ErrInvalid
+	}
+	issued := time.Unix(unix, 0)
+	if s.maxAge > 0 && s.now().Sub(issued) > s.maxAge {
+		return "", time.Time{}, ErrExpired
+	}
+	return string(id), issued, nil
+}
+
+// Stale reports whether a token issued at issued is past half its maximum
+// age and should be replaced by a fresh one.
+func (s *Signer) Stale(issued time.Time) bool {
+	return s.maxAge > 0 && s.now().Sub(issued) > s.maxAge/2
+}
+
+// MaxAge returns how long tokens stay valid.
+func (s *Signer) MaxAge() time.Duration {
+	return s.maxAge
+}
+
+func (s *Signer) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,107 @@
+package session
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+)
+
+func TestVerify(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	signer := NewSigner([]byte("key"), time.Hour)
+	signer.now = func() time.Time { return now }
+	other := NewSigner([]byte("other key"), time.Hour)
+	other.now = signer.now
+
+	valid := signer.Issue("ada")
+	id, sig, _ := strings.Cut(valid, ".")
+	tests := []struct {
+		name  string
+		token string
+		age   time.Duration
+		want  string
+		err   error
+	}{
+		{name: "valid", token: valid, want: "ada"},
+		{name: "within max age", token: valid, age: time.Hour, want: "ada"},
+		{name: "expired", token: valid, age: time.Hour + time.Second, err: ErrExpired},
+		{name: "other key", token: other.Issue("ada"), err: ErrInvalid},
+		{name: "other user", token: base64.RawURLEncoding.EncodeToString([]byte("cy")) + "." + sig, err: ErrInvalid},
+		{name: "empty", token: "", err: ErrInvalid},
+		{name: "no signature", token: id, err: ErrInvalid},
+		{name: "empty id", token: "." + sig, err: ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer.now = func() time.Time { return now.Add(tt.age) }
+			defer func() { signer.now = func() time.Time { return now } }()
+			got, issued, err := signer.Verify(tt.token)
+			if !errors.Is(err, tt.err) || got != tt.want {
+				t.Fatalf("Verify = %q, %v; want %q, %v", got, err, tt.want, tt.err)
+			}
+			if err == nil && !issued.Equal(now) {
+				t.Fatalf("issued at %s, want %s", issued, now)
+			}
+		})
+	}
+}
+
+func TestStale(t *testing.T) {
+	now := time.Now()
+	signer := NewSigner([]byte("key"), time.Hour)
+	signer.now = func() time.Time { return now }
+	tests := []struct {
+		age  time.Duration
+		want bool
+	}{
+		{age: 0, want: false},
+		{age: 30 * time.Minute, want: false},
+		{age: 31 * time.Minute, want: true},
+	}
+	for _, tt := range tests {
+		if got := signer.Stale(now.Add(-tt.age)); got != tt.want {
+			t.Errorf("Stale after %s = %v, want %v", tt.age, got, tt.want)
+		}
+	}
+	if NewSigner([]byte("key"), 0).Stale(now.Add(-365 * 24 * time.Hour)) {
+		t.Error("a session without a maximum age went stale")
+	}
+}
+
+func TestLoadKeepsGeneratedKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "session.key")
+	first, err := Load(config.SessionConfig{KeyFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("key file mode %o, want 600", perm)
+	}
+	second, err := Load(config.SessionConfig{KeyFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _, err := second.Verify(first.Issue("ada")); err != nil || id != "ada" {
+		t.Fatalf("token of the first start: %q, %v", id, err)
+	}
+
+	if err := os.WriteFile(path, []byte("short"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(config.SessionConfig{KeyFile: path}); err == nil {
+		t.Fatal("loaded a malformed key")
+	}
+	if _, err := Load(config.SessionConfig{Secret: "s", KeyFile: path}); err != nil {
+		t.Fatalf("a secret must take precedence over the key file: %v", err)
+	}
+}
@@ -0,0 +1,232 @@
+package tabular
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// maxLineSize bounds a single JSON Lines record.
+const maxLineSize = 1 << 20
+
+// Reader yields records until it returns io.EOF. CSV and XLSX take column
+// names from their first row.
+type Reader interface {
+	Next() (Record, error)
+	Close() error
+}
+
+// NewReader reads records of format f from r. XLSX is a zip archive that
+// cannot be read sequentially, so it is first spooled to a temporary file.
+func NewReader(f Format, r io.Reader) (Reader, error) {
+	switch f {
+	case CSV:
+		return newCSVReader(r)
+	case JSONL:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+		return &jsonlReader{scanner: scanner}, nil
+	case XLSX:
+		return newXLSXReader(r)
+	default:
+		return nil, fmt.Errorf("unsupported format %q", f)
+	}
+}
+
+type csvReader struct {
+	r      *csv.Reader
+	header []string
+}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	cr.ReuseRecord = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &csvReader{r: cr, header: cleanHeader(header)}, nil
+}
+
+func (c *csvReader) Next() (Record, error) {
+	for {
+		fields, err := c.r.Read()
+		if err != nil {
+			var perr *csv.ParseError
+			if errors.As(err, &perr) {
+				return Record{Row: perr.Line}, err
+			}
+			return Record{}, err
+		}
+		if blank(fields) {
+			continue
+		}
+		line, _ := c.r.FieldPos(0)
+		return Record{Row: line, Values: zip(c.header, fields)}, nil
+	}
+}
+
+func (c *csvReader) Close() error { return nil }
+
+type jsonlReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (j *jsonlReader) Next() (Record, error) {
+	for j.scanner.Scan() {
+		j.line++
+		data := bytes.TrimSpace(j.scanner.Bytes())
+		if j.line == 1 {
+			data = bytes.TrimPrefix(data, []byte("\uFEFF"))
+		}
+		if len(data) == 0 {
+			continue
+		}
+		var obj map[string]interface{}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err := dec.Decode(&obj); err != nil {
+			return Record{Row: j.line}, fmt.Errorf("line %d: %w", j.line, err)
+		}
+		values := make(map[string]string, len(obj))
+		for k, v := range obj {
+			switch v := v.(type) {
+			case nil:
+			case string:
+				values[k] = v
+			default:
+				values[k] = fmt.Sprint(v)
+			}
+		}
+		return Record{Row: j.line, Values: values}, nil
+	}
+	if err := j.scanner.Err(); err != nil {
+		return Record{}, err
+	}
+	return Record{}, io.EOF
+}
+
+func (j *jsonlReader) Close() error { return nil }
+
+type xlsxReader struct {
+	path   string
+	file   *excelize.File
+	rows   *excelize.Rows
+	header []string
+	row    int
+}
+
+func newXLSXReader(r io.Reader) (reader *xlsxReader, err error) {
+	tmp, err := os.CreateTemp("", "tabular-*.xlsx")
+	if err != nil {
+		return nil, err
+	}
+	x := &xlsxReader{path: tmp.Name()}
+	defer func() {
+		if err != nil {
+			_ = x.Close()
+		}
+	}()
+	_, err = io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if x.file, err = excelize.OpenFile(x.path); err != nil {
+		return nil, err
+	}
+	sheets := x.file.GetSheetList()
+	if len(sheets) == 0 {
+		return nil, fmt.Errorf("workbook has no sheets")
+	}
+	if x.rows, err = x.file.Rows(sheets[0]); err != nil {
+		return nil, err
+	}
+	if !x.rows.Next() {
+		return nil, fmt.Errorf("file is empty")
+	}
+	x.row++
+	header, err := x.rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	x.header = cleanHeader(header)
+	return x, nil
+}
+
+func (x *xlsxReader) Next() (Record, error) {
+	for x.rows.Next() {
+		x.row++
+		fields, err := x.rows.Columns()
+		if err != nil {
+			return Record{}, err
+		}
+		if blank(fields) {
+			continue
+		}
+		return Record{Row: x.row, Values: zip(x.header, fields)}, nil
+	}
+	if err := x.rows.Error(); err != nil {
+		return Record{}, err
+	}
+	return Record{}, io.EOF
+}
+
+func (x *xlsxReader) Close() error {
+	if x.rows != nil {
+		_ = x.rows.Close()
+	}
+	if x.file != nil {
+		_ = x.file.Close()
+	}
+	return os.Remove(x.path)
+}
+
+// cleanHeader trims column names and the byte order mark Excel prepends to
+// CSV files.
+func cleanHeader(header []string) []string {
+	out := make([]string, len(header))
+	for i, h := range header {
+		if i == 0 {
+			h = strings.TrimPrefix(h, "\uFEFF")
+		}
+		out[i] = strings.TrimSpace(h)
+	}
+	return out
+}
+
+func zip(header, fields []string) map[string]string {
+	values := make(map[string]string, len(header))
+	for i, name := range header {
+		if i < len(fields) && name != "" {
+			values[name] = strings.TrimSpace(fields[i])
+		}
+	}
+	return values
+}
+
+func blank(fields []string) bool {
+	for _, f := range fields {
+		if strings.TrimSpace(f) != "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,79 @@
+// Package tabular reads and writes row-oriented files (CSV, JSON Lines and
+// XLSX) one record at a time, so large files never have to fit in memory.
+package tabular
+
+import (
+	"fmt"
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// Format identifies a file format.
+type Format string
+
+const (
+	CSV   Format = "csv"
+	JSONL Format = "jsonl"
+	XLSX  Format = "xlsx"
+)
+
+// Formats lists the supported formats.
+func Formats() []Format {
+	return []Format{CSV, JSONL, XLSX}
+}
+
+// ParseFormat accepts a format name or common alias such as "ndjson".
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(strings.TrimPrefix(name, ".")) {
+	case "csv":
+		return CSV, nil
+	case "jsonl", "ndjson", "json":
+		return JSONL, nil
+	case "xlsx":
+		return XLSX, nil
+	default:
+		return "", fmt.Errorf("unsupported format %q, use csv, jsonl or xlsx", name)
+	}
+}
+
+// DetectFormat guesses the format from a content type or file name.
+func DetectFormat(contentType, filename string) (Format, bool) {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		switch mediaType {
+		case "text/csv", "application/csv":
+			return CSV, true
+		case "application/x-ndjson", "application/jsonl", "application/x-jsonlines", "application/json":
+			return JSONL, true
+		case "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":
+			return XLSX, true
+		}
+	}
+	if ext := filepath.Ext(filename); ext != "" {
+		if f, err := ParseFormat(ext); err == nil {
+			return f, true
+		}
+	}
+	return "", false
+}
+
+// ContentType returns the MIME type of the format.
+func (f Format) ContentType() string {
+	switch f {
+	case CSV:
+		return "text/csv; charset=utf-8"
+	case JSONL:
+		return "application/x-ndjson"
+	case XLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// Record is one data row keyed by column name. Row is the 1-based position
+// in the source: the line for CSV and JSON Lines, the sheet row for XLSX.
+type Record struct {
+	Row    int
+	Values map[string]string
+}
@@ -0,0 +1,204 @@
+package tabular
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		want Format
+		err  bool
+	}{
+		{name: "csv", want: CSV},
+		{name: ".XLSX", want: XLSX},
+		{name: "ndjson", want: JSONL},
+		{name: "json", want: JSONL},
+		{name: "xls", err: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseFormat(tt.name)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("ParseFormat(%q) = %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		contentType, filename string
+		want                  Format
+		ok                    bool
+	}{
+		{contentType: "text/csv; charset=utf-8", want: CSV, ok: true},
+		{contentType: "application/x-ndjson", want: JSONL, ok: true},
+		{contentType: "application/octet-stream", filename: "users.xlsx", want: XLSX, ok: true},
+		{filename: "users.CSV", want: CSV, ok: true},
+		{contentType: "application/octet-stream", filename: "users.txt"},
+	}
+	for _, tt := range tests {
+		got, ok := DetectFormat(tt.contentType, tt.filename)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("DetectFormat(%q, %q) = %q, %v; want %q, %v", tt.contentType, tt.filename, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600))
+	rows := [][]interface{}{
+		{1, created, "Ada", "ada@example.com"},
+		{2, nil, "Cy, Jr.", "cy@example.com"},
+	}
+	want := []Record{
+		{Row: 2, Values: map[string]string{"id": "1", "name": "Ada", "email": "ada@example.com", "created": "2026-01-02T02:04:05Z"}},
+		{Row: 3, Values: map[string]string{"id": "2", "name": "Cy, Jr.", "email": "cy@example.com", "created": ""}},
+	}
+	jsonlWant := []Record{
+		{Row: 1, Values: map[string]string{"id": "1", "name": "Ada", "email": "ada@example.com", "created": "2026-01-02T03:04:05+01:00"}},
+		{Row: 2, Values: map[string]string{"id": "2", "name": "Cy, Jr.", "email": "cy@example.com"}},
+	}
+	for _, f := range Formats() {
+		t.Run(string(f), func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(f, &buf, []string{"id", "created", "name", "email"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, row := range rows {
+				if err := w.Write(row); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := NewReader(f, &buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			for i, rec := range want {
+				got, err := r.Next()
+				if err != nil {
+					t.Fatalf("record %d: %v", i, err)
+				}
+				if f == JSONL {
+					// JSON Lines has no header line, keeps the time zone and
+					// leaves out nulls.
+					rec = jsonlWant[i]
+				}
+				if got.Row != rec.Row || !equal(got.Values, rec.Values) {
+					t.Fatalf("record %d = %+v, want %+v", i, got, rec)
+				}
+			}
+			if _, err := r.Next(); err != io.EOF {
+				t.Fatalf("after the last record: %v, want EOF", err)
+			}
+		})
+	}
+}
+
+func TestFormulasAreEscaped(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{value: "=HYPERLINK(\"http://evil\")", want: "'=HYPERLINK(\"http://evil\")"},
+		{value: "+1", want: "'+1"},
+		{value: "-1+1", want: "'-1+1"},
+		{value: "@SUM(A1)", want: "'@SUM(A1)"},
+		{value: "\tcmd", want: "'\tcmd"},
+		{value: "\rcmd", want: "'\rcmd"},
+		{value: "a=b", want: "a=b"},
+		{value: "", want: ""},
+		{value: -5, want: "-5"},
+	}
+	for _, tt := range tests {
+		if got := formatValue(tt.value); got != tt.want {
+			t.Errorf("formatValue(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+
+	for _, f := range []Format{CSV, XLSX} {
+		var buf bytes.Buffer
+		w, err := NewWriter(f, &buf, []string{"name"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Write([]interface{}{"=1+1"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewReader(f, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec, err := r.Next()
+		_ = r.Close()
+		if err != nil || rec.Values["name"] != "'=1+1" {
+			t.Errorf("%s wrote %q, %v; want the formula quoted", f, rec.Values["name"], err)
+		}
+	}
+}
+
+func TestReadErrorsReportLine(t *testing.T) {
+	tests := []struct {
+		name   string
+		format Format
+		input  string
+		row    int
+	}{
+		{name: "csv bare quote", format: CSV, input: "name,email\nAda,ada@example.com\n\nCy,\"cy@\"example.com\n", row: 4},
+		{name: "jsonl", format: JSONL, input: "{\"name\":\"Ada\"}\n\n{\"name\":\n", row: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewReader(tt.format, strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			if _, err := r.Next(); err != nil {
+				t.Fatal(err)
+			}
+			rec, err := r.Next()
+			if err == nil || rec.Row != tt.row {
+				t.Fatalf("got row %d, %v; want an error on row %d", rec.Row, err, tt.row)
+			}
+		})
+	}
+}
+
+func TestReaderSkipsBlankRowsAndBOM(t *testing.T) {
+	r, err := NewReader(CSV, strings.NewReader("\uFEFF Name , Email\n\n , \nAda, ada@example.com\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Row != 4 || rec.Values["Name"] != "Ada" || rec.Values["Email"] != "ada@example.com" {
+		t.Fatalf("got %+v", rec)
+	}
+}
+
+func equal(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,155 @@
+package tabular
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Writer writes rows whose values line up with the header given to
+// NewWriter. Close must be called to flush the output.
+type Writer interface {
+	Write(values []interface{}) error
+	Close() error
+}
+
+// NewWriter writes format f to w, starting with header where the format has
+// one. JSON Lines uses the header as object keys instead.
+func NewWriter(f Format, w io.Writer, header []string) (Writer, error) {
+	switch f {
+	case CSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(header); err != nil {
+			return nil, err
+		}
+		return &csvWriter{w: cw}, nil
+	case JSONL:
+		return &jsonlWriter{enc: json.NewEncoder(w), header: header}, nil
+	case XLSX:
+		return newXLSXWriter(w, header)
+	default:
+		return nil, fmt.Errorf("unsupported format %q", f)
+	}
+}
+
+type csvWriter struct {
+	w      *csv.Writer
+	fields []string
+}
+
+func (c *csvWriter) Write(values []interface{}) error {
+	c.fields = c.fields[:0]
+	for _, v := range values {
+		c.fields = append(c.fields, formatValue(v))
+	}
+	return c.w.Write(c.fields)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type jsonlWriter struct {
+	enc    *json.Encoder
+	header []string
+}
+
+func (j *jsonlWriter) Write(values []interface{}) error {
+	obj := make(map[string]interface{}, len(j.header))
+	for i, name := range j.header {
+		if i < len(values) {
+			obj[name] = values[i]
+		}
+	}
+	return j.enc.Encode(obj)
+}
+
+func (j *jsonlWriter) Close() error { return nil }
+
+// xlsxWriter uses excelize's stream writer, which spills rows to a temporary
+// file instead of keeping the sheet in memory. The archive is written to the
+// output on Close.
+type xlsxWriter struct {
+	out    io.Writer
+	file   *excelize.File
+	stream *excelize.StreamWriter
+	row    int
+}
+
+func newXLSXWriter(w io.Writer, header []string) (*xlsxWriter, error) {
+	f := excelize.NewFile()
+	stream, err := f.NewStreamWriter("Sheet1")
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	x := &xlsxWriter{out: w, file: f, stream: stream}
+	cells := make([]interface{}, len(header))
+	for i, h := range header {
+		cells[i] = h
+	}
+	if err := x.Write(cells); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return x, nil
+}
+
+func (x *xlsxWriter) Write(values []interface{}) error {
+	x.row++
+	cell, err := excelize.CoordinatesToCellName(1, x.row)
+	if err != nil {
+		return err
+	}
+	cells := make([]interface{}, len(values))
+	for i, v := range values {
+		switch t := v.(type) {
+		case time.Time:
+			// Spreadsheets have no time zone; keep timestamps readable as text.
+			v = t.UTC().Format(time.RFC3339)
+		case string:
+			v = escapeFormula(t)
+		}
+		cells[i] = v
+	}
+	return x.stream.SetRow(cell, cells)
+}
+
+func (x *xlsxWriter) Close() error {
+	defer x.file.Close()
+	if err := x.stream.Flush(); err != nil {
+		return err
+	}
+	_, err := x.file.WriteTo(x.out)
+	return err
+}
+
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return escapeFormula(v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// escapeFormula prefixes text that a spreadsheet would evaluate as a
+// formula with a single quote, so that an exported value such as
+// "=HYPERLINK(...)" shows as typed instead of running when the file is
+// opened.
+func escapeFormula(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
@@ -0,0 +1,507 @@
+// Package tasks is a database-backed background task queue. Tasks are
+// enqueued by kind, picked up by a pool of workers, retried with exponential
+// backoff and moved to the dead state when they keep failing. Because every
+// state change is persisted, queued and interrupted tasks resume after a
+// restart.
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/wonderfulsuccess/go-web-app/back/logger"
+	"github.com/wonderfulsuccess/go-web-app/back/model"
+	"github.com/wonderfulsuccess/go-web-app/back/tenant"
+)
+
+var (
+	// ErrNotFound is returned for unknown task ids.
+	ErrNotFound = errors.New("task not found")
+	// ErrUnknownKind is returned when enqueuing a kind without a handler.
+	ErrUnknownKind = errors.New("unknown task kind")
+	// ErrFinished is returned when cancelling a task that already ended.
+	ErrFinished = errors.New("task already finished")
+	// ErrNotRetryable is returned when retrying a task that is not dead or
+	// canceled.
+	ErrNotRetryable = errors.New("only dead or canceled tasks can be retried")
+)
+
+// Handler performs one attempt of a task. Returning an error schedules a
+// retry unless it is wrapped with Permanent or the attempts are used up.
+// Handlers should return promptly once ctx is cancelled.
+type Handler func(ctx context.Context, t *Task) error
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying; the task goes straight to the
+// dead state.
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// Options tunes a Queue. Zero values pick the defaults.
+type Options struct {
+	Workers      int           // default 2
+	MaxAttempts  int           // default 5
+	PollInterval time.Duration // default 1s
+	BaseBackoff  time.Duration // default 2s, doubled after every failure
+	MaxBackoff   time.Duration // default 10m
+}
+
+// EnqueueOptions customises a single task.
+type EnqueueOptions struct {
+	// Submitter is the user id that receives progress updates.
+	Submitter string
+	// Tenant is the tenant the handler runs for: its context is scoped to
+	// it, see tenant.WithID. 0 runs the handler without a tenant.
+	Tenant      uint
+	MaxAttempts int
+	Delay       time.Duration
+}
+
+// ListFilter narrows List; empty fields match everything.
+type ListFilter struct {
+	Status model.TaskStatus
+	Kind   string
+	Tenant uint
+	Limit  int
+}
+
+// Queue runs persisted tasks on a worker pool.
+type Queue struct {
+	db   *gorm.DB
+	opts Options
+	wake chan struct{}
+	wg   sync.WaitGroup
+
+	mu       sync.Mutex
+	handlers map[string]Handler
+	running  map[uint]context.CancelFunc
+	// canceling holds running tasks whose cancellation was requested.
+	canceling map[uint]bool
+	onChange  []func(model.Task)
+	started   bool
+}
+
+// New creates a queue storing tasks in db. Register handlers with Handle
+// before calling Start.
+func New(db *gorm.DB, opts Options) *Queue {
+	if opts.Workers <= 0 {
+		opts.Workers = 2
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.BaseBackoff <= 0 {
+		opts.BaseBackoff = 2 * time.Second
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = 10 * time.Minute
+	}
+	return &Queue{
+		db:        db,
+		opts:      opts,
+		wake:      make(chan struct{}, 1),
+		handlers:  make(map[string]Handler),
+		running:   make(map[uint]context.CancelFunc),
+		canceling: make(map[uint]bool),
+	}
+}
+
+// Handle registers the handler for a task kind.
+func (q *Queue) Handle(kind string, h Handler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[kind] = h
+}
+
+// Kinds lists the registered task kinds.
+func (q *Queue) Kinds() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	kinds := make([]string, 0, len(q.handlers))
+	for kind := range q.handlers {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// OnChange registers fn to be called after every persisted state change,
+// including progress updates.
+func (q *Queue) OnChange(fn func(model.Task)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.onChange = append(q.onChange, fn)
+}
+
+// Enqueue stores a new task; payload is encoded as JSON.
+func (q *Queue) Enqueue(kind string, payload interface{}, opts EnqueueOptions) (model.Task, error) {
+	q.mu.Lock()
+	_, ok := q.handlers[kind]
+	q.mu.Unlock()
+	if !ok {
+		return model.Task{}, fmt.Errorf("%w %q", ErrUnknownKind, kind)
+	}
+
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return model.Task{}, fmt.Errorf("encode payload: %w", err)
+	}
+	maxAttempts := opts.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = q.opts.MaxAttempts
+	}
+	task := model.Task{
+		Kind:        kind,
+		Payload:     raw,
+		Status:      model.TaskQueued,
+		MaxAttempts: maxAttempts,
+		RunAt:       time.Now().Add(opts.Delay),
+		Submitter:   opts.Submitter,
+		TenantID:    opts.Tenant,
+	}
+	if err := q.db.Create(&task).Error; err != nil {
+		return model.Task{}, err
+	}
+	q.notify(task)
+	signal(q.wake)
+	return task, nil
+}
+
+// Get loads one task.
+func (q *Queue) Get(id uint) (model.Task, error) {
+	var task model.Task
+	res := q.db.Limit(1).Find(&task, id)
+	if res.Error != nil {
+		return model.Task{}, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return model.Task{}, ErrNotFound
+	}
+	return task, nil
+}
+
+// List returns the newest tasks matching filter.
+func (q *Queue) List(filter ListFilter) ([]model.Task, error) {
+	limit := filter.Limit
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	query := q.db.Order("id DESC").Limit(limit)
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.Kind != "" {
+		query = query.Where("kind = ?", filter.Kind)
+	}
+	if filter.Tenant != 0 {
+		query = query.Where("tenant_id = ?", filter.Tenant)
+	}
+	var list []model.Task
+	err := query.Find(&list).Error
+	return list, err
+}
+
+// Cancel stops a queued task immediately. A running task has its context
+// cancelled and becomes canceled once its handler returns.
+func (q *Queue) Cancel(id uint) (model.Task, error) {
+	task, err := q.Get(id)
+	if err != nil {
+		return model.Task{}, err
+	}
+	switch task.Status {
+	case model.TaskQueued:
+		now := time.Now()
+		res := q.db.Model(&model.Task{}).Where("id = ? AND status = ?", id, model.TaskQueued).
+			Updates(map[string]interface{}{"status": model.TaskCanceled, "finished_at": now})
+		if res.Error != nil {
+			return model.Task{}, res.Error
+		}
+		if res.RowsAffected == 0 {
+			// A worker claimed it in the meantime.
+			return q.Cancel(id)
+		}
+		task.Status, task.FinishedAt = model.TaskCanceled, &now
+		q.notify(task)
+		return task, nil
+	case model.TaskRunning:
+		q.mu.Lock()
+		cancel, ok := q.running[id]
+		if ok {
+			q.canceling[id] = true
+		}
+		q.mu.Unlock()
+		if ok {
+			cancel()
+			return task, nil
+		}
+		// Left running by a process that is gone.
+		now := time.Now()
+		task.Status, task.FinishedAt = model.TaskCanceled, &now
+		if err := q.db.Save(&task).Error; err != nil {
+			return model.Task{}, err
+		}
+		q.notify(task)
+		return task, nil
+	default:
+		return task, ErrFinished
+	}
+}
+
+// Retry requeues a dead or canceled task with a fresh set of attempts.
+func (q *Queue) Retry(id uint) (model.Task, error) {
+	task, err := q.Get(id)
+	if err != nil {
+		return model.Task{}, err
+	}
+	if task.Status != model.TaskDead && task.Status != model.TaskCanceled {
+		return task, ErrNotRetryable
+	}
+	task.Status = model.TaskQueued
+	task.Attempts = 0
+	task.RunAt = time.Now()
+	task.FinishedAt = nil
+	if err := q.db.Save(&task).Error; err != nil {
+		return model.Task{}, err
+	}
+	q.notify(task)
+	signal(q.wake)
+	return task, nil
+}
+
+// Purge deletes succeeded and canceled tasks that finished before cutoff.
+// Dead tasks are kept for inspection.
+func (q *Queue) Purge(cutoff time.Time) (int64, error) {
+	res := q.db.Where("status IN ? AND finished_at < ?", []model.TaskStatus{model.TaskSucceeded, model.TaskCanceled}, cutoff).
+		Delete(&model.Task{})
+	return res.RowsAffected, res.Error
+}
+
+// Start requeues tasks interrupted by a previous shutdown and launches the
+// workers, which stop when ctx is done.
+func (q *Queue) Start(ctx context.Context) {
+	q.mu.Lock()
+	if q.started || q.db == nil {
+		q.mu.Unlock()
+		return
+	}
+	q.started = true
+	q.mu.Unlock()
+
+	res := q.db.Model(&model.Task{}).Where("status = ?", model.TaskRunning).
+		Updates(map[string]interface{}{"status": model.TaskQueued, "run_at": time.Now()})
+	if res.Error != nil {
+		logger.Errorf("failed to requeue interrupted tasks: %v", res.Error)
+	} else if res.RowsAffected > 0 {
+		logger.Infof("requeued %d interrupted tasks", res.RowsAffected)
+	}
+
+	for i := 0; i < q.opts.Workers; i++ {
+		q.wg.Add(1)
+		go q.work(ctx)
+	}
+}
+
+// Wait blocks until the workers have stopped or ctx is done.
+func (q *Queue) Wait(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// Drain runs the due tasks one after another in the calling goroutine until
+// none are left and reports how many ran. Tests use it instead of Start to
+// run queued work deterministically; failed tasks are retried by a later
+// Drain once their backoff has passed.
+func (q *Queue) Drain(ctx context.Context) (int, error) {
+	ran := 0
+	for ctx.Err() == nil {
+		task, handler, err := q.claim()
+		if err != nil || task == nil {
+			return ran, err
+		}
+		q.execute(ctx, task, handler)
+		ran++
+	}
+	return ran, ctx.Err()
+}
+
+func (q *Queue) work(ctx context.Context) {
+	defer q.wg.Done()
+	ticker := time.NewTicker(q.opts.PollInterval)
+	defer ticker.Stop()
+
+	for ctx.Err() == nil {
+		task, handler, err := q.claim()
+		if err != nil {
+			logger.Errorf("failed to claim task: %v", err)
+		}
+		if task != nil {
+			q.execute(ctx, task, handler)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// claim atomically moves the oldest due task of a known kind to running.
+func (q *Queue) claim() (*Task, Handler, error) {
+	q.mu.Lock()
+	kinds := make([]string, 0, len(q.handlers))
+	for kind := range q.handlers {
+		kinds = append(kinds, kind)
+	}
+	q.mu.Unlock()
+	if len(kinds) == 0 {
+		return nil, nil, nil
+	}
+
+	for {
+		var task model.Task
+		res := q.db.Where("status = ? AND run_at <= ? AND kind IN ?", model.TaskQueued, time.Now(), kinds).
+			Order("run_at, id").Limit(1).Find(&task)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return nil, nil, res.Error
+		}
+
+		now := time.Now()
+		res = q.db.Model(&model.Task{}).Where("id = ? AND status = ?", task.ID, model.TaskQueued).
+			Updates(map[string]interface{}{
+				"status":     model.TaskRunning,
+				"attempts":   gorm.Expr("attempts + 1"),
+				"started_at": now,
+			})
+		if res.Error != nil {
+			return nil, nil, res.Error
+		}
+		if res.RowsAffected == 0 {
+			continue // another worker won the race
+		}
+		task.Status = model.TaskRunning
+		task.Attempts++
+		task.StartedAt = &now
+
+		q.mu.Lock()
+		handler := q.handlers[task.Kind]
+		q.mu.Unlock()
+		return &Task{Task: task, q: q}, handler, nil
+	}
+}
+
+func (q *Queue) execute(ctx context.Context, t *Task, handler Handler) {
+	runCtx, cancel := context.WithCancel(ctx)
+	if t.TenantID != 0 {
+		runCtx = tenant.WithID(runCtx, t.TenantID)
+	}
+	q.mu.Lock()
+	q.running[t.ID] = cancel
+	q.mu.Unlock()
+	q.notify(t.snapshot())
+
+	err := call(runCtx, handler, t)
+	cancel()
+
+	q.mu.Lock()
+	delete(q.running, t.ID)
+	canceled := q.canceling[t.ID]
+	delete(q.canceling, t.ID)
+	q.mu.Unlock()
+
+	t.mu.Lock()
+	now := time.Now()
+	var permanent permanentError
+	switch {
+	case err == nil:
+		t.Status = model.TaskSucceeded
+		t.Progress = 100
+		t.LastError = ""
+		t.FinishedAt = &now
+	case canceled:
+		t.Status = model.TaskCanceled
+		t.FinishedAt = &now
+	case ctx.Err() != nil:
+		// The app is shutting down; the interrupted attempt does not count.
+		t.Status = model.TaskQueued
+		t.Attempts--
+		t.RunAt = now
+	case errors.As(err, &permanent) || t.Attempts >= t.MaxAttempts:
+		t.Status = model.TaskDead
+		t.LastError = err.Error()
+		t.FinishedAt = &now
+	default:
+		t.Status = model.TaskQueued
+		t.LastError = err.Error()
+		t.RunAt = now.Add(q.backoff(t.Attempts))
+	}
+	task := t.Task
+	t.mu.Unlock()
+
+	if err != nil && !canceled && ctx.Err() == nil {
+		logger.Warningf("task %d (%s) attempt %d failed: %v", task.ID, task.Kind, task.Attempts, err)
+	}
+	if err := q.db.Save(&task).Error; err != nil {
+		logger.Errorf("failed to save task %d: %v", task.ID, err)
+	}
+	q.notify(task)
+}
+
+// backoff doubles the delay after every failed attempt.
+func (q *Queue) backoff(attempts int) time.Duration {
+	d := q.opts.BaseBackoff
+	for i := 1; i < attempts && d < q.opts.MaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, q.opts.MaxBackoff)
+}
+
+// call runs the handler, turning a panic into an error.
+func call(ctx context.Context, handler Handler, t *Task) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+	return handler(ctx, t)
+}
+
+func (q *Queue) notify(task model.Task) {
+	q.mu.Lock()
+	listeners := q.onChange
+	q.mu.Unlock()
+	for _, fn := range listeners {
+		fn(task)
+	}
+}
+
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
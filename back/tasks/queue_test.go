@@ -0,0 +1,289 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/wonderfulsuccess/go-web-app/back/model"
+)
+
+var dbs atomic.Int64
+
+// newQueue returns a queue on a fresh in-memory database.
+func newQueue(t *testing.T, opts Options) *Queue {
+	t.Helper()
+	dsn := fmt.Sprintf("file:tasks-%d?mode=memory&cache=shared", dbs.Add(1))
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One connection keeps the database alive and avoids lock contention.
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&model.Task{}); err != nil {
+		t.Fatal(err)
+	}
+	return New(db, opts)
+}
+
+func TestBackoff(t *testing.T) {
+	q := New(nil, Options{BaseBackoff: 2 * time.Second, MaxBackoff: 10 * time.Second})
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 1, want: 2 * time.Second},
+		{attempts: 2, want: 4 * time.Second},
+		{attempts: 3, want: 8 * time.Second},
+		{attempts: 4, want: 10 * time.Second},
+		{attempts: 50, want: 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := q.backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestExecute(t *testing.T) {
+	boom := errors.New("boom")
+	tests := []struct {
+		name        string
+		handler     Handler
+		maxAttempts int
+		status      model.TaskStatus
+		lastError   string
+		retryLater  bool
+	}{
+		{
+			name: "success",
+			handler: func(ctx context.Context, t *Task) error {
+				return t.SetResult(map[string]int{"rows": 3})
+			},
+			status: model.TaskSucceeded,
+		},
+		{
+			name:       "failure is retried",
+			handler:    func(context.Context, *Task) error { return boom },
+			status:     model.TaskQueued,
+			lastError:  "boom",
+			retryLater: true,
+		},
+		{
+			name:        "last attempt fails",
+			handler:     func(context.Context, *Task) error { return boom },
+			maxAttempts: 1,
+			status:      model.TaskDead,
+			lastError:   "boom",
+		},
+		{
+			name:      "permanent failure",
+			handler:   func(context.Context, *Task) error { return Permanent(boom) },
+			status:    model.TaskDead,
+			lastError: "boom",
+		},
+		{
+			name:       "panic",
+			handler:    func(context.Context, *Task) error { panic("nil map") },
+			status:     model.TaskQueued,
+			lastError:  "panic: nil map",
+			retryLater: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newQueue(t, Options{BaseBackoff: time.Hour})
+			q.Handle("job", tt.handler)
+			task, err := q.Enqueue("job", nil, EnqueueOptions{MaxAttempts: tt.maxAttempts})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ran, err := q.Drain(context.Background()); err != nil || ran != 1 {
+				t.Fatalf("Drain ran %d tasks, %v", ran, err)
+			}
+			got, err := q.Get(task.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != tt.status || !strings.HasPrefix(got.LastError, tt.lastError) || got.Attempts != 1 {
+				t.Fatalf("task %+v, want status %s and error %q", got, tt.status, tt.lastError)
+			}
+			if tt.retryLater != got.RunAt.After(time.Now().Add(time.Minute)) {
+				t.Fatalf("next run at %s", got.RunAt)
+			}
+			if tt.status == model.TaskSucceeded && (got.Progress != 100 || string(got.Result) != `{"rows":3}`) {
+				t.Fatalf("succeeded task %+v", got)
+			}
+		})
+	}
+}
+
+func TestRetriesUntilDead(t *testing.T) {
+	q := newQueue(t, Options{MaxAttempts: 3, BaseBackoff: time.Nanosecond})
+	var calls atomic.Int32
+	q.Handle("flaky", func(context.Context, *Task) error {
+		calls.Add(1)
+		return errors.New("unavailable")
+	})
+	task, err := q.Enqueue("flaky", nil, EnqueueOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := q.Get(task.ID)
+	if calls.Load() != 3 || got.Status != model.TaskDead || got.Attempts != 3 {
+		t.Fatalf("%d calls, task %+v; want 3 calls and a dead task", calls.Load(), got)
+	}
+
+	got, err = q.Retry(task.ID)
+	if err != nil || got.Status != model.TaskQueued || got.Attempts != 0 {
+		t.Fatalf("Retry = %+v, %v", got, err)
+	}
+	q.Handle("flaky", func(context.Context, *Task) error { return nil })
+	if _, err := q.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Retry(task.ID); !errors.Is(err, ErrNotRetryable) {
+		t.Fatalf("retrying a succeeded task: %v", err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	q := newQueue(t, Options{})
+	started := make(chan struct{})
+	q.Handle("slow", func(ctx context.Context, _ *Task) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	q.Handle("later", func(context.Context, *Task) error { return nil })
+
+	queued, err := q.Enqueue("later", nil, EnqueueOptions{Delay: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := q.Cancel(queued.ID)
+	if err != nil || got.Status != model.TaskCanceled || got.FinishedAt == nil {
+		t.Fatalf("cancel queued task: %+v, %v", got, err)
+	}
+	if _, err := q.Cancel(queued.ID); !errors.Is(err, ErrFinished) {
+		t.Fatalf("cancel twice: %v", err)
+	}
+
+	running, err := q.Enqueue("slow", nil, EnqueueOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = q.Drain(context.Background())
+	}()
+	<-started
+	if _, err := q.Cancel(running.ID); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	got, _ = q.Get(running.ID)
+	if got.Status != model.TaskCanceled || got.Attempts != 1 {
+		t.Fatalf("cancel running task: %+v", got)
+	}
+	if _, err := q.Cancel(12345); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("cancel unknown task: %v", err)
+	}
+}
+
+func TestShutdownDoesNotCountAttempt(t *testing.T) {
+	q := newQueue(t, Options{})
+	ctx, stop := context.WithCancel(context.Background())
+	q.Handle("slow", func(ctx context.Context, _ *Task) error {
+		stop()
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	task, err := q.Enqueue("slow", nil, EnqueueOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = q.Drain(ctx)
+	got, _ := q.Get(task.ID)
+	if got.Status != model.TaskQueued || got.Attempts != 0 || got.LastError != "" {
+		t.Fatalf("interrupted task %+v, want it queued with no attempt used", got)
+	}
+}
+
+func TestStartRequeuesInterruptedTasks(t *testing.T) {
+	q := newQueue(t, Options{PollInterval: 10 * time.Millisecond})
+	// A task left running by a process that crashed.
+	stale := model.Task{Kind: "job", Status: model.TaskRunning, Attempts: 1, MaxAttempts: 5, RunAt: time.Now().Add(-time.Hour)}
+	if err := q.db.Create(&stale).Error; err != nil {
+		t.Fatal(err)
+	}
+	ran := make(chan uint, 1)
+	q.Handle("job", func(_ context.Context, t *Task) error {
+		ran <- t.ID
+		return nil
+	})
+
+	ctx, stop := context.WithCancel(context.Background())
+	q.Start(ctx)
+	defer func() {
+		stop()
+		q.Wait(context.Background())
+	}()
+	select {
+	case id := <-ran:
+		if id != stale.ID {
+			t.Fatalf("ran task %d, want %d", id, stale.ID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the interrupted task was not resumed")
+	}
+}
+
+func TestEnqueue(t *testing.T) {
+	q := newQueue(t, Options{MaxAttempts: 4})
+	var changes []model.TaskStatus
+	q.OnChange(func(t model.Task) { changes = append(changes, t.Status) })
+	if _, err := q.Enqueue("missing", nil, EnqueueOptions{}); !errors.Is(err, ErrUnknownKind) {
+		t.Fatalf("unknown kind: %v", err)
+	}
+	q.Handle("job", func(context.Context, *Task) error { return nil })
+	task, err := q.Enqueue("job", map[string]string{"file": "a.csv"}, EnqueueOptions{Submitter: "ada", Tenant: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.MaxAttempts != 4 || task.Submitter != "ada" || task.TenantID != 7 || string(task.Payload) != `{"file":"a.csv"}` {
+		t.Fatalf("enqueued %+v", task)
+	}
+	if _, err := q.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := []model.TaskStatus{model.TaskQueued, model.TaskRunning, model.TaskSucceeded}
+	if fmt.Sprint(changes) != fmt.Sprint(want) {
+		t.Fatalf("changes %v, want %v", changes, want)
+	}
+
+	list, err := q.List(ListFilter{Tenant: 7, Status: model.TaskSucceeded})
+	if err != nil || len(list) != 1 {
+		t.Fatalf("List = %d tasks, %v", len(list), err)
+	}
+	if n, err := q.Purge(time.Now().Add(time.Minute)); err != nil || n != 1 {
+		t.Fatalf("Purge = %d, %v", n, err)
+	}
+}
@@ -0,0 +1,69 @@
+package tasks
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/wonderfulsuccess/go-web-app/back/logger"
+	"github.com/wonderfulsuccess/go-web-app/back/model"
+)
+
+// progressInterval limits how often progress is written and pushed.
+const progressInterval = 200 * time.Millisecond
+
+// Task is the running attempt handed to a Handler.
+type Task struct {
+	mu sync.Mutex
+	model.Task
+	q            *Queue
+	lastProgress time.Time
+}
+
+// Decode unmarshals the task's payload into v.
+func (t *Task) Decode(v interface{}) error {
+	return json.Unmarshal(t.Payload, v)
+}
+
+// SetProgress records how far the task got, as a percentage, with an
+// optional message. Updates closer together than 200ms are coalesced.
+func (t *Task) SetProgress(percent int, message string) {
+	percent = max(0, min(percent, 100))
+	now := time.Now()
+
+	t.mu.Lock()
+	t.Progress = percent
+	t.ProgressMessage = message
+	if now.Sub(t.lastProgress) < progressInterval && percent < 100 {
+		t.mu.Unlock()
+		return
+	}
+	t.lastProgress = now
+	task := t.Task
+	t.mu.Unlock()
+
+	err := t.q.db.Model(&model.Task{}).Where("id = ?", task.ID).
+		Updates(map[string]interface{}{"progress": percent, "progress_message": message}).Error
+	if err != nil {
+		logger.Errorf("failed to save progress of task %d: %v", task.ID, err)
+	}
+	t.q.notify(task)
+}
+
+// SetResult stores v, encoded as JSON, as the task's result.
+func (t *Task) SetResult(v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.Result = raw
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *Task) snapshot() model.Task {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.Task
+}
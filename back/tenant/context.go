@@ -0,0 +1,46 @@
+// Package tenant isolates the data of the app's tenants. Requests are
+// mapped to a tenant by subdomain, header or cookie, the tenant travels in
+// the request context, and a Gorm plugin adds tenant_id to every query on
+// the models listed by model.TenantScoped, so a handler that forgets to
+// filter cannot read or write another tenant's rows. With SQLite, each
+// tenant may instead get a database file of its own.
+package tenant
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrMissing is returned by queries on tenant-scoped models whose
+	// context carries no tenant.
+	ErrMissing = errors.New("no tenant in context")
+	// ErrNotFound is returned for unknown tenant slugs and ids.
+	ErrNotFound = errors.New("tenant not found")
+	// ErrCrossTenant is returned when creating a row that names a tenant
+	// other than the one in the context.
+	ErrCrossTenant = errors.New("row belongs to another tenant")
+	// ErrInvalidSlug is returned by Create for slugs that cannot be used in
+	// a host name.
+	ErrInvalidSlug = errors.New("tenant slug must be 1-63 lowercase letters, digits or hyphens")
+	// ErrExists is returned by Create for a slug that is taken.
+	ErrExists = errors.New("tenant already exists")
+	// ErrDefault is returned when deleting the default tenant.
+	ErrDefault = errors.New("the default tenant cannot be deleted")
+)
+
+type contextKey struct{}
+
+// WithID returns a context whose queries are scoped to the tenant id.
+func WithID(ctx context.Context, id uint) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the tenant set by WithID.
+func FromContext(ctx context.Context) (uint, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+	id, ok := ctx.Value(contextKey{}).(uint)
+	return id, ok && id != 0
+}
@@ -0,0 +1,164 @@
+package tenant
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"gorm.io/gorm"
+
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+	"github.com/wonderfulsuccess/go-web-app/back/database"
+	"github.com/wonderfulsuccess/go-web-app/back/logger"
+	"github.com/wonderfulsuccess/go-web-app/back/model"
+)
+
+// files routes the statements of each tenant to a SQLite file of its own,
+// data/tenants/<slug>.db next to the main database. It replaces the
+// connection pool of the *gorm.DB, so every query, transaction and
+// migration whose context names a tenant runs against that tenant's file;
+// statements without a tenant, such as those on tenants, jobs and tasks,
+// stay in the main database.
+type files struct {
+	main *sql.DB
+	dir  string
+	cfg  config.DatabaseConfig
+
+	mu    sync.RWMutex
+	pools map[uint]*sql.DB
+	// closed answers QueryRowContext for tenants whose file cannot be
+	// opened, since a *sql.Row cannot carry an error of our own.
+	closed *sql.DB
+}
+
+func newFiles(db *gorm.DB, cfg config.DatabaseConfig) (*files, error) {
+	main, ok := db.ConnPool.(*sql.DB)
+	if !ok {
+		return nil, fmt.Errorf("per-tenant databases need a plain SQLite connection, got %T; set SQLITE_READERS=0", db.ConnPool)
+	}
+	path, err := database.SQLitePath(cfg.DSN)
+	if err != nil {
+		return nil, err
+	}
+	closed, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		return nil, err
+	}
+	closed.Close()
+	f := &files{
+		main:   main,
+		dir:    filepath.Join(filepath.Dir(path), "tenants"),
+		cfg:    cfg,
+		pools:  make(map[uint]*sql.DB),
+		closed: closed,
+	}
+	db.ConnPool = f
+	db.Statement.ConnPool = f
+	return f, nil
+}
+
+func (f *files) path(slug string) string {
+	return filepath.Join(f.dir, slug+".db")
+}
+
+// open opens the file of t unless it is open already.
+func (f *files) open(t model.Tenant) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.pools[t.ID]; ok {
+		return false, nil
+	}
+	pool, err := database.OpenSQLiteFile(f.cfg, f.path(t.Slug))
+	if err != nil {
+		return false, fmt.Errorf("open database of tenant %s: %w", t.Slug, err)
+	}
+	f.pools[t.ID] = pool
+	return true, nil
+}
+
+// remove closes the file of t and deletes it with its WAL files.
+func (f *files) remove(t model.Tenant) error {
+	f.mu.Lock()
+	pool, ok := f.pools[t.ID]
+	delete(f.pools, t.ID)
+	f.mu.Unlock()
+	if ok {
+		pool.Close()
+	}
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		if err := os.Remove(f.path(t.Slug) + suffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *files) pool(ctx context.Context) (*sql.DB, error) {
+	id, ok := FromContext(ctx)
+	if !ok {
+		return f.main, nil
+	}
+	f.mu.RLock()
+	pool, ok := f.pools[id]
+	f.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: id %d has no open database", ErrNotFound, id)
+	}
+	return pool, nil
+}
+
+// PrepareContext implements gorm.ConnPool.
+func (f *files) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	pool, err := f.pool(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return pool.PrepareContext(ctx, query)
+}
+
+// ExecContext implements gorm.ConnPool.
+func (f *files) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	pool, err := f.pool(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return pool.ExecContext(ctx, query, args...)
+}
+
+// QueryContext implements gorm.ConnPool.
+func (f *files) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	pool, err := f.pool(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return pool.QueryContext(ctx, query, args...)
+}
+
+// QueryRowContext implements gorm.ConnPool.
+func (f *files) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	pool, err := f.pool(ctx)
+	if err != nil {
+		logger.Errorf("%v", err)
+		pool = f.closed
+	}
+	return pool.QueryRowContext(ctx, query, args...)
+}
+
+// BeginTx implements gorm.TxBeginner, starting the transaction in the
+// file of the context's tenant.
+func (f *files) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	pool, err := f.pool(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return pool.BeginTx(ctx, opts)
+}
+
+// GetDBConn implements gorm.GetDBConnector. db.DB() returns the main
+// database, which is what health checks, backups and pool settings act on.
+func (f *files) GetDBConn() (*sql.DB, error) {
+	return f.main, nil
+}
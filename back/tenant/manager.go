@@ -0,0 +1,368 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+	"github.com/wonderfulsuccess/go-web-app/back/logger"
+	"github.com/wonderfulsuccess/go-web-app/back/model"
+)
+
+// slugPattern keeps slugs usable as a DNS label.
+var slugPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// Manager resolves requests to tenants and creates and deletes them.
+type Manager struct {
+	db    *gorm.DB
+	cfg   config.TenantConfig
+	files *files
+
+	mu sync.RWMutex
+	// fallback is the default tenant.
+	fallback model.Tenant
+	bySlug   map[string]model.Tenant
+	onOpen   []func(ctx context.Context) error
+}
+
+// New installs the scoping plugin on db, creates the default tenant and
+// hands it the rows written before tenants existed. With cfg.SQLiteFiles it
+// also opens the database file of every tenant; the default tenant keeps
+// the main database. Run it after model.AutoMigrate.
+func New(db *gorm.DB, dbCfg config.DatabaseConfig, cfg config.TenantConfig) (*Manager, error) {
+	if !slugPattern.MatchString(cfg.Default) {
+		return nil, fmt.Errorf("invalid TENANT_DEFAULT %q: %w", cfg.Default, ErrInvalidSlug)
+	}
+	m := &Manager{db: db, cfg: cfg, bySlug: make(map[string]model.Tenant)}
+	// The plugin hooks Gorm's create, query, row, update and delete
+	// callbacks. db.Raw and db.Exec bypass them: hand-written SQL is
+	// neither filtered nor checked and must handle tenant_id itself.
+	if err := db.Use(Scope()); err != nil {
+		return nil, err
+	}
+	if cfg.SQLiteFiles {
+		if dbCfg.Type != config.DBTypeSQLite {
+			return nil, fmt.Errorf("TENANT_SQLITE_FILES requires DB_TYPE=sqlite, got %s", dbCfg.Type)
+		}
+		files, err := newFiles(db, dbCfg)
+		if err != nil {
+			return nil, err
+		}
+		m.files = files
+	}
+
+	if err := m.Migrate(context.Background()); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Migrate creates the default tenant, hands it the rows without a tenant,
+// such as those of a restored backup taken before tenants existed, and
+// opens the database files of all tenants.
+func (m *Manager) Migrate(ctx context.Context) error {
+	def := model.Tenant{Slug: m.cfg.Default, Name: m.cfg.Default}
+	if err := m.main(ctx).Where("slug = ?", def.Slug).FirstOrCreate(&def).Error; err != nil {
+		return fmt.Errorf("create default tenant: %w", err)
+	}
+	m.mu.Lock()
+	m.fallback = def
+	m.bySlug = make(map[string]model.Tenant)
+	m.mu.Unlock()
+	if err := m.adoptOrphans(def.ID); err != nil {
+		return err
+	}
+	if m.files == nil {
+		return nil
+	}
+
+	m.files.mu.Lock()
+	m.files.pools[def.ID] = m.files.main
+	m.files.mu.Unlock()
+	list, err := m.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, t := range list {
+		if err := m.openFile(ctx, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// adoptOrphans moves rows without a tenant, written before tenants
+// existed, to the default tenant.
+func (m *Manager) adoptOrphans(id uint) error {
+	for _, value := range model.TenantScoped() {
+		sch, err := schema.Parse(value, &sync.Map{}, m.db.NamingStrategy)
+		if err != nil {
+			return err
+		}
+		res := m.db.Exec("UPDATE ? SET "+Column+" = ? WHERE "+Column+" = 0", clause.Table{Name: sch.Table}, id)
+		if res.Error != nil {
+			return fmt.Errorf("assign %s to the default tenant: %w", sch.Table, res.Error)
+		}
+		if res.RowsAffected > 0 {
+			logger.Infof("assigned %d %s rows to tenant %s", res.RowsAffected, sch.Table, m.cfg.Default)
+		}
+	}
+	return nil
+}
+
+// Default returns the tenant of requests that name none.
+func (m *Manager) Default() model.Tenant {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.fallback
+}
+
+// OnOpen registers fn to prepare a tenant database, e.g. create its search
+// index, whenever one is opened. It runs at once for the databases open
+// already. fn receives a context scoped to the tenant. Without per-tenant
+// files there are no tenant databases and fn never runs.
+func (m *Manager) OnOpen(fn func(ctx context.Context) error) error {
+	m.mu.Lock()
+	m.onOpen = append(m.onOpen, fn)
+	m.mu.Unlock()
+	if m.files == nil {
+		return nil
+	}
+	m.files.mu.RLock()
+	ids := make([]uint, 0, len(m.files.pools))
+	for id := range m.files.pools {
+		if id != m.Default().ID {
+			ids = append(ids, id)
+		}
+	}
+	m.files.mu.RUnlock()
+	for _, id := range ids {
+		if err := fn(WithID(context.Background(), id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prepare migrates a freshly opened tenant database.
+func (m *Manager) prepare(ctx context.Context, t model.Tenant) error {
+	ctx = WithID(ctx, t.ID)
+	if err := m.db.WithContext(ctx).AutoMigrate(model.TenantScoped()...); err != nil {
+		return fmt.Errorf("migrate database of tenant %s: %w", t.Slug, err)
+	}
+	m.mu.RLock()
+	hooks := m.onOpen
+	m.mu.RUnlock()
+	for _, fn := range hooks {
+		if err := fn(ctx); err != nil {
+			return fmt.Errorf("prepare database of tenant %s: %w", t.Slug, err)
+		}
+	}
+	return nil
+}
+
+// FromRequest picks the tenant of a request: the tenant header, then the
+// subdomain of the base domain, then the tenant cookie, then the default
+// tenant. A named tenant that does not exist is ErrNotFound rather than
+// the default, so a typo never shows another tenant's data.
+//
+// The tenant is what the caller asks for; it is not checked against the
+// session or client certificate. Tenants separate data, they do not
+// control access: any client that knows a slug can act in that tenant.
+// Deployments that tie users to tenants must set the header in an
+// authenticating proxy that drops the one sent by the client.
+func (m *Manager) FromRequest(r *http.Request) (model.Tenant, error) {
+	slug := strings.TrimSpace(r.Header.Get(m.cfg.Header))
+	if slug == "" {
+		slug = m.subdomain(r.Host)
+	}
+	if slug == "" && m.cfg.Cookie != "" {
+		if cookie, err := r.Cookie(m.cfg.Cookie); err == nil {
+			slug = cookie.Value
+		}
+	}
+	if slug == "" {
+		return m.Default(), nil
+	}
+	return m.Resolve(r.Context(), slug)
+}
+
+// subdomain returns "acme" for acme.example.com when the base domain is
+// example.com.
+func (m *Manager) subdomain(host string) string {
+	if m.cfg.BaseDomain == "" {
+		return ""
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	sub, ok := strings.CutSuffix(strings.ToLower(host), "."+strings.ToLower(m.cfg.BaseDomain))
+	if !ok || strings.Contains(sub, ".") {
+		return ""
+	}
+	return sub
+}
+
+// Resolve returns the tenant with the given slug.
+func (m *Manager) Resolve(ctx context.Context, slug string) (model.Tenant, error) {
+	slug = strings.ToLower(slug)
+	m.mu.RLock()
+	t, ok := m.bySlug[slug]
+	m.mu.RUnlock()
+	if ok {
+		return t, nil
+	}
+	if !slugPattern.MatchString(slug) {
+		return model.Tenant{}, fmt.Errorf("%w: %q", ErrNotFound, slug)
+	}
+	res := m.main(ctx).Where("slug = ?", slug).Limit(1).Find(&t)
+	if res.Error != nil {
+		return model.Tenant{}, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return model.Tenant{}, fmt.Errorf("%w: %q", ErrNotFound, slug)
+	}
+	// Created by another process since this one started.
+	if err := m.openFile(ctx, t); err != nil {
+		return model.Tenant{}, err
+	}
+	m.mu.Lock()
+	m.bySlug[slug] = t
+	m.mu.Unlock()
+	return t, nil
+}
+
+func (m *Manager) openFile(ctx context.Context, t model.Tenant) error {
+	if m.files == nil {
+		return nil
+	}
+	opened, err := m.files.open(t)
+	if err != nil || !opened {
+		return err
+	}
+	return m.prepare(ctx, t)
+}
+
+// main returns db for the tables of the main database, dropping any tenant
+// from ctx so per-tenant files do not capture the statements.
+func (m *Manager) main(ctx context.Context) *gorm.DB {
+	return m.db.WithContext(WithID(ctx, 0))
+}
+
+// List returns every tenant ordered by id.
+func (m *Manager) List(ctx context.Context) ([]model.Tenant, error) {
+	var list []model.Tenant
+	err := m.main(ctx).Order("id").Find(&list).Error
+	return list, err
+}
+
+// Get returns the tenant with the given id.
+func (m *Manager) Get(ctx context.Context, id uint) (model.Tenant, error) {
+	var t model.Tenant
+	res := m.main(ctx).Limit(1).Find(&t, id)
+	if res.Error != nil {
+		return model.Tenant{}, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return model.Tenant{}, fmt.Errorf("%w: id %d", ErrNotFound, id)
+	}
+	return t, nil
+}
+
+// Create adds a tenant and, with per-tenant files, its database.
+func (m *Manager) Create(ctx context.Context, slug, name string) (model.Tenant, error) {
+	slug = strings.ToLower(strings.TrimSpace(slug))
+	if !slugPattern.MatchString(slug) {
+		return model.Tenant{}, ErrInvalidSlug
+	}
+	if name == "" {
+		name = slug
+	}
+	if _, err := m.Resolve(ctx, slug); err == nil {
+		return model.Tenant{}, fmt.Errorf("%w: %q", ErrExists, slug)
+	} else if !errors.Is(err, ErrNotFound) {
+		return model.Tenant{}, err
+	}
+
+	t := model.Tenant{Slug: slug, Name: name}
+	if err := m.main(ctx).Create(&t).Error; err != nil {
+		return model.Tenant{}, err
+	}
+	if err := m.openFile(ctx, t); err != nil {
+		if m.files != nil {
+			_ = m.files.remove(t)
+		}
+		m.main(ctx).Delete(&t)
+		return model.Tenant{}, err
+	}
+	return t, nil
+}
+
+// Delete removes a tenant together with its rows, or its database file,
+// and its tasks.
+func (m *Manager) Delete(ctx context.Context, id uint) error {
+	t, err := m.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if t.ID == m.Default().ID {
+		return ErrDefault
+	}
+
+	m.mu.Lock()
+	delete(m.bySlug, t.Slug)
+	m.mu.Unlock()
+
+	if m.files != nil {
+		if err := m.files.remove(t); err != nil {
+			return err
+		}
+	} else {
+		scoped := m.db.WithContext(WithID(ctx, t.ID)).Session(&gorm.Session{AllowGlobalUpdate: true})
+		for _, value := range model.TenantScoped() {
+			if err := scoped.Delete(value).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return m.main(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where(Column+" = ?", t.ID).Delete(&model.Task{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&t).Error
+	})
+}
+
+// ForEach calls fn for every tenant with a context scoped to it, for
+// system work such as scheduled jobs that has no request to take the
+// tenant from. It stops at the first error.
+func (m *Manager) ForEach(ctx context.Context, fn func(ctx context.Context, t model.Tenant) error) error {
+	list, err := m.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, t := range list {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(WithID(ctx, t.ID), t); err != nil {
+			return fmt.Errorf("tenant %s: %w", t.Slug, err)
+		}
+	}
+	return nil
+}
+
+// Cookie names the cookie that remembers the tenant a browser chose.
+func (m *Manager) Cookie() string {
+	return m.cfg.Cookie
+}
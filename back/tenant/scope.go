@@ -0,0 +1,204 @@
+package tenant
+
+import (
+	"reflect"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+
+	"github.com/wonderfulsuccess/go-web-app/back/model"
+)
+
+// Column is the tenant key of tenant-scoped tables.
+const Column = "tenant_id"
+
+const scopePlugin = "tenant:scope"
+
+// scope is the Gorm plugin that confines statements on tenant-scoped tables
+// to the tenant in the statement's context. Raw SQL and Exec are not
+// rewritten; queries written by hand must filter on tenant_id themselves.
+type scope struct {
+	tables map[string]bool
+}
+
+// Scope returns the plugin that scopes the models of model.TenantScoped.
+// Install it with db.Use.
+func Scope() gorm.Plugin {
+	return &scope{tables: make(map[string]bool)}
+}
+
+// Name implements gorm.Plugin.
+func (*scope) Name() string { return scopePlugin }
+
+// Initialize implements gorm.Plugin.
+func (s *scope) Initialize(db *gorm.DB) error {
+	for _, m := range model.TenantScoped() {
+		sch, err := schema.Parse(m, &sync.Map{}, db.NamingStrategy)
+		if err != nil {
+			return err
+		}
+		s.tables[sch.Table] = true
+	}
+
+	callbacks := db.Callback()
+	if err := callbacks.Create().Before("gorm:create").Register("tenant:assign", s.create); err != nil {
+		return err
+	}
+	if err := callbacks.Query().Before("gorm:query").Register("tenant:filter", s.query); err != nil {
+		return err
+	}
+	if err := callbacks.Row().Before("gorm:row").Register("tenant:filter", s.query); err != nil {
+		return err
+	}
+	if err := callbacks.Update().Before("gorm:update").Register("tenant:filter", s.update); err != nil {
+		return err
+	}
+	return callbacks.Delete().Before("gorm:delete").Register("tenant:filter", s.delete)
+}
+
+// tenant returns the tenant a statement is confined to, or false when the
+// statement does not touch a tenant-scoped table. A scoped statement
+// without a tenant fails with ErrMissing.
+func (s *scope) tenant(db *gorm.DB) (uint, bool) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.SQL.Len() > 0 {
+		return 0, false
+	}
+	scoped := s.tables[stmt.Table]
+	if stmt.Schema != nil && s.tables[stmt.Schema.Table] {
+		scoped = true
+	}
+	if !scoped {
+		return 0, false
+	}
+	id, ok := FromContext(stmt.Context)
+	if !ok {
+		_ = db.AddError(ErrMissing)
+		return 0, false
+	}
+	return id, true
+}
+
+func (s *scope) create(db *gorm.DB) {
+	if id, ok := s.tenant(db); ok {
+		assign(db, id)
+	}
+}
+
+func (s *scope) query(db *gorm.DB) {
+	if id, ok := s.tenant(db); ok {
+		filter(db, id)
+	}
+}
+
+func (s *scope) update(db *gorm.DB) {
+	id, ok := s.tenant(db)
+	if !ok {
+		return
+	}
+	// Save writes every column, so a model loaded without its tenant
+	// would otherwise be moved to tenant 0.
+	assign(db, id)
+	if targeted(db) {
+		filter(db, id)
+	}
+}
+
+func (s *scope) delete(db *gorm.DB) {
+	if id, ok := s.tenant(db); ok && targeted(db) {
+		filter(db, id)
+	}
+}
+
+func filter(db *gorm.DB, id uint) {
+	db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: Column}, Value: id},
+	}})
+}
+
+// targeted reports whether an update or delete names its rows. Gorm
+// refuses statements that do not, unless global updates are allowed, and
+// the tenant condition must not make them look targeted.
+func targeted(db *gorm.DB) bool {
+	stmt := db.Statement
+	if _, ok := stmt.Clauses["WHERE"]; ok || db.AllowGlobalUpdate {
+		return true
+	}
+	if stmt.Schema == nil || stmt.Schema.PrioritizedPrimaryField == nil {
+		return false
+	}
+	pk := stmt.Schema.PrioritizedPrimaryField
+	found := false
+	eachRow(stmt.ReflectValue, func(row reflect.Value) {
+		if row.Type() == stmt.Schema.ModelType {
+			if _, zero := pk.ValueOf(stmt.Context, row); !zero {
+				found = true
+			}
+		}
+	})
+	return found
+}
+
+// assign sets the tenant of the rows being written, refusing rows that
+// already name another tenant.
+func assign(db *gorm.DB, id uint) {
+	stmt := db.Statement
+	if values, ok := stmt.Dest.(map[string]interface{}); ok {
+		setMapTenant(db, values, id)
+		return
+	}
+	if rows, ok := stmt.Dest.([]map[string]interface{}); ok {
+		for _, values := range rows {
+			setMapTenant(db, values, id)
+		}
+		return
+	}
+	if stmt.Schema == nil {
+		return
+	}
+	field := stmt.Schema.LookUpField(Column)
+	if field == nil {
+		return
+	}
+	eachRow(stmt.ReflectValue, func(row reflect.Value) {
+		if row.Type() != stmt.Schema.ModelType {
+			return
+		}
+		value, zero := field.ValueOf(stmt.Context, row)
+		if zero {
+			if err := field.Set(stmt.Context, row, id); err != nil {
+				_ = db.AddError(err)
+			}
+			return
+		}
+		if current, ok := value.(uint); !ok || current != id {
+			_ = db.AddError(ErrCrossTenant)
+		}
+	})
+}
+
+func setMapTenant(db *gorm.DB, values map[string]interface{}, id uint) {
+	for _, key := range []string{Column, "TenantID"} {
+		if v, ok := values[key]; ok {
+			if current, ok := v.(uint); !ok || current != id {
+				_ = db.AddError(ErrCrossTenant)
+			}
+			return
+		}
+	}
+	values[Column] = id
+}
+
+func eachRow(v reflect.Value, fn func(reflect.Value)) {
+	v = reflect.Indirect(v)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			fn(reflect.Indirect(v.Index(i)))
+		}
+	case reflect.Struct:
+		fn(v)
+	}
+}
@@ -0,0 +1,250 @@
+package tenant_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gorm.io/gorm"
+
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+	"github.com/wonderfulsuccess/go-web-app/back/database"
+	"github.com/wonderfulsuccess/go-web-app/back/model"
+	"github.com/wonderfulsuccess/go-web-app/back/tenant"
+)
+
+// setup opens a SQLite database in a temporary directory, with or without
+// per-tenant files, and returns it with its tenant manager.
+func setup(t *testing.T, files bool) (*gorm.DB, *tenant.Manager, string) {
+	t.Helper()
+	dir := t.TempDir()
+	cfg := config.Load()
+	cfg.Database.Type = config.DBTypeSQLite
+	cfg.Database.DSN = filepath.Join(dir, "app.db")
+	cfg.Database.SQLite.Readers = 0
+	cfg.Database.ReplicaDSNs = nil
+	cfg.Database.ReplicaHosts = nil
+	cfg.Tenant.Default = "default"
+	cfg.Tenant.BaseDomain = "example.com"
+	cfg.Tenant.SQLiteFiles = files
+
+	db, err := database.InitDatabase(cfg.Database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	if err := model.AutoMigrate(db); err != nil {
+		t.Fatal(err)
+	}
+	m, err := tenant.New(db, cfg.Database, cfg.Tenant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, m, dir
+}
+
+func TestScope(t *testing.T) {
+	for _, files := range []bool{false, true} {
+		name := "shared tables"
+		if files {
+			name = "tenant files"
+		}
+		t.Run(name, func(t *testing.T) {
+			db, m, _ := setup(t, files)
+			acme, err := m.Create(context.Background(), "acme", "Acme")
+			if err != nil {
+				t.Fatal(err)
+			}
+			def := tenant.WithID(context.Background(), m.Default().ID)
+			other := tenant.WithID(context.Background(), acme.ID)
+
+			ada := model.User{Name: "Ada", Email: "ada@example.com"}
+			if err := db.WithContext(def).Create(&ada).Error; err != nil {
+				t.Fatal(err)
+			}
+			if ada.TenantID != m.Default().ID {
+				t.Fatalf("created in tenant %d, want %d", ada.TenantID, m.Default().ID)
+			}
+			// The same email may exist once per tenant.
+			if err := db.WithContext(other).Create(&model.User{Name: "Ada", Email: "ada@example.com"}).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			tests := []struct {
+				name string
+				run  func() (int64, error)
+				rows int64
+				err  error
+			}{
+				{name: "list", rows: 1, run: func() (int64, error) {
+					var users []model.User
+					res := db.WithContext(other).Find(&users)
+					return res.RowsAffected, res.Error
+				}},
+				{name: "count", rows: 1, run: func() (int64, error) {
+					var n int64
+					err := db.WithContext(other).Model(&model.User{}).Count(&n).Error
+					return n, err
+				}},
+				{name: "get by id of another tenant", rows: 0, run: func() (int64, error) {
+					// With tenant files ids are per file, so the id may name
+					// a row of acme's own; count only foreign rows.
+					var users []model.User
+					err := db.WithContext(other).Find(&users, ada.ID).Error
+					var foreign int64
+					for _, u := range users {
+						if u.TenantID != acme.ID {
+							foreign++
+						}
+					}
+					return foreign, err
+				}},
+				{name: "update of another tenant's row", rows: 0, run: func() (int64, error) {
+					res := db.WithContext(other).Model(&model.User{}).Where("id = ? AND tenant_id = ?", ada.ID, m.Default().ID).Update("name", "Mallory")
+					return res.RowsAffected, res.Error
+				}},
+				{name: "save into another tenant", err: tenant.ErrCrossTenant, run: func() (int64, error) {
+					u := ada
+					u.ID = 0
+					u.Email = "moved@example.com"
+					res := db.WithContext(other).Create(&u)
+					return res.RowsAffected, res.Error
+				}},
+				{name: "no tenant", err: tenant.ErrMissing, run: func() (int64, error) {
+					var users []model.User
+					res := db.WithContext(context.Background()).Find(&users)
+					return res.RowsAffected, res.Error
+				}},
+			}
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					rows, err := tt.run()
+					if !errors.Is(err, tt.err) || (tt.err == nil && rows != tt.rows) {
+						t.Fatalf("got %d rows, %v; want %d rows, %v", rows, err, tt.rows, tt.err)
+					}
+				})
+			}
+
+			var reloaded model.User
+			if err := db.WithContext(def).First(&reloaded, ada.ID).Error; err != nil || reloaded.Name != "Ada" {
+				t.Fatalf("default tenant's row is now %+v, %v", reloaded, err)
+			}
+		})
+	}
+}
+
+func TestRawSQLIsNotScoped(t *testing.T) {
+	db, m, _ := setup(t, false)
+	acme, err := m.Create(context.Background(), "acme", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []uint{m.Default().ID, acme.ID} {
+		if err := db.WithContext(tenant.WithID(context.Background(), id)).Create(&model.User{Name: "Ada", Email: "ada@example.com"}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	var n int64
+	if err := db.WithContext(tenant.WithID(context.Background(), acme.ID)).Raw("SELECT COUNT(*) FROM users").Scan(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("raw SQL counted %d users, want both tenants' 2", n)
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	_, m, _ := setup(t, false)
+	acme, err := m.Create(context.Background(), "acme", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	globex, err := m.Create(context.Background(), "globex", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		host   string
+		header string
+		cookie string
+		want   uint
+		err    error
+	}{
+		{name: "default", host: "localhost", want: m.Default().ID},
+		{name: "header", host: "localhost", header: "acme", want: acme.ID},
+		{name: "header is case-insensitive", host: "localhost", header: " ACME ", want: acme.ID},
+		{name: "subdomain", host: "globex.example.com:8080", want: globex.ID},
+		{name: "nested subdomain is ignored", host: "a.globex.example.com", want: m.Default().ID},
+		{name: "cookie", host: "localhost", cookie: "acme", want: acme.ID},
+		{name: "header before subdomain", host: "globex.example.com", header: "acme", want: acme.ID},
+		{name: "subdomain before cookie", host: "globex.example.com", cookie: "acme", want: globex.ID},
+		{name: "unknown tenant", host: "localhost", header: "initech", err: tenant.ErrNotFound},
+		{name: "invalid slug", host: "localhost", header: "../acme", err: tenant.ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/users", nil)
+			r.Host = tt.host
+			if tt.header != "" {
+				r.Header.Set("X-Tenant", tt.header)
+			}
+			if tt.cookie != "" {
+				r.Header.Set("Cookie", "tenant="+tt.cookie)
+			}
+			got, err := m.FromRequest(r)
+			if !errors.Is(err, tt.err) || got.ID != tt.want {
+				t.Fatalf("got tenant %d, %v; want %d, %v", got.ID, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestTenantFiles(t *testing.T) {
+	db, m, dir := setup(t, true)
+	acme, err := m.Create(context.Background(), "acme", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "tenants", "acme.db")
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("tenant file: %v", err)
+	}
+	ctx := tenant.WithID(context.Background(), acme.ID)
+	if err := db.WithContext(ctx).Create(&model.User{Name: "Ada", Email: "ada@example.com"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// The row went to the tenant's file, not to the main database.
+	var inMain, inFile int64
+	if err := db.Raw("SELECT COUNT(*) FROM users").Scan(&inMain).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.WithContext(ctx).Raw("SELECT COUNT(*) FROM users").Scan(&inFile).Error; err != nil {
+		t.Fatal(err)
+	}
+	if inMain != 0 || inFile != 1 {
+		t.Fatalf("main database has %d users, tenant file %d; want 0 and 1", inMain, inFile)
+	}
+
+	// Tenants stay in the main database.
+	if _, err := m.Resolve(context.Background(), "acme"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Delete(context.Background(), acme.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("tenant file after delete: %v", err)
+	}
+	if err := m.Delete(context.Background(), m.Default().ID); !errors.Is(err, tenant.ErrDefault) {
+		t.Fatalf("deleting the default tenant: %v", err)
+	}
+}
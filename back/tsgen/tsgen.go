@@ -0,0 +1,452 @@
+// Package tsgen renders TypeScript declarations and a typed fetch client from
+// the Go types and routes the back end exposes.
+package tsgen
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wonderfulsuccess/go-web-app/back/openapi"
+)
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	fileType       = reflect.TypeOf(openapi.File{})
+)
+
+// Message describes one WebSocket message type and its payload.
+type Message struct {
+	Type        string
+	Description string
+	// Direction is "client-to-server", "server-to-client" or "both".
+	Direction string
+	Payload   interface{}
+}
+
+// Input is everything rendered into the generated module.
+type Input struct {
+	Routes     []openapi.Route
+	ErrorCodes []string
+	Messages   []Message
+	// Envelope is the Go type of the WebSocket envelope; its "payload" field
+	// is rendered as the generic parameter T.
+	Envelope interface{}
+	// Types are additional models or DTOs to declare even when no route
+	// references them.
+	Types []interface{}
+}
+
+// Generator accumulates TypeScript declarations for Go types.
+type Generator struct {
+	names map[reflect.Type]string
+	taken map[string]bool
+	order []string
+	decls map[string]string
+}
+
+// Generate renders the TypeScript module.
+func Generate(in Input) (string, error) {
+	g := &Generator{
+		names: make(map[reflect.Type]string),
+		taken: make(map[string]bool),
+		decls: make(map[string]string),
+	}
+
+	var b strings.Builder
+	b.WriteString("// Code generated by go run ./cmd/tsgen. DO NOT EDIT.\n\n")
+
+	// Error codes.
+	b.WriteString("export const ERROR_CODES = [\n")
+	for _, code := range in.ErrorCodes {
+		fmt.Fprintf(&b, "  %q,\n", code)
+	}
+	b.WriteString("] as const;\n\n")
+	b.WriteString("export type ErrorCode = (typeof ERROR_CODES)[number];\n\n")
+
+	// WebSocket messages.
+	if in.Envelope != nil {
+		envelope, err := g.envelope(reflect.TypeOf(in.Envelope))
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(envelope)
+	}
+	if len(in.Messages) > 0 {
+		b.WriteString("export interface WSMessagePayloads {\n")
+		for _, m := range in.Messages {
+			if m.Description != "" {
+				fmt.Fprintf(&b, "  /** %s */\n", m.Description)
+			}
+			fmt.Fprintf(&b, "  %q: %s;\n", m.Type, g.typeOf(reflect.TypeOf(m.Payload)))
+		}
+		b.WriteString("}\n\n")
+		b.WriteString("export type WSMessageType = keyof WSMessagePayloads;\n\n")
+		b.WriteString("export const WS_MESSAGE_DIRECTIONS = {\n")
+		for _, m := range in.Messages {
+			fmt.Fprintf(&b, "  %q: %q,\n", m.Type, m.Direction)
+		}
+		b.WriteString("} as const satisfies Record<WSMessageType, \"client-to-server\" | \"server-to-client\" | \"both\">;\n\n")
+		b.WriteString("/** Message types the server accepts from clients. */\n")
+		b.WriteString("export type ClientMessageType = {\n")
+		b.WriteString("  [K in WSMessageType]: (typeof WS_MESSAGE_DIRECTIONS)[K] extends \"server-to-client\" ? never : K;\n")
+		b.WriteString("}[WSMessageType];\n\n")
+		b.WriteString("/** Message types the server may push to clients. */\n")
+		b.WriteString("export type ServerMessageType = {\n")
+		b.WriteString("  [K in WSMessageType]: (typeof WS_MESSAGE_DIRECTIONS)[K] extends \"client-to-server\" ? never : K;\n")
+		b.WriteString("}[WSMessageType];\n\n")
+		b.WriteString("export type TypedWSMessage<K extends WSMessageType = WSMessageType> = {\n")
+		b.WriteString("  [P in K]: WSMessage<WSMessagePayloads[P]> & { type: P };\n")
+		b.WriteString("}[K];\n\n")
+	}
+
+	// Client functions; rendered before the declarations so every referenced
+	// type is registered.
+	client, err := g.client(in.Routes)
+	if err != nil {
+		return "", err
+	}
+	for _, v := range in.Types {
+		g.typeOf(reflect.TypeOf(v))
+	}
+
+	for _, name := range g.order {
+		b.WriteString(g.decls[name])
+	}
+	b.WriteString(clientRuntime)
+	b.WriteString(client)
+	return b.String(), nil
+}
+
+func (g *Generator) envelope(t reflect.Type) (string, error) {
+	if t.Kind() != reflect.Struct {
+		return "", fmt.Errorf("envelope %s must be a struct", t)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "export interface %s<T = unknown> {\n", t.Name())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, optional, ok := jsonName(f)
+		if !ok {
+			continue
+		}
+		tsType := g.typeOf(f.Type)
+		if name == "payload" {
+			tsType = "T"
+		}
+		fmt.Fprintf(&b, "  %s%s: %s;\n", name, optionalMark(optional), tsType)
+	}
+	b.WriteString("}\n\n")
+	g.names[t] = t.Name()
+	g.taken[t.Name()] = true
+	return b.String(), nil
+}
+
+// typeOf returns the TypeScript expression for t, registering named structs.
+func (g *Generator) typeOf(t reflect.Type) string {
+	if t == nil {
+		return "unknown"
+	}
+	switch t {
+	case timeType:
+		return "string"
+	case rawMessageType:
+		return "unknown"
+	case fileType:
+		return "Blob"
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.typeOf(t.Elem()) + " | null"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		if t.Name() == "ErrorCode" {
+			return "ErrorCode"
+		}
+		return "string"
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "string"
+		}
+		elem := g.typeOf(t.Elem())
+		if strings.Contains(elem, " ") {
+			elem = "(" + elem + ")"
+		}
+		return elem + "[]"
+	case reflect.Map:
+		return "Record<string, " + g.typeOf(t.Elem()) + ">"
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structBody(t, "")
+		}
+		return g.named(t)
+	case reflect.Interface:
+		return "unknown"
+	default:
+		return "unknown"
+	}
+}
+
+func (g *Generator) named(t reflect.Type) string {
+	if name, ok := g.names[t]; ok {
+		return name
+	}
+	name := t.Name()
+	if g.taken[name] {
+		pkg := t.PkgPath()
+		if idx := strings.LastIndex(pkg, "/"); idx >= 0 {
+			pkg = pkg[idx+1:]
+		}
+		name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+	}
+	g.names[t] = name
+	g.taken[name] = true
+	g.order = append(g.order, name)
+	g.decls[name] = "export interface " + name + " " + g.structBody(t, "") + "\n\n"
+	return name
+}
+
+func (g *Generator) structBody(t reflect.Type, indent string) string {
+	var b strings.Builder
+	b.WriteString("{\n")
+	g.writeFields(&b, t, indent+"  ")
+	b.WriteString(indent + "}")
+	return b.String()
+}
+
+func (g *Generator) writeFields(b *strings.Builder, t reflect.Type, indent string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Tag.Get("json") == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.writeFields(b, ft, indent)
+				continue
+			}
+		}
+		name, optional, ok := jsonName(f)
+		if !ok {
+			continue
+		}
+		if desc := f.Tag.Get("doc"); desc != "" {
+			fmt.Fprintf(b, "%s/** %s */\n", indent, desc)
+		}
+		fmt.Fprintf(b, "%s%s%s: %s;\n", indent, name, optionalMark(optional), g.typeOf(f.Type))
+	}
+}
+
+func jsonName(f reflect.StructField) (name string, optional bool, ok bool) {
+	if !f.IsExported() {
+		return "", false, false
+	}
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return "", false, false
+	}
+	name, opts, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = f.Name
+	}
+	return name, strings.Contains(opts, "omitempty"), true
+}
+
+func optionalMark(optional bool) string {
+	if optional {
+		return "?"
+	}
+	return ""
+}
+
+func (g *Generator) client(routes []openapi.Route) (string, error) {
+	var b strings.Builder
+	seen := make(map[string]bool)
+
+	sorted := append([]openapi.Route(nil), routes...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Path < sorted[j].Path })
+
+	for _, r := range sorted {
+		success, hasSuccess := successResponse(r.Responses)
+		if hasSuccess && success.Status == http.StatusSwitchingProtocols {
+			continue // WebSocket upgrades are handled by api/websocket.ts
+		}
+		if hasSuccess && success.ContentType == "text/event-stream" {
+			continue // so are event streams, its fallback transport
+		}
+
+		for _, resp := range r.Responses {
+			if resp.Body != nil {
+				g.typeOf(reflect.TypeOf(resp.Body))
+			}
+		}
+
+		id := r.OperationID(r.Path)
+		if seen[id] {
+			return "", fmt.Errorf("duplicate operation id %q", id)
+		}
+		seen[id] = true
+
+		tsPath, params := openapi.ConvertPath(r.Path)
+		var args []string
+		for _, p := range params {
+			argType := "string"
+			if p == "id" || strings.HasSuffix(p, "Id") {
+				argType = "number"
+			}
+			args = append(args, fmt.Sprintf("%s: %s", p, argType))
+		}
+		if r.Request != nil {
+			args = append(args, "body: "+g.typeOf(reflect.TypeOf(r.Request)))
+		}
+		var queryNames []string
+		for _, p := range r.Params {
+			if p.In == "query" {
+				queryNames = append(queryNames, p.Name)
+			}
+		}
+		if len(queryNames) > 0 {
+			fields := make([]string, len(queryNames))
+			for i, n := range queryNames {
+				fields[i] = fmt.Sprintf("%s?: string | number | boolean", n)
+			}
+			args = append(args, "query: { "+strings.Join(fields, "; ")+" } = {}")
+		}
+		args = append(args, "init: RequestInit = {}")
+
+		result, parse := "void", "none"
+		if hasSuccess && reflect.TypeOf(success.Body) == fileType {
+			result, parse = "Blob", "blob"
+		} else if hasSuccess && success.Body != nil {
+			result, parse = g.typeOf(reflect.TypeOf(success.Body)), "json"
+		} else if hasSuccess && success.Status != http.StatusNoContent {
+			result, parse = "string", "text"
+		}
+
+		url := "`" + strings.NewReplacer("{", "${encodeURIComponent(", "}", ")}").Replace(tsPath) + "`"
+		if !strings.Contains(tsPath, "{") {
+			url = fmt.Sprintf("%q", tsPath)
+		}
+
+		if r.Summary != "" {
+			fmt.Fprintf(&b, "/** %s */\n", r.Summary)
+		}
+		fmt.Fprintf(&b, "export function %s(%s): Promise<%s> {\n", id, strings.Join(args, ", "), result)
+		fmt.Fprintf(&b, "  return apiRequest<%s>(%q, %s, {\n", result, strings.ToUpper(r.Method), url)
+		if r.Request != nil {
+			b.WriteString("    body,\n")
+		}
+		if len(queryNames) > 0 {
+			b.WriteString("    query,\n")
+		}
+		fmt.Fprintf(&b, "    parse: %q,\n", parse)
+		b.WriteString("    init,\n")
+		b.WriteString("  });\n}\n\n")
+	}
+	return strings.TrimRight(b.String(), "\n") + "\n", nil
+}
+
+func successResponse(responses []openapi.Response) (openapi.Response, bool) {
+	for _, r := range responses {
+		if r.Status >= 100 && r.Status < 300 {
+			return r, true
+		}
+	}
+	return openapi.Response{}, false
+}
+
+const clientRuntime = `export class ApiError extends Error {
+  readonly status: number;
+  readonly code: ErrorCode | undefined;
+
+  constructor(status: number, message: string, code?: ErrorCode) {
+    super(message);
+    this.name = "ApiError";
+    this.status = status;
+    this.code = code;
+  }
+}
+
+type RequestOptions = {
+  body?: unknown;
+  query?: Record<string, string | number | boolean | undefined>;
+  parse: "json" | "text" | "blob" | "none";
+  init: RequestInit;
+};
+
+async function apiRequest<T>(method: string, path: string, options: RequestOptions): Promise<T> {
+  let url = path;
+  if (options.query) {
+    const search = new URLSearchParams();
+    for (const [key, value] of Object.entries(options.query)) {
+      if (value !== undefined) {
+        search.set(key, String(value));
+      }
+    }
+    const qs = search.toString();
+    if (qs) {
+      url += "?" + qs;
+    }
+  }
+
+  // Files and form data are sent as is; the browser sets their content type.
+  const raw = options.body instanceof Blob || options.body instanceof FormData;
+  const headers = new Headers(options.init.headers);
+  if (options.body !== undefined && !raw && !headers.has("Content-Type")) {
+    headers.set("Content-Type", "application/json");
+  }
+
+  let body: BodyInit | undefined;
+  if (raw) {
+    body = options.body as Blob | FormData;
+  } else if (options.body !== undefined) {
+    body = JSON.stringify(options.body);
+  }
+
+  const response = await fetch(url, {
+    ...options.init,
+    method,
+    headers,
+    body,
+  });
+
+  if (!response.ok) {
+    let message = ` + "`请求失败: ${response.status}`" + `;
+    let code: ErrorCode | undefined;
+    try {
+      const data = (await response.json()) as Partial<ErrorResponse>;
+      if (data.error) {
+        message = data.error;
+      }
+      code = data.code;
+    } catch {
+      // ignore non-JSON error bodies
+    }
+    throw new ApiError(response.status, message, code);
+  }
+
+  if (options.parse === "json") {
+    return (await response.json()) as T;
+  }
+  if (options.parse === "text") {
+    return (await response.text()) as T;
+  }
+  if (options.parse === "blob") {
+    return (await response.blob()) as T;
+  }
+  return undefined as T;
+}
+
+`
@@ -6,19 +6,31 @@ import (
 	"fmt"
 )
 
-// MapPrint 打印 map 类型的数据
+// MapPrint 打印 map 类型的数据，密码、密钥与连接串中的凭据会被遮盖
 func MapPrint(mapData interface{}) {
 	if mapData == nil {
 		fmt.Println("null")
 		return
 	}
+	raw, err := json.Marshal(mapData)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	// UseNumber keeps large integers such as durations exact.
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var decoded interface{}
+	if err := dec.Decode(&decoded); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
 	var buf bytes.Buffer
 	enc := json.NewEncoder(&buf)
 	enc.SetEscapeHTML(false)
 	enc.SetIndent("", "  ")
-	if err := enc.Encode(mapData); err != nil {
+	if err := enc.Encode(MaskSecrets(decoded)); err != nil {
 		fmt.Println("Error:", err)
-		fmt.Printf("%#v\n", mapData)
 		return
 	}
 	// json.Encoder adds a trailing newline; Print keeps it as-is
@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	// masked replaces secrets in printed output.
+	masked = "****"
+	// placeholder stands in for masked while a URL is re-encoded, which
+	// would escape the asterisks.
+	placeholder = "MASKEDSECRET"
+)
+
+var (
+	// user:password@tcp(host)/db, the MySQL driver's format. The password
+	// may itself contain "@", so match up to the one before the address.
+	mysqlCredentials = regexp.MustCompile(`^([^:@/]*):.*@([a-z0-9]*\(|/)`)
+	// password=secret or password='a secret', PostgreSQL's key=value format.
+	keywordPassword = regexp.MustCompile(`(?i)\b((?:ssl)?password\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+)
+
+// MaskDSN hides the password in a MySQL, PostgreSQL URL or PostgreSQL
+// key=value connection string so it can be logged.
+func MaskDSN(dsn string) string {
+	if strings.HasPrefix(dsn, "file:") {
+		return dsn
+	}
+	if strings.Contains(dsn, "://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return masked
+		}
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), placeholder)
+		}
+		query := u.Query()
+		for key := range query {
+			if isSecretKey(key) {
+				query.Set(key, placeholder)
+			}
+		}
+		u.RawQuery = query.Encode()
+		return strings.ReplaceAll(u.String(), placeholder, masked)
+	}
+	if mysqlCredentials.MatchString(dsn) {
+		return mysqlCredentials.ReplaceAllString(dsn, "${1}:"+masked+"@${2}")
+	}
+	return keywordPassword.ReplaceAllString(dsn, "${1}"+masked)
+}
+
+// MaskSecrets returns a copy of a decoded JSON value with the values of
+// password, secret and token keys replaced and connection strings under
+// keys ending in "dsn" or "dsns" passed through MaskDSN. Lists under such
+// keys are masked element by element.
+func MaskSecrets(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			out[key] = maskValue(key, item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = MaskSecrets(item)
+		}
+		return out
+	default:
+		return value
+	}
+}
+
+// maskValue masks item, the value of key or an element of its list.
+func maskValue(key string, item interface{}) interface{} {
+	switch v := item.(type) {
+	case string:
+		switch {
+		case v == "":
+			return v
+		case isDSNKey(key):
+			return MaskDSN(v)
+		case isSecretKey(key):
+			return masked
+		}
+		return v
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, elem := range v {
+			out[i] = maskValue(key, elem)
+		}
+		return out
+	default:
+		return MaskSecrets(item)
+	}
+}
+
+// isDSNKey reports whether a key names a connection string or a list of
+// them, such as DSN or ReplicaDSNs.
+func isDSNKey(key string) bool {
+	key = strings.ToLower(key)
+	return strings.HasSuffix(key, "dsn") || strings.HasSuffix(key, "dsns")
+}
+
+// isSecretKey reports whether a key names a secret. Keys naming a file,
+// such as PasswordFile, hold a path and are left alone.
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	if strings.HasSuffix(key, "file") {
+		return false
+	}
+	for _, word := range []string{"password", "passwd", "secret", "token"} {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+	return false
+}
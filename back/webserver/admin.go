@@ -0,0 +1,94 @@
+package webserver
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+	"github.com/wonderfulsuccess/go-web-app/back/controller"
+	"github.com/wonderfulsuccess/go-web-app/back/logger"
+	"github.com/wonderfulsuccess/go-web-app/back/openapi"
+)
+
+// requireAdmin guards the administration endpoints. With an admin token
+// configured, requests must carry it as "Authorization: Bearer <token>";
+// without one, only requests from the local machine are answered.
+func requireAdmin(cfg config.SecurityConfig) gin.HandlerFunc {
+	token := []byte(cfg.AdminToken)
+	return func(c *gin.Context) {
+		if len(token) == 0 {
+			if localRequest(c.Request) {
+				c.Next()
+				return
+			}
+			logger.Warningf("refused %s %s from %s: administration endpoints are local only", c.Request.Method, c.Request.URL.Path, c.Request.RemoteAddr)
+			c.AbortWithStatusJSON(http.StatusForbidden, controller.ErrorResponse{
+				Error: "administration endpoints only answer local requests; set ADMIN_TOKEN to allow remote access",
+				Code:  controller.ErrCodeForbidden,
+			})
+			return
+		}
+
+		given, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(strings.TrimSpace(given)), token) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="admin"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, controller.ErrorResponse{
+				Error: "admin token required",
+				Code:  controller.ErrCodeUnauthorized,
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// localRequest reports whether r comes from the local machine. The peer
+// address is used rather than gin's ClientIP, which trusts forwarding
+// headers; a request relayed by a proxy counts as local only when every
+// address it was forwarded for is a loopback address too.
+func localRequest(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil || !isLoopback(host) {
+		return false
+	}
+	for _, name := range []string{"X-Forwarded-For", "X-Real-Ip"} {
+		for _, value := range r.Header.Values(name) {
+			for _, addr := range strings.Split(value, ",") {
+				if !isLoopback(strings.TrimSpace(addr)) {
+					return false
+				}
+			}
+		}
+	}
+	return r.Header.Get("Forwarded") == ""
+}
+
+// mountAdmin is mount for the controllers of administration endpoints.
+func mountAdmin(api *gin.RouterGroup, docs *openapi.Document, path string, ctl documentedController, guard gin.HandlerFunc) {
+	group := api.Group(path, guard)
+	ctl.RegisterRoutes(group)
+	ops := ctl.Operations()
+	for i := range ops {
+		ops[i] = adminOperation(ops[i])
+	}
+	docs.Add(group.BasePath(), ops...)
+}
+
+// adminOperation documents the responses of requireAdmin on op.
+func adminOperation(op openapi.Operation) openapi.Operation {
+	op.Responses = append(op.Responses[:len(op.Responses):len(op.Responses)],
+		openapi.Response{Status: http.StatusUnauthorized, Description: "Admin token missing or wrong", Body: controller.ErrorResponse{}},
+		openapi.Response{Status: http.StatusForbidden, Description: "Remote request while no admin token is configured", Body: controller.ErrorResponse{}},
+	)
+	note := "Administration endpoint: requires the admin token as a bearer token, or a local request when none is configured."
+	if op.Description == "" {
+		op.Description = note
+	} else {
+		op.Description += " " + note
+	}
+	return op
+}
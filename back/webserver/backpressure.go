@@ -0,0 +1,181 @@
+package webserver
+
+import (
+	"fmt"
+	"sync"
+)
+
+// BackpressurePolicy decides what happens when a client's send queue is full.
+type BackpressurePolicy string
+
+const (
+	// DropOldest discards the oldest queued message to make room.
+	DropOldest BackpressurePolicy = "drop-oldest"
+	// DropNewest discards the message being enqueued.
+	DropNewest BackpressurePolicy = "drop-newest"
+	// CoalesceByType keeps only the latest queued message of each type and
+	// falls back to dropping the oldest message when the queue is still full.
+	CoalesceByType BackpressurePolicy = "coalesce"
+	// Disconnect closes the connection of a client that cannot keep up.
+	Disconnect BackpressurePolicy = "disconnect"
+)
+
+// ParseBackpressurePolicy validates a policy name, e.g. from a query string.
+func ParseBackpressurePolicy(s string) (BackpressurePolicy, error) {
+	switch p := BackpressurePolicy(s); p {
+	case DropOldest, DropNewest, CoalesceByType, Disconnect:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown backpressure policy %q", s)
+	}
+}
+
+// BackpressureConfig configures the send queue of a client.
+type BackpressureConfig struct {
+	Policy    BackpressurePolicy
+	QueueSize int
+}
+
+// DefaultBackpressure matches the historical behaviour: a 16-slot queue whose
+// owner is disconnected when it overflows.
+var DefaultBackpressure = BackpressureConfig{Policy: Disconnect, QueueSize: 16}
+
+// ClientStats reports the send queue metrics of one connection.
+type ClientStats struct {
+	ClientID  string             `json:"clientId"`
+	ConnID    string             `json:"connId"`
+	Policy    BackpressurePolicy `json:"policy"`
+	Depth     int                `json:"depth"`
+	Capacity  int                `json:"capacity"`
+	HighWater int                `json:"highWater"`
+	Enqueued  uint64             `json:"enqueued"`
+	Sent      uint64             `json:"sent"`
+	Dropped   uint64             `json:"dropped"`
+	Coalesced uint64             `json:"coalesced"`
+}
+
+// sendQueue is a bounded per-client outbox that applies a BackpressurePolicy
+// instead of blocking the hub.
+type sendQueue struct {
+	mu     sync.Mutex
+	items  []WSMessage
+	cfg    BackpressureConfig
+	closed bool
+	// notify has capacity one and is signalled whenever items are added.
+	notify chan struct{}
+
+	highWater int
+	enqueued  uint64
+	sent      uint64
+	dropped   uint64
+	coalesced uint64
+}
+
+func newSendQueue(cfg BackpressureConfig) *sendQueue {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = DefaultBackpressure.QueueSize
+	}
+	if cfg.Policy == "" {
+		cfg.Policy = DefaultBackpressure.Policy
+	}
+	return &sendQueue{
+		items:  make([]WSMessage, 0, cfg.QueueSize),
+		cfg:    cfg,
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// push enqueues msg. It returns false when the policy requires the client to
+// be disconnected.
+func (q *sendQueue) push(msg WSMessage) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return true
+	}
+
+	if q.cfg.Policy == CoalesceByType {
+		for i := range q.items {
+			if q.items[i].Type == msg.Type && q.items[i].Receiver == msg.Receiver {
+				q.items = append(q.items[:i], q.items[i+1:]...)
+				q.coalesced++
+				break
+			}
+		}
+	}
+
+	if len(q.items) >= q.cfg.QueueSize {
+		switch q.cfg.Policy {
+		case Disconnect:
+			q.dropped++
+			return false
+		case DropNewest:
+			q.dropped++
+			return true
+		default: // DropOldest, CoalesceByType
+			q.items = q.items[1:]
+			q.dropped++
+		}
+	}
+
+	q.items = append(q.items, msg)
+	q.enqueued++
+	if len(q.items) > q.highWater {
+		q.highWater = len(q.items)
+	}
+	q.signal()
+	return true
+}
+
+// pop removes up to max queued messages. done is true once the queue has been
+// closed and fully drained.
+func (q *sendQueue) pop(max int) (batch []WSMessage, done bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := len(q.items)
+	if n > max {
+		n = max
+	}
+	batch = make([]WSMessage, n)
+	copy(batch, q.items[:n])
+	q.items = append(q.items[:0], q.items[n:]...)
+	q.sent += uint64(n)
+	if len(q.items) > 0 {
+		q.signal()
+	}
+	return batch, q.closed && len(q.items) == 0
+}
+
+func (q *sendQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	q.signal()
+}
+
+func (q *sendQueue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (q *sendQueue) stats(clientID, connID string) ClientStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return ClientStats{
+		ClientID:  clientID,
+		ConnID:    connID,
+		Policy:    q.cfg.Policy,
+		Depth:     len(q.items),
+		Capacity:  q.cfg.QueueSize,
+		HighWater: q.highWater,
+		Enqueued:  q.enqueued,
+		Sent:      q.sent,
+		Dropped:   q.dropped,
+		Coalesced: q.coalesced,
+	}
+}
@@ -0,0 +1,62 @@
+package webserver
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+
+	"github.com/wonderfulsuccess/go-web-app/back/backup"
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+	"github.com/wonderfulsuccess/go-web-app/back/logger"
+	"github.com/wonderfulsuccess/go-web-app/back/model"
+	"github.com/wonderfulsuccess/go-web-app/back/scheduler"
+	"github.com/wonderfulsuccess/go-web-app/back/search"
+	"github.com/wonderfulsuccess/go-web-app/back/tasks"
+	"github.com/wonderfulsuccess/go-web-app/back/tenant"
+)
+
+// registerBackupJobs schedules the db.backup job. A schedule of "off"
+// registers it disabled so it can still be run by hand. With tenants in
+// files of their own, where backups are refused, it starts disabled too.
+func registerBackupJobs(jobs *scheduler.Scheduler, backups *backup.Manager, cfg config.BackupConfig, tenantCfg config.TenantConfig) error {
+	schedule, enabled := cfg.Schedule, true
+	if schedule == "off" {
+		schedule, enabled = "@daily", false
+	}
+	if tenantCfg.SQLiteFiles && enabled {
+		logger.Warningf("scheduled backups are disabled: %v", backup.ErrTenantFiles)
+		enabled = false
+	}
+	return jobs.Register(scheduler.Job{
+		Name:        "db.backup",
+		Description: "Back up the database and prune old backups",
+		Schedule:    schedule,
+		Enabled:     enabled,
+		Run: func(ctx context.Context) error {
+			_, err := backups.Backup(ctx, "")
+			return err
+		},
+	})
+}
+
+// migrateAfterRestore brings a restored database, which may come from an
+// older release, up to the current schema, tenants and search indexes.
+func migrateAfterRestore(backups *backup.Manager, db *gorm.DB, engine *search.Engine, queue *tasks.Queue, tenants *tenant.Manager) {
+	backups.OnRestore(func() {
+		if err := model.AutoMigrate(db); err != nil {
+			logger.Errorf("failed to migrate restored database: %v", err)
+			return
+		}
+		if err := tenants.Migrate(context.Background()); err != nil {
+			logger.Errorf("failed to migrate tenants of restored database: %v", err)
+			return
+		}
+		if err := engine.Migrate(); err != nil {
+			logger.Errorf("failed to initialise search on restored database: %v", err)
+			return
+		}
+		if err := queueStaleIndexes(engine, queue, tenants); err != nil {
+			logger.Errorf("failed to queue search rebuild: %v", err)
+		}
+	})
+}
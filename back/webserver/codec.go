@@ -0,0 +1,160 @@
+package webserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// WebSocket subprotocols understood by the server. Clients that negotiate one
+// of them accept batched frames: a single frame carrying an array of
+// messages when the server has a backlog. Clients that negotiate nothing get
+// one JSON object per text frame.
+const (
+	SubprotocolJSON    = "wsmsg.json"
+	SubprotocolMsgpack = "wsmsg.msgpack"
+)
+
+// maxBatchSize bounds how many queued messages are coalesced into one frame.
+const maxBatchSize = 64
+
+// codec encodes WSMessages for one connection.
+type codec interface {
+	frameType() int
+	batches() bool
+	// encode writes msgs as a single frame: an object for one message, an
+	// array when the codec batches.
+	encode(w io.Writer, msgs []WSMessage) error
+	decode(data []byte) (WSMessage, error)
+}
+
+func codecFor(subprotocol string) codec {
+	switch subprotocol {
+	case SubprotocolMsgpack:
+		return msgpackCodec{}
+	case SubprotocolJSON:
+		return jsonCodec{batch: true}
+	default:
+		return jsonCodec{}
+	}
+}
+
+type jsonCodec struct {
+	batch bool
+}
+
+func (jsonCodec) frameType() int  { return websocket.TextMessage }
+func (c jsonCodec) batches() bool { return c.batch }
+
+func (jsonCodec) encode(w io.Writer, msgs []WSMessage) error {
+	if len(msgs) == 1 {
+		return json.NewEncoder(w).Encode(msgs[0])
+	}
+	return json.NewEncoder(w).Encode(msgs)
+}
+
+func (jsonCodec) decode(data []byte) (WSMessage, error) {
+	var msg WSMessage
+	err := json.Unmarshal(data, &msg)
+	return msg, err
+}
+
+// msgpackMessage mirrors WSMessage with a decoded payload so MessagePack
+// clients receive native maps and arrays instead of embedded JSON.
+type msgpackMessage struct {
+	Sender    string      `msgpack:"sender"`
+	Receiver  string      `msgpack:"receiver"`
+	Timestamp time.Time   `msgpack:"timestamp"`
+	Type      string      `msgpack:"type"`
+	Payload   interface{} `msgpack:"payload"`
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) frameType() int { return websocket.BinaryMessage }
+func (msgpackCodec) batches() bool  { return true }
+
+func (msgpackCodec) encode(w io.Writer, msgs []WSMessage) error {
+	wire := make([]msgpackMessage, len(msgs))
+	for i, msg := range msgs {
+		payload, err := jsonToNative(msg.Payload)
+		if err != nil {
+			return err
+		}
+		wire[i] = msgpackMessage{
+			Sender:    msg.Sender,
+			Receiver:  msg.Receiver,
+			Timestamp: msg.Timestamp,
+			Type:      msg.Type,
+			Payload:   payload,
+		}
+	}
+
+	enc := msgpack.NewEncoder(w)
+	enc.UseCompactInts(true)
+	if len(wire) == 1 {
+		return enc.Encode(wire[0])
+	}
+	return enc.Encode(wire)
+}
+
+func (msgpackCodec) decode(data []byte) (WSMessage, error) {
+	var wire msgpackMessage
+	if err := msgpack.Unmarshal(data, &wire); err != nil {
+		return WSMessage{}, err
+	}
+	payload, err := json.Marshal(wire.Payload)
+	if err != nil {
+		return WSMessage{}, fmt.Errorf("payload is not JSON compatible: %w", err)
+	}
+	return WSMessage{
+		Sender:    wire.Sender,
+		Receiver:  wire.Receiver,
+		Timestamp: wire.Timestamp,
+		Type:      wire.Type,
+		Payload:   payload,
+	}, nil
+}
+
+// jsonToNative decodes a JSON payload into maps, slices and scalars, keeping
+// integers as int64 so they stay compact on the wire.
+func jsonToNative(raw json.RawMessage) (interface{}, error) {
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return nil, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return convertNumbers(v), nil
+}
+
+func convertNumbers(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+		f, _ := t.Float64()
+		return f
+	case map[string]interface{}:
+		for k, item := range t {
+			t[k] = convertNumbers(item)
+		}
+		return t
+	case []interface{}:
+		for i, item := range t {
+			t[i] = convertNumbers(item)
+		}
+		return t
+	default:
+		return v
+	}
+}
@@ -0,0 +1,96 @@
+package webserver
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+	"github.com/wonderfulsuccess/go-web-app/back/database"
+	"github.com/wonderfulsuccess/go-web-app/back/scheduler"
+)
+
+// registerDatabaseJobs schedules SQLite's periodic PRAGMA optimize. An
+// interval of 0 registers the job disabled so it can still be run by hand.
+func registerDatabaseJobs(jobs *scheduler.Scheduler, db *gorm.DB, cfg config.DatabaseConfig) error {
+	if cfg.Type != config.DBTypeSQLite {
+		return nil
+	}
+	interval, enabled := cfg.SQLite.OptimizeInterval, true
+	if interval <= 0 {
+		interval, enabled = 24*time.Hour, false
+	}
+	return jobs.Register(scheduler.Job{
+		Name:        "db.optimize",
+		Description: "Refresh SQLite query planner statistics with PRAGMA optimize",
+		Schedule:    "@every " + interval.String(),
+		Enabled:     enabled,
+		Run: func(ctx context.Context) error {
+			return database.Optimize(db.WithContext(ctx))
+		},
+	})
+}
+
+const (
+	// primaryHeader lets a client ask for a request's reads to come from
+	// the primary database, e.g. "X-DB-Primary: true".
+	primaryHeader = "X-DB-Primary"
+	// primaryCookie marks a client that wrote recently.
+	primaryCookie = "db_primary"
+)
+
+// readYourWrites sends a request's queries to the primary database when it
+// is a write, when the client asks for it with the X-DB-Primary header, or
+// when the client made a write request within the last window, which is
+// tracked with a short-lived cookie. A window of 0 sets no cookie.
+func readYourWrites(window time.Duration) gin.HandlerFunc {
+	maxAge := int(math.Ceil(window.Seconds()))
+	return func(c *gin.Context) {
+		primary, _ := strconv.ParseBool(c.GetHeader(primaryHeader))
+		if _, err := c.Cookie(primaryCookie); err == nil {
+			primary = true
+		}
+		if isWrite(c.Request.Method) {
+			primary = true
+			if maxAge > 0 {
+				c.SetSameSite(http.SameSiteLaxMode)
+				c.SetCookie(primaryCookie, "1", maxAge, "/", "", false, true)
+			}
+		}
+		if primary {
+			c.Request = c.Request.WithContext(database.WithPrimary(c.Request.Context()))
+		}
+		c.Next()
+	}
+}
+
+func isWrite(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+// HealthStatus is the body of GET /api/health.
+type HealthStatus struct {
+	// Status is "ok", or "unavailable" when the primary database does not
+	// answer.
+	Status   string                `json:"status"`
+	Database []database.NodeStatus `json:"database"`
+}
+
+func healthHandler(cluster *database.Cluster) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !cluster.Healthy() {
+			c.JSON(http.StatusServiceUnavailable, HealthStatus{Status: "unavailable", Database: cluster.Status()})
+			return
+		}
+		c.JSON(http.StatusOK, HealthStatus{Status: "ok", Database: cluster.Status()})
+	}
+}
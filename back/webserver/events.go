@@ -0,0 +1,244 @@
+package webserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wonderfulsuccess/go-web-app/back/logger"
+	"github.com/wonderfulsuccess/go-web-app/back/session"
+	"github.com/wonderfulsuccess/go-web-app/back/tenant"
+)
+
+// eventHistorySize is the number of recent messages kept for SSE resume.
+const eventHistorySize = 1024
+
+// eventHistory is a ring of recently dispatched messages. Event ids combine
+// a per-process epoch with a sequence number, so ids issued before a restart
+// are recognised as stale instead of skipping new messages.
+type eventHistory struct {
+	mu    sync.RWMutex
+	epoch string
+	next  uint64
+	ring  []WSMessage
+}
+
+func newEventHistory(size int) *eventHistory {
+	return &eventHistory{epoch: newConnID()[:8], next: 1, ring: make([]WSMessage, size)}
+}
+
+// append stores msg and returns its sequence number.
+func (e *eventHistory) append(msg WSMessage) uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	msg.seq = e.next
+	e.ring[msg.seq%uint64(len(e.ring))] = msg
+	e.next++
+	return msg.seq
+}
+
+// since returns the messages after seq accepted by match. complete is false
+// when some of them already fell out of the ring.
+func (e *eventHistory) since(seq uint64, match func(WSMessage) bool) (msgs []WSMessage, complete bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	oldest := uint64(1)
+	if size := uint64(len(e.ring)); e.next > size {
+		oldest = e.next - size
+	}
+	complete = seq+1 >= oldest
+	for s := max(seq+1, oldest); s < e.next; s++ {
+		if msg := e.ring[s%uint64(len(e.ring))]; match(msg) {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, complete
+}
+
+func (e *eventHistory) id(seq uint64) string {
+	return fmt.Sprintf("%s-%d", e.epoch, seq)
+}
+
+// parse returns the sequence of an id issued by this process.
+func (e *eventHistory) parse(id string) (uint64, bool) {
+	epoch, raw, ok := strings.Cut(id, "-")
+	if !ok || epoch != e.epoch {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(raw, 10, 64)
+	return seq, err == nil
+}
+
+// EventsReady is the first event of an SSE stream. The connection id must be
+// passed to POST /api/events to send messages as this connection.
+type EventsReady struct {
+	UserID string `json:"userId"`
+	ConnID string `json:"connId"`
+}
+
+// HandleEvents streams hub messages as Server-Sent Events for clients that
+// cannot use WebSockets. It honours the same origin rules, connection caps
+// and receiver/topic filtering, and replays missed messages when the client
+// resumes with Last-Event-ID. Like HandleWebSocket it takes the user
+// identity from the session.
+func (h *Hub) HandleEvents(c *gin.Context) {
+	t := h.transport
+	ip := c.ClientIP()
+	userID, ok := connectionUser(c)
+	if !ok {
+		return
+	}
+
+	if !t.origins.check(c.Request) {
+		logger.Warningf("rejected event stream from %s: origin %q not allowed", ip, c.GetHeader("Origin"))
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "origin not allowed"})
+		return
+	}
+	if err := t.guard.acquire(ip, userID); err != nil {
+		var admission *admissionError
+		errors.As(err, &admission)
+		logger.Warningf("rejected event stream from %s user=%s: %v", ip, userID, err)
+		c.AbortWithStatusJSON(admission.Status, gin.H{"error": admission.Reason})
+		return
+	}
+	defer t.guard.release(ip, userID)
+
+	backpressure := h.backpressure
+	if name := c.Query("backpressure"); name != "" {
+		if policy, err := ParseBackpressurePolicy(name); err == nil {
+			backpressure.Policy = policy
+		}
+	}
+
+	tenantID, _ := tenant.FromContext(c.Request.Context())
+	sub := h.subscribe(tenantID, userID, ip, verifiedUser(c.Request), splitTopics(c.Query("topics")), backpressure)
+	defer sub.Close()
+	client := sub.client
+	h.presence.connected(client)
+	defer h.presence.disconnected(client)
+	logger.Infof("event stream connected user=%s conn=%s", client.id, client.connID)
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	// Stop nginx and similar proxies from buffering the stream.
+	header.Set("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	w := c.Writer
+	ready, _ := json.Marshal(EventsReady{UserID: client.id, ConnID: client.connID})
+	fmt.Fprintf(w, "retry: 3000\nevent: ready\ndata: %s\n\n", ready)
+
+	// Registering before reading the history means nothing is lost between
+	// the two; duplicates are skipped by sequence number.
+	var lastSent uint64
+	lastID := firstNonEmpty(c.GetHeader("Last-Event-ID"), c.Query("lastEventId"))
+	if seq, ok := h.history.parse(lastID); ok {
+		missed, complete := h.history.since(seq, client.receives)
+		if !complete {
+			logger.Warningf("event stream for %s resumed after %s; older messages were lost", client.id, lastID)
+		}
+		lastSent = seq
+		for _, msg := range missed {
+			if err := h.writeEvent(w, msg); err != nil {
+				return
+			}
+			lastSent = msg.seq
+		}
+	}
+	w.Flush()
+
+	ticker := time.NewTicker(t.pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-ticker.C:
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+			w.Flush()
+		case <-sub.Ready():
+			batch, done := sub.Next(maxBatchSize)
+			for _, msg := range batch {
+				if msg.seq <= lastSent {
+					continue
+				}
+				if err := h.writeEvent(w, msg); err != nil {
+					return
+				}
+				lastSent = msg.seq
+			}
+			w.Flush()
+			if done {
+				return
+			}
+		}
+	}
+}
+
+func (h *Hub) writeEvent(w io.Writer, msg WSMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		logger.Errorf("failed to encode event: %v", err)
+		return nil
+	}
+	_, err = fmt.Fprintf(w, "id: %s\ndata: %s\n\n", h.history.id(msg.seq), data)
+	return err
+}
+
+// HandleEventPost accepts a client message for the event stream identified
+// by the connId query parameter, which must belong to the user of the
+// request's session. It goes through the same validation, rate limits and
+// routing as a WebSocket frame; failures are answered with an ErrorPayload
+// instead of an error message on the stream.
+func (h *Hub) HandleEventPost(c *gin.Context) {
+	if !h.transport.origins.check(c.Request) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "origin not allowed"})
+		return
+	}
+	user, _ := session.UserFromContext(c.Request.Context())
+	client, ok := h.clientByConn(c.Query("connId"))
+	if !ok || client.id != user {
+		c.JSON(http.StatusNotFound, ErrorPayload{Code: WSErrInvalidFrame, Message: "unknown event stream connection"})
+		return
+	}
+
+	if err := client.admit(); err != nil {
+		logger.Warningf("rejected event post from %s: %v", client.id, err)
+		c.JSON(http.StatusTooManyRequests, errorPayload(err))
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, h.transport.readLimit+1))
+	if err != nil || int64(len(body)) > h.transport.readLimit {
+		c.JSON(http.StatusRequestEntityTooLarge, ErrorPayload{Code: WSErrInvalidFrame, Message: "message too large"})
+		return
+	}
+	msg, err := jsonCodec{}.decode(body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorPayload{Code: WSErrInvalidFrame, Message: err.Error()})
+		return
+	}
+
+	if err := client.handleInbound(msg); err != nil {
+		payload := errorPayload(err)
+		status := http.StatusBadRequest
+		if payload.Code == WSErrRateLimited {
+			status = http.StatusTooManyRequests
+		}
+		logger.Warningf("rejected event post from %s: %s", client.id, payload.Message)
+		c.JSON(status, payload)
+		return
+	}
+	c.Status(http.StatusAccepted)
+}
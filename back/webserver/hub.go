@@ -0,0 +1,544 @@
+package webserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"hash/fnv"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+	"github.com/wonderfulsuccess/go-web-app/back/logger"
+	"github.com/wonderfulsuccess/go-web-app/back/ratelimit"
+)
+
+// Receiver prefixes. A plain Receiver is a user identity and reaches every
+// connection of that user.
+const (
+	// TopicPrefix addresses every client subscribed to a topic, e.g.
+	// "topic:dashboard".
+	TopicPrefix = "topic:"
+	// ConnPrefix addresses a single connection, e.g. "conn:3f2a…".
+	ConnPrefix = "conn:"
+)
+
+// Hub orchestrates WebSocket clients and message routing.
+//
+// Clients are partitioned into shards by id, and within a shard into
+// buckets. Each bucket publishes an immutable index that dispatch reads
+// without locking; registration copies the index of a single bucket, so its
+// cost does not grow with the number of connected clients. Targeted
+// messages go straight to the shard owning the receiver, broadcasts and
+// topic messages fan out to every shard worker in parallel.
+type Hub struct {
+	registry  *MessageRegistry
+	routing   *RoutingPolicy
+	shards    []*hubShard
+	broadcast chan WSMessage
+	incoming  chan WSMessage
+	runOnce   sync.Once
+	presence  *Presence
+	// conns maps connection ids to user ids so a connection's shard can be
+	// found without scanning.
+	conns sync.Map
+
+	backpressure BackpressureConfig
+	transport    *transport
+	// history keeps recent messages so SSE clients can resume.
+	history *eventHistory
+
+	// limiter, when set, limits the messages of each address and user
+	// across their connections.
+	limiter *ratelimit.Limiter
+	perIP   config.Rate
+	perUser config.Rate
+}
+
+func NewHub() *Hub {
+	return NewShardedHub(0)
+}
+
+// NewShardedHub creates a hub with the given number of dispatch shards; zero
+// picks a default based on GOMAXPROCS.
+func NewShardedHub(shards int) *Hub {
+	if shards <= 0 {
+		shards = 4 * runtime.GOMAXPROCS(0)
+	}
+	h := &Hub{
+		registry:  NewMessageRegistry(),
+		routing:   NewRoutingPolicy(),
+		shards:    make([]*hubShard, shards),
+		broadcast: make(chan WSMessage, 256),
+		incoming:  make(chan WSMessage, 32),
+
+		backpressure: DefaultBackpressure,
+		transport:    newTransport(config.WebSocketConfig{}),
+		history:      newEventHistory(eventHistorySize),
+	}
+	for i := range h.shards {
+		h.shards[i] = newHubShard(h)
+	}
+	h.presence = newPresence(h)
+	return h
+}
+
+// SetBackpressure changes the queue configuration used for connections that
+// do not request a policy themselves. Call it before Run.
+func (h *Hub) SetBackpressure(cfg BackpressureConfig) {
+	h.backpressure = cfg
+}
+
+// Configure applies origin rules, connection caps, timings and the default
+// backpressure policy from cfg. Call it before Run.
+func (h *Hub) Configure(cfg config.WebSocketConfig) error {
+	h.transport = newTransport(cfg)
+	backpressure := DefaultBackpressure
+	if cfg.Backpressure != "" {
+		policy, err := ParseBackpressurePolicy(cfg.Backpressure)
+		if err != nil {
+			return err
+		}
+		backpressure.Policy = policy
+	}
+	if cfg.SendQueueSize > 0 {
+		backpressure.QueueSize = cfg.SendQueueSize
+	}
+	h.backpressure = backpressure
+	return nil
+}
+
+// SetRateLimiter limits the messages received from each client address and
+// user across all their connections, on top of the per-connection limits of
+// the routing policy. Call it before Run.
+func (h *Hub) SetRateLimiter(limiter *ratelimit.Limiter, perIP, perUser config.Rate) {
+	h.limiter, h.perIP, h.perUser = limiter, perIP, perUser
+}
+
+// Run starts the shard workers and dispatches queued messages. It blocks
+// forever, so callers start it in its own goroutine.
+func (h *Hub) Run() {
+	h.runOnce.Do(func() {
+		for _, shard := range h.shards {
+			go shard.run()
+		}
+	})
+	for msg := range h.broadcast {
+		h.dispatch(msg)
+	}
+}
+
+func (h *Hub) dispatch(msg WSMessage) {
+	msg.seq = h.history.append(msg)
+	if msg.Receiver == "" || msg.Receiver == "*" || strings.HasPrefix(msg.Receiver, TopicPrefix) {
+		for _, shard := range h.shards {
+			shard.in <- msg
+		}
+		return
+	}
+	if connID, ok := strings.CutPrefix(msg.Receiver, ConnPrefix); ok {
+		userID, ok := h.conns.Load(connID)
+		if !ok {
+			return
+		}
+		h.shardFor(userID.(string)).in <- msg
+		return
+	}
+	h.shardFor(msg.Receiver).in <- msg
+}
+
+func (h *Hub) shardFor(id string) *hubShard {
+	shard, _ := h.locate(id)
+	return shard
+}
+
+// locate returns the shard and the bucket within it that own the clients
+// of id.
+func (h *Hub) locate(id string) (*hubShard, *atomic.Pointer[shardIndex]) {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(id))
+	sum, n := hash.Sum32(), uint32(len(h.shards))
+	shard := h.shards[sum%n]
+	return shard, &shard.buckets[sum/n%shardBuckets]
+}
+
+func (h *Hub) register(c *Client) {
+	h.conns.Store(c.connID, c.id)
+	shard, bucket := h.locate(c.id)
+	shard.add(bucket, c)
+}
+
+// unregister detaches c. The backpressure policy may have removed it from
+// its shard already, but its connection id is still known.
+func (h *Hub) unregister(c *Client) {
+	h.conns.Delete(c.connID)
+	shard, bucket := h.locate(c.id)
+	if shard.remove(bucket, c) {
+		c.queue.close()
+	}
+}
+
+// SendMessage allows other packages to emit WebSocket messages. It blocks
+// while the hub's dispatch queue is full; see TrySend and SendContext.
+func (h *Hub) SendMessage(msg WSMessage) {
+	h.broadcast <- stamp(msg)
+}
+
+// TrySend queues msg without blocking and reports whether it was accepted.
+func (h *Hub) TrySend(msg WSMessage) bool {
+	select {
+	case h.broadcast <- stamp(msg):
+		return true
+	default:
+		return false
+	}
+}
+
+// SendContext queues msg, giving up when ctx is done.
+func (h *Hub) SendContext(ctx context.Context, msg WSMessage) error {
+	select {
+	case h.broadcast <- stamp(msg):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats returns the send queue metrics of every connected client.
+func (h *Hub) Stats() []ClientStats {
+	var stats []ClientStats
+	for _, shard := range h.shards {
+		for i := range shard.buckets {
+			for client := range shard.buckets[i].Load().clients {
+				stats = append(stats, client.queue.stats(client.id, client.connID))
+			}
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ClientID < stats[j].ClientID })
+	return stats
+}
+
+// ClientCount returns the number of registered clients.
+func (h *Hub) ClientCount() int {
+	n := 0
+	for _, shard := range h.shards {
+		for i := range shard.buckets {
+			n += len(shard.buckets[i].Load().clients)
+		}
+	}
+	return n
+}
+
+func stamp(msg WSMessage) WSMessage {
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now().UTC()
+	}
+	return msg
+}
+
+// Registry exposes the message types the hub accepts and emits so other
+// packages can register their own.
+func (h *Hub) Registry() *MessageRegistry {
+	return h.registry
+}
+
+// Routing exposes the policy deciding how client frames are relayed.
+func (h *Hub) Routing() *RoutingPolicy {
+	return h.routing
+}
+
+// Presence exposes the online-user tracker.
+func (h *Hub) Presence() *Presence {
+	return h.presence
+}
+
+// Incoming exposes server-side visibility into messages pushed by clients.
+func (h *Hub) Incoming() <-chan WSMessage {
+	return h.incoming
+}
+
+// Subscribe attaches an in-process subscriber that receives the same
+// messages as a WebSocket client with the given id and topics.
+func (h *Hub) Subscribe(id string, topics []string, cfg BackpressureConfig) *Subscription {
+	return h.SubscribeTenant(0, id, topics, cfg)
+}
+
+// SubscribeTenant is Subscribe for a client of the given tenant, which
+// receives messages sent to its tenant and to all tenants.
+func (h *Hub) SubscribeTenant(tenant uint, id string, topics []string, cfg BackpressureConfig) *Subscription {
+	return h.subscribe(tenant, id, "", "", topics, cfg)
+}
+
+// subscribe attaches a subscriber whose inbound messages, if any, come from
+// the client address ip and the verified user, see verifiedUser.
+func (h *Hub) subscribe(tenant uint, id, ip, verified string, topics []string, cfg BackpressureConfig) *Subscription {
+	c := &Client{
+		id:       id,
+		tenant:   tenant,
+		ip:       ip,
+		verified: verified,
+		connID:   newConnID(),
+		topics:   topics,
+		hub:      h,
+		queue:    newSendQueue(cfg),
+		limiters: make(map[string]*tokenBucket),
+	}
+	h.register(c)
+	return &Subscription{client: c}
+}
+
+// Subscription receives hub messages without a network connection.
+type Subscription struct {
+	client *Client
+}
+
+// Ready is signalled whenever messages are waiting or the subscription was
+// closed by the hub.
+func (s *Subscription) Ready() <-chan struct{} {
+	return s.client.queue.notify
+}
+
+// Next removes up to max queued messages. done reports that the hub closed
+// the subscription, e.g. because the disconnect backpressure policy fired.
+func (s *Subscription) Next(max int) (msgs []WSMessage, done bool) {
+	return s.client.queue.pop(max)
+}
+
+// Stats returns the queue metrics of the subscription.
+func (s *Subscription) Stats() ClientStats {
+	return s.client.queue.stats(s.client.id, s.client.connID)
+}
+
+// Close detaches the subscription from the hub.
+func (s *Subscription) Close() {
+	s.client.hub.unregister(s.client)
+	s.client.queue.close()
+}
+
+// clientByConn finds a registered client by connection id.
+func (h *Hub) clientByConn(connID string) (*Client, bool) {
+	userID, ok := h.conns.Load(connID)
+	if !ok {
+		return nil, false
+	}
+	_, bucket := h.locate(userID.(string))
+	client, ok := bucket.Load().byConn[connID]
+	return client, ok
+}
+
+// shardBuckets is the number of buckets per shard. All connections of a
+// user share a bucket.
+const shardBuckets = 64
+
+// shardIndex is an immutable view of the clients in a bucket.
+type shardIndex struct {
+	clients map[*Client]struct{}
+	byID    map[string][]*Client
+	byConn  map[string]*Client
+	byTopic map[string][]*Client
+}
+
+type hubShard struct {
+	hub *Hub
+	// mu serialises writers; readers only load buckets.
+	mu      sync.Mutex
+	buckets [shardBuckets]atomic.Pointer[shardIndex]
+	in      chan WSMessage
+}
+
+func newHubShard(h *Hub) *hubShard {
+	s := &hubShard{hub: h, in: make(chan WSMessage, 256)}
+	empty := &shardIndex{
+		clients: map[*Client]struct{}{},
+		byID:    map[string][]*Client{},
+		byConn:  map[string]*Client{},
+		byTopic: map[string][]*Client{},
+	}
+	for i := range s.buckets {
+		s.buckets[i].Store(empty)
+	}
+	return s
+}
+
+func (s *hubShard) run() {
+	for msg := range s.in {
+		s.deliver(msg)
+	}
+}
+
+// receives reports whether msg is addressed to c.
+func (c *Client) receives(msg WSMessage) bool {
+	if !c.inTenant(msg) {
+		return false
+	}
+	switch {
+	case msg.Receiver == "" || msg.Receiver == "*":
+		return true
+	case strings.HasPrefix(msg.Receiver, ConnPrefix):
+		return strings.TrimPrefix(msg.Receiver, ConnPrefix) == c.connID
+	case strings.HasPrefix(msg.Receiver, TopicPrefix):
+		topic := strings.TrimPrefix(msg.Receiver, TopicPrefix)
+		for _, t := range c.topics {
+			if t == topic {
+				return true
+			}
+		}
+		return false
+	default:
+		return msg.Receiver == c.id
+	}
+}
+
+func (s *hubShard) deliver(msg WSMessage) {
+	switch {
+	case msg.Receiver == "" || msg.Receiver == "*":
+		for i := range s.buckets {
+			for client := range s.buckets[i].Load().clients {
+				s.push(client, msg)
+			}
+		}
+	case strings.HasPrefix(msg.Receiver, ConnPrefix):
+		if client, ok := s.hub.clientByConn(strings.TrimPrefix(msg.Receiver, ConnPrefix)); ok {
+			s.push(client, msg)
+		}
+	case strings.HasPrefix(msg.Receiver, TopicPrefix):
+		topic := strings.TrimPrefix(msg.Receiver, TopicPrefix)
+		for i := range s.buckets {
+			for _, client := range s.buckets[i].Load().byTopic[topic] {
+				s.push(client, msg)
+			}
+		}
+	default:
+		_, bucket := s.hub.locate(msg.Receiver)
+		for _, client := range bucket.Load().byID[msg.Receiver] {
+			s.push(client, msg)
+		}
+	}
+}
+
+// inTenant reports whether msg may reach c: receivers, user ids and
+// topics are only meaningful inside a tenant.
+func (c *Client) inTenant(msg WSMessage) bool {
+	return msg.tenant == 0 || msg.tenant == c.tenant
+}
+
+func (s *hubShard) push(client *Client, msg WSMessage) {
+	if !client.inTenant(msg) {
+		return
+	}
+	if client.queue.push(msg) {
+		return
+	}
+	logger.Warningf("disconnecting slow websocket client %s", client.id)
+	_, bucket := s.hub.locate(client.id)
+	if s.remove(bucket, client) {
+		client.queue.close()
+	}
+}
+
+// add publishes a copy of bucket that includes c.
+func (s *hubShard) add(bucket *atomic.Pointer[shardIndex], c *Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := bucket.Load()
+	next := &shardIndex{
+		clients: make(map[*Client]struct{}, len(old.clients)+1),
+		byID:    copyIndex(old.byID, ""),
+		byConn:  make(map[string]*Client, len(old.byConn)+1),
+		byTopic: copyIndex(old.byTopic, ""),
+	}
+	for client := range old.clients {
+		next.clients[client] = struct{}{}
+		next.byConn[client.connID] = client
+	}
+	next.clients[c] = struct{}{}
+	next.byConn[c.connID] = c
+	next.byID[c.id] = appendCopy(next.byID[c.id], c)
+	for _, topic := range c.topics {
+		next.byTopic[topic] = appendCopy(next.byTopic[topic], c)
+	}
+	bucket.Store(next)
+}
+
+// remove publishes a copy of bucket without c and reports whether c was
+// registered.
+func (s *hubShard) remove(bucket *atomic.Pointer[shardIndex], c *Client) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := bucket.Load()
+	if _, ok := old.clients[c]; !ok {
+		return false
+	}
+	next := &shardIndex{
+		clients: make(map[*Client]struct{}, len(old.clients)),
+		byID:    copyIndex(old.byID, c.id),
+		byConn:  make(map[string]*Client, len(old.byConn)),
+		byTopic: copyIndex(old.byTopic, ""),
+	}
+	for client := range old.clients {
+		if client != c {
+			next.clients[client] = struct{}{}
+			next.byConn[client.connID] = client
+		}
+	}
+	next.byID[c.id] = without(old.byID[c.id], c)
+	if len(next.byID[c.id]) == 0 {
+		delete(next.byID, c.id)
+	}
+	for _, topic := range c.topics {
+		next.byTopic[topic] = without(old.byTopic[topic], c)
+		if len(next.byTopic[topic]) == 0 {
+			delete(next.byTopic, topic)
+		}
+	}
+	bucket.Store(next)
+	return true
+}
+
+// copyIndex copies the outer map; slices are shared because they are never
+// modified in place, except for skip whose slice the caller replaces.
+func copyIndex(src map[string][]*Client, skip string) map[string][]*Client {
+	dst := make(map[string][]*Client, len(src)+1)
+	for k, v := range src {
+		if k != skip {
+			dst[k] = v
+		}
+	}
+	return dst
+}
+
+// appendCopy appends without writing into a backing array that an older
+// index may still be reading.
+func appendCopy(list []*Client, c *Client) []*Client {
+	return append(list[:len(list):len(list)], c)
+}
+
+func without(list []*Client, c *Client) []*Client {
+	out := make([]*Client, 0, len(list))
+	for _, item := range list {
+		if item != c {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func newConnID() string {
+	var b [12]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func splitTopics(raw string) []string {
+	var topics []string
+	for _, topic := range strings.Split(raw, ",") {
+		if topic = strings.TrimSpace(topic); topic != "" {
+			topics = append(topics, topic)
+		}
+	}
+	return topics
+}
@@ -0,0 +1,141 @@
+package webserver_test
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/wonderfulsuccess/go-web-app/back/webserver"
+)
+
+const (
+	// benchClients is the number of simulated clients.
+	benchClients = 10000
+	// fanoutBudget is the p99 time until every receiver has a message.
+	fanoutBudget = 50 * time.Millisecond
+)
+
+// benchHub is a hub with benchClients subscribers, one in ten of them also
+// subscribed to the topic "tenth", each drained by its own goroutine like
+// the write pump of a connection.
+type benchHub struct {
+	hub      *webserver.Hub
+	subs     []*webserver.Subscription
+	received atomic.Int64
+	want     atomic.Int64
+	arrived  chan struct{}
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func newBenchHub(b *testing.B) *benchHub {
+	b.Helper()
+	bh := &benchHub{
+		hub:     webserver.NewHub(),
+		arrived: make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+	go bh.hub.Run()
+	for i := 0; i < benchClients; i++ {
+		topics := []string{"all"}
+		if i%10 == 0 {
+			topics = append(topics, "tenth")
+		}
+		sub := bh.hub.Subscribe("client-"+strconv.Itoa(i), topics, webserver.BackpressureConfig{
+			Policy:    webserver.DropOldest,
+			QueueSize: 16,
+		})
+		bh.subs = append(bh.subs, sub)
+		bh.wg.Add(1)
+		go bh.drain(sub)
+	}
+	b.Cleanup(func() {
+		close(bh.stop)
+		for _, sub := range bh.subs {
+			sub.Close()
+		}
+		bh.wg.Wait()
+	})
+	return bh
+}
+
+func (bh *benchHub) drain(sub *webserver.Subscription) {
+	defer bh.wg.Done()
+	for {
+		select {
+		case <-bh.stop:
+			return
+		case <-sub.Ready():
+			batch, done := sub.Next(16)
+			if n := bh.received.Add(int64(len(batch))); len(batch) > 0 && n == bh.want.Load() {
+				bh.arrived <- struct{}{}
+			}
+			if done {
+				return
+			}
+		}
+	}
+}
+
+// send dispatches a message to receiver and waits until want subscribers
+// have it.
+func (bh *benchHub) send(b *testing.B, receiver string, want int) time.Duration {
+	bh.received.Store(0)
+	bh.want.Store(int64(want))
+	start := time.Now()
+	bh.hub.SendMessage(webserver.WSMessage{Sender: "bench", Receiver: receiver, Type: "bench"})
+	select {
+	case <-bh.arrived:
+		return time.Since(start)
+	case <-time.After(10 * time.Second):
+		b.Fatalf("%s: %d of %d receivers got the message", receiver, bh.received.Load(), want)
+		return 0
+	}
+}
+
+// BenchmarkHubFanout measures the time from sending a message until every
+// receiver has it, with benchClients clients connected, and fails when
+// the p99 exceeds fanoutBudget.
+func BenchmarkHubFanout(b *testing.B) {
+	bh := newBenchHub(b)
+	for _, sc := range []struct {
+		name     string
+		receiver func(i int) string
+		want     int
+	}{
+		{"broadcast", func(int) string { return "*" }, benchClients},
+		{"topic", func(int) string { return webserver.TopicPrefix + "tenth" }, benchClients / 10},
+		{"targeted", func(i int) string { return "client-" + strconv.Itoa(i%benchClients) }, 1},
+	} {
+		b.Run(sc.name, func(b *testing.B) {
+			latencies := make([]time.Duration, 0, b.N)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				latencies = append(latencies, bh.send(b, sc.receiver(i), sc.want))
+			}
+			b.StopTimer()
+
+			sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+			p99 := latencies[(len(latencies)-1)*99/100]
+			b.ReportMetric(float64(p99.Microseconds()), "p99-µs")
+			if p99 > fanoutBudget {
+				b.Errorf("p99 fan-out latency %v exceeds the budget of %v", p99, fanoutBudget)
+			}
+		})
+	}
+}
+
+// BenchmarkHubRegister measures connecting and disconnecting a client
+// while benchClients clients are connected.
+func BenchmarkHubRegister(b *testing.B) {
+	bh := newBenchHub(b)
+	cfg := webserver.BackpressureConfig{Policy: webserver.DropOldest, QueueSize: 16}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sub := bh.hub.Subscribe("extra-"+strconv.Itoa(i), []string{"all"}, cfg)
+		sub.Close()
+	}
+}
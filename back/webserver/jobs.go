@@ -0,0 +1,52 @@
+package webserver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/wonderfulsuccess/go-web-app/back/logger"
+	"github.com/wonderfulsuccess/go-web-app/back/scheduler"
+)
+
+// MessageTypeJobStatus carries a scheduler.JobStatus whenever a job changes.
+// It is sent to JobsTopic; subscribe with ?topics=jobs.
+const MessageTypeJobStatus = "job.status"
+
+// JobsTopic is the topic job status updates are published on.
+const JobsTopic = "jobs"
+
+// demoTickJob replaces the old hand-rolled broadcast loop. It starts
+// disabled on every start of the server and is enabled by the demo-start
+// message.
+const demoTickJob = "demo.tick"
+
+// publishJobStatus streams job status changes over the hub.
+func publishJobStatus(hub *Hub, jobs *scheduler.Scheduler) {
+	jobs.OnChange(func(status scheduler.JobStatus) {
+		raw, err := hub.registry.encodeOutbound(MessageTypeJobStatus, status)
+		if err != nil {
+			logger.Errorf("failed to encode job status: %v", err)
+			return
+		}
+		// Status updates are advisory; never block a job on a busy hub.
+		hub.TrySend(WSMessage{Sender: "server", Receiver: TopicPrefix + JobsTopic, Type: MessageTypeJobStatus, Payload: raw})
+	})
+}
+
+func registerDemoJobs(hub *Hub, jobs *scheduler.Scheduler) error {
+	counter := 0
+	return jobs.Register(scheduler.Job{
+		Name:        demoTickJob,
+		Description: "Broadcast a server-tick message every second",
+		Schedule:    "@every 1s",
+		Volatile:    true,
+		Run: func(ctx context.Context) error {
+			counter++
+			return Broadcast(hub, MessageTypeServerTick, ServerTickPayload{
+				Message: fmt.Sprintf("server tick #%d", counter),
+				SentAt:  time.Now().UTC(),
+			})
+		},
+	})
+}
@@ -0,0 +1,190 @@
+package webserver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+)
+
+// transport holds the per-connection settings and admission rules derived
+// from config.WebSocketConfig.
+type transport struct {
+	upgrader     websocket.Upgrader
+	readLimit    int64
+	messageRate  RoutePolicy
+	pongWait     time.Duration
+	pingInterval time.Duration
+	writeWait    time.Duration
+	origins      originPolicy
+	guard        *connGuard
+}
+
+func newTransport(cfg config.WebSocketConfig) *transport {
+	t := &transport{
+		readLimit:    cfg.ReadLimit,
+		pongWait:     cfg.PongWait,
+		pingInterval: cfg.PingInterval,
+		writeWait:    cfg.WriteWait,
+		origins:      originPolicy{allowed: cfg.AllowedOrigins, localhost: cfg.AllowLocalhost},
+		guard: &connGuard{
+			max:     cfg.MaxConnections,
+			maxIP:   cfg.MaxConnectionsPerIP,
+			maxUser: cfg.MaxConnectionsPerUser,
+			perIP:   make(map[string]int),
+			perUser: make(map[string]int),
+		},
+	}
+	if t.readLimit <= 0 {
+		t.readLimit = 5120
+	}
+	if cfg.MessageRate.Enabled() {
+		t.messageRate = RoutePolicy{Rate: float64(cfg.MessageRate.Count) / cfg.MessageRate.Period.Seconds(), Burst: cfg.MessageRate.Count}
+	}
+	if t.pongWait <= 0 {
+		t.pongWait = 60 * time.Second
+	}
+	// Pings must arrive before the peer's read deadline expires.
+	if t.pingInterval <= 0 || t.pingInterval >= t.pongWait {
+		t.pingInterval = t.pongWait * 9 / 10
+	}
+	if t.writeWait <= 0 {
+		t.writeWait = 10 * time.Second
+	}
+	t.upgrader = websocket.Upgrader{
+		ReadBufferSize:    positiveOr(cfg.ReadBufferSize, 4096),
+		WriteBufferSize:   positiveOr(cfg.WriteBufferSize, 4096),
+		EnableCompression: true,
+		Subprotocols:      []string{SubprotocolMsgpack, SubprotocolJSON},
+		CheckOrigin:       t.origins.check,
+	}
+	return t
+}
+
+func positiveOr(v, fallback int) int {
+	if v > 0 {
+		return v
+	}
+	return fallback
+}
+
+// originPolicy accepts same-host requests, requests without an Origin header
+// (non-browser clients) and the configured origins.
+type originPolicy struct {
+	allowed   []string
+	localhost bool
+}
+
+func (p originPolicy) check(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	if p.localhost && isLoopback(u.Hostname()) {
+		return true
+	}
+	for _, pattern := range p.allowed {
+		if originMatches(pattern, u) {
+			return true
+		}
+	}
+	return false
+}
+
+// originMatches supports "*", exact origins and a leading "*." wildcard in the
+// host, e.g. "https://*.example.com".
+func originMatches(pattern string, origin *url.URL) bool {
+	if pattern == "*" {
+		return true
+	}
+	p, err := url.Parse(pattern)
+	if err != nil || !strings.EqualFold(p.Scheme, origin.Scheme) {
+		return false
+	}
+	if suffix, ok := strings.CutPrefix(strings.ToLower(p.Host), "*."); ok {
+		return strings.HasSuffix(strings.ToLower(origin.Host), "."+suffix)
+	}
+	return strings.EqualFold(p.Host, origin.Host)
+}
+
+func isLoopback(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// connGuard enforces the global, per-IP and per-user connection caps. A zero
+// or negative limit disables that cap.
+type connGuard struct {
+	mu      sync.Mutex
+	max     int
+	maxIP   int
+	maxUser int
+	total   int
+	perIP   map[string]int
+	perUser map[string]int
+}
+
+// admissionError explains why an upgrade was refused and which HTTP status
+// to answer with.
+type admissionError struct {
+	Status int
+	Reason string
+}
+
+func (e *admissionError) Error() string {
+	return e.Reason
+}
+
+// acquire reserves a connection slot; every successful call must be paired
+// with release.
+func (g *connGuard) acquire(ip, user string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	switch {
+	case g.max > 0 && g.total >= g.max:
+		return &admissionError{Status: http.StatusServiceUnavailable, Reason: fmt.Sprintf("server connection limit of %d reached", g.max)}
+	case g.maxIP > 0 && g.perIP[ip] >= g.maxIP:
+		return &admissionError{Status: http.StatusTooManyRequests, Reason: fmt.Sprintf("connection limit of %d per address reached", g.maxIP)}
+	case g.maxUser > 0 && g.perUser[user] >= g.maxUser:
+		return &admissionError{Status: http.StatusTooManyRequests, Reason: fmt.Sprintf("connection limit of %d per user reached", g.maxUser)}
+	}
+	g.total++
+	g.perIP[ip]++
+	g.perUser[user]++
+	return nil
+}
+
+func (g *connGuard) release(ip, user string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.total--
+	decrement(g.perIP, ip)
+	decrement(g.perUser, user)
+}
+
+func decrement(m map[string]int, key string) {
+	if m[key] <= 1 {
+		delete(m, key)
+		return
+	}
+	m[key]--
+}
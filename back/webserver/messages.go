@@ -0,0 +1,265 @@
+package webserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wonderfulsuccess/go-web-app/back/model"
+	"github.com/wonderfulsuccess/go-web-app/back/scheduler"
+)
+
+// Message types exchanged over the WebSocket.
+const (
+	MessageTypeDemoStart  = "demo-start"
+	MessageTypeServerTick = "server-tick"
+	MessageTypeClientAck  = "client-ack"
+	MessageTypeError      = "error"
+)
+
+// Direction states who may send a message type.
+type Direction int
+
+const (
+	// ClientToServer messages are accepted from browsers.
+	ClientToServer Direction = 1 << iota
+	// ServerToClient messages are only emitted by the server.
+	ServerToClient
+	// Bidirectional messages may travel both ways.
+	Bidirectional = ClientToServer | ServerToClient
+)
+
+func (d Direction) String() string {
+	switch d {
+	case ClientToServer:
+		return "client-to-server"
+	case ServerToClient:
+		return "server-to-client"
+	case Bidirectional:
+		return "both"
+	default:
+		return fmt.Sprintf("Direction(%d)", int(d))
+	}
+}
+
+// Allows reports whether d includes the given direction.
+func (d Direction) Allows(other Direction) bool {
+	return d&other == other
+}
+
+// Validator is implemented by payloads that check their own fields after
+// decoding.
+type Validator interface {
+	Validate() error
+}
+
+// Error codes carried by ErrorPayload.
+const (
+	WSErrInvalidFrame   = "invalid_frame"
+	WSErrUnknownType    = "unknown_type"
+	WSErrWrongDirection = "wrong_direction"
+	WSErrInvalidPayload = "invalid_payload"
+	WSErrRateLimited    = "rate_limited"
+	WSErrRelayDenied    = "relay_denied"
+)
+
+// ErrorPayload is sent back to a client whose frame was rejected.
+type ErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	RefType string `json:"refType,omitempty"`
+}
+
+// DemoStartPayload asks the server to start the demo broadcast loop.
+type DemoStartPayload struct {
+	Message     string    `json:"message"`
+	RequestedAt time.Time `json:"requestedAt"`
+}
+
+// ServerTickPayload is pushed once per second by the demo broadcast loop.
+type ServerTickPayload struct {
+	Message string    `json:"message"`
+	SentAt  time.Time `json:"sentAt"`
+}
+
+// ClientAckPayload is sent by the demo component for every tick it receives.
+type ClientAckPayload struct {
+	Message   string    `json:"message"`
+	RepliedAt time.Time `json:"repliedAt"`
+}
+
+// Validate rejects acknowledgements without a message.
+func (p ClientAckPayload) Validate() error {
+	if strings.TrimSpace(p.Message) == "" {
+		return errors.New("message is required")
+	}
+	return nil
+}
+
+// MessageType describes one registered WebSocket message type.
+type MessageType struct {
+	Type        string
+	Description string
+	Direction   Direction
+	// Payload is a zero value of the payload struct, used by code generators.
+	Payload interface{}
+
+	payloadType reflect.Type
+}
+
+// MessageRegistry holds the message types the Hub accepts and emits.
+type MessageRegistry struct {
+	mu    sync.RWMutex
+	types map[string]MessageType
+}
+
+// NewMessageRegistry returns a registry with the built-in message types.
+func NewMessageRegistry() *MessageRegistry {
+	r := &MessageRegistry{types: make(map[string]MessageType)}
+	RegisterMessage[ErrorPayload](r, MessageTypeError, ServerToClient, "Server rejected a client frame")
+	RegisterMessage[DemoStartPayload](r, MessageTypeDemoStart, ClientToServer, "Client asks the server to start the demo broadcast")
+	RegisterMessage[ServerTickPayload](r, MessageTypeServerTick, ServerToClient, "Server demo tick")
+	RegisterMessage[ClientAckPayload](r, MessageTypeClientAck, ClientToServer, "Client acknowledges a server tick")
+	RegisterMessage[PresenceEvent](r, MessageTypePresenceJoin, ServerToClient, "A user opened their first connection")
+	RegisterMessage[PresenceEvent](r, MessageTypePresenceLeave, ServerToClient, "A user closed their last connection")
+	RegisterMessage[PresenceQuery](r, MessageTypePresenceQuery, ClientToServer, "Ask which users are online")
+	RegisterMessage[PresenceList](r, MessageTypePresenceList, ServerToClient, "Users currently online")
+	RegisterMessage[scheduler.JobStatus](r, MessageTypeJobStatus, ServerToClient, "A scheduled job changed state")
+	RegisterMessage[model.Task](r, MessageTypeTaskUpdate, ServerToClient, "A background task changed state or reported progress")
+	return r
+}
+
+// RegisterMessage adds a message type whose payload decodes into T. It
+// panics when the type is registered twice, as that is a programming error.
+func RegisterMessage[T any](r *MessageRegistry, msgType string, dir Direction, description string) {
+	var zero T
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.types[msgType]; exists {
+		panic(fmt.Sprintf("websocket message type %q registered twice", msgType))
+	}
+	r.types[msgType] = MessageType{
+		Type:        msgType,
+		Description: description,
+		Direction:   dir,
+		Payload:     zero,
+		payloadType: reflect.TypeOf(zero),
+	}
+}
+
+// Lookup returns the registration for msgType.
+func (r *MessageRegistry) Lookup(msgType string) (MessageType, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	mt, ok := r.types[msgType]
+	return mt, ok
+}
+
+// Types returns every registered message type sorted by name.
+func (r *MessageRegistry) Types() []MessageType {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]MessageType, 0, len(r.types))
+	for _, mt := range r.types {
+		out = append(out, mt)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Type < out[j].Type })
+	return out
+}
+
+// MessageError explains why an inbound frame was rejected.
+type MessageError struct {
+	Code    string
+	Message string
+	RefType string
+}
+
+func (e *MessageError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// DecodeInbound validates a frame received from a client and returns its
+// decoded payload.
+func (r *MessageRegistry) DecodeInbound(msg WSMessage) (interface{}, error) {
+	mt, ok := r.Lookup(msg.Type)
+	if !ok {
+		return nil, &MessageError{Code: WSErrUnknownType, Message: fmt.Sprintf("unknown message type %q", msg.Type), RefType: msg.Type}
+	}
+	if !mt.Direction.Allows(ClientToServer) {
+		return nil, &MessageError{Code: WSErrWrongDirection, Message: fmt.Sprintf("message type %q cannot be sent by clients", msg.Type), RefType: msg.Type}
+	}
+
+	payload := reflect.New(mt.payloadType)
+	raw := msg.Payload
+	if len(bytes.TrimSpace(raw)) == 0 {
+		raw = json.RawMessage("null")
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(payload.Interface()); err != nil {
+		return nil, &MessageError{Code: WSErrInvalidPayload, Message: err.Error(), RefType: msg.Type}
+	}
+	value := payload.Elem().Interface()
+	if v, ok := value.(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return nil, &MessageError{Code: WSErrInvalidPayload, Message: err.Error(), RefType: msg.Type}
+		}
+	}
+	return value, nil
+}
+
+// encodeOutbound checks that payload matches the registration for msgType and
+// that the server may emit it.
+func (r *MessageRegistry) encodeOutbound(msgType string, payload interface{}) (json.RawMessage, error) {
+	mt, ok := r.Lookup(msgType)
+	if !ok {
+		return nil, fmt.Errorf("unknown websocket message type %q", msgType)
+	}
+	if !mt.Direction.Allows(ServerToClient) {
+		return nil, fmt.Errorf("websocket message type %q cannot be sent by the server", msgType)
+	}
+	if got := reflect.TypeOf(payload); got != mt.payloadType {
+		return nil, fmt.Errorf("websocket message type %q expects %s payload, got %s", msgType, mt.payloadType, got)
+	}
+	return json.Marshal(payload)
+}
+
+// Send emits a registered message type through the hub. The payload type is
+// checked against the registry so callers never marshal payloads by hand.
+func Send[T any](h *Hub, msgType, receiver string, payload T) error {
+	return SendTenant(h, 0, msgType, receiver, payload)
+}
+
+// SendTenant is Send confined to the clients of one tenant: the receiver,
+// whether a user, a topic or everyone, is looked up among them only. A
+// tenant of 0 reaches every tenant.
+func SendTenant[T any](h *Hub, tenant uint, msgType, receiver string, payload T) error {
+	raw, err := h.registry.encodeOutbound(msgType, payload)
+	if err != nil {
+		return err
+	}
+	h.SendMessage(WSMessage{
+		Sender:   "server",
+		Receiver: receiver,
+		Type:     msgType,
+		Payload:  raw,
+		tenant:   tenant,
+	})
+	return nil
+}
+
+// Broadcast emits a registered message type to every client.
+func Broadcast[T any](h *Hub, msgType string, payload T) error {
+	return Send(h, msgType, "*", payload)
+}
+
+// MessageTypes lists every built-in WebSocket message type for code generators.
+func MessageTypes() []MessageType {
+	return NewMessageRegistry().Types()
+}
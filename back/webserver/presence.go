@@ -0,0 +1,153 @@
+package webserver
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/wonderfulsuccess/go-web-app/back/logger"
+)
+
+// Presence message types.
+const (
+	MessageTypePresenceJoin  = "presence.join"
+	MessageTypePresenceLeave = "presence.leave"
+	MessageTypePresenceQuery = "presence.query"
+	MessageTypePresenceList  = "presence.list"
+)
+
+// DefaultPresenceGrace is how long a user stays online after their last
+// connection closes, so reloads and flapping networks do not emit events.
+const DefaultPresenceGrace = 5 * time.Second
+
+// PresenceEntry describes one online user.
+type PresenceEntry struct {
+	UserID      string    `json:"userId"`
+	Connections int       `json:"connections"`
+	Since       time.Time `json:"since"`
+}
+
+// PresenceEvent is the payload of presence.join and presence.leave.
+type PresenceEvent struct {
+	UserID string    `json:"userId"`
+	At     time.Time `json:"at"`
+}
+
+// PresenceQuery is the (empty) payload of presence.query.
+type PresenceQuery struct{}
+
+// PresenceList answers presence.query.
+type PresenceList struct {
+	Users []PresenceEntry `json:"users"`
+}
+
+type presenceUser struct {
+	conns map[string]struct{}
+	since time.Time
+	leave *time.Timer
+}
+
+// presenceKey identifies a user; the same id in two tenants is two users.
+type presenceKey struct {
+	tenant uint
+	user   string
+}
+
+// Presence tracks which user identities hold at least one connection.
+type Presence struct {
+	hub   *Hub
+	mu    sync.Mutex
+	grace time.Duration
+	users map[presenceKey]*presenceUser
+}
+
+func newPresence(h *Hub) *Presence {
+	return &Presence{hub: h, grace: DefaultPresenceGrace, users: make(map[presenceKey]*presenceUser)}
+}
+
+// SetGrace changes the delay before a disconnected user is reported as gone.
+func (p *Presence) SetGrace(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.grace = d
+}
+
+func (p *Presence) connected(c *Client) {
+	key := presenceKey{tenant: c.tenant, user: c.id}
+	p.mu.Lock()
+	user, ok := p.users[key]
+	joined := !ok
+	if !ok {
+		user = &presenceUser{conns: make(map[string]struct{}), since: time.Now().UTC()}
+		p.users[key] = user
+	}
+	if user.leave != nil {
+		// Reconnected within the grace period: the user never left.
+		user.leave.Stop()
+		user.leave = nil
+	}
+	user.conns[c.connID] = struct{}{}
+	since := user.since
+	p.mu.Unlock()
+
+	if joined {
+		p.emit(key.tenant, MessageTypePresenceJoin, PresenceEvent{UserID: key.user, At: since})
+	}
+}
+
+func (p *Presence) disconnected(c *Client) {
+	key := presenceKey{tenant: c.tenant, user: c.id}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	user, ok := p.users[key]
+	if !ok {
+		return
+	}
+	delete(user.conns, c.connID)
+	if len(user.conns) > 0 || user.leave != nil {
+		return
+	}
+	user.leave = time.AfterFunc(p.grace, func() { p.expire(key, user) })
+}
+
+func (p *Presence) expire(key presenceKey, user *presenceUser) {
+	p.mu.Lock()
+	if p.users[key] != user || len(user.conns) > 0 || user.leave == nil {
+		p.mu.Unlock()
+		return
+	}
+	delete(p.users, key)
+	p.mu.Unlock()
+
+	p.emit(key.tenant, MessageTypePresenceLeave, PresenceEvent{UserID: key.user, At: time.Now().UTC()})
+}
+
+// emit tells the user's tenant about a join or leave.
+func (p *Presence) emit(tenant uint, msgType string, event PresenceEvent) {
+	if err := SendTenant(p.hub, tenant, msgType, "*", event); err != nil {
+		logger.Errorf("failed to send %s: %v", msgType, err)
+	}
+}
+
+// IsOnline reports whether userID of the tenant currently holds a
+// connection, or lost its last one less than the grace period ago.
+func (p *Presence) IsOnline(tenant uint, userID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.users[presenceKey{tenant: tenant, user: userID}]
+	return ok
+}
+
+// Online lists the online users of the tenant sorted by id.
+func (p *Presence) Online(tenant uint) []PresenceEntry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entries := make([]PresenceEntry, 0, len(p.users))
+	for key, user := range p.users {
+		if key.tenant == tenant {
+			entries = append(entries, PresenceEntry{UserID: key.user, Connections: len(user.conns), Since: user.since})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].UserID < entries[j].UserID })
+	return entries
+}
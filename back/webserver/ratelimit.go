@@ -0,0 +1,166 @@
+package webserver
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/wonderfulsuccess/go-web-app/back/certs"
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+	"github.com/wonderfulsuccess/go-web-app/back/controller"
+	"github.com/wonderfulsuccess/go-web-app/back/logger"
+	"github.com/wonderfulsuccess/go-web-app/back/ratelimit"
+	"github.com/wonderfulsuccess/go-web-app/back/scheduler"
+)
+
+// Rate limit response headers, named after the IETF RateLimit header
+// fields draft.
+const (
+	headerRateLimitLimit     = "RateLimit-Limit"
+	headerRateLimitRemaining = "RateLimit-Remaining"
+	headerRateLimitReset     = "RateLimit-Reset"
+	headerRetryAfter         = "Retry-After"
+)
+
+// newLimiter returns the limiter selected by cfg, or nil when rate limiting
+// is disabled.
+func newLimiter(cfg config.RateLimitConfig, db *gorm.DB, dbType config.DatabaseType) *ratelimit.Limiter {
+	if !cfg.Enabled {
+		return nil
+	}
+	switch cfg.Store {
+	case "database":
+		if useDBStore(cfg, db, dbType) {
+			return ratelimit.New(ratelimit.NewDBStore(db))
+		}
+		if db != nil {
+			logger.Warningf("rate limits are kept in memory: the database store would queue every request behind the single SQLite writer")
+		}
+	case "memory", "":
+	default:
+		logger.Warningf("unknown rate limit store %q, using memory", cfg.Store)
+	}
+	return ratelimit.New(ratelimit.NewMemoryStore())
+}
+
+// useDBStore reports whether the buckets go to the database. SQLite is
+// left out: it has a single writer connection, which every API request
+// would wait for, and cannot be shared by several nodes anyway.
+func useDBStore(cfg config.RateLimitConfig, db *gorm.DB, dbType config.DatabaseType) bool {
+	return cfg.Store == "database" && db != nil && dbType != config.DBTypeSQLite
+}
+
+// rateLimit throttles API requests per client address, per user and per
+// route group, the first path segment below /api. Group limits count per
+// address and, for requests of a verified user, per user too. Health checks
+// are never limited, and requests are let through while the store of the
+// limiter fails.
+func rateLimit(limiter *ratelimit.Limiter, cfg config.RateLimitConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		group := routeGroup(c.Request.URL.Path)
+		if limiter == nil || group == "health" {
+			c.Next()
+			return
+		}
+
+		ip := c.ClientIP()
+		user := verifiedUser(c.Request)
+		var rules []ratelimit.Rule
+		if rate, ok := cfg.Groups[group]; ok {
+			rules = append(rules, ratelimit.Rule{Key: "api:group:" + group + ":ip:" + ip, Rate: rate})
+			if user != "" {
+				rules = append(rules, ratelimit.Rule{Key: "api:group:" + group + ":user:" + user, Rate: rate})
+			}
+		}
+		if user != "" {
+			rules = append(rules, ratelimit.Rule{Key: "api:user:" + user, Rate: cfg.User})
+		}
+		rules = append(rules, ratelimit.Rule{Key: "api:ip:" + ip, Rate: cfg.IP})
+
+		res, err := limiter.Allow(c.Request.Context(), rules...)
+		if err != nil {
+			logger.Warningf("rate limiter failed, allowing %s %s: %v", c.Request.Method, c.Request.URL.Path, err)
+			c.Next()
+			return
+		}
+		setRateLimitHeaders(c.Writer.Header(), res)
+		if !res.Allowed {
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, controller.ErrorResponse{
+				Error: "rate limit exceeded",
+				Code:  controller.ErrCodeRateLimited,
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// verifiedUser returns the user that per-user limits count against: the
+// device name of the request's verified client certificate, or "" when
+// there is none. Sessions are handed out to anyone, so counting their
+// users would let a client escape its limits by dropping its cookie.
+func verifiedUser(r *http.Request) string {
+	name, _ := certs.ClientName(r)
+	return name
+}
+
+// routeGroup returns the first path segment below /api, e.g. "users" for
+// /api/users/1.
+func routeGroup(path string) string {
+	rest, ok := strings.CutPrefix(path, "/api/")
+	if !ok {
+		return ""
+	}
+	group, _, _ := strings.Cut(rest, "/")
+	return strings.ToLower(group)
+}
+
+func setRateLimitHeaders(header http.Header, res ratelimit.Result) {
+	if res.Limit == 0 {
+		return
+	}
+	header.Set(headerRateLimitLimit, strconv.Itoa(res.Limit))
+	header.Set(headerRateLimitRemaining, strconv.Itoa(res.Remaining))
+	header.Set(headerRateLimitReset, seconds(res.Reset))
+	if !res.Allowed {
+		header.Set(headerRetryAfter, seconds(max(res.RetryAfter, time.Second)))
+	}
+}
+
+// seconds renders d in whole seconds, rounded up.
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// registerRateLimitJobs schedules the removal of idle buckets from the
+// database store. Buckets untouched for the longest configured period are
+// full, so deleting them changes no limit.
+func registerRateLimitJobs(jobs *scheduler.Scheduler, db *gorm.DB, cfg config.RateLimitConfig, dbType config.DatabaseType) error {
+	if !cfg.Enabled || !useDBStore(cfg, db, dbType) {
+		return nil
+	}
+	longest := max(cfg.IP.Period, cfg.User.Period, cfg.WSIP.Period, cfg.WSUser.Period)
+	for _, rate := range cfg.Groups {
+		longest = max(longest, rate.Period)
+	}
+	store := ratelimit.NewDBStore(db)
+	return jobs.Register(scheduler.Job{
+		Name:        "ratelimit.purge",
+		Description: "Delete rate limit buckets that have refilled completely",
+		Schedule:    "@hourly",
+		Enabled:     true,
+		Run: func(ctx context.Context) error {
+			n, err := store.Purge(ctx, time.Now().Add(-longest))
+			if err == nil && n > 0 {
+				logger.Infof("purged %d idle rate limit buckets", n)
+			}
+			return err
+		},
+	})
+}
@@ -4,29 +4,194 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 
+	"github.com/wonderfulsuccess/go-web-app/back/backup"
 	"github.com/wonderfulsuccess/go-web-app/back/config"
 	"github.com/wonderfulsuccess/go-web-app/back/controller"
+	"github.com/wonderfulsuccess/go-web-app/back/database"
+	"github.com/wonderfulsuccess/go-web-app/back/logger"
+	"github.com/wonderfulsuccess/go-web-app/back/openapi"
+	"github.com/wonderfulsuccess/go-web-app/back/ratelimit"
+	"github.com/wonderfulsuccess/go-web-app/back/scheduler"
+	"github.com/wonderfulsuccess/go-web-app/back/search"
+	"github.com/wonderfulsuccess/go-web-app/back/session"
+	"github.com/wonderfulsuccess/go-web-app/back/tasks"
+	"github.com/wonderfulsuccess/go-web-app/back/tenant"
 )
 
+const (
+	apiTitle   = "Go Web App API"
+	apiVersion = "1.0.0"
+
+	apiDescription = "Requests are rate limited per client address, per client certificate and per route group. " +
+		"Limited responses carry the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers; " +
+		"a request over the limit is answered with 429, an ErrorResponse with code rate_limited " +
+		"and a Retry-After header."
+)
+
+// documentedController is implemented by every controller in the controller
+// package so its routes and their OpenAPI description stay side by side.
+type documentedController interface {
+	RegisterRoutes(group *gin.RouterGroup)
+	Operations() []openapi.Operation
+}
+
 // NewRouter wires the HTTP endpoints for API and static assets.
-func NewRouter(cfg config.Config, db *gorm.DB, hub *Hub) *gin.Engine {
+func NewRouter(cfg config.Config, db *gorm.DB, hub *Hub, jobs *scheduler.Scheduler, queue *tasks.Queue, engine *search.Engine, backups *backup.Manager, tenants *tenant.Manager, limiter *ratelimit.Limiter, sessions *session.Signer) *gin.Engine {
+	router, docs := newRouter(cfg, db, hub, jobs, queue, engine, backups, tenants, limiter, sessions)
+	if err := docs.Verify(router.Routes(), "/api"); err != nil {
+		logger.Errorf("%v", err)
+	}
+	return router
+}
+
+// BuildOpenAPI returns the OpenAPI document for the routes registered by
+// NewRouter, failing when a route and its documentation have drifted apart.
+func BuildOpenAPI(cfg config.Config) (*openapi.Document, error) {
+	router, docs := newRouter(cfg, nil, NewHub(), scheduler.New(nil), tasks.New(nil, tasks.Options{}), search.New(nil, cfg.Database.Type), backup.New(nil, cfg.Database, cfg.Backup, cfg.Tenant), nil, nil, session.NewSigner(nil, 0))
+	if err := docs.Verify(router.Routes(), "/api"); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+func newRouter(cfg config.Config, db *gorm.DB, hub *Hub, jobs *scheduler.Scheduler, queue *tasks.Queue, engine *search.Engine, backups *backup.Manager, tenants *tenant.Manager, limiter *ratelimit.Limiter, sessions *session.Signer) (*gin.Engine, *openapi.Document) {
 	router := gin.Default()
+	if err := router.SetTrustedProxies(cfg.Security.TrustedProxies); err != nil {
+		logger.Errorf("invalid trusted proxies, trusting none: %v", err)
+		_ = router.SetTrustedProxies(nil)
+	}
+	docs := openapi.NewDocument(apiTitle, apiVersion)
+	docs.Description = apiDescription
+
+	router.Use(securityHeaders(cfg.Security), cors(cfg.Security))
+	if cfg.Security.CSRF {
+		router.Use(csrfProtection(cfg.Security))
+	}
 
 	api := router.Group("/api")
 	{
-		api.GET("/health", func(c *gin.Context) {
-			c.JSON(http.StatusOK, gin.H{"status": "ok"})
+		cluster := database.ClusterOf(db)
+		window := time.Duration(0)
+		if cluster.HasReplicas() {
+			window = cfg.Database.PrimaryAfterWrite
+		}
+		api.Use(readYourWrites(window))
+		api.Use(rateLimit(limiter, cfg.RateLimit))
+		api.Use(identify(sessions, cfg.Session))
+
+		api.GET("/health", healthHandler(cluster))
+		docs.Add(api.BasePath(), openapi.Operation{
+			Method: http.MethodGet, Path: "/health", Summary: "Health check", Tags: []string{"system"},
+			Description: "Reports the latest health check of the primary database and its read replicas.",
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Body: HealthStatus{}},
+				{Status: http.StatusServiceUnavailable, Description: "The primary database is unreachable", Body: HealthStatus{}},
+			},
 		})
 
-		userGroup := api.Group("/users")
-		userController := controller.NewUserController(db)
-		userController.RegisterRoutes(userGroup)
+		admin := requireAdmin(cfg.Security)
+		mountAdmin(api, docs, "/jobs", controller.NewJobController(jobs), admin)
+		mountAdmin(api, docs, "/backups", controller.NewBackupController(backups), admin)
+		mountAdmin(api, docs, "/tenants", controller.NewTenantController(tenants), admin)
+
+		// Routes registered from here on act for the request's tenant; the
+		// ones above are system-wide.
+		api.Use(resolveTenant(tenants))
+
+		mount(api, docs, "/users", controller.NewUserController(db, cfg.Import.MaxBytes))
+		mount(api, docs, "/tasks", controller.NewTaskController(queue))
+		mount(api, docs, "/search", controller.NewSearchController(engine, queue))
+
+		// scaffold:routes
 
 		api.GET("/ws", hub.HandleWebSocket)
+		docs.Add(api.BasePath(), openapi.Operation{
+			Method: http.MethodGet, Path: "/ws", Summary: "Open a WebSocket connection", Tags: []string{"realtime"},
+			Description: "Upgrades to a WebSocket that exchanges WSMessage envelopes. The connection acts as the user of the " +
+				"client certificate or of the session cookie that /api responses set.",
+			Params: []openapi.Param{
+				{Name: "userId", In: "query", Description: "Expected user identity; the connection is refused unless it is the user of the session cookie or client certificate"},
+				{Name: "clientId", In: "query", Description: "Deprecated alias of userId"},
+				{Name: "topics", In: "query", Description: "Comma separated topics to subscribe to"},
+				{Name: "backpressure", In: "query", Description: "Send queue policy: drop-oldest, drop-newest, coalesce or disconnect"},
+			},
+			Responses: []openapi.Response{
+				{Status: http.StatusSwitchingProtocols, Description: "WebSocket upgrade", Body: WSMessage{}},
+				{Status: http.StatusForbidden, Description: "Origin not allowed, or userId is not the user of the session", Body: map[string]string{}},
+				{Status: http.StatusTooManyRequests, Description: "Per-address or per-user connection limit reached", Body: map[string]string{}},
+				{Status: http.StatusServiceUnavailable, Description: "Server connection limit reached", Body: map[string]string{}},
+			},
+		})
+
+		api.GET("/events", hub.HandleEvents)
+		docs.Add(api.BasePath(), openapi.Operation{
+			ID: "streamEvents", Method: http.MethodGet, Path: "/events", Summary: "Stream messages as Server-Sent Events", Tags: []string{"realtime"},
+			Description: "Fallback for /ws when WebSocket upgrades are blocked. The first event is `ready` carrying EventsReady; " +
+				"every further event carries a WSMessage. Reconnecting with Last-Event-ID replays recent missed messages.",
+			Params: []openapi.Param{
+				{Name: "userId", In: "query", Description: "Expected user identity; the stream is refused unless it is the user of the session cookie or client certificate"},
+				{Name: "topics", In: "query", Description: "Comma separated topics to subscribe to"},
+				{Name: "backpressure", In: "query", Description: "Send queue policy: drop-oldest, drop-newest, coalesce or disconnect"},
+				{Name: "lastEventId", In: "query", Description: "Resume after this event id when the Last-Event-ID header cannot be set"},
+			},
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Description: "Stream of WSMessage events", Body: WSMessage{}, ContentType: "text/event-stream"},
+				{Status: http.StatusForbidden, Description: "Origin not allowed, or userId is not the user of the session", Body: map[string]string{}},
+				{Status: http.StatusTooManyRequests, Description: "Per-address or per-user connection limit reached", Body: map[string]string{}},
+				{Status: http.StatusServiceUnavailable, Description: "Server connection limit reached", Body: map[string]string{}},
+			},
+		})
+
+		api.POST("/events", hub.HandleEventPost)
+		docs.Add(api.BasePath(), openapi.Operation{
+			ID: "postEvent", Method: http.MethodPost, Path: "/events", Summary: "Send a message over an event stream", Tags: []string{"realtime"},
+			Description: "Client-to-server counterpart of /events; the message is handled like a WebSocket frame.",
+			Params: []openapi.Param{
+				{Name: "connId", In: "query", Required: true, Description: "Connection id from the stream's ready event"},
+			},
+			Request: WSMessage{},
+			Responses: []openapi.Response{
+				{Status: http.StatusAccepted, Description: "Message accepted"},
+				{Status: http.StatusBadRequest, Description: "Invalid message", Body: ErrorPayload{}},
+				{Status: http.StatusNotFound, Description: "Unknown connection, or one of another user", Body: ErrorPayload{}},
+				{Status: http.StatusTooManyRequests, Description: "Rate limited", Body: ErrorPayload{}},
+			},
+		})
+
+		api.GET("/presence", func(c *gin.Context) {
+			tenantID, _ := tenant.FromContext(c.Request.Context())
+			c.JSON(http.StatusOK, hub.Presence().Online(tenantID))
+		})
+		docs.Add(api.BasePath(), openapi.Operation{
+			ID: "getPresence", Method: http.MethodGet, Path: "/presence", Summary: "Users currently online", Tags: []string{"realtime"},
+			Responses: []openapi.Response{{Status: http.StatusOK, Body: []PresenceEntry{}}},
+		})
+
+		api.GET("/ws/stats", admin, func(c *gin.Context) {
+			c.JSON(http.StatusOK, hub.Stats())
+		})
+		docs.Add(api.BasePath(), adminOperation(openapi.Operation{
+			ID: "getWebSocketStats", Method: http.MethodGet, Path: "/ws/stats", Summary: "WebSocket send queue metrics", Tags: []string{"realtime"},
+			Responses: []openapi.Response{{Status: http.StatusOK, Body: []ClientStats{}}},
+		}))
+
+		api.GET("/openapi.json", docs.Handler())
+		api.GET("/docs", openapi.ViewerHandler(apiTitle, "/api/openapi.json"))
+		docs.Add(api.BasePath(),
+			openapi.Operation{
+				Method: http.MethodGet, Path: "/openapi.json", Summary: "OpenAPI document", Tags: []string{"system"},
+				Responses: []openapi.Response{{Status: http.StatusOK, Body: map[string]interface{}{}}},
+			},
+			openapi.Operation{
+				Method: http.MethodGet, Path: "/docs", Summary: "API documentation viewer", Tags: []string{"system"},
+				Responses: []openapi.Response{{Status: http.StatusOK, Description: "HTML page"}},
+			},
+		)
 	}
 
 	// Serve the compiled front-end assets.
@@ -52,5 +217,12 @@ func NewRouter(cfg config.Config, db *gorm.DB, hub *Hub) *gin.Engine {
 		c.File(indexPath)
 	})
 
-	return router
+	return router, docs
+}
+
+// mount registers a controller under the api group and records its docs.
+func mount(api *gin.RouterGroup, docs *openapi.Document, path string, ctl documentedController) {
+	group := api.Group(path)
+	ctl.RegisterRoutes(group)
+	docs.Add(group.BasePath(), ctl.Operations()...)
 }
@@ -0,0 +1,141 @@
+package webserver
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RelayMode decides whether a client frame is forwarded to other clients.
+type RelayMode int
+
+const (
+	// RelayNone delivers the frame to the server only (Hub.Incoming).
+	RelayNone RelayMode = iota
+	// RelayBroadcast forwards the frame to every connected client.
+	RelayBroadcast
+	// RelayDirect forwards the frame only to the client named in Receiver.
+	RelayDirect
+	// RelayAny forwards to the named Receiver, or to everyone for "*".
+	RelayAny
+)
+
+// RoutePolicy is the routing rule for one message type.
+type RoutePolicy struct {
+	Relay RelayMode
+	// Rate is the sustained number of frames per second a single client may
+	// send; Burst is the bucket size. A zero Rate disables limiting.
+	Rate  float64
+	Burst int
+}
+
+// RoutingPolicy maps message types to their RoutePolicy. Types without an
+// explicit entry use the fallback, which never relays.
+type RoutingPolicy struct {
+	mu       sync.RWMutex
+	policies map[string]RoutePolicy
+	fallback RoutePolicy
+}
+
+// NewRoutingPolicy returns the default policy for the built-in message types.
+func NewRoutingPolicy() *RoutingPolicy {
+	p := &RoutingPolicy{
+		policies: make(map[string]RoutePolicy),
+		fallback: RoutePolicy{Relay: RelayNone, Rate: 10, Burst: 20},
+	}
+	p.Set(MessageTypeDemoStart, RoutePolicy{Relay: RelayNone, Rate: 1, Burst: 3})
+	p.Set(MessageTypeClientAck, RoutePolicy{Relay: RelayNone, Rate: 5, Burst: 10})
+	return p
+}
+
+// Set replaces the policy for msgType.
+func (p *RoutingPolicy) Set(msgType string, policy RoutePolicy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.policies[msgType] = policy
+}
+
+// SetFallback replaces the policy used for types without an explicit entry.
+func (p *RoutingPolicy) SetFallback(policy RoutePolicy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fallback = policy
+}
+
+// Lookup returns the policy that applies to msgType.
+func (p *RoutingPolicy) Lookup(msgType string) RoutePolicy {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if policy, ok := p.policies[msgType]; ok {
+		return policy
+	}
+	return p.fallback
+}
+
+// relayTarget returns the receiver a client frame is forwarded to, or false
+// when it must stay on the server. Relaying requires the message type to be
+// registered as deliverable to clients.
+func (p *RoutingPolicy) relayTarget(msg WSMessage, mt MessageType) (string, bool, error) {
+	policy := p.Lookup(msg.Type)
+	if policy.Relay == RelayNone {
+		return "", false, nil
+	}
+	if !mt.Direction.Allows(ServerToClient) {
+		return "", false, nil
+	}
+
+	broadcast := msg.Receiver == "" || msg.Receiver == "*"
+	switch policy.Relay {
+	case RelayBroadcast:
+		return "*", true, nil
+	case RelayDirect:
+		if broadcast || msg.Receiver == "server" {
+			return "", false, &MessageError{
+				Code:    WSErrRelayDenied,
+				Message: fmt.Sprintf("message type %q must name a single receiver", msg.Type),
+				RefType: msg.Type,
+			}
+		}
+		return msg.Receiver, true, nil
+	case RelayAny:
+		if msg.Receiver == "server" {
+			return "", false, nil
+		}
+		if broadcast {
+			return "*", true, nil
+		}
+		return msg.Receiver, true, nil
+	default:
+		return "", false, nil
+	}
+}
+
+// tokenBucket is a small per-client rate limiter.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) allow(policy RoutePolicy, now time.Time) bool {
+	if policy.Rate <= 0 {
+		return true
+	}
+	burst := float64(policy.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * policy.Rate
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
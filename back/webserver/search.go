@@ -0,0 +1,82 @@
+package webserver
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/wonderfulsuccess/go-web-app/back/controller"
+	"github.com/wonderfulsuccess/go-web-app/back/logger"
+	"github.com/wonderfulsuccess/go-web-app/back/model"
+	"github.com/wonderfulsuccess/go-web-app/back/scheduler"
+	"github.com/wonderfulsuccess/go-web-app/back/search"
+	"github.com/wonderfulsuccess/go-web-app/back/tasks"
+	"github.com/wonderfulsuccess/go-web-app/back/tenant"
+)
+
+// registerSearchIndexes declares the searchable models.
+func registerSearchIndexes(engine *search.Engine) error {
+	return engine.Register(search.Index{Kind: "user", Model: &model.User{}, Fields: []string{"name", "email"}})
+}
+
+// registerSearchTasks adds the reindex task, schedules a nightly run that
+// repairs writes the Gorm callbacks could not see, and queues a rebuild of
+// indexes that are out of step with their tables.
+func registerSearchTasks(engine *search.Engine, queue *tasks.Queue, jobs *scheduler.Scheduler, tenants *tenant.Manager) error {
+	queue.Handle(controller.SearchReindexTask, func(ctx context.Context, t *tasks.Task) error {
+		var input controller.SearchReindexInput
+		if err := t.Decode(&input); err != nil {
+			return tasks.Permanent(err)
+		}
+		kinds := engine.Kinds()
+		if input.Kind != "" {
+			kinds = []string{input.Kind}
+		}
+		for i, kind := range kinds {
+			err := engine.Reindex(ctx, kind, func(done, total int64) {
+				percent := i * 100 / len(kinds)
+				if total > 0 {
+					percent += int(done * 100 / total / int64(len(kinds)))
+				}
+				t.SetProgress(percent, fmt.Sprintf("%s: %d/%d", kind, done, total))
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return t.SetResult(map[string][]string{"kinds": kinds})
+	})
+
+	if err := jobs.Register(scheduler.Job{
+		Name:        "search.reindex",
+		Description: "Rebuild the full-text search indexes",
+		Schedule:    "@daily",
+		Enabled:     true,
+		Run: func(ctx context.Context) error {
+			return tenants.ForEach(ctx, func(ctx context.Context, t model.Tenant) error {
+				_, err := queue.Enqueue(controller.SearchReindexTask, controller.SearchReindexInput{}, tasks.EnqueueOptions{Tenant: t.ID})
+				return err
+			})
+		},
+	}); err != nil {
+		return err
+	}
+	return queueStaleIndexes(engine, queue, tenants)
+}
+
+// queueStaleIndexes queues a rebuild of every index whose document count
+// differs from its table, tenant by tenant.
+func queueStaleIndexes(engine *search.Engine, queue *tasks.Queue, tenants *tenant.Manager) error {
+	return tenants.ForEach(context.Background(), func(ctx context.Context, t model.Tenant) error {
+		stale, err := engine.Stale(ctx)
+		if err != nil {
+			return err
+		}
+		for _, kind := range stale {
+			logger.Infof("search index %s of tenant %s is out of date, queueing a rebuild", kind, t.Slug)
+			if _, err := queue.Enqueue(controller.SearchReindexTask, controller.SearchReindexInput{Kind: kind}, tasks.EnqueueOptions{Tenant: t.ID}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
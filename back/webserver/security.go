@@ -0,0 +1,183 @@
+package webserver
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+	"github.com/wonderfulsuccess/go-web-app/back/controller"
+	"github.com/wonderfulsuccess/go-web-app/back/logger"
+)
+
+// corsExposedHeaders are the response headers cross-origin callers may read.
+var corsExposedHeaders = strings.Join([]string{
+	headerRateLimitLimit, headerRateLimitRemaining, headerRateLimitReset, headerRetryAfter, "Content-Disposition",
+}, ", ")
+
+// securityHeaders adds the configured security headers to every response,
+// the API as well as the front-end assets.
+func securityHeaders(cfg config.SecurityConfig) gin.HandlerFunc {
+	cspHeader := "Content-Security-Policy"
+	if cfg.CSPReportOnly {
+		cspHeader = "Content-Security-Policy-Report-Only"
+	}
+	hsts := ""
+	if cfg.HSTSMaxAge > 0 {
+		hsts = "max-age=" + strconv.Itoa(int(cfg.HSTSMaxAge.Seconds()))
+	}
+	proxies := parseProxies(cfg.TrustedProxies)
+	return func(c *gin.Context) {
+		header := c.Writer.Header()
+		header.Set("X-Content-Type-Options", "nosniff")
+		if cfg.CSP != "" {
+			header.Set(cspHeader, cfg.CSP)
+		}
+		if cfg.FrameOptions != "" {
+			header.Set("X-Frame-Options", cfg.FrameOptions)
+		}
+		if cfg.ReferrerPolicy != "" {
+			header.Set("Referrer-Policy", cfg.ReferrerPolicy)
+		}
+		// Browsers ignore Strict-Transport-Security received over HTTP.
+		if hsts != "" && (c.Request.TLS != nil || forwardedHTTPS(c.Request, proxies)) {
+			header.Set("Strict-Transport-Security", hsts)
+		}
+		c.Next()
+	}
+}
+
+// forwardedHTTPS reports whether a trusted reverse proxy received r over
+// HTTPS. X-Forwarded-Proto from any other peer is ignored, as a client
+// could otherwise have HSTS sent over plain HTTP, e.g. to a proxy that
+// does not set the header itself.
+func forwardedHTTPS(r *http.Request, proxies []*net.IPNet) bool {
+	if !strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+		return false
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range proxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseProxies parses the trusted proxy addresses and CIDR ranges. Like
+// the router, it trusts no proxy at all when any entry is invalid.
+func parseProxies(list []string) []*net.IPNet {
+	var proxies []*net.IPNet
+	for _, entry := range list {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			entry = ip.String() + "/" + strconv.Itoa(bits)
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies
+}
+
+// cors lets the configured origins call the API with the user's cookies
+// and answers their preflight requests. Same-origin requests, including
+// those proxied by the Vite dev server, need no CORS headers.
+func cors(cfg config.SecurityConfig) gin.HandlerFunc {
+	origins := originPolicy{allowed: cfg.CORSOrigins, localhost: cfg.CORSAllowLocalhost}
+	maxAge := strconv.Itoa(int(cfg.CORSMaxAge.Seconds()))
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+		header := c.Writer.Header()
+		header.Add("Vary", "Origin")
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		if !origins.check(c.Request) {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		header.Set("Access-Control-Allow-Origin", origin)
+		header.Set("Access-Control-Allow-Credentials", "true")
+		if !preflight {
+			header.Set("Access-Control-Expose-Headers", corsExposedHeaders)
+			c.Next()
+			return
+		}
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+		header.Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		if requested := c.GetHeader("Access-Control-Request-Headers"); requested != "" {
+			header.Set("Access-Control-Allow-Headers", requested)
+		}
+		if cfg.CORSMaxAge > 0 {
+			header.Set("Access-Control-Max-Age", maxAge)
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
+
+// csrfProtection refuses state-changing requests that a browser sends from
+// another site, such as a form posted by a malicious page, which would
+// otherwise carry the user's cookies. Browsers mark such requests with
+// Sec-Fetch-Site, or at least an Origin header; requests without either
+// come from non-browser clients and are let through. Origins allowed by
+// the CORS settings are trusted.
+func csrfProtection(cfg config.SecurityConfig) gin.HandlerFunc {
+	origins := originPolicy{allowed: cfg.CORSOrigins, localhost: cfg.CORSAllowLocalhost}
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+		if crossSite(c.Request) && !origins.check(c.Request) {
+			logger.Warningf("rejected cross-site %s %s from origin %q", c.Request.Method, c.Request.URL.Path, c.GetHeader("Origin"))
+			c.AbortWithStatusJSON(http.StatusForbidden, controller.ErrorResponse{
+				Error: "cross-site request refused",
+				Code:  controller.ErrCodeForbidden,
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// crossSite reports whether a browser sent r on behalf of another origin.
+func crossSite(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return false
+	case "same-site", "cross-site":
+		return true
+	}
+	// Older browsers send Origin on cross-origin requests; originPolicy
+	// then compares it with the request's host.
+	return r.Header.Get("Origin") != ""
+}
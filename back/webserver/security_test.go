@@ -0,0 +1,42 @@
+package webserver_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/wonderfulsuccess/go-web-app/back/apptest"
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+)
+
+func TestHSTSBehindProxy(t *testing.T) {
+	tests := []struct {
+		name    string
+		proxies []string
+		proto   string
+		want    bool
+	}{
+		{name: "plain http", proxies: []string{"127.0.0.1"}, want: false},
+		{name: "trusted proxy", proxies: []string{"127.0.0.1"}, proto: "https", want: true},
+		{name: "trusted range", proxies: []string{"127.0.0.0/8"}, proto: "HTTPS", want: true},
+		{name: "no trusted proxies", proto: "https", want: false},
+		{name: "peer outside the range", proxies: []string{"10.0.0.0/8"}, proto: "https", want: false},
+		{name: "invalid entry trusts none", proxies: []string{"127.0.0.1", "proxy"}, proto: "https", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := apptest.New(t, apptest.Options{Config: func(cfg *config.Config) {
+				cfg.Security.HSTSMaxAge = time.Hour
+				cfg.Security.TrustedProxies = tt.proxies
+			}})
+			client := env.Client()
+			if tt.proto != "" {
+				client = client.With("X-Forwarded-Proto", tt.proto)
+			}
+			res := client.Get("/api/health").Expect(http.StatusOK)
+			if got := res.Header.Get("Strict-Transport-Security"); (got != "") != tt.want {
+				t.Fatalf("Strict-Transport-Security %q, want sent %v", got, tt.want)
+			}
+		})
+	}
+}
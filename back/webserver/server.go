@@ -2,18 +2,23 @@ package webserver
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 
+	"github.com/wonderfulsuccess/go-web-app/back/backup"
 	"github.com/wonderfulsuccess/go-web-app/back/config"
+	"github.com/wonderfulsuccess/go-web-app/back/database"
 	"github.com/wonderfulsuccess/go-web-app/back/logger"
+	"github.com/wonderfulsuccess/go-web-app/back/scheduler"
+	"github.com/wonderfulsuccess/go-web-app/back/search"
+	"github.com/wonderfulsuccess/go-web-app/back/session"
+	"github.com/wonderfulsuccess/go-web-app/back/tasks"
+	"github.com/wonderfulsuccess/go-web-app/back/tenant"
 )
 
 // Server bundles together the Gin engine, Gorm connection and websocket hub.
@@ -21,12 +26,15 @@ type Server struct {
 	cfg        config.Config
 	httpServer *http.Server
 	hub        *Hub
-	quit       chan struct{}
-	runMu      sync.Mutex
-	running    bool
+	jobs       *scheduler.Scheduler
+	tasks      *tasks.Queue
+	search     *search.Engine
+	backups    *backup.Manager
+	sessions   *session.Signer
+	cluster    *database.Cluster
 }
 
-func NewServer(cfg config.Config, db *gorm.DB) *Server {
+func NewServer(cfg config.Config, db *gorm.DB, tenants *tenant.Manager) *Server {
 	gin.SetMode(cfg.Mode)
 	if cfg.Mode == gin.ReleaseMode {
 		gin.DefaultWriter = io.Discard
@@ -34,7 +42,51 @@ func NewServer(cfg config.Config, db *gorm.DB) *Server {
 	}
 
 	hub := NewHub()
-	router := NewRouter(cfg, db, hub)
+	if err := hub.Configure(cfg.WebSocket); err != nil {
+		logger.Warningf("invalid websocket configuration, using default backpressure: %v", err)
+	}
+	jobs := scheduler.New(db)
+	publishJobStatus(hub, jobs)
+	if err := registerDemoJobs(hub, jobs); err != nil {
+		logger.Errorf("failed to register demo jobs: %v", err)
+	}
+	queue := tasks.New(db, tasks.Options{Workers: cfg.Tasks.Workers, MaxAttempts: cfg.Tasks.MaxAttempts})
+	publishTaskUpdates(hub, queue)
+	registerDemoTasks(queue)
+	if err := registerTaskJobs(jobs, queue, cfg.Tasks.Retention); err != nil {
+		logger.Errorf("failed to register task jobs: %v", err)
+	}
+	engine := search.New(db, cfg.Database.Type)
+	if err := registerSearchIndexes(engine); err != nil {
+		logger.Errorf("failed to register search indexes: %v", err)
+	}
+	if err := engine.Migrate(); err != nil {
+		logger.Errorf("failed to initialise search: %v", err)
+	} else if err := prepareTenantDatabases(tenants, engine); err != nil {
+		logger.Errorf("failed to initialise search of tenant databases: %v", err)
+	} else if err := registerSearchTasks(engine, queue, jobs, tenants); err != nil {
+		logger.Errorf("failed to register search tasks: %v", err)
+	}
+	if err := registerDatabaseJobs(jobs, db, cfg.Database); err != nil {
+		logger.Errorf("failed to register database jobs: %v", err)
+	}
+	backups := backup.New(db, cfg.Database, cfg.Backup, cfg.Tenant)
+	migrateAfterRestore(backups, db, engine, queue, tenants)
+	if err := registerBackupJobs(jobs, backups, cfg.Backup, cfg.Tenant); err != nil {
+		logger.Errorf("failed to register backup job: %v", err)
+	}
+	limiter := newLimiter(cfg.RateLimit, db, cfg.Database.Type)
+	hub.SetRateLimiter(limiter, cfg.RateLimit.WSIP, cfg.RateLimit.WSUser)
+	if err := registerRateLimitJobs(jobs, db, cfg.RateLimit, cfg.Database.Type); err != nil {
+		logger.Errorf("failed to register rate limit job: %v", err)
+	}
+	sessions, err := session.Load(cfg.Session)
+	if err != nil {
+		// Sessions then end with the process.
+		logger.Errorf("failed to load the session key, using a temporary one: %v", err)
+		sessions = session.NewSigner([]byte(session.NewID()), cfg.Session.MaxAge)
+	}
+	router := NewRouter(cfg, db, hub, jobs, queue, engine, backups, tenants, limiter, sessions)
 
 	srv := &http.Server{
 		Addr:    cfg.Address(),
@@ -45,7 +97,12 @@ func NewServer(cfg config.Config, db *gorm.DB) *Server {
 		cfg:        cfg,
 		httpServer: srv,
 		hub:        hub,
-		quit:       make(chan struct{}),
+		jobs:       jobs,
+		tasks:      queue,
+		search:     engine,
+		backups:    backups,
+		sessions:   sessions,
+		cluster:    database.ClusterOf(db),
 	}
 
 	go hub.Run()
@@ -62,7 +119,7 @@ func (s *Server) logIncomingMessages() {
 		}
 		logger.Infof("websocket message type=%s sender=%s receiver=%s payload=%s", msg.Type, msg.Sender, msg.Receiver, payload)
 
-		if msg.Type == "demo-start" {
+		if msg.Type == MessageTypeDemoStart {
 			logger.Infof("websocket demo start requested by %s", msg.Sender)
 			s.ensureDemoBroadcast()
 		}
@@ -70,72 +127,103 @@ func (s *Server) logIncomingMessages() {
 }
 
 func (s *Server) ensureDemoBroadcast() {
-	s.runMu.Lock()
-	if s.running {
-		s.runMu.Unlock()
+	if status, err := s.jobs.Get(demoTickJob); err != nil || status.Enabled {
 		return
 	}
-	s.running = true
-	s.runMu.Unlock()
-
-	logger.Infof("starting websocket demo broadcast loop")
-	go s.broadcastLoop()
-}
-
-func (s *Server) broadcastLoop() {
-	ticker := time.NewTicker(1 * time.Second)
-	defer ticker.Stop()
-
-	counter := 0
-	for {
-		select {
-		case <-s.quit:
-			return
-		case t := <-ticker.C:
-			counter++
-			payload, err := json.Marshal(map[string]string{
-				"message": fmt.Sprintf("server tick #%d", counter),
-				"sentAt":  t.UTC().Format(time.RFC3339Nano),
-			})
-			if err != nil {
-				logger.Errorf("failed to marshal websocket payload: %v", err)
-				continue
-			}
-
-			s.hub.SendMessage(WSMessage{
-				Sender:    "server",
-				Receiver:  "*",
-				Type:      "server-tick",
-				Timestamp: t.UTC(),
-				Payload:   payload,
-			})
-		}
+	logger.Infof("starting websocket demo broadcast")
+	if _, err := s.jobs.SetEnabled(demoTickJob, true); err != nil {
+		logger.Errorf("failed to enable %s: %v", demoTickJob, err)
 	}
 }
 
-// Start runs the HTTP server until the context is cancelled.
+// Start runs the HTTP server, or the HTTPS server and its redirect when TLS
+// is enabled, until the context is cancelled.
 func (s *Server) Start(ctx context.Context) error {
-	defer close(s.quit)
+	s.jobs.Start(ctx)
+	s.tasks.Start(ctx)
+	go s.cluster.Watch(ctx, s.cfg.Database.HealthInterval)
+
+	var redirect *http.Server
+	if s.cfg.TLS.Enabled {
+		var err error
+		if redirect, err = s.configureTLS(s.cfg.TLS); err != nil {
+			return fmt.Errorf("tls: %w", err)
+		}
+	}
 
-	errCh := make(chan error, 1)
+	errCh := make(chan error, 2)
 
 	go func() {
-		logger.Infof("starting webserver on %s", s.httpServer.Addr)
-		if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if s.httpServer.TLSConfig != nil {
+			logger.Infof("starting webserver on %s (https)", s.httpServer.Addr)
+			// The certificates come from TLSConfig.
+			err = s.httpServer.ListenAndServeTLS("", "")
+		} else {
+			logger.Infof("starting webserver on %s", s.httpServer.Addr)
+			err = s.httpServer.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			errCh <- err
 		}
 	}()
+	if redirect != nil {
+		go func() {
+			logger.Infof("redirecting http on %s to https", redirect.Addr)
+			if err := redirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				errCh <- err
+			}
+		}()
+		defer redirect.Close()
+	}
 
 	select {
 	case <-ctx.Done():
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
-		return s.httpServer.Shutdown(shutdownCtx)
+		err := s.httpServer.Shutdown(shutdownCtx)
+		// Let workers put interrupted tasks back in the queue.
+		s.tasks.Wait(shutdownCtx)
+		return err
 	case err := <-errCh:
 		return err
 	}
 }
 
+// Handler returns the HTTP handler serving the API, WebSocket and static
+// files, for embedding the server or testing it without a listener.
+func (s *Server) Handler() http.Handler {
+	return s.httpServer.Handler
+}
+
+// Jobs exposes the scheduler so other packages can register jobs.
+func (s *Server) Jobs() *scheduler.Scheduler {
+	return s.jobs
+}
+
+// Tasks exposes the background task queue so other packages can register
+// handlers and enqueue work.
+func (s *Server) Tasks() *tasks.Queue {
+	return s.tasks
+}
+
+// Search exposes the full-text search engine so other packages can register
+// indexes and run queries.
+func (s *Server) Search() *search.Engine {
+	return s.search
+}
+
+// Backups exposes the database backup manager.
+func (s *Server) Backups() *backup.Manager {
+	return s.backups
+}
+
+// Sessions exposes the signer of the session cookies, e.g. to issue a
+// session for a known user.
+func (s *Server) Sessions() *session.Signer {
+	return s.sessions
+}
+
 // Hub exposes the websocket hub so other packages can push messages.
 func (s *Server) Hub() *Hub {
 	return s.hub
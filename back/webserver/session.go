@@ -0,0 +1,69 @@
+package webserver
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wonderfulsuccess/go-web-app/back/certs"
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+	"github.com/wonderfulsuccess/go-web-app/back/logger"
+	"github.com/wonderfulsuccess/go-web-app/back/session"
+)
+
+// identify gives every API request a user identity: the device name of a
+// verified client certificate, or else the user of the session cookie. A
+// request without a valid session gets a new random identity and a cookie
+// carrying it; the cookie is renewed once half its lifetime has passed.
+func identify(signer *session.Signer, cfg config.SessionConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if name, ok := certs.ClientName(c.Request); ok {
+			c.Request = c.Request.WithContext(session.WithUser(c.Request.Context(), name))
+			c.Next()
+			return
+		}
+
+		id := ""
+		if cookie, err := c.Request.Cookie(cfg.Cookie); err == nil {
+			if user, issued, err := signer.Verify(cookie.Value); err == nil {
+				id = user
+				if !signer.Stale(issued) {
+					c.Request = c.Request.WithContext(session.WithUser(c.Request.Context(), id))
+					c.Next()
+					return
+				}
+			}
+		}
+		if id == "" {
+			id = session.NewID()
+		}
+		http.SetCookie(c.Writer, &http.Cookie{
+			Name:     cfg.Cookie,
+			Value:    signer.Issue(id),
+			Path:     "/",
+			MaxAge:   int(signer.MaxAge().Seconds()),
+			HttpOnly: true,
+			Secure:   c.Request.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+		c.Request = c.Request.WithContext(session.WithUser(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+// connectionUser returns the identity a WebSocket or event stream connects
+// as. A userId (or clientId) parameter is only accepted when it names that
+// identity; otherwise the request is refused and false returned.
+func connectionUser(c *gin.Context) (string, bool) {
+	user, ok := session.UserFromContext(c.Request.Context())
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "no session"})
+		return "", false
+	}
+	if claimed := firstNonEmpty(c.Query("userId"), c.Query("clientId")); claimed != "" && claimed != user {
+		logger.Warningf("rejected connection from %s claiming user %q as %q", c.ClientIP(), claimed, user)
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "userId does not match the session"})
+		return "", false
+	}
+	return user, true
+}
@@ -0,0 +1,82 @@
+package webserver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/wonderfulsuccess/go-web-app/back/logger"
+	"github.com/wonderfulsuccess/go-web-app/back/model"
+	"github.com/wonderfulsuccess/go-web-app/back/scheduler"
+	"github.com/wonderfulsuccess/go-web-app/back/tasks"
+)
+
+// MessageTypeTaskUpdate carries a model.Task whenever it changes state or
+// reports progress. It is sent to the submitting user, or to TasksTopic for
+// tasks without a submitter.
+const MessageTypeTaskUpdate = "task.update"
+
+// TasksTopic receives updates of tasks nobody submitted.
+const TasksTopic = "tasks"
+
+// demoCountdownTask counts down for a number of seconds, reporting progress.
+const demoCountdownTask = "demo.countdown"
+
+// DemoCountdownPayload is the payload of the demo.countdown task.
+type DemoCountdownPayload struct {
+	Seconds int `json:"seconds"`
+}
+
+func publishTaskUpdates(hub *Hub, queue *tasks.Queue) {
+	queue.OnChange(func(task model.Task) {
+		raw, err := hub.registry.encodeOutbound(MessageTypeTaskUpdate, task)
+		if err != nil {
+			logger.Errorf("failed to encode task update: %v", err)
+			return
+		}
+		receiver := task.Submitter
+		if receiver == "" {
+			receiver = TopicPrefix + TasksTopic
+		}
+		// Progress is advisory; never block a worker on a busy hub.
+		hub.TrySend(WSMessage{Sender: "server", Receiver: receiver, Type: MessageTypeTaskUpdate, Payload: raw, tenant: task.TenantID})
+	})
+}
+
+func registerDemoTasks(queue *tasks.Queue) {
+	queue.Handle(demoCountdownTask, func(ctx context.Context, t *tasks.Task) error {
+		var payload DemoCountdownPayload
+		if err := t.Decode(&payload); err != nil {
+			return tasks.Permanent(err)
+		}
+		if payload.Seconds <= 0 || payload.Seconds > 3600 {
+			return tasks.Permanent(fmt.Errorf("seconds must be between 1 and 3600, got %d", payload.Seconds))
+		}
+		for i := 0; i < payload.Seconds; i++ {
+			t.SetProgress(i*100/payload.Seconds, fmt.Sprintf("%d seconds left", payload.Seconds-i))
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Second):
+			}
+		}
+		return t.SetResult(map[string]int{"seconds": payload.Seconds})
+	})
+}
+
+// registerTaskJobs schedules the daily purge of finished tasks.
+func registerTaskJobs(jobs *scheduler.Scheduler, queue *tasks.Queue, retention time.Duration) error {
+	return jobs.Register(scheduler.Job{
+		Name:        "tasks.purge",
+		Description: "Delete succeeded and canceled tasks past their retention",
+		Schedule:    "@daily",
+		Enabled:     true,
+		Run: func(ctx context.Context) error {
+			n, err := queue.Purge(time.Now().Add(-retention))
+			if err == nil && n > 0 {
+				logger.Infof("purged %d finished tasks", n)
+			}
+			return err
+		},
+	})
+}
@@ -0,0 +1,43 @@
+package webserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wonderfulsuccess/go-web-app/back/controller"
+	"github.com/wonderfulsuccess/go-web-app/back/search"
+	"github.com/wonderfulsuccess/go-web-app/back/tenant"
+)
+
+// resolveTenant scopes the request's context to its tenant, see
+// tenant.Manager.FromRequest. Requests naming an unknown tenant get 404.
+func resolveTenant(tenants *tenant.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if tenants == nil {
+			c.Next()
+			return
+		}
+		t, err := tenants.FromRequest(c.Request)
+		if err != nil {
+			status, code := http.StatusInternalServerError, controller.ErrCodeInternal
+			if errors.Is(err, tenant.ErrNotFound) {
+				status, code = http.StatusNotFound, controller.ErrCodeNotFound
+			}
+			c.AbortWithStatusJSON(status, controller.ErrorResponse{Error: err.Error(), Code: code})
+			return
+		}
+		c.Request = c.Request.WithContext(tenant.WithID(c.Request.Context(), t.ID))
+		c.Next()
+	}
+}
+
+// prepareTenantDatabases creates the search index in every per-tenant
+// database, now and whenever one is opened.
+func prepareTenantDatabases(tenants *tenant.Manager, engine *search.Engine) error {
+	return tenants.OnOpen(func(ctx context.Context) error {
+		return engine.MigrateContext(ctx)
+	})
+}
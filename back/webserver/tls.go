@@ -0,0 +1,76 @@
+package webserver
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/wonderfulsuccess/go-web-app/back/certs"
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+	"github.com/wonderfulsuccess/go-web-app/back/logger"
+)
+
+// configureTLS switches the server to HTTPS as described by cfg and returns
+// the plain HTTP server that redirects to it, if one is configured.
+func (s *Server) configureTLS(cfg config.TLSConfig) (*http.Server, error) {
+	tc, err := certs.ServerConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s.httpServer.TLSConfig = tc
+	logCertificate(cfg, tc.Certificates[0])
+
+	if cfg.RedirectPort == "" {
+		return nil, nil
+	}
+	return &http.Server{
+		Addr:              ":" + cfg.RedirectPort,
+		Handler:           redirectToHTTPS(s.cfg.Port),
+		ReadHeaderTimeout: 10 * time.Second,
+	}, nil
+}
+
+func logCertificate(cfg config.TLSConfig, cert tls.Certificate) {
+	leaf := cert.Leaf
+	if leaf == nil {
+		return
+	}
+	source := cfg.CertFile
+	if source == "" {
+		source = "self-signed certificate in " + cfg.Dir
+	}
+	logger.Infof("serving https with %s for %s, valid until %s, SHA-256 %s",
+		source, strings.Join(append(leaf.DNSNames, ipStrings(leaf.IPAddresses)...), ", "),
+		leaf.NotAfter.Format(time.DateOnly), certs.Fingerprint(leaf))
+	if cfg.ClientCAFile != "" {
+		logger.Infof("client certificates from %s are %s", cfg.ClientCAFile, cfg.ClientAuth)
+	}
+}
+
+func ipStrings(ips []net.IP) []string {
+	out := make([]string, len(ips))
+	for i, ip := range ips {
+		out[i] = ip.String()
+	}
+	return out
+}
+
+// redirectToHTTPS answers every request with a permanent redirect to the
+// same URL on the HTTPS port. 308 keeps the method and body of the request.
+func redirectToHTTPS(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -1,14 +1,20 @@
 package webserver
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 
 	"github.com/wonderfulsuccess/go-web-app/back/logger"
+	"github.com/wonderfulsuccess/go-web-app/back/ratelimit"
+	"github.com/wonderfulsuccess/go-web-app/back/tenant"
 )
 
 // WSMessage represents the envelope shared between server and clients.
@@ -18,95 +24,82 @@ type WSMessage struct {
 	Timestamp time.Time       `json:"timestamp"`
 	Type      string          `json:"type"`
 	Payload   json.RawMessage `json:"payload"`
-}
 
-// Hub orchestrates WebSocket clients and message routing.
-type Hub struct {
-	clients    map[*Client]bool
-	register   chan *Client
-	unregister chan *Client
-	broadcast  chan WSMessage
-	incoming   chan WSMessage
+	// seq is assigned by the hub on dispatch and used as the SSE event id.
+	seq uint64
+	// tenant confines delivery to the clients of one tenant; 0 reaches
+	// every tenant.
+	tenant uint
 }
 
-func NewHub() *Hub {
-	return &Hub{
-		clients:    make(map[*Client]bool),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		broadcast:  make(chan WSMessage, 32),
-		incoming:   make(chan WSMessage, 32),
+// HandleWebSocket upgrades an HTTP request to a WebSocket connection.
+// Requests from disallowed origins and requests whose userId is not the
+// user of their session get 403, requests over a connection cap get 429
+// (per address or user) or 503 (server-wide).
+func (h *Hub) HandleWebSocket(c *gin.Context) {
+	t := h.transport
+	ip := c.ClientIP()
+	// The user identity, taken from the session, may be shared by several
+	// connections (tabs); each connection additionally gets its own id.
+	clientID, ok := connectionUser(c)
+	if !ok {
+		return
 	}
-}
 
-func (h *Hub) Run() {
-	for {
-		select {
-		case client := <-h.register:
-			h.clients[client] = true
-		case client := <-h.unregister:
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
-			}
-		case msg := <-h.broadcast:
-			for client := range h.clients {
-				if msg.Receiver != "" && msg.Receiver != client.id && msg.Receiver != "*" {
-					continue
-				}
-				select {
-				case client.send <- msg:
-				default:
-					delete(h.clients, client)
-					close(client.send)
-				}
-			}
-		}
+	if !t.origins.check(c.Request) {
+		logger.Warningf("rejected websocket from %s: origin %q not allowed", ip, c.GetHeader("Origin"))
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "origin not allowed"})
+		return
 	}
-}
-
-// SendMessage allows other packages to emit WebSocket messages.
-func (h *Hub) SendMessage(msg WSMessage) {
-	if msg.Timestamp.IsZero() {
-		msg.Timestamp = time.Now().UTC()
+	if err := t.guard.acquire(ip, clientID); err != nil {
+		var admission *admissionError
+		errors.As(err, &admission)
+		logger.Warningf("rejected websocket from %s user=%s: %v", ip, clientID, err)
+		c.AbortWithStatusJSON(admission.Status, gin.H{"error": admission.Reason})
+		return
 	}
-	h.broadcast <- msg
-}
 
-// Incoming exposes server-side visibility into messages pushed by clients.
-func (h *Hub) Incoming() <-chan WSMessage {
-	return h.incoming
-}
-
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
-}
-
-// HandleWebSocket upgrades an HTTP request to a WebSocket connection.
-func (h *Hub) HandleWebSocket(c *gin.Context) {
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	// The upgrade writes its own response; a renewed session cookie must
+	// be passed along.
+	var header http.Header
+	if cookies := c.Writer.Header().Values("Set-Cookie"); len(cookies) > 0 {
+		header = http.Header{"Set-Cookie": cookies}
+	}
+	conn, err := t.upgrader.Upgrade(c.Writer, c.Request, header)
 	if err != nil {
+		t.guard.release(ip, clientID)
 		logger.Errorf("failed to upgrade websocket: %v", err)
 		return
 	}
 
-	clientID := c.Query("clientId")
-	if clientID == "" {
-		clientID = c.ClientIP()
+	backpressure := h.backpressure
+	if name := c.Query("backpressure"); name != "" {
+		policy, err := ParseBackpressurePolicy(name)
+		if err != nil {
+			logger.Warningf("ignoring backpressure policy from %s: %v", clientID, err)
+		} else {
+			backpressure.Policy = policy
+		}
 	}
 
+	tenantID, _ := tenant.FromContext(c.Request.Context())
 	client := &Client{
-		id:   clientID,
-		hub:  h,
-		conn: conn,
-		send: make(chan WSMessage, 16),
+		id:       clientID,
+		tenant:   tenantID,
+		ip:       ip,
+		verified: verifiedUser(c.Request),
+		connID:   newConnID(),
+		topics:   splitTopics(c.Query("topics")),
+		hub:      h,
+		conn:     conn,
+		codec:    codecFor(conn.Subprotocol()),
+		queue:    newSendQueue(backpressure),
+		limiters: make(map[string]*tokenBucket),
 	}
 
-	h.register <- client
+	h.register(client)
+	h.presence.connected(client)
+	logger.Infof("websocket connected user=%s conn=%s", client.id, client.connID)
 
 	go client.writePump()
 	go client.readPump()
@@ -114,27 +107,45 @@ func (h *Hub) HandleWebSocket(c *gin.Context) {
 
 // Client represents an active websocket connection.
 type Client struct {
-	id   string
-	hub  *Hub
-	conn *websocket.Conn
-	send chan WSMessage
+	id     string // user identity, shared by the user's connections
+	tenant uint   // tenant of the request that opened the connection
+	ip     string
+	// verified is the user per-user rate limits count against, see
+	// verifiedUser; empty for most clients.
+	verified string
+	connID   string
+	topics   []string
+	hub      *Hub
+	conn     *websocket.Conn
+	queue    *sendQueue
+
+	codec codec
+
+	// inboundMu serialises admit and handleInbound, which own frames and
+	// limiters.
+	inboundMu sync.Mutex
+	frames    tokenBucket
+	limiters  map[string]*tokenBucket
 }
 
 func (c *Client) readPump() {
 	defer func() {
-		c.hub.unregister <- c
+		c.hub.unregister(c)
+		c.hub.presence.disconnected(c)
+		c.hub.transport.guard.release(c.ip, c.id)
 		_ = c.conn.Close()
 	}()
 
-	c.conn.SetReadLimit(5120)
-	_ = c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	t := c.hub.transport
+	c.conn.SetReadLimit(t.readLimit)
+	_ = c.conn.SetReadDeadline(time.Now().Add(t.pongWait))
 	c.conn.SetPongHandler(func(string) error {
-		return c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		return c.conn.SetReadDeadline(time.Now().Add(t.pongWait))
 	})
 
 	for {
-		var msg WSMessage
-		if err := c.conn.ReadJSON(&msg); err != nil {
+		frameType, data, err := c.conn.ReadMessage()
+		if err != nil {
 			if websocket.IsCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
 				return
 			}
@@ -142,24 +153,172 @@ func (c *Client) readPump() {
 			return
 		}
 
-		if msg.Timestamp.IsZero() {
-			msg.Timestamp = time.Now().UTC()
+		if err := c.admit(); err != nil {
+			if err == errTooManyFrames {
+				logger.Warningf("closing websocket of %s conn=%s: too many messages", c.id, c.connID)
+				_ = c.conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "too many messages"), time.Now().Add(t.writeWait))
+				return
+			}
+			c.reject(err)
+			continue
+		}
+
+		// Text frames are always JSON; binary frames use the negotiated codec.
+		var dec codec = jsonCodec{}
+		if frameType == websocket.BinaryMessage {
+			dec = c.codec
+		}
+		msg, err := dec.decode(data)
+		if err != nil {
+			c.reject(&MessageError{Code: WSErrInvalidFrame, Message: err.Error()})
+			continue
 		}
-		if msg.Sender == "" {
-			msg.Sender = c.id
+		if err := c.handleInbound(msg); err != nil {
+			c.reject(err)
 		}
+	}
+}
 
-		select {
-		case c.hub.incoming <- msg:
-		default:
+// errTooManyFrames is returned by admit when the connection itself sends
+// more than its message rate.
+var errTooManyFrames = &MessageError{Code: WSErrRateLimited, Message: "too many messages"}
+
+// admit charges a frame to the message rate of the connection and to the
+// limits of its sender before the frame is decoded, so that malformed
+// frames and frames of unknown types count as well.
+func (c *Client) admit() error {
+	c.inboundMu.Lock()
+	defer c.inboundMu.Unlock()
+	if !c.frames.allow(c.hub.transport.messageRate, time.Now()) {
+		return errTooManyFrames
+	}
+	return c.allowSender()
+}
+
+// handleInbound validates, rate limits and routes a message sent by the
+// client, whichever transport carried it. The frame must have been
+// admitted first.
+func (c *Client) handleInbound(msg WSMessage) error {
+	c.inboundMu.Lock()
+	defer c.inboundMu.Unlock()
+
+	// Replace the sender claimed in the message by the identity of the
+	// connection and keep whatever the client relays inside its tenant.
+	msg.Sender = c.id
+	msg.tenant = c.tenant
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now().UTC()
+	}
+
+	if !c.allow(msg.Type) {
+		return &MessageError{Code: WSErrRateLimited, Message: fmt.Sprintf("too many %q messages", msg.Type), RefType: msg.Type}
+	}
+	if _, err := c.hub.registry.DecodeInbound(msg); err != nil {
+		return err
+	}
+
+	if msg.Type == MessageTypePresenceQuery {
+		c.replyPresence()
+		return nil
+	}
+
+	mt, _ := c.hub.registry.Lookup(msg.Type)
+	receiver, relay, err := c.hub.routing.relayTarget(msg, mt)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case c.hub.incoming <- msg:
+	default:
+	}
+
+	if relay {
+		msg.Receiver = receiver
+		if !c.hub.TrySend(msg) {
+			logger.Warningf("dropped relayed websocket message from %s: hub is busy", c.id)
 		}
+	}
+	return nil
+}
 
-		c.hub.SendMessage(msg)
+// allow applies the per-type rate limit of the routing policy. Types that
+// are not registered share one bucket, so made-up types cannot grow the
+// map of buckets.
+func (c *Client) allow(msgType string) bool {
+	key := msgType
+	if _, ok := c.hub.registry.Lookup(msgType); !ok {
+		key = ""
+	}
+	bucket, ok := c.limiters[key]
+	if !ok {
+		bucket = &tokenBucket{}
+		c.limiters[key] = bucket
+	}
+	return bucket.allow(c.hub.routing.Lookup(msgType), time.Now())
+}
+
+// allowSender applies the hub's limits shared by all connections of the
+// client's address and verified user. The limits are not enforced while
+// the store of the limiter fails.
+func (c *Client) allowSender() error {
+	h := c.hub
+	if h.limiter == nil {
+		return nil
+	}
+	var rules []ratelimit.Rule
+	if c.verified != "" {
+		rules = append(rules, ratelimit.Rule{Key: "ws:user:" + c.verified, Rate: h.perUser})
+	}
+	if c.ip != "" {
+		rules = append(rules, ratelimit.Rule{Key: "ws:ip:" + c.ip, Rate: h.perIP})
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	res, err := h.limiter.Allow(context.Background(), rules...)
+	if err != nil {
+		logger.Warningf("rate limiter failed, accepting websocket message from %s: %v", c.id, err)
+		return nil
+	}
+	if !res.Allowed {
+		return &MessageError{
+			Code:    WSErrRateLimited,
+			Message: fmt.Sprintf("too many messages, retry in %s", res.RetryAfter.Round(time.Millisecond)),
+		}
+	}
+	return nil
+}
+
+// reject reports a refused inbound frame back to the client that sent it.
+func (c *Client) reject(err error) {
+	payload := errorPayload(err)
+	logger.Warningf("rejected websocket frame from %s: %s", c.id, payload.Message)
+	if err := Send(c.hub, MessageTypeError, ConnPrefix+c.connID, payload); err != nil {
+		logger.Errorf("failed to send websocket error frame: %v", err)
+	}
+}
+
+func errorPayload(err error) ErrorPayload {
+	var msgErr *MessageError
+	if errors.As(err, &msgErr) {
+		return ErrorPayload{Code: msgErr.Code, Message: msgErr.Message, RefType: msgErr.RefType}
+	}
+	return ErrorPayload{Code: WSErrInvalidPayload, Message: err.Error()}
+}
+
+// replyPresence answers presence.query on the asking connection only.
+func (c *Client) replyPresence() {
+	list := PresenceList{Users: c.hub.presence.Online(c.tenant)}
+	if err := Send(c.hub, MessageTypePresenceList, ConnPrefix+c.connID, list); err != nil {
+		logger.Errorf("failed to send presence list: %v", err)
 	}
 }
 
 func (c *Client) writePump() {
-	ticker := time.NewTicker(30 * time.Second)
+	t := c.hub.transport
+	ticker := time.NewTicker(t.pingInterval)
 	defer func() {
 		ticker.Stop()
 		_ = c.conn.Close()
@@ -167,16 +326,21 @@ func (c *Client) writePump() {
 
 	for {
 		select {
-		case msg, ok := <-c.send:
-			if !ok {
-				_ = c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
+		case <-c.queue.notify:
+			batch, done := c.queue.pop(maxBatchSize)
+			if len(batch) > 0 {
+				if err := c.write(batch); err != nil {
+					logger.Errorf("websocket write error: %v", err)
+					return
+				}
 			}
-			if err := c.conn.WriteJSON(msg); err != nil {
-				logger.Errorf("websocket write error: %v", err)
+			if done {
+				_ = c.conn.SetWriteDeadline(time.Now().Add(t.writeWait))
+				_ = c.conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
 		case <-ticker.C:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(t.writeWait))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
@@ -184,6 +348,50 @@ func (c *Client) writePump() {
 	}
 }
 
+// write sends batch as one frame when the codec batches, otherwise as one
+// frame per message.
+func (c *Client) write(batch []WSMessage) error {
+	if c.codec.batches() {
+		return c.writeFrame(batch)
+	}
+	for i := range batch {
+		if err := c.writeFrame(batch[i : i+1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFrame writes one frame. The write deadline applies to every later
+// write of the connection, so each frame sets its own.
+func (c *Client) writeFrame(msgs []WSMessage) error {
+	_ = c.conn.SetWriteDeadline(time.Now().Add(c.hub.transport.writeWait))
+	w, err := c.conn.NextWriter(c.codec.frameType())
+	if err != nil {
+		return err
+	}
+	if err := c.codec.encode(w, msgs); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// ID returns the user identity of the connection.
 func (c *Client) ID() string {
 	return c.id
 }
+
+// ConnID returns the id unique to this connection.
+func (c *Client) ConnID() string {
+	return c.connID
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
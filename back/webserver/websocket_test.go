@@ -0,0 +1,173 @@
+package webserver_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/wonderfulsuccess/go-web-app/back/apptest"
+	"github.com/wonderfulsuccess/go-web-app/back/config"
+	"github.com/wonderfulsuccess/go-web-app/back/controller"
+	"github.com/wonderfulsuccess/go-web-app/back/model"
+	"github.com/wonderfulsuccess/go-web-app/back/webserver"
+)
+
+func TestPresenceStaysInTenant(t *testing.T) {
+	env := apptest.New(t, apptest.Options{})
+	env.CreateTenant("acme")
+	watcher := env.Client().Dial("watcher")
+	watcher.Expect(webserver.MessageTypePresenceJoin)
+
+	env.Client().Tenant("acme").Dial("cy")
+	env.Client().Dial("ada")
+	var join webserver.PresenceEvent
+	watcher.ExpectPayload(webserver.MessageTypePresenceJoin, &join)
+	if join.UserID != "ada" {
+		t.Fatalf("got join of %q, want ada; cy belongs to another tenant", join.UserID)
+	}
+
+	var online []webserver.PresenceEntry
+	env.Client().Get("/api/presence").Expect(http.StatusOK).JSON(&online)
+	if len(online) != 2 {
+		t.Fatalf("default tenant has %+v online, want ada and watcher", online)
+	}
+}
+
+func TestSpoofedUserGetsNothing(t *testing.T) {
+	env := apptest.New(t, apptest.Options{})
+	ada := env.Client().Dial("ada")
+	ada.Expect(webserver.MessageTypePresenceJoin)
+
+	_, res, err := env.Client().As("mallory").DialRaw(url.Values{"userId": {"ada"}})
+	if err == nil || res == nil || res.StatusCode != http.StatusForbidden {
+		t.Fatalf("connecting as ada with mallory's session: err %v, response %+v", err, res)
+	}
+	// Without a session the server picks an identity, which is not ada.
+	anonymous := env.Client().DialSession()
+
+	tick := webserver.ServerTickPayload{Message: "for ada", SentAt: time.Now().UTC()}
+	if err := webserver.Send(env.Server.Hub(), webserver.MessageTypeServerTick, "ada", tick); err != nil {
+		t.Fatal(err)
+	}
+	ada.Expect(webserver.MessageTypeServerTick)
+	anonymous.ExpectNone(webserver.MessageTypeServerTick, 100*time.Millisecond)
+}
+
+func TestInvalidFrameIsRejected(t *testing.T) {
+	env := apptest.New(t, apptest.Options{})
+	ws := env.Client().Dial("ada")
+	ws.Send(webserver.MessageTypeClientAck, "", webserver.ClientAckPayload{})
+
+	var got webserver.ErrorPayload
+	ws.ExpectPayload(webserver.MessageTypeError, &got)
+	if got.Code != webserver.WSErrInvalidPayload || got.RefType != webserver.MessageTypeClientAck {
+		t.Fatalf("got error %+v", got)
+	}
+	// Server-to-client types cannot be sent by clients.
+	ws.Send(webserver.MessageTypeServerTick, "", webserver.ServerTickPayload{Message: "fake"})
+	ws.ExpectPayload(webserver.MessageTypeError, &got)
+	if got.Code != webserver.WSErrWrongDirection {
+		t.Fatalf("got error %+v", got)
+	}
+}
+
+func TestInvalidFramesAreThrottled(t *testing.T) {
+	env := apptest.New(t, apptest.Options{Config: func(cfg *config.Config) {
+		cfg.WebSocket.MessageRate = config.Rate{Count: 5, Period: time.Minute}
+	}})
+	ws := env.Client().Dial("ada")
+	ws.Expect(webserver.MessageTypePresenceJoin)
+
+	for i := 0; i < 5; i++ {
+		ws.SendRaw([]byte("{not json"))
+		var got webserver.ErrorPayload
+		ws.ExpectPayload(webserver.MessageTypeError, &got)
+		if got.Code != webserver.WSErrInvalidFrame {
+			t.Fatalf("frame %d: got error %+v", i, got)
+		}
+	}
+	ws.Send("made.up.type", "", nil)
+	err := ws.ExpectClosed()
+	if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Fatalf("connection ended with %v, want a policy violation close", err)
+	}
+}
+
+func TestInvalidFramesCountAgainstSender(t *testing.T) {
+	env := apptest.New(t, apptest.Options{Config: func(cfg *config.Config) {
+		cfg.RateLimit.Enabled = true
+		cfg.RateLimit.WSIP = config.Rate{Count: 3, Period: time.Minute}
+	}})
+	ws := env.Client().Dial("ada")
+	ws.Expect(webserver.MessageTypePresenceJoin)
+
+	want := []string{webserver.WSErrInvalidFrame, webserver.WSErrUnknownType, webserver.WSErrInvalidFrame, webserver.WSErrRateLimited}
+	for i, code := range want {
+		if i == 1 {
+			ws.Send("made.up.type", "", nil)
+		} else {
+			ws.SendRaw([]byte("{not json"))
+		}
+		var got webserver.ErrorPayload
+		ws.ExpectPayload(webserver.MessageTypeError, &got)
+		if got.Code != code {
+			t.Fatalf("frame %d: got error %+v, want code %s", i, got, code)
+		}
+	}
+}
+
+func TestTaskUpdatesReachSubmitter(t *testing.T) {
+	env := apptest.New(t, apptest.Options{})
+	ada := env.Client().Dial("ada")
+	other := env.Client().Dial("cy")
+
+	// Naming another user does not send them the progress.
+	var task model.Task
+	env.Client().As("ada").Post("/api/tasks?userId=cy", controller.TaskInput{Kind: "demo.countdown", Payload: []byte(`{"seconds": 0}`)}).
+		Expect(http.StatusAccepted).JSON(&task)
+	if task.Submitter != "ada" {
+		t.Fatalf("task submitted by %q, want ada", task.Submitter)
+	}
+	var update model.Task
+	ada.ExpectPayload(webserver.MessageTypeTaskUpdate, &update)
+	if update.ID != task.ID || update.Status != model.TaskQueued {
+		t.Fatalf("got update %+v", update)
+	}
+
+	if n := env.DrainTasks(); n != 1 {
+		t.Fatalf("ran %d tasks, want 1", n)
+	}
+	for update.Status != model.TaskDead {
+		ada.ExpectPayload(webserver.MessageTypeTaskUpdate, &update)
+	}
+	if update.LastError == "" {
+		t.Fatalf("dead task without error: %+v", update)
+	}
+	other.ExpectNone(webserver.MessageTypeTaskUpdate, 100*time.Millisecond)
+}
+
+// A message written more than WriteWait after a ping must not inherit the
+// ping's write deadline.
+func TestWriteAfterPing(t *testing.T) {
+	env := apptest.New(t, apptest.Options{Config: func(cfg *config.Config) {
+		cfg.WebSocket.PingInterval = 300 * time.Millisecond
+		cfg.WebSocket.WriteWait = 50 * time.Millisecond
+		cfg.WebSocket.PongWait = 2 * time.Second
+	}})
+	ws := env.Client().Dial("ada")
+	ws.Expect(webserver.MessageTypePresenceJoin)
+
+	time.Sleep(450 * time.Millisecond)
+	tick := webserver.ServerTickPayload{Message: "late", SentAt: time.Now().UTC()}
+	if err := webserver.Send(env.Server.Hub(), webserver.MessageTypeServerTick, "ada", tick); err != nil {
+		t.Fatal(err)
+	}
+	var got webserver.ServerTickPayload
+	ws.ExpectPayload(webserver.MessageTypeServerTick, &got)
+	if got.Message != "late" {
+		t.Fatalf("got tick %q, want %q", got.Message, "late")
+	}
+}
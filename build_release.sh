@@ -13,6 +13,18 @@ STAMP="$(date +"%Y%m%d-%H%M%S")"
 mkdir -p "$RELEASE_DIR"
 rm -rf "$RELEASE_DIR"/*
 
+echo "==> Checking OpenAPI document"
+(
+  cd "$BACK_DIR"
+  go run ./cmd/openapi -check
+)
+
+echo "==> Generating TypeScript API client"
+(
+  cd "$BACK_DIR"
+  go run ./cmd/tsgen
+)
+
 echo "==> Building front-end assets"
 (
   cd "$FRONT_DIR"
@@ -63,7 +75,7 @@ for entry in "${TARGETS[@]}"; do
 
   (
     cd "$BACK_DIR"
-    env "${build_env[@]}" go build -trimpath -ldflags="-s -w" -o "$PACKAGE_DIR/$BINARY_NAME" ./app
+    env "${build_env[@]}" go build -tags sqlite_fts5 -trimpath -ldflags="-s -w" -o "$PACKAGE_DIR/$BINARY_NAME" ./app
   )
 
   mkdir -p "$PACKAGE_DIR/webserver"
@@ -10,6 +10,7 @@ import DashboardPage from "@/pages/dashboard";
 import SettingsPage from "@/pages/settings";
 import UsersPage from "@/pages/users";
 import TestPage from "@/pages/test";
+// scaffold:imports
 
 const NAVIGATION = [
   {
@@ -28,6 +29,7 @@ const NAVIGATION = [
     label: "测试",
     icon: <FiTerminal className="h-4 w-4" />,
   },
+  // scaffold:navigation
 ];
 
 function App() {
@@ -103,6 +105,7 @@ function App() {
             <Route path="/users" element={<UsersPage />} />
             <Route path="/settings" element={<SettingsPage />} />
             <Route path="/test" element={<TestPage />} />
+            {/* scaffold:routes */}
           </Routes>
         </div>
       </main>
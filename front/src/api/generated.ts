@@ -0,0 +1,656 @@
+// Code generated by go run ./cmd/tsgen. DO NOT EDIT.
+
+export const ERROR_CODES = [
+  "invalid_request",
+  "not_found",
+  "conflict",
+  "internal_error",
+  "forbidden",
+  "rate_limited",
+  "unauthorized",
+  "too_large",
+] as const;
+
+export type ErrorCode = (typeof ERROR_CODES)[number];
+
+export interface WSMessage<T = unknown> {
+  sender: string;
+  receiver: string;
+  timestamp: string;
+  type: string;
+  payload: T;
+}
+
+export interface WSMessagePayloads {
+  /** Client acknowledges a server tick */
+  "client-ack": ClientAckPayload;
+  /** Client asks the server to start the demo broadcast */
+  "demo-start": DemoStartPayload;
+  /** Server rejected a client frame */
+  "error": ErrorPayload;
+  /** A scheduled job changed state */
+  "job.status": JobStatus;
+  /** A user opened their first connection */
+  "presence.join": PresenceEvent;
+  /** A user closed their last connection */
+  "presence.leave": PresenceEvent;
+  /** Users currently online */
+  "presence.list": PresenceList;
+  /** Ask which users are online */
+  "presence.query": PresenceQuery;
+  /** Server demo tick */
+  "server-tick": ServerTickPayload;
+  /** A background task changed state or reported progress */
+  "task.update": Task;
+}
+
+export type WSMessageType = keyof WSMessagePayloads;
+
+export const WS_MESSAGE_DIRECTIONS = {
+  "client-ack": "client-to-server",
+  "demo-start": "client-to-server",
+  "error": "server-to-client",
+  "job.status": "server-to-client",
+  "presence.join": "server-to-client",
+  "presence.leave": "server-to-client",
+  "presence.list": "server-to-client",
+  "presence.query": "client-to-server",
+  "server-tick": "server-to-client",
+  "task.update": "server-to-client",
+} as const satisfies Record<WSMessageType, "client-to-server" | "server-to-client" | "both">;
+
+/** Message types the server accepts from clients. */
+export type ClientMessageType = {
+  [K in WSMessageType]: (typeof WS_MESSAGE_DIRECTIONS)[K] extends "server-to-client" ? never : K;
+}[WSMessageType];
+
+/** Message types the server may push to clients. */
+export type ServerMessageType = {
+  [K in WSMessageType]: (typeof WS_MESSAGE_DIRECTIONS)[K] extends "client-to-server" ? never : K;
+}[WSMessageType];
+
+export type TypedWSMessage<K extends WSMessageType = WSMessageType> = {
+  [P in K]: WSMessage<WSMessagePayloads[P]> & { type: P };
+}[K];
+
+export interface ClientAckPayload {
+  message: string;
+  repliedAt: string;
+}
+
+export interface DemoStartPayload {
+  message: string;
+  requestedAt: string;
+}
+
+export interface ErrorPayload {
+  code: string;
+  message: string;
+  refType?: string;
+}
+
+export interface JobStatus {
+  name: string;
+  description: string;
+  schedule: string;
+  enabled: boolean;
+  running: boolean;
+  lastRunAt: string | null;
+  lastDurationMs: number;
+  lastError: string;
+  nextRunAt: string | null;
+  runCount: number;
+  failCount: number;
+  skipped: number;
+}
+
+export interface PresenceEvent {
+  userId: string;
+  at: string;
+}
+
+export interface PresenceList {
+  users: PresenceEntry[];
+}
+
+export interface PresenceEntry {
+  userId: string;
+  connections: number;
+  since: string;
+}
+
+export interface PresenceQuery {
+}
+
+export interface ServerTickPayload {
+  message: string;
+  sentAt: string;
+}
+
+export interface Task {
+  id: number;
+  kind: string;
+  payload: unknown;
+  status: string;
+  attempts: number;
+  maxAttempts: number;
+  runAt: string;
+  progress: number;
+  progressMessage: string;
+  result: unknown;
+  lastError: string;
+  submitter: string;
+  tenantId: number;
+  startedAt: string | null;
+  finishedAt: string | null;
+  createdAt: string;
+  updatedAt: string;
+}
+
+export interface Info {
+  name: string;
+  size: number;
+  createdAt: string;
+}
+
+export interface ErrorResponse {
+  error: string;
+  code: ErrorCode;
+}
+
+export interface Verification {
+  name: string;
+  ok: boolean;
+  problems: string[];
+}
+
+export interface HealthStatus {
+  status: string;
+  database: NodeStatus[];
+}
+
+export interface NodeStatus {
+  name: string;
+  healthy: boolean;
+  error?: string;
+  checkedAt: string;
+}
+
+export interface Results {
+  query: string;
+  backend: string;
+  hits: Hit[];
+}
+
+export interface Hit {
+  kind: string;
+  id: number;
+  score: number;
+  fields: Record<string, string>;
+  highlights: Record<string, string>;
+}
+
+export interface SearchReindexInput {
+  kind: string;
+}
+
+export interface TaskInput {
+  kind: string;
+  payload: unknown;
+}
+
+export interface Tenant {
+  id: number;
+  slug: string;
+  name: string;
+  createdAt: string;
+  updatedAt: string;
+}
+
+export interface TenantInput {
+  slug: string;
+  name: string;
+}
+
+export interface TenantSelection {
+  slug: string;
+}
+
+export interface User {
+  id: number;
+  tenantId: number;
+  name: string;
+  email: string;
+  role: string;
+  createdAt: string;
+  updatedAt: string;
+}
+
+export interface UserInput {
+  name: string;
+  email: string;
+  role: string;
+}
+
+export interface UserImportResult {
+  dryRun: boolean;
+  mode: string;
+  rows: number;
+  created: number;
+  updated: number;
+  failed: number;
+  committed: boolean;
+  errors: ImportRowError[];
+  errorsTruncated: boolean;
+}
+
+export interface ImportRowError {
+  row: number;
+  field?: string;
+  message: string;
+}
+
+export interface ClientStats {
+  clientId: string;
+  connId: string;
+  policy: string;
+  depth: number;
+  capacity: number;
+  highWater: number;
+  enqueued: number;
+  sent: number;
+  dropped: number;
+  coalesced: number;
+}
+
+export class ApiError extends Error {
+  readonly status: number;
+  readonly code: ErrorCode | undefined;
+
+  constructor(status: number, message: string, code?: ErrorCode) {
+    super(message);
+    this.name = "ApiError";
+    this.status = status;
+    this.code = code;
+  }
+}
+
+type RequestOptions = {
+  body?: unknown;
+  query?: Record<string, string | number | boolean | undefined>;
+  parse: "json" | "text" | "blob" | "none";
+  init: RequestInit;
+};
+
+async function apiRequest<T>(method: string, path: string, options: RequestOptions): Promise<T> {
+  let url = path;
+  if (options.query) {
+    const search = new URLSearchParams();
+    for (const [key, value] of Object.entries(options.query)) {
+      if (value !== undefined) {
+        search.set(key, String(value));
+      }
+    }
+    const qs = search.toString();
+    if (qs) {
+      url += "?" + qs;
+    }
+  }
+
+  // Files and form data are sent as is; the browser sets their content type.
+  const raw = options.body instanceof Blob || options.body instanceof FormData;
+  const headers = new Headers(options.init.headers);
+  if (options.body !== undefined && !raw && !headers.has("Content-Type")) {
+    headers.set("Content-Type", "application/json");
+  }
+
+  let body: BodyInit | undefined;
+  if (raw) {
+    body = options.body as Blob | FormData;
+  } else if (options.body !== undefined) {
+    body = JSON.stringify(options.body);
+  }
+
+  const response = await fetch(url, {
+    ...options.init,
+    method,
+    headers,
+    body,
+  });
+
+  if (!response.ok) {
+    let message = `请求失败: ${response.status}`;
+    let code: ErrorCode | undefined;
+    try {
+      const data = (await response.json()) as Partial<ErrorResponse>;
+      if (data.error) {
+        message = data.error;
+      }
+      code = data.code;
+    } catch {
+      // ignore non-JSON error bodies
+    }
+    throw new ApiError(response.status, message, code);
+  }
+
+  if (options.parse === "json") {
+    return (await response.json()) as T;
+  }
+  if (options.parse === "text") {
+    return (await response.text()) as T;
+  }
+  if (options.parse === "blob") {
+    return (await response.blob()) as T;
+  }
+  return undefined as T;
+}
+
+/** List database backups, newest first */
+export function listBackups(init: RequestInit = {}): Promise<Info[]> {
+  return apiRequest<Info[]>("GET", "/api/backups", {
+    parse: "json",
+    init,
+  });
+}
+
+/** Back up the database now */
+export function createBackup(init: RequestInit = {}): Promise<Info> {
+  return apiRequest<Info>("POST", "/api/backups", {
+    parse: "json",
+    init,
+  });
+}
+
+/** Download a backup */
+export function downloadBackup(name: string, init: RequestInit = {}): Promise<Blob> {
+  return apiRequest<Blob>("GET", `/api/backups/${encodeURIComponent(name)}`, {
+    parse: "blob",
+    init,
+  });
+}
+
+/** Delete a backup */
+export function deleteBackup(name: string, init: RequestInit = {}): Promise<void> {
+  return apiRequest<void>("DELETE", `/api/backups/${encodeURIComponent(name)}`, {
+    parse: "none",
+    init,
+  });
+}
+
+/** Restore the database from a backup */
+export function restoreBackup(name: string, init: RequestInit = {}): Promise<void> {
+  return apiRequest<void>("POST", `/api/backups/${encodeURIComponent(name)}/restore`, {
+    parse: "none",
+    init,
+  });
+}
+
+/** Check a backup */
+export function verifyBackup(name: string, init: RequestInit = {}): Promise<Verification> {
+  return apiRequest<Verification>("POST", `/api/backups/${encodeURIComponent(name)}/verify`, {
+    parse: "json",
+    init,
+  });
+}
+
+/** API documentation viewer */
+export function getDocs(init: RequestInit = {}): Promise<string> {
+  return apiRequest<string>("GET", "/api/docs", {
+    parse: "text",
+    init,
+  });
+}
+
+/** Send a message over an event stream */
+export function postEvent(body: WSMessage, query: { connId?: string | number | boolean } = {}, init: RequestInit = {}): Promise<string> {
+  return apiRequest<string>("POST", "/api/events", {
+    body,
+    query,
+    parse: "text",
+    init,
+  });
+}
+
+/** Health check */
+export function getHealth(init: RequestInit = {}): Promise<HealthStatus> {
+  return apiRequest<HealthStatus>("GET", "/api/health", {
+    parse: "json",
+    init,
+  });
+}
+
+/** List scheduled jobs */
+export function listJobs(init: RequestInit = {}): Promise<JobStatus[]> {
+  return apiRequest<JobStatus[]>("GET", "/api/jobs", {
+    parse: "json",
+    init,
+  });
+}
+
+/** Get a scheduled job */
+export function getJob(name: string, init: RequestInit = {}): Promise<JobStatus> {
+  return apiRequest<JobStatus>("GET", `/api/jobs/${encodeURIComponent(name)}`, {
+    parse: "json",
+    init,
+  });
+}
+
+/** Disable a job's schedule */
+export function disableJob(name: string, init: RequestInit = {}): Promise<JobStatus> {
+  return apiRequest<JobStatus>("POST", `/api/jobs/${encodeURIComponent(name)}/disable`, {
+    parse: "json",
+    init,
+  });
+}
+
+/** Enable a job's schedule */
+export function enableJob(name: string, init: RequestInit = {}): Promise<JobStatus> {
+  return apiRequest<JobStatus>("POST", `/api/jobs/${encodeURIComponent(name)}/enable`, {
+    parse: "json",
+    init,
+  });
+}
+
+/** Run a job now */
+export function runJob(name: string, init: RequestInit = {}): Promise<JobStatus> {
+  return apiRequest<JobStatus>("POST", `/api/jobs/${encodeURIComponent(name)}/run`, {
+    parse: "json",
+    init,
+  });
+}
+
+/** OpenAPI document */
+export function getOpenapiJson(init: RequestInit = {}): Promise<Record<string, unknown>> {
+  return apiRequest<Record<string, unknown>>("GET", "/api/openapi.json", {
+    parse: "json",
+    init,
+  });
+}
+
+/** Users currently online */
+export function getPresence(init: RequestInit = {}): Promise<PresenceEntry[]> {
+  return apiRequest<PresenceEntry[]>("GET", "/api/presence", {
+    parse: "json",
+    init,
+  });
+}
+
+/** Full-text search */
+export function search(query: { q?: string | number | boolean; kinds?: string | number | boolean; limit?: string | number | boolean; offset?: string | number | boolean } = {}, init: RequestInit = {}): Promise<Results> {
+  return apiRequest<Results>("GET", "/api/search", {
+    query,
+    parse: "json",
+    init,
+  });
+}
+
+/** Rebuild search indexes in the background */
+export function reindexSearch(body: SearchReindexInput, init: RequestInit = {}): Promise<Task> {
+  return apiRequest<Task>("POST", "/api/search/reindex", {
+    body,
+    parse: "json",
+    init,
+  });
+}
+
+/** List recent tasks */
+export function listTasks(query: { status?: string | number | boolean; kind?: string | number | boolean; limit?: string | number | boolean } = {}, init: RequestInit = {}): Promise<Task[]> {
+  return apiRequest<Task[]>("GET", "/api/tasks", {
+    query,
+    parse: "json",
+    init,
+  });
+}
+
+/** Enqueue a task */
+export function createTask(body: TaskInput, init: RequestInit = {}): Promise<Task> {
+  return apiRequest<Task>("POST", "/api/tasks", {
+    body,
+    parse: "json",
+    init,
+  });
+}
+
+/** Get a task */
+export function getTask(id: number, init: RequestInit = {}): Promise<Task> {
+  return apiRequest<Task>("GET", `/api/tasks/${encodeURIComponent(id)}`, {
+    parse: "json",
+    init,
+  });
+}
+
+/** Cancel a queued or running task */
+export function cancelTask(id: number, init: RequestInit = {}): Promise<Task> {
+  return apiRequest<Task>("POST", `/api/tasks/${encodeURIComponent(id)}/cancel`, {
+    parse: "json",
+    init,
+  });
+}
+
+/** Requeue a dead or canceled task */
+export function retryTask(id: number, init: RequestInit = {}): Promise<Task> {
+  return apiRequest<Task>("POST", `/api/tasks/${encodeURIComponent(id)}/retry`, {
+    parse: "json",
+    init,
+  });
+}
+
+/** List task kinds that can be enqueued */
+export function listTaskKinds(init: RequestInit = {}): Promise<string[]> {
+  return apiRequest<string[]>("GET", "/api/tasks/kinds", {
+    parse: "json",
+    init,
+  });
+}
+
+/** List tenants */
+export function listTenants(init: RequestInit = {}): Promise<Tenant[]> {
+  return apiRequest<Tenant[]>("GET", "/api/tenants", {
+    parse: "json",
+    init,
+  });
+}
+
+/** Create a tenant */
+export function createTenant(body: TenantInput, init: RequestInit = {}): Promise<Tenant> {
+  return apiRequest<Tenant>("POST", "/api/tenants", {
+    body,
+    parse: "json",
+    init,
+  });
+}
+
+/** Delete a tenant and all of its data */
+export function deleteTenant(id: number, init: RequestInit = {}): Promise<void> {
+  return apiRequest<void>("DELETE", `/api/tenants/${encodeURIComponent(id)}`, {
+    parse: "none",
+    init,
+  });
+}
+
+/** Get the tenant of this request */
+export function getCurrentTenant(init: RequestInit = {}): Promise<Tenant> {
+  return apiRequest<Tenant>("GET", "/api/tenants/current", {
+    parse: "json",
+    init,
+  });
+}
+
+/** Choose the tenant of later requests */
+export function selectTenant(body: TenantSelection, init: RequestInit = {}): Promise<Tenant> {
+  return apiRequest<Tenant>("PUT", "/api/tenants/current", {
+    body,
+    parse: "json",
+    init,
+  });
+}
+
+/** List users */
+export function listUsers(query: { q?: string | number | boolean; role?: string | number | boolean } = {}, init: RequestInit = {}): Promise<User[]> {
+  return apiRequest<User[]>("GET", "/api/users", {
+    query,
+    parse: "json",
+    init,
+  });
+}
+
+/** Create a user */
+export function createUser(body: UserInput, init: RequestInit = {}): Promise<User> {
+  return apiRequest<User>("POST", "/api/users", {
+    body,
+    parse: "json",
+    init,
+  });
+}
+
+/** Get a user */
+export function getUser(id: number, init: RequestInit = {}): Promise<User> {
+  return apiRequest<User>("GET", `/api/users/${encodeURIComponent(id)}`, {
+    parse: "json",
+    init,
+  });
+}
+
+/** Update a user */
+export function updateUser(id: number, body: UserInput, init: RequestInit = {}): Promise<User> {
+  return apiRequest<User>("PUT", `/api/users/${encodeURIComponent(id)}`, {
+    body,
+    parse: "json",
+    init,
+  });
+}
+
+/** Delete a user */
+export function deleteUser(id: number, init: RequestInit = {}): Promise<void> {
+  return apiRequest<void>("DELETE", `/api/users/${encodeURIComponent(id)}`, {
+    parse: "none",
+    init,
+  });
+}
+
+/** Export users as CSV, JSON Lines or XLSX */
+export function exportUsers(query: { format?: string | number | boolean; q?: string | number | boolean; role?: string | number | boolean } = {}, init: RequestInit = {}): Promise<Blob> {
+  return apiRequest<Blob>("GET", "/api/users/export", {
+    query,
+    parse: "blob",
+    init,
+  });
+}
+
+/** Import users from CSV, JSON Lines or XLSX */
+export function importUsers(body: Blob, query: { format?: string | number | boolean; mapping?: string | number | boolean; mode?: string | number | boolean; dryRun?: string | number | boolean } = {}, init: RequestInit = {}): Promise<UserImportResult> {
+  return apiRequest<UserImportResult>("POST", "/api/users/import", {
+    body,
+    query,
+    parse: "json",
+    init,
+  });
+}
+
+/** WebSocket send queue metrics */
+export function getWebSocketStats(init: RequestInit = {}): Promise<ClientStats[]> {
+  return apiRequest<ClientStats[]>("GET", "/api/ws/stats", {
+    parse: "json",
+    init,
+  });
+}
@@ -1,31 +1,62 @@
-export type WSMessage<T = unknown> = {
-  sender: string;
-  receiver: string;
-  timestamp: string;
-  type: string;
-  payload: T;
-};
+import {
+  type ClientMessageType,
+  type WSMessage,
+  type WSMessagePayloads,
+  postEvent,
+} from "@/api/generated";
+
+export type {
+  ClientMessageType,
+  ErrorPayload,
+  ServerMessageType,
+  TypedWSMessage,
+  WSMessage,
+  WSMessagePayloads,
+  WSMessageType,
+} from "@/api/generated";
 
 type Listener = (message: WSMessage) => void;
 
+// Negotiating the JSON subprotocol lets the server batch queued messages into
+// a single frame carrying an array.
+const SUBPROTOCOLS = ["wsmsg.json"];
+
+// After this many WebSocket attempts that never opened (e.g. a proxy that
+// breaks upgrades) the client switches to Server-Sent Events for the rest of
+// the session: /api/events for server pushes, POST /api/events to send.
+const MAX_FAILED_UPGRADES = 2;
+const TRANSPORT_KEY = "ws.transport";
+
+export type Transport = "websocket" | "sse";
+
+let transport: Transport =
+  typeof WebSocket === "undefined" || window.sessionStorage.getItem(TRANSPORT_KEY) === "sse"
+    ? "sse"
+    : "websocket";
 let socket: WebSocket | null = null;
+let eventSource: EventSource | null = null;
+let eventConnId: string | null = null;
+let lastEventId: string | null = null;
+let failedUpgrades = 0;
 let reconnectTimer: number | null = null;
 let reconnectAttempts = 0;
 let cachedUrl: string | null = null;
 const listeners = new Set<Listener>();
 const pendingMessages: Array<Omit<WSMessage, "timestamp">> = [];
 
+/** The transport currently carrying real-time messages. */
+export function getTransport(): Transport {
+  return transport;
+}
+
 function getSocketUrl(): string {
   if (cachedUrl) {
     return cachedUrl;
   }
   const protocol = window.location.protocol === "https:" ? "wss" : "ws";
   const base = `${protocol}://${window.location.host}`;
-  const clientId =
-    typeof window.crypto !== "undefined" && "randomUUID" in window.crypto
-      ? window.crypto.randomUUID()
-      : Math.random().toString(36).slice(2);
-  cachedUrl = `${base}/api/ws?clientId=${encodeURIComponent(clientId)}`;
+  // The server identifies the user by the session cookie it sets.
+  cachedUrl = `${base}/api/ws`;
   if (import.meta.env.DEV) {
     console.debug("[websocket] resolved socket url:", cachedUrl);
   }
@@ -36,6 +67,15 @@ function notify(message: WSMessage) {
   listeners.forEach((listener) => listener(message));
 }
 
+function dispatchFrame(raw: string) {
+  try {
+    const data = JSON.parse(raw) as WSMessage | WSMessage[];
+    (Array.isArray(data) ? data : [data]).forEach(notify);
+  } catch (error) {
+    console.error("Unable to parse websocket message", error);
+  }
+}
+
 function scheduleReconnect() {
   if (reconnectTimer) {
     window.clearTimeout(reconnectTimer);
@@ -48,6 +88,10 @@ function scheduleReconnect() {
 }
 
 export function connectWebSocket() {
+  if (transport === "sse") {
+    connectEventSource();
+    return;
+  }
   if (
     socket &&
     (socket.readyState === WebSocket.OPEN || socket.readyState === WebSocket.CONNECTING)
@@ -55,8 +99,9 @@ export function connectWebSocket() {
     return socket;
   }
 
+  let opened = false;
   try {
-    socket = new WebSocket(getSocketUrl());
+    socket = new WebSocket(getSocketUrl(), SUBPROTOCOLS);
   } catch (error) {
     console.error("Failed to open websocket", error);
     scheduleReconnect();
@@ -64,21 +109,25 @@ export function connectWebSocket() {
   }
 
   socket.addEventListener("open", () => {
+    opened = true;
+    failedUpgrades = 0;
     reconnectAttempts = 0;
     flushPending();
   });
 
   socket.addEventListener("message", (event) => {
-    try {
-      const data = JSON.parse(event.data) as WSMessage;
-      notify(data);
-    } catch (error) {
-      console.error("Unable to parse websocket message", error);
-    }
+    dispatchFrame(event.data);
   });
 
   socket.addEventListener("close", () => {
     socket = null;
+    if (!opened) {
+      failedUpgrades += 1;
+      if (failedUpgrades >= MAX_FAILED_UPGRADES) {
+        fallBackToEventSource();
+        return;
+      }
+    }
     scheduleReconnect();
   });
 
@@ -89,6 +138,51 @@ export function connectWebSocket() {
   return socket;
 }
 
+function fallBackToEventSource() {
+  console.warn("[websocket] upgrades keep failing, falling back to server-sent events");
+  transport = "sse";
+  window.sessionStorage.setItem(TRANSPORT_KEY, "sse");
+  reconnectAttempts = 0;
+  connectEventSource();
+}
+
+function connectEventSource() {
+  if (eventSource && eventSource.readyState !== EventSource.CLOSED) {
+    return;
+  }
+
+  const params = new URLSearchParams();
+  if (lastEventId) {
+    // The browser only sends Last-Event-ID on its own reconnects.
+    params.set("lastEventId", lastEventId);
+  }
+  const source = new EventSource(`/api/events?${params.toString()}`);
+  eventSource = source;
+
+  source.addEventListener("ready", (event) => {
+    const ready = JSON.parse((event as MessageEvent<string>).data) as { connId: string };
+    eventConnId = ready.connId;
+    reconnectAttempts = 0;
+    flushPending();
+  });
+
+  source.addEventListener("message", (event) => {
+    if (event.lastEventId) {
+      lastEventId = event.lastEventId;
+    }
+    dispatchFrame(event.data);
+  });
+
+  source.addEventListener("error", () => {
+    // The browser retries by itself unless the stream was refused.
+    eventConnId = null;
+    if (source.readyState === EventSource.CLOSED) {
+      eventSource = null;
+      scheduleReconnect();
+    }
+  });
+}
+
 export function subscribeToMessages(listener: Listener) {
   listeners.add(listener);
   return () => {
@@ -96,25 +190,62 @@ export function subscribeToMessages(listener: Listener) {
   };
 }
 
-export function sendMessage<T>(message: Omit<WSMessage<T>, "timestamp">) {
+/** An outbound frame; the payload type follows from the message type. */
+export type OutgoingMessage<K extends ClientMessageType = ClientMessageType> = {
+  sender: string;
+  receiver: string;
+  type: K;
+  payload: WSMessagePayloads[K];
+};
+
+export function sendMessage<K extends ClientMessageType>(message: OutgoingMessage<K>) {
+  const outgoing = message as Omit<WSMessage, "timestamp">;
+  if (transport === "sse") {
+    connectEventSource();
+    if (!eventConnId) {
+      pendingMessages.push(outgoing);
+      return;
+    }
+    postNow(eventConnId, outgoing);
+    return;
+  }
+
   const currentSocket = connectWebSocket();
   if (!currentSocket || currentSocket.readyState !== WebSocket.OPEN) {
-    pendingMessages.push(message as Omit<WSMessage, "timestamp">);
+    pendingMessages.push(outgoing);
     return;
   }
 
-  sendNow(currentSocket, message);
+  sendNow(currentSocket, outgoing);
 }
 
-function sendNow(socketInstance: WebSocket, message: Omit<WSMessage, "timestamp">) {
-  const payload = {
+function stampMessage(message: Omit<WSMessage, "timestamp">): WSMessage {
+  return {
     ...message,
     timestamp: new Date().toISOString(),
   };
-  socketInstance.send(JSON.stringify(payload));
+}
+
+function sendNow(socketInstance: WebSocket, message: Omit<WSMessage, "timestamp">) {
+  socketInstance.send(JSON.stringify(stampMessage(message)));
+}
+
+function postNow(connId: string, message: Omit<WSMessage, "timestamp">) {
+  postEvent(stampMessage(message), { connId }).catch((error) => {
+    console.error("Failed to send message over event stream", error);
+  });
 }
 
 function flushPending() {
+  if (transport === "sse") {
+    const connId = eventConnId;
+    if (!connId) {
+      return;
+    }
+    pendingMessages.splice(0).forEach((message) => postNow(connId, message));
+    return;
+  }
+
   const activeSocket = socket;
   if (!activeSocket || activeSocket.readyState !== WebSocket.OPEN) {
     return;
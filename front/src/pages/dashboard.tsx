@@ -1,6 +1,7 @@
 import { useEffect, useState } from "react";
 import { FiActivity, FiMessageSquare, FiUsers } from "react-icons/fi";
 
+import { getPresence } from "@/api/generated";
 import { type WSMessage, subscribeToMessages } from "@/api/websocket";
 import {
   Card,
@@ -11,12 +12,6 @@ import {
 } from "@/components/ui/card";
 
 const METRICS = [
-  {
-    title: "在线用户",
-    value: "128",
-    description: "当前保持 websocket 连接的总数",
-    icon: <FiUsers className="h-5 w-5 text-primary" />,
-  },
   {
     title: "今日请求",
     value: "4,621",
@@ -33,18 +28,39 @@ const METRICS = [
 
 function DashboardPage() {
   const [messages, setMessages] = useState<WSMessage[]>([]);
+  const [onlineUsers, setOnlineUsers] = useState<number | null>(null);
 
   useEffect(() => {
+    const refreshPresence = () => {
+      getPresence()
+        .then((users) => setOnlineUsers(users.length))
+        .catch(() => setOnlineUsers(null));
+    };
+    refreshPresence();
+
     const unsubscribe = subscribeToMessages((message) => {
       setMessages((prev) => [message, ...prev].slice(0, 5));
+      if (message.type === "presence.join" || message.type === "presence.leave") {
+        refreshPresence();
+      }
     });
     return unsubscribe;
   }, []);
 
+  const metrics = [
+    {
+      title: "在线用户",
+      value: onlineUsers === null ? "—" : String(onlineUsers),
+      description: "当前保持 websocket 连接的用户数",
+      icon: <FiUsers className="h-5 w-5 text-primary" />,
+    },
+    ...METRICS,
+  ];
+
   return (
     <div className="space-y-6">
       <div className="grid gap-4 sm:grid-cols-2 xl:grid-cols-3">
-        {METRICS.map((metric) => (
+        {metrics.map((metric) => (
           <Card key={metric.title}>
             <CardHeader className="flex flex-row items-center justify-between space-y-0 pb-2">
               <CardTitle className="text-sm font-medium">
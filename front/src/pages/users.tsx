@@ -1,6 +1,22 @@
-import { useCallback, useEffect, useState } from "react";
-import { FiPlus, FiRefreshCcw } from "react-icons/fi";
+import { type ChangeEvent, useCallback, useEffect, useRef, useState } from "react";
+import {
+  FiDownload,
+  FiPlus,
+  FiRefreshCcw,
+  FiSearch,
+  FiUpload,
+} from "react-icons/fi";
 
+import {
+  ApiError,
+  createUser as createUserRequest,
+  importUsers,
+  listUsers,
+  search,
+  type Hit,
+  type User,
+  type UserImportResult,
+} from "@/api/generated";
 import { Button } from "@/components/ui/button";
 import {
   Card,
@@ -10,29 +26,21 @@ import {
   CardTitle,
 } from "@/components/ui/card";
 
-interface User {
-  id: number;
-  name: string;
-  email: string;
-  role: string;
-  createdAt: string;
-}
-
 function UsersPage() {
   const [users, setUsers] = useState<User[]>([]);
   const [loading, setLoading] = useState(false);
   const [error, setError] = useState<string | null>(null);
+  const [importResult, setImportResult] = useState<UserImportResult | null>(null);
+  const [query, setQuery] = useState("");
+  // 搜索结果按相关度排序，null 表示未在搜索。
+  const [hits, setHits] = useState<Hit[] | null>(null);
+  const fileInput = useRef<HTMLInputElement>(null);
 
   const fetchUsers = useCallback(async () => {
     setLoading(true);
     setError(null);
     try {
-      const response = await fetch("/api/users");
-      if (!response.ok) {
-        throw new Error(`请求失败: ${response.status}`);
-      }
-      const data = (await response.json()) as User[];
-      setUsers(data);
+      setUsers(await listUsers());
     } catch (err) {
       setError(
         err instanceof Error
@@ -51,15 +59,7 @@ function UsersPage() {
         email: `${Date.now()}@example.com`,
         role: "viewer",
       };
-      const response = await fetch("/api/users", {
-        method: "POST",
-        headers: { "Content-Type": "application/json" },
-        body: JSON.stringify(payload),
-      });
-      if (!response.ok) {
-        throw new Error(`创建失败: ${response.status}`);
-      }
-      const created = (await response.json()) as User;
+      const created = await createUserRequest(payload);
       setUsers((prev) => [created, ...prev]);
     } catch (err) {
       setError(
@@ -70,10 +70,73 @@ function UsersPage() {
     }
   }, []);
 
+  const importFile = useCallback(
+    async (event: ChangeEvent<HTMLInputElement>) => {
+      const file = event.target.files?.[0];
+      event.target.value = "";
+      if (!file) {
+        return;
+      }
+      const format = file.name.split(".").pop()?.toLowerCase() ?? "";
+      let result: UserImportResult | null = null;
+      let message: string | null = null;
+      try {
+        result = await importUsers(file, { format, mode: "best-effort" });
+      } catch (err) {
+        // best-effort 模式下部分行失败时返回 422，合法的行已经写入。
+        if (err instanceof ApiError && err.status === 422) {
+          message = "部分行未通过校验，其余行已导入。";
+        } else {
+          message = err instanceof Error ? err.message : "导入失败。";
+        }
+      }
+      await fetchUsers();
+      setImportResult(result);
+      if (message) {
+        setError(message);
+      }
+    },
+    [fetchUsers]
+  );
+
   useEffect(() => {
     fetchUsers();
   }, [fetchUsers]);
 
+  useEffect(() => {
+    const q = query.trim();
+    if (!q) {
+      setHits(null);
+      return;
+    }
+    const controller = new AbortController();
+    const timer = window.setTimeout(async () => {
+      try {
+        const results = await search(
+          { q, kinds: "user", limit: 100 },
+          { signal: controller.signal }
+        );
+        setHits(results.hits);
+      } catch (err) {
+        if (!controller.signal.aborted) {
+          setError(err instanceof Error ? err.message : "搜索失败。");
+        }
+      }
+    }, 250);
+    return () => {
+      controller.abort();
+      window.clearTimeout(timer);
+    };
+  }, [query, users]);
+
+  const byId = new Map(users.map((user) => [user.id, user]));
+  const rows = hits
+    ? hits.flatMap((hit) => {
+        const user = byId.get(hit.id);
+        return user ? [{ user, highlights: hit.highlights }] : [];
+      })
+    : users.map((user) => ({ user, highlights: {} as Record<string, string> }));
+
   return (
     <div className="space-y-6">
       <Card>
@@ -91,18 +154,55 @@ function UsersPage() {
             >
               <FiRefreshCcw className="mr-2 h-4 w-4" /> 刷新
             </Button>
+            <Button
+              variant="outline"
+              size="sm"
+              onClick={() => fileInput.current?.click()}
+            >
+              <FiUpload className="mr-2 h-4 w-4" /> 导入
+            </Button>
+            <input
+              ref={fileInput}
+              type="file"
+              accept=".csv,.jsonl,.ndjson,.xlsx"
+              className="hidden"
+              onChange={importFile}
+            />
+            <Button variant="outline" size="sm" asChild>
+              <a href="/api/users/export?format=csv" download>
+                <FiDownload className="mr-2 h-4 w-4" /> 导出
+              </a>
+            </Button>
             <Button size="sm" onClick={createUser}>
               <FiPlus className="mr-2 h-4 w-4" /> 新建用户
             </Button>
           </div>
         </CardHeader>
         <CardContent>
+          <div className="relative mb-4 max-w-sm">
+            <FiSearch className="absolute left-3 top-1/2 h-4 w-4 -translate-y-1/2 text-muted-foreground" />
+            <input
+              type="search"
+              value={query}
+              onChange={(event) => setQuery(event.target.value)}
+              placeholder="按姓名或邮箱搜索"
+              className="h-9 w-full rounded-md border bg-background pl-9 pr-3 text-sm outline-none focus-visible:ring-2 focus-visible:ring-ring"
+            />
+          </div>
+          {importResult && (
+            <p className="mb-4 text-sm text-muted-foreground">
+              已导入 {importResult.rows} 行：新增 {importResult.created}，更新{" "}
+              {importResult.updated}，失败 {importResult.failed}。
+            </p>
+          )}
           {loading ? (
             <p className="text-sm text-muted-foreground">加载中...</p>
           ) : error ? (
             <p className="text-sm text-destructive">{error}</p>
-          ) : users.length === 0 ? (
-            <p className="text-sm text-muted-foreground">暂无用户数据。</p>
+          ) : rows.length === 0 ? (
+            <p className="text-sm text-muted-foreground">
+              {hits ? "没有匹配的用户。" : "暂无用户数据。"}
+            </p>
           ) : (
             <div className="overflow-hidden rounded-md border">
               <table className="min-w-full divide-y divide-border text-sm">
@@ -117,13 +217,13 @@ function UsersPage() {
                   </tr>
                 </thead>
                 <tbody className="divide-y divide-border">
-                  {users.map((user) => (
+                  {rows.map(({ user, highlights }) => (
                     <tr key={user.id}>
                       <td className="px-4 py-2 font-medium text-foreground">
-                        {user.name}
+                        <Highlighted html={highlights.name} text={user.name} />
                       </td>
                       <td className="px-4 py-2 text-muted-foreground">
-                        {user.email}
+                        <Highlighted html={highlights.email} text={user.email} />
                       </td>
                       <td className="px-4 py-2 text-muted-foreground">
                         {user.role}
@@ -143,4 +243,18 @@ function UsersPage() {
   );
 }
 
+// Highlighted renders a search highlight. The server HTML-escapes the value
+// and only adds <mark> tags, so it is safe to inject.
+function Highlighted({ html, text }: { html?: string; text: string }) {
+  if (!html) {
+    return <>{text}</>;
+  }
+  return (
+    <span
+      className="[&_mark]:rounded-sm [&_mark]:bg-yellow-200 [&_mark]:text-foreground dark:[&_mark]:bg-yellow-500/40"
+      dangerouslySetInnerHTML={{ __html: html }}
+    />
+  );
+}
+
 export default UsersPage;
@@ -14,9 +14,12 @@ export default defineConfig({
   server: {
     proxy: {
       "/api": {
-        target: "http://localhost:8080",
+        // Set to https://localhost:8080 when the backend runs with TLS_ENABLED.
+        target: process.env.API_TARGET ?? "http://localhost:8080",
         changeOrigin: true,
         ws: true,
+        // Accept the backend's self-signed certificate.
+        secure: false,
       },
     },
   },